@@ -2,4 +2,21 @@ module tsmap-extract.safepic.fr
 
 go 1.24.0
 
-require golang.org/x/net v0.46.0
+require (
+	golang.org/x/net v0.46.0
+	golang.org/x/sys v0.37.0
+	golang.org/x/term v0.36.0
+	golang.org/x/text v0.30.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/dop251/goja v0.0.0-20260311135729-065cd970411c
+	golang.org/x/crypto v0.43.0
+)
+
+require (
+	github.com/dlclark/regexp2 v1.11.4 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
+	github.com/google/pprof v0.0.0-20230207041349-798e818bf904 // indirect
+)
@@ -13,6 +13,15 @@ func usage() {
 	fmt.Println("Usage:")
 	fmt.Println("  tsmap-extract extract [flags]    Extract sources from a .map file")
 	fmt.Println("  tsmap-extract crawl   [flags]    Crawl a page, find JS and extract .map sources")
+	fmt.Println("  tsmap-extract validate [flags]   Check a .map file against the source map v3 format")
+	fmt.Println("  tsmap-extract diff OLD NEW       Compare two outputs: added, removed and modified files")
+	fmt.Println("  tsmap-extract grep [flags]       Search the recovered files of an output")
+	fmt.Println("  tsmap-extract serve [flags]      Browse an output in a local web UI")
+	fmt.Println("  tsmap-extract monitor [flags]    Re-crawl a page periodically and alert on changed sources")
+	fmt.Println("  tsmap-extract server [flags]     Run extractions and crawls as jobs behind an HTTP API")
+	fmt.Println("  tsmap-extract coordinate [flags] Shard a list of targets over several server workers")
+	fmt.Println("  tsmap-extract selftest [flags]   Crawl built-in fixture sites to check the setup")
+	fmt.Println("  tsmap-extract completion <shell> Print a completion script (bash|zsh|fish|powershell)")
 	fmt.Println()
 	fmt.Println("Run 'tsmap-extract <subcommand> -h' for subcommand help.")
 }
@@ -21,15 +30,33 @@ func usage() {
 func main() {
 	if len(os.Args) < 2 {
 		usage()
-		os.Exit(1)
+		os.Exit(2)
 	}
 	cmd := os.Args[1]
 
 	switch cmd {
 	case "extract":
-		tsmap.RunExtract(os.Args[2:])
+		os.Exit(tsmap.RunExtract(os.Args[2:]))
 	case "crawl":
-		tsmap.RunCrawl(os.Args[2:])
+		os.Exit(tsmap.RunCrawl(os.Args[2:]))
+	case "validate":
+		os.Exit(tsmap.RunValidate(os.Args[2:]))
+	case "diff":
+		os.Exit(tsmap.RunDiff(os.Args[2:]))
+	case "grep":
+		os.Exit(tsmap.RunGrep(os.Args[2:]))
+	case "serve":
+		os.Exit(tsmap.RunServe(os.Args[2:]))
+	case "monitor":
+		os.Exit(tsmap.RunMonitor(os.Args[2:]))
+	case "server":
+		os.Exit(tsmap.RunServer(os.Args[2:]))
+	case "coordinate":
+		os.Exit(tsmap.RunCoordinate(os.Args[2:]))
+	case "selftest":
+		os.Exit(tsmap.RunSelftest(os.Args[2:]))
+	case "completion":
+		os.Exit(tsmap.RunCompletion(os.Args[2:]))
 	case "help", "-h", "--help":
 		usage()
 	default:
@@ -3,22 +3,19 @@
 package tsmap
 
 import (
+	"fmt"
 	"os"
-	"runtime"
+	"strings"
 )
 
 var (
-	// Couleurs ANSI si TTY Linux/macOS
-	useColor = func() bool {
-		fi, err := os.Stdout.Stat()
-		return err == nil && (fi.Mode()&os.ModeCharDevice) != 0 &&
-			(runtime.GOOS == "linux" || runtime.GOOS == "darwin")
-	}()
-	cRed = ansi("\033[31m")
-	cGrn = ansi("\033[32m")
-	cYel = ansi("\033[33m")
-	cCyn = ansi("\033[36m")
-	cRst = "\033[0m"
+	// Couleurs ANSI si TTY (auto), voir aussi -color et NO_COLOR
+	useColor = colorAuto()
+	cRed     = ansi("\033[31m")
+	cGrn     = ansi("\033[32m")
+	cYel     = ansi("\033[33m")
+	cCyn     = ansi("\033[36m")
+	cRst     = ansi("\033[0m")
 )
 
 func ansi(code string) string {
@@ -27,3 +24,38 @@ func ansi(code string) string {
 	}
 	return ""
 }
+
+// colorAuto enables colors on terminals, unless NO_COLOR is set (https://no-color.org)
+// or TERM=dumb. On Windows it also turns on VT processing for the console.
+func colorAuto() bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(os.Stdout) && enableVT(os.Stdout)
+}
+
+// applyColorMode handles -color auto|always|never.
+func applyColorMode(mode string) error {
+	switch strings.ToLower(mode) {
+	case "", "auto":
+		setColor(colorAuto())
+	case "always":
+		enableVT(os.Stdout)
+		setColor(true)
+	case "never":
+		setColor(false)
+	default:
+		return fmt.Errorf("invalid -color %q (auto|always|never)", mode)
+	}
+	return nil
+}
+
+// setColor forces colors on or off.
+func setColor(on bool) {
+	useColor = on
+	cRed = ansi("\033[31m")
+	cGrn = ansi("\033[32m")
+	cYel = ansi("\033[33m")
+	cCyn = ansi("\033[36m")
+	cRst = ansi("\033[0m")
+}
@@ -0,0 +1,11 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+
+//go:build !windows
+
+package tsmap
+
+import "os"
+
+// enableVT is a no-op: Unix terminals understand ANSI escapes natively.
+func enableVT(*os.File) bool { return true }
@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+
+//go:build windows
+
+package tsmap
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// enableVT turns on ANSI escape processing for a Windows console (Windows 10+).
+func enableVT(f *os.File) bool {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return false
+	}
+	if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING != 0 {
+		return true
+	}
+	return windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
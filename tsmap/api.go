@@ -0,0 +1,298 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ParseSourceMap parses a map as found in a .map file, XSSI prefix included.
+// The sections of an index map are flattened into Sources, with their own
+// sourceRoot applied; sections referencing another map by URL are not loaded
+// and return an error. x_google_ignoreList is merged into IgnoreList.
+func ParseSourceMap(data []byte) (*SourceMap, error) {
+	sm, err := decodeSourceMap(data, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if ignored := sm.ignored(); len(ignored) > 0 {
+		sm.IgnoreList = slices.Sorted(maps.Keys(ignored))
+	}
+	sm.GoogleIgnore = nil
+	return &sm, nil
+}
+
+// ExtractOptions controls how recovered sources are written, by Extract and Crawl.
+// The zero value writes every source with content as is.
+type ExtractOptions struct {
+	Out           string   // output directory, used when Sink is nil
+	Sink          Sink     // where files go; it is not closed
+	Beautify      bool     // as -beautify
+	EOL           string   // "", "unix" or "dos", as -eol
+	KeepNamespace bool     // as -keep-namespace
+	SkipVendor    bool     // as -skip-vendor
+	SkipIgnored   bool     // as -skip-ignored
+	Include       []string // globs, as -include
+	Exclude       []string // globs, as -exclude
+	ScanSecrets   bool     // as -scan-secrets, with the built-in rules
+	Observer      Observer // receives the events of the run, may be nil
+}
+
+// Result describes what Extract wrote.
+type Result struct {
+	Files    []string // written paths, slash separated and relative to the output
+	Skipped  int      // sources without content, filtered out, or resolving outside the output
+	Secrets  []Secret // only with ScanSecrets
+	Severity string   // none, low, medium, high or critical
+}
+
+// Secret is a credential found in a recovered source.
+type Secret struct {
+	Rule   string `json:"rule"` // rule ID, e.g. aws-access-key-id
+	Path   string `json:"path"` // relative to the output, slash separated
+	Line   int    `json:"line"`
+	Match  string `json:"match"`
+	Source string `json:"source,omitempty"` // source name in the map
+	Map    string `json:"map,omitempty"`    // map URL or path, "inline" for inline maps
+}
+
+// recordSink forwards files to a Sink and lists the written paths.
+type recordSink struct {
+	sink Sink
+
+	mu    sync.Mutex
+	files []string
+}
+
+func (r *recordSink) WriteFile(p string, content []byte, meta FileMeta) error {
+	if err := r.sink.WriteFile(p, content, meta); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.files = append(r.files, p)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *recordSink) Close() error { return nil }
+
+// output builds the outputOptions of a library run.
+func (opts *ExtractOptions) output() (*outputOptions, *recordSink, error) {
+	sink := opts.Sink
+	if sink == nil {
+		if opts.Out == "" {
+			return nil, nil, errors.New("tsmap: ExtractOptions needs Out or Sink")
+		}
+		sink = NewDirSink(opts.Out)
+	}
+	switch opts.EOL {
+	case "", "unix", "dos":
+	default:
+		return nil, nil, errors.New("tsmap: EOL must be unix or dos")
+	}
+	rec := &recordSink{sink: sink}
+	secrets := &secretScanner{enabled: opts.ScanSecrets}
+	o := &outputOptions{
+		beautify:   opts.Beautify,
+		eol:        opts.EOL,
+		namespaces: opts.KeepNamespace,
+		conflicts:  newConflictTracker(),
+		scrubbed:   &scrubLog{},
+		filter:     &sourceFilter{skipVendor: opts.SkipVendor, skipIgnored: opts.SkipIgnored},
+		secrets:    secrets,
+		analyzers:  []analyzer{secrets},
+		scores:     &exposureScores{maps: make(map[string]riskLevel)},
+		root:       opts.Out,
+		sinks:      []Sink{rec},
+		events:     observe(opts.Observer),
+	}
+	for _, g := range opts.Include {
+		if err := o.filter.include.Set(g); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, g := range opts.Exclude {
+		if err := o.filter.exclude.Set(g); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := o.secrets.load(); err != nil {
+		return nil, nil, err
+	}
+	return o, rec, nil
+}
+
+func exportSecrets(list []secretFinding) []Secret {
+	var out []Secret
+	for _, f := range list {
+		out = append(out, Secret(f))
+	}
+	return out
+}
+
+// Extract writes the sources of sm with the path handling of the extract
+// subcommand. It stops with ctx.Err() when ctx is done.
+func Extract(ctx context.Context, sm *SourceMap, opts ExtractOptions) (Result, error) {
+	out, rec, err := opts.output()
+	if err != nil {
+		return Result{}, err
+	}
+	cp := *sm // filtered sources are emptied, sm stays untouched
+	cp.SourcesContent = slices.Clone(sm.SourcesContent)
+	n, err := writeMapSources(ctx, cp, opts.Out, out, mapOrigin{})
+	res := Result{
+		Files:    rec.files,
+		Skipped:  len(sm.Sources) - n,
+		Secrets:  exportSecrets(out.secrets.list()),
+		Severity: out.scores.overall(len(out.secrets.list())).String(),
+	}
+	return res, err
+}
+
+// CrawlOptions controls Crawl. Concurrency defaults to 4, the user agent to the
+// one of the crawl subcommand and HTTPClient to a client with a 25s timeout.
+type CrawlOptions struct {
+	URL          string       // root page
+	Concurrency  int          // parallel scripts
+	UserAgent    string       // User-Agent header
+	Headers      http.Header  // extra request headers, e.g. Authorization
+	HTTPClient   *http.Client // used for every request, e.g. with a proxy or custom TLS
+	Scope        []string     // hosts allowed besides the root one, as -scope
+	SaveJS       bool         // as -save-js
+	SaveMap      bool         // as -save-map
+	SaveMapRaw   bool         // as -save-map-raw
+	EvalChunks   bool         // as -eval-chunks
+	Harvest      bool         // as -harvest
+	MapGuess     string       // "", "default" or "aggressive", as -map-guess
+	MapTemplates []string     // as -map-template, implies MapGuess "aggressive"
+	AllMaps      bool         // as -all-maps
+	OutTemplate  string       // as -out-template, e.g. "{host}/{date}/{bundle}"
+	ExtractOptions
+}
+
+// Report describes a crawl, like report.json.
+type Report struct {
+	RootURL  string
+	Scripts  []ScriptResult
+	Files    []string // written paths, slash separated and relative to the output
+	Sources  int      // sources written
+	Secrets  []Secret // only with ScanSecrets
+	Severity string   // none, low, medium, high or critical
+}
+
+// ScriptResult is what happened to one script of the crawl.
+type ScriptResult struct {
+	URL      string   `json:"url"`
+	Parent   string   `json:"parent,omitempty"`   // script that referenced this chunk
+	Status   int      `json:"status,omitempty"`   // HTTP status, 0 when the fetch failed
+	MapURL   string   `json:"map_url,omitempty"`  // "inline" for data: URLs, empty when no map was found
+	Sources  int      `json:"sources_written"`    // sources written
+	Severity string   `json:"severity,omitempty"` // none, low, medium, high or critical
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// Crawl fetches opts.URL, follows its scripts and chunks and extracts their
+// source maps, like the crawl subcommand with the merged layout. Only the
+// error of the root page is returned; the errors of each script are in the
+// report. When ctx is done, the scripts not started yet are skipped and Crawl
+// returns the partial report with ctx.Err(). Crawl may run concurrently: it
+// only shares the package logger (see SetLogger), discarded by default.
+func Crawl(ctx context.Context, opts CrawlOptions) (Report, error) {
+	cr := &crawler{client: opts.HTTPClient, userAgent: opts.UserAgent, headers: opts.Headers}
+	if cr.client == nil {
+		cr.client = &http.Client{Timeout: fetchTimeout}
+	}
+	if cr.userAgent == "" {
+		cr.userAgent = defaultUserAgent
+	}
+	return crawl(ctx, opts, cr)
+}
+
+// crawl is Crawl downloading through cr, which replaces the HTTP settings of opts.
+func crawl(ctx context.Context, opts CrawlOptions, cr *crawler) (Report, error) {
+	rootURL, err := url.Parse(opts.URL)
+	if err != nil {
+		return Report{}, err
+	}
+	if rootURL.Scheme != "http" && rootURL.Scheme != "https" {
+		return Report{}, errors.New("tsmap: CrawlOptions.URL must be an http(s) URL")
+	}
+	switch opts.MapGuess {
+	case "", "default", "aggressive":
+	default:
+		return Report{}, errors.New("tsmap: MapGuess must be default or aggressive")
+	}
+	for _, t := range opts.MapTemplates {
+		if err := checkMapTemplate(t); err != nil {
+			return Report{}, fmt.Errorf("tsmap: %w", err)
+		}
+	}
+	var tmpl outTemplate
+	if err := tmpl.Set(opts.OutTemplate); err != nil {
+		return Report{}, fmt.Errorf("tsmap: %w", err)
+	}
+	out, rec, err := opts.output()
+	if err != nil {
+		return Report{}, err
+	}
+	sess := &crawlSession{
+		ctx:         ctx,
+		rootURL:     rootURL,
+		outBase:     opts.Out,
+		output:      out,
+		http:        cr,
+		saveJS:      opts.SaveJS,
+		saveMap:     opts.SaveMap,
+		evalChunks:  opts.EvalChunks,
+		harvest:     opts.Harvest,
+		guessMaps:   opts.MapGuess == "aggressive" || len(opts.MapTemplates) > 0,
+		templates:   opts.MapTemplates,
+		allMaps:     opts.AllMaps,
+		outTemplate: tmpl,
+		day:         time.Now(),
+	}
+	if opts.SaveMapRaw {
+		sess.rawMaps = &rawMapStore{}
+	}
+	if len(opts.Scope) > 0 {
+		sess.scope = append(append(hostList(nil), opts.Scope...), rootURL.Hostname())
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	if _, _, err := runCrawlPass(sess, concurrency); err != nil {
+		return Report{}, err
+	}
+	sess.rawMaps.save(out)
+
+	rep := &crawlReport{RootURL: rootURL.String(), Scripts: sess.scripts, Secrets: out.secrets.list()}
+	out.scores.scoreReport(rep)
+	res := Report{
+		RootURL:  rep.RootURL,
+		Files:    rec.files,
+		Secrets:  exportSecrets(rep.Secrets),
+		Severity: rep.Severity.String(),
+	}
+	for _, sr := range rep.Scripts {
+		res.Sources += sr.Sources
+		res.Scripts = append(res.Scripts, ScriptResult{
+			URL:      sr.URL,
+			Parent:   sr.Parent,
+			Status:   sr.Status,
+			MapURL:   sr.MapURL,
+			Sources:  sr.Sources,
+			Severity: sr.Severity.String(),
+			Errors:   sr.Errors,
+		})
+	}
+	return res, ctx.Err()
+}
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// archiveFormat writes entries to one archive stream.
+type archiveFormat interface {
+	add(name string, data []byte, modTime time.Time, exec bool) error
+	close() error
+}
+
+// ArchiveSink writes files into a ZIP or tar.gz archive (-out-zip, -out-tar).
+// Contents are staged in a temporary file and the archive is written by
+// Close, so that a path written twice keeps its last content, as in a
+// directory tree, while memory stays bounded.
+type ArchiveSink struct {
+	fmt archiveFormat
+
+	mu      sync.Mutex
+	stage   *os.File // contents, nil until the first write
+	size    int64
+	entries []*archiveEntry // in the order of their first write
+	byName  map[string]*archiveEntry
+}
+
+// archiveEntry is the last content written at a path: a range of the stage file.
+type archiveEntry struct {
+	name      string
+	off, size int64
+	modTime   time.Time
+	exec      bool
+}
+
+// NewZipSink returns a sink writing a ZIP archive to w. Close writes and
+// finishes the archive but does not close w.
+func NewZipSink(w io.Writer) *ArchiveSink {
+	return &ArchiveSink{fmt: &zipFormat{zw: zip.NewWriter(w)}, byName: make(map[string]*archiveEntry)}
+}
+
+// NewTarSink returns a sink writing a gzip-compressed tar archive to w. Close
+// writes and finishes the archive but does not close w.
+func NewTarSink(w io.Writer) *ArchiveSink {
+	gz := gzip.NewWriter(w)
+	return &ArchiveSink{fmt: &tarFormat{gz: gz, tw: tar.NewWriter(gz)}, byName: make(map[string]*archiveEntry)}
+}
+
+func (a *ArchiveSink) WriteFile(p string, content []byte, meta FileMeta) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stage == nil {
+		f, err := os.CreateTemp("", "tsmap-archive-*")
+		if err != nil {
+			return err
+		}
+		a.stage = f
+	}
+	if _, err := a.stage.WriteAt(content, a.size); err != nil {
+		return err
+	}
+	modTime := meta.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	e := a.byName[p]
+	if e == nil {
+		e = &archiveEntry{name: p}
+		a.byName[p] = e
+		a.entries = append(a.entries, e)
+	}
+	e.off, e.size, e.modTime, e.exec = a.size, int64(len(content)), modTime, meta.Executable
+	a.size += int64(len(content))
+	return nil
+}
+
+// Close writes every entry with its last content, finishes the archive and
+// removes the stage file.
+func (a *ArchiveSink) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stage != nil {
+		defer os.Remove(a.stage.Name())
+		defer a.stage.Close()
+	}
+	for _, e := range a.entries {
+		data := make([]byte, e.size)
+		if _, err := a.stage.ReadAt(data, e.off); err != nil {
+			return err
+		}
+		if err := a.fmt.add(e.name, data, e.modTime, e.exec); err != nil {
+			return err
+		}
+	}
+	return a.fmt.close()
+}
+
+type zipFormat struct {
+	zw *zip.Writer
+}
+
+func (z *zipFormat) add(name string, data []byte, modTime time.Time, exec bool) error {
+	hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}
+	if exec {
+		hdr.SetMode(0755)
+	}
+	w, err := z.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (z *zipFormat) close() error { return z.zw.Close() }
+
+type tarFormat struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarFormat) add(name string, data []byte, modTime time.Time, exec bool) error {
+	mode := int64(0644)
+	if exec {
+		mode = 0755
+	}
+	hdr := &tar.Header{Name: name, Mode: mode, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := t.tw.Write(data)
+	return err
+}
+
+func (t *tarFormat) close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
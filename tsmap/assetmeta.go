@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// assetMeta is the .meta.json sidecar written next to a script or map saved
+// with -save-js, -save-map or -save-map-raw: how it was fetched, for evidence.
+type assetMeta struct {
+	URL        string      `json:"url"` // after redirects
+	Status     int         `json:"status"`
+	FetchedAt  time.Time   `json:"fetched_at"`
+	DurationMS int64       `json:"duration_ms"`
+	Cached     bool        `json:"cached,omitempty"` // revalidated against -http-cache
+	Size       int64       `json:"size"`
+	SHA256     string      `json:"sha256"`
+	Header     http.Header `json:"headers"`
+	TLS        *tlsMeta    `json:"tls,omitempty"`
+}
+
+// tlsMeta describes the TLS connection a response came over.
+type tlsMeta struct {
+	Version     string     `json:"version"`
+	CipherSuite string     `json:"cipher_suite"`
+	ServerName  string     `json:"server_name,omitempty"`
+	ALPN        string     `json:"alpn,omitempty"`
+	Chain       []certMeta `json:"chain"` // leaf first, as sent by the server
+}
+
+type certMeta struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	SHA256    string    `json:"sha256"` // fingerprint of the DER certificate
+}
+
+// writeAssetMeta writes dst + ".meta.json" for the asset saved at dst with
+// the content data from res. Nothing is written for inline maps, which have
+// no response of their own (res nil).
+func (o *outputOptions) writeAssetMeta(dst string, data []byte, res *fetchResult) {
+	if res == nil {
+		return
+	}
+	sum := sha256.Sum256(data)
+	m := assetMeta{
+		URL:        res.URL,
+		Status:     res.Status,
+		FetchedAt:  res.FetchedAt.UTC(),
+		DurationMS: res.Duration.Milliseconds(),
+		Cached:     res.Cached,
+		Size:       int64(len(data)),
+		SHA256:     hex.EncodeToString(sum[:]),
+		Header:     res.Header,
+		TLS:        newTLSMeta(res.TLS),
+	}
+	out, err := json.MarshalIndent(m, "", "  ")
+	if err == nil {
+		err = o.writeFile(dst+".meta.json", out)
+	}
+	if err != nil {
+		logger.Warn(msgAssetMetaError.String(), "path", dst, "err", err)
+	}
+}
+
+func newTLSMeta(cs *tls.ConnectionState) *tlsMeta {
+	if cs == nil {
+		return nil
+	}
+	t := &tlsMeta{
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ServerName:  cs.ServerName,
+		ALPN:        cs.NegotiatedProtocol,
+		Chain:       []certMeta{},
+	}
+	for _, c := range cs.PeerCertificates {
+		sum := sha256.Sum256(c.Raw)
+		t.Chain = append(t.Chain, certMeta{
+			Subject:   c.Subject.String(),
+			Issuer:    c.Issuer.String(),
+			Serial:    c.SerialNumber.Text(16),
+			DNSNames:  c.DNSNames,
+			NotBefore: c.NotBefore.UTC(),
+			NotAfter:  c.NotAfter.UTC(),
+			SHA256:    hex.EncodeToString(sum[:]),
+		})
+	}
+	return t
+}
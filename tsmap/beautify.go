@@ -0,0 +1,355 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"strings"
+)
+
+// beautifyWidth is the line length above which -beautify splits a line;
+// shorter lines are taken as formatted and kept as written.
+const beautifyWidth = 120
+
+const beautifyIndent = "  "
+
+// words after which a slash starts a regexp literal rather than a division
+var regexpKeywords = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true, "of": true, "new": true, "delete": true,
+	"void": true, "throw": true, "case": true, "do": true, "else": true, "yield": true, "await": true,
+}
+
+// words continuing the statement of a closing brace, kept on its line
+var braceContinuations = map[string]bool{
+	"else": true, "catch": true, "finally": true, "in": true, "instanceof": true, "of": true, "as": true, "satisfies": true,
+}
+
+// beautifiable tells the sources -beautify applies to: JavaScript, TypeScript
+// and files without extension, mostly bundler runtime modules. JSX is left
+// alone, a line break in its text being part of the output.
+func beautifiable(name string) bool {
+	name, _, _ = strings.Cut(name, "?")
+	switch languageOf(name) {
+	case "js", "ts", "none":
+		return true
+	}
+	return false
+}
+
+type jsTokenKind int
+
+const (
+	tokSpace   jsTokenKind = iota // blanks and line breaks
+	tokComment                    // line or block comment, hashbang
+	tokLiteral                    // string, template or regexp literal
+	tokWord                       // identifier, keyword or number
+	tokPunct                      // one punctuation character, ++ or --
+)
+
+// nextJSToken returns the kind and end of the token starting at src[i];
+// regexpOK tells whether a slash there starts a regexp literal.
+func nextJSToken(src string, i int, regexpOK bool) (jsTokenKind, int) {
+	c, j := src[i], i+1
+	switch {
+	case isJSBlank(c):
+		for j < len(src) && isJSBlank(src[j]) {
+			j++
+		}
+		return tokSpace, j
+	case c == '/' && j < len(src) && src[j] == '/', c == '#' && i == 0 && strings.HasPrefix(src, "#!"):
+		if e := strings.IndexAny(src[i:], "\r\n"); e >= 0 {
+			return tokComment, i + e
+		}
+		return tokComment, len(src)
+	case c == '/' && j < len(src) && src[j] == '*':
+		if e := strings.Index(src[i+2:], "*/"); e >= 0 {
+			return tokComment, i + 2 + e + 2
+		}
+		return tokComment, len(src)
+	case c == '"' || c == '\'':
+		return tokLiteral, skipQuoted(src, i)
+	case c == '`':
+		return tokLiteral, skipTemplate(src, i)
+	case c == '/' && regexpOK:
+		if e := skipRegexp(src, i); e > 0 {
+			return tokLiteral, e
+		}
+	case isJSWordByte(c):
+		for j < len(src) && isJSWordByte(src[j]) {
+			j++
+		}
+		return tokWord, j
+	case (c == '+' || c == '-') && j < len(src) && src[j] == c:
+		return tokPunct, j + 1
+	}
+	return tokPunct, j
+}
+
+// regexpAfter tells whether a slash after the token text of kind starts a
+// regexp literal; regexpOK is the answer before the token. A slash after a
+// closing brace is taken as a regexp, blocks being more common there than
+// object literals.
+func regexpAfter(kind jsTokenKind, text string, regexpOK bool) bool {
+	switch kind {
+	case tokSpace, tokComment:
+		return regexpOK
+	case tokWord:
+		return regexpKeywords[text]
+	case tokPunct:
+		return text != ")" && text != "]" && text != "++" && text != "--"
+	}
+	return false
+}
+
+func isJSBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// isJSWordByte accepts the bytes of identifiers and numbers, non-ASCII
+// characters included.
+func isJSWordByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '$' || c >= 0x80
+}
+
+// skipQuoted returns the end of the string literal at src[i]; an unterminated
+// string ends at the line break.
+func skipQuoted(src string, i int) int {
+	q := src[i]
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			if strings.HasPrefix(src[j+1:], "\r\n") {
+				j++
+			}
+			j++
+		case q:
+			return j + 1
+		case '\n', '\r':
+			return j
+		}
+	}
+	return len(src)
+}
+
+// skipTemplate returns the end of the template literal at src[i], its
+// ${} expressions included.
+func skipTemplate(src string, i int) int {
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '`':
+			return j + 1
+		case '$':
+			if j+1 < len(src) && src[j+1] == '{' {
+				j = skipBraces(src, j+1) - 1
+			}
+		}
+	}
+	return len(src)
+}
+
+// skipBraces returns the end of the braces opening at src[i], the literals
+// and comments inside them skipped.
+func skipBraces(src string, i int) int {
+	depth, regexpOK := 0, true
+	for i < len(src) {
+		kind, end := nextJSToken(src, i, regexpOK)
+		text := src[i:end]
+		if kind == tokPunct && text == "{" {
+			depth++
+		} else if kind == tokPunct && text == "}" {
+			if depth--; depth == 0 {
+				return end
+			}
+		}
+		regexpOK = regexpAfter(kind, text, regexpOK)
+		i = end
+	}
+	return len(src)
+}
+
+// skipRegexp returns the end of the regexp literal at src[i], flags
+// included, or 0 when the line ends first.
+func skipRegexp(src string, i int) int {
+	class := false
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '\n', '\r':
+			return 0
+		case '[':
+			class = true
+		case ']':
+			class = false
+		case '/':
+			if !class {
+				for j++; j < len(src) && isJSWordByte(src[j]); j++ {
+				}
+				return j
+			}
+		}
+	}
+	return 0
+}
+
+// objectBrace tells whether a brace after the token prev opens an object
+// literal rather than a block.
+func objectBrace(prev jsToken) bool {
+	switch prev.kind {
+	case tokWord:
+		return regexpKeywords[prev.text] && prev.text != "else" && prev.text != "do"
+	case tokPunct:
+		return !strings.Contains(");{}>", prev.text)
+	}
+	return false
+}
+
+type jsToken struct {
+	kind jsTokenKind
+	text string
+}
+
+func jsTokens(src string) []jsToken {
+	var out []jsToken
+	regexpOK := true
+	for i := 0; i < len(src); {
+		kind, end := nextJSToken(src, i, regexpOK)
+		out = append(out, jsToken{kind, src[i:end]})
+		regexpOK = regexpAfter(kind, src[i:end], regexpOK)
+		i = end
+	}
+	return out
+}
+
+// beautifyJS formats minified JavaScript or TypeScript for -beautify. Lines
+// longer than width are broken after braces, statements and the members of
+// object literals, and indented from the line's own indentation; other lines
+// are kept, trailing blanks and runs of blank lines aside.
+// Literals and comments are copied as is, so code is never changed, only the
+// blanks between tokens.
+func beautifyJS(src string, width int) string {
+	nl := "\n"
+	if strings.Contains(src, "\r\n") {
+		nl = "\r\n"
+	}
+	type opener struct {
+		c      byte
+		object bool // an object literal brace
+		broken bool // a line break follows it
+	}
+	var (
+		out     = make([]byte, 0, len(src)+len(src)/8)
+		stack   []opener
+		indent  string // blanks starting the current line of src
+		long    bool   // the current line of src is split
+		pending bool   // a line break is due before the next token
+		prev    jsToken
+		pos     int
+	)
+	startLine := func(at int) {
+		end := strings.IndexByte(src[at:], '\n')
+		if end < 0 {
+			end = len(src) - at
+		}
+		line := strings.TrimRight(src[at:at+end], "\r")
+		long = len(line) > width
+		indent = line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		for k := range stack {
+			stack[k].broken = false
+		}
+	}
+	breakLine := func() {
+		out = append(bytes.TrimRight(out, " \t"), nl...)
+		out = append(out, indent...)
+		for _, o := range stack {
+			if o.broken {
+				out = append(out, beautifyIndent...)
+			}
+		}
+	}
+	next := func(toks []jsToken) jsToken {
+		for _, t := range toks {
+			if t.kind != tokSpace {
+				return t
+			}
+		}
+		return jsToken{}
+	}
+	top := func() byte {
+		if len(stack) == 0 {
+			return 0
+		}
+		return stack[len(stack)-1].c
+	}
+
+	toks := jsTokens(src)
+	startLine(0)
+	for k, t := range toks {
+		if t.kind == tokSpace {
+			switch {
+			case strings.Contains(t.text, "\n"):
+				// a line break of src: kept, with one blank line at most
+				pending = false
+				out = bytes.TrimRight(out, " \t")
+				for range min(strings.Count(t.text, "\n"), 2) {
+					out = append(out, nl...)
+				}
+			case pending:
+			case long && pos > 0: // the first line keeps its indentation too
+				out = append(out, ' ')
+			default:
+				out = append(out, t.text...)
+			}
+		} else {
+			closer := t.kind == tokPunct && (t.text == "}" || t.text == ")" || t.text == "]")
+			if closer && len(stack) > 0 {
+				o := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				pending = pending || o.broken
+			}
+			if pending {
+				breakLine()
+				pending = false
+			}
+			if long && len(out) > 0 {
+				last := out[len(out)-1]
+				switch {
+				case t.kind == tokPunct && t.text == "{" && (last == ')' || last == '>' || isJSWordByte(last)),
+					t.kind == tokWord && braceContinuations[t.text] && last == '}':
+					out = append(out, ' ')
+				}
+			}
+			out = append(out, t.text...)
+			if t.kind == tokPunct {
+				switch t.text {
+				case "{", "(", "[":
+					o := opener{c: t.text[0], object: t.text == "{" && objectBrace(prev)}
+					if t.text == "{" && long && next(toks[k+1:]).text != "}" {
+						o.broken, pending = true, true
+					}
+					stack = append(stack, o)
+				case "}":
+					n := next(toks[k+1:])
+					pending = long && n.kind == tokWord && !braceContinuations[n.text]
+				case ";":
+					pending = long && top() != '(' && top() != '['
+				case ",":
+					pending = long && len(stack) > 0 && stack[len(stack)-1].object && stack[len(stack)-1].broken
+				}
+			}
+			if t.kind != tokComment {
+				prev = t
+			}
+		}
+		pos += len(t.text)
+		if i := strings.LastIndexByte(t.text, '\n'); i >= 0 {
+			startLine(pos - len(t.text) + i + 1)
+			if t.kind == tokSpace {
+				out = append(out, t.text[i+1:]...)
+			}
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBeautifyJS(t *testing.T) {
+	tests := []struct {
+		name, src string
+		width     int
+		want      string
+	}{
+		{
+			name:  "statements and blocks",
+			src:   `function f(a,b){if(a){return b}else{return "x { y"}}`,
+			width: 20,
+			want:  "function f(a,b) {\n  if(a) {\n    return b\n  } else {\n    return \"x { y\"\n  }\n}",
+		},
+		{
+			name:  "object literal members",
+			src:   "var o={a:1,b:[1,2,3],c:function(){return/re}x/g.test(s)}};",
+			width: 20,
+			want:  "var o={\n  a:1,\n  b:[1,2,3],\n  c:function() {\n    return/re}x/g.test(s)\n  }\n};",
+		},
+		{
+			name:  "division is not a regexp",
+			src:   "var r=a/2,o={p:1,q:2}/1;",
+			width: 12,
+			want:  "var r=a/2,o={\n  p:1,\n  q:2\n}/1;",
+		},
+		{
+			name:  "literals and comments",
+			src:   "x={a:'}',b:/[}]/};/* { */f({c:`${d+{e:1}.e}`});// g { h",
+			width: 12,
+			want:  "x={\n  a:'}',\n  b:/[}]/\n};\n/* { */f({\n  c:`${d+{e:1}.e}`\n});\n// g { h",
+		},
+		{
+			name:  "indentation and blank lines",
+			src:   "  if(a){b();c()}\n\n\n\nd()   \n",
+			width: 12,
+			want:  "  if(a) {\n    b();\n    c()\n  }\n\nd()\n",
+		},
+		{
+			name:  "short lines kept",
+			src:   "if(a){b()}\r\nelse{c()}\r\n",
+			width: 12,
+			want:  "if(a){b()}\r\nelse{c()}\r\n",
+		},
+		{
+			name:  "line endings kept",
+			src:   "if(a){b()}\r\n",
+			width: 4,
+			want:  "if(a) {\r\n  b()\r\n}\r\n",
+		},
+	}
+	blanks := strings.NewReplacer(" ", "", "\t", "", "\r", "", "\n", "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := beautifyJS(tt.src, tt.width)
+			if got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+			// only blanks between tokens change
+			if blanks.Replace(got) != blanks.Replace(tt.src) {
+				t.Errorf("tokens changed: %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// isBlobURL reports whether -out names an object store instead of a directory.
+func isBlobURL(s string) bool {
+	for _, p := range []string{"s3://", "gs://", "az://"} {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// BlobSink uploads every file as one object, with no local staging:
+//
+//	s3://bucket/prefix        AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN,
+//	                          AWS_REGION (default us-east-1), AWS_ENDPOINT_URL for S3-compatible stores
+//	gs://bucket/prefix        GOOGLE_OAUTH_ACCESS_TOKEN (e.g. from "gcloud auth print-access-token")
+//	az://account/container/prefix  AZURE_STORAGE_SAS_TOKEN
+//
+// Objects are written with a single PUT (or upload request), so a path written
+// twice keeps the last content.
+type BlobSink struct {
+	client *http.Client
+	put    func(key string, content []byte) (*http.Request, error)
+	prefix string
+}
+
+// NewBlobSink returns a sink for an s3://, gs:// or az:// URL, reading the
+// credentials from the environment.
+func NewBlobSink(rawURL string) (*BlobSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	b := &BlobSink{client: &http.Client{Timeout: 5 * time.Minute}}
+	switch u.Scheme {
+	case "s3":
+		b.prefix = strings.Trim(u.Path, "/")
+		err = b.setupS3(u.Host)
+	case "gs":
+		b.prefix = strings.Trim(u.Path, "/")
+		err = b.setupGCS(u.Host)
+	case "az":
+		container, prefix, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+		if container == "" {
+			return nil, errors.New("az:// URL must be az://account/container[/prefix]")
+		}
+		b.prefix = prefix
+		err = b.setupAzure(u.Host, container)
+	default:
+		err = fmt.Errorf("unsupported object store %q (s3, gs, az)", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s: missing bucket", rawURL)
+	}
+	return b, nil
+}
+
+func (b *BlobSink) WriteFile(p string, content []byte, _ FileMeta) error {
+	key := path.Join(b.prefix, p)
+	req, err := b.put(key, content)
+	if err != nil {
+		return err
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("upload %s: %s: %s", key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (b *BlobSink) Close() error { return nil }
+
+func (b *BlobSink) setupS3(bucket string) error {
+	keyID, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if keyID == "" || secret == "" {
+		return errors.New("s3: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	token := os.Getenv("AWS_SESSION_TOKEN")
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	b.put = func(key string, content []byte) (*http.Request, error) {
+		// virtual-hosted style on AWS, path style on S3-compatible endpoints (MinIO...)
+		u := "https://" + bucket + ".s3." + region + ".amazonaws.com/" + s3Escape(key)
+		if endpoint != "" {
+			u = strings.TrimRight(endpoint, "/") + "/" + bucket + "/" + s3Escape(key)
+		}
+		req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		if token != "" {
+			req.Header.Set("X-Amz-Security-Token", token)
+		}
+		sum := sha256.Sum256(content)
+		signV4(req, hex.EncodeToString(sum[:]), keyID, secret, region, "s3", time.Now())
+		return req, nil
+	}
+	return nil
+}
+
+func (b *BlobSink) setupGCS(bucket string) error {
+	token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	if token == "" {
+		return errors.New("gs: GOOGLE_OAUTH_ACCESS_TOKEN must be set")
+	}
+	b.put = func(key string, content []byte) (*http.Request, error) {
+		u := "https://storage.googleapis.com/upload/storage/v1/b/" + url.PathEscape(bucket) +
+			"/o?uploadType=media&name=" + url.QueryEscape(key)
+		req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req, nil
+	}
+	return nil
+}
+
+func (b *BlobSink) setupAzure(account, container string) error {
+	sas := strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?")
+	if sas == "" {
+		return errors.New("az: AZURE_STORAGE_SAS_TOKEN must be set")
+	}
+	b.put = func(key string, content []byte) (*http.Request, error) {
+		u := "https://" + account + ".blob.core.windows.net/" + container + "/" + s3Escape(key) + "?" + sas
+		req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+		req.Header.Set("X-Ms-Version", "2021-08-06")
+		return req, nil
+	}
+	return nil
+}
+
+// s3Escape percent-encodes an object key, keeping "/" (RFC 3986 unreserved only).
+func s3Escape(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header covering the
+// host and every header already set on req.
+func signV4(req *http.Request, payloadHash, keyID, secret, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canon strings.Builder
+	for _, k := range names {
+		canon.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	creq := strings.Join([]string{req.Method, uri, req.URL.RawQuery, canon.String(), signed, payloadHash}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	h := sha256.Sum256([]byte(creq))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(h[:])
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+keyID+"/"+scope+", SignedHeaders="+signed+", Signature="+sig)
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errBudgetExhausted = errors.New("host budget exhausted")
+	errOutOfScope      = errors.New("host out of scope")
+)
+
+// matchHost reports whether host equals pattern, or is a subdomain of a "*.domain" pattern.
+func matchHost(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
+
+// hostList is a comma separated, repeatable list of host patterns (-scope).
+type hostList []string
+
+func (h *hostList) String() string { return strings.Join(*h, ",") }
+
+func (h *hostList) reset() { *h = nil }
+
+func (h *hostList) Set(v string) error {
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			*h = append(*h, p)
+		}
+	}
+	return nil
+}
+
+func (h hostList) match(host string) bool {
+	for _, p := range h {
+		if matchHost(p, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostLimiter enforces the per-host budgets from the config file.
+type hostLimiter struct {
+	budgets map[string]hostBudget
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	budget   hostBudget
+	sem      chan struct{}
+	mu       sync.Mutex
+	bytes    int64
+	requests int
+	next     time.Time // earliest start of the next request
+}
+
+func newHostLimiter(budgets map[string]hostBudget) *hostLimiter {
+	return &hostLimiter{budgets: budgets, hosts: make(map[string]*hostState)}
+}
+
+// budgetFor returns the exact host entry, else the longest matching "*.domain" entry.
+func (l *hostLimiter) budgetFor(host string) (hostBudget, bool) {
+	host = strings.ToLower(host)
+	if b, ok := l.budgets[host]; ok {
+		return b, true
+	}
+	best, found := "", false
+	for pat := range l.budgets {
+		if strings.HasPrefix(pat, "*.") && matchHost(pat, host) && len(pat) > len(best) {
+			best, found = pat, true
+		}
+	}
+	return l.budgets[best], found
+}
+
+func (l *hostLimiter) state(host string) *hostState {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if st, ok := l.hosts[host]; ok {
+		return st
+	}
+	b, ok := l.budgetFor(host)
+	if !ok {
+		l.hosts[host] = nil
+		return nil
+	}
+	st := &hostState{budget: b}
+	if b.Concurrency > 0 {
+		st.sem = make(chan struct{}, b.Concurrency)
+	}
+	l.hosts[host] = st
+	return st
+}
+
+// acquire waits for a request slot on host and returns its release function and
+// the number of bytes still allowed (-1 when unlimited).
+func (l *hostLimiter) acquire(host string) (func(int64), int64, error) {
+	st := l.state(host)
+	if st == nil {
+		return func(int64) {}, -1, nil
+	}
+	if st.sem != nil {
+		st.sem <- struct{}{}
+	}
+	st.mu.Lock()
+	if (st.budget.MaxBytes > 0 && st.bytes >= int64(st.budget.MaxBytes)) ||
+		(st.budget.MaxRequests > 0 && st.requests >= st.budget.MaxRequests) {
+		st.mu.Unlock()
+		if st.sem != nil {
+			<-st.sem
+		}
+		return nil, 0, errBudgetExhausted
+	}
+	st.requests++
+	wait := time.Until(st.next)
+	if st.budget.Delay > 0 {
+		start := time.Now()
+		if wait > 0 {
+			start = st.next
+		}
+		st.next = start.Add(time.Duration(st.budget.Delay))
+	}
+	remaining := int64(-1)
+	if st.budget.MaxBytes > 0 {
+		remaining = int64(st.budget.MaxBytes) - st.bytes
+	}
+	st.mu.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	release := func(n int64) {
+		st.mu.Lock()
+		st.bytes += n
+		st.mu.Unlock()
+		if st.sem != nil {
+			<-st.sem
+		}
+	}
+	return release, remaining, nil
+}
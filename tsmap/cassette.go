@@ -0,0 +1,188 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cassette is a transport recording every HTTP exchange of a run below a
+// directory (-record), or answering from such a recording without touching
+// the network (-replay). Exchanges are told apart by method, URL and the
+// Authorization and Cookie headers, so that a replay may change the other
+// flags. Conditional headers are dropped: recordings hold whole bodies, up to
+// what the crawler reads under its per-host max-bytes budget.
+type cassette struct {
+	dir    string
+	replay bool
+	next   http.RoundTripper // the network, when recording
+}
+
+// cassetteEntry is the metadata stored next to a recorded body.
+type cassetteEntry struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Status     int         `json:"status,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Error      string      `json:"error,omitempty"`     // the request failed, no body
+	Truncated  bool        `json:"truncated,omitempty"` // body cut at the byte budget of the recording run
+	RecordedAt time.Time   `json:"recorded_at"`
+}
+
+var (
+	errNotRecorded        = errors.New("not in the recording")
+	errTruncatedRecording = errors.New("recorded body truncated at the byte budget")
+)
+
+type bodyLimitKey struct{}
+
+// withBodyLimit tells a recording cassette that no more than n bytes of the
+// body of the request made with ctx will be read, so that no more are kept.
+func withBodyLimit(ctx context.Context, n int64) context.Context {
+	return context.WithValue(ctx, bodyLimitKey{}, n)
+}
+
+func (c *cassette) paths(req *http.Request) (meta, body string) {
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.String()))
+	for _, k := range []string{"Authorization", "Cookie"} {
+		h.Write([]byte("\n" + k + ": " + req.Header.Get(k)))
+	}
+	key := hex.EncodeToString(h.Sum(nil))
+	base := filepath.Join(c.dir, key[:2], key)
+	return base + ".json", base + ".body"
+}
+
+func (c *cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	metaPath, bodyPath := c.paths(req)
+	if c.replay {
+		return c.play(req, metaPath, bodyPath)
+	}
+	resp, err := c.next.RoundTrip(req)
+	e := cassetteEntry{Method: req.Method, URL: req.URL.String(), RecordedAt: time.Now().UTC()}
+	if err != nil {
+		e.Error = err.Error()
+		c.save(e, metaPath, bodyPath, nil)
+		return nil, err
+	}
+	e.Status, e.Header = resp.StatusCode, resp.Header
+	var body io.Reader = resp.Body
+	if limit, ok := req.Context().Value(bodyLimitKey{}).(int64); ok {
+		body = &cappedReader{r: resp.Body, n: limit}
+	}
+	err = c.save(e, metaPath, bodyPath, body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("record %s: %w", req.URL, err)
+	}
+	return c.play(req, metaPath, bodyPath)
+}
+
+// save writes a recorded exchange, body first so that a meta file always
+// points to a complete body, or one marked truncated.
+func (c *cassette) save(e cassetteEntry, metaPath, bodyPath string, body io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(metaPath), 0755); err != nil {
+		return err
+	}
+	if body != nil {
+		if err := writeAtomicFrom(bodyPath, body, false); err != nil {
+			return err
+		}
+	}
+	if cr, ok := body.(*cappedReader); ok {
+		e.Truncated = cr.truncated
+	}
+	data, _ := json.Marshal(e)
+	return writeAtomic(metaPath, data, false)
+}
+
+// play answers req from the recording.
+func (c *cassette) play(req *http.Request, metaPath, bodyPath string) (*http.Response, error) {
+	data, err := os.ReadFile(metaPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w %s", errNotRecorded, c.dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e cassetteEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("%s: %w", metaPath, err)
+	}
+	if e.Error != "" {
+		return nil, fmt.Errorf("recorded: %s", e.Error)
+	}
+	f, err := os.Open(bodyPath)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var body io.ReadCloser = f
+	size := fi.Size()
+	if e.Truncated {
+		body, size = truncatedBody{f}, -1
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header,
+		Body:          body,
+		ContentLength: size,
+		Request:       req,
+	}, nil
+}
+
+// cappedReader reads at most n bytes of r and tells whether r had more.
+type cappedReader struct {
+	r         io.Reader
+	n         int64
+	truncated bool
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		var b [1]byte
+		if k, _ := io.ReadFull(c.r, b[:]); k > 0 {
+			c.truncated = true
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	k, err := c.r.Read(p)
+	c.n -= int64(k)
+	return k, err
+}
+
+// truncatedBody replays a body recorded truncated: reading past its end
+// fails, so that a replay with a larger budget does not take it as whole.
+type truncatedBody struct{ *os.File }
+
+func (b truncatedBody) Read(p []byte) (int, error) {
+	n, err := b.File.Read(p)
+	if err == io.EOF {
+		err = errTruncatedRecording
+	}
+	return n, err
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// bodyEncoding returns the encoding of a response body that is not UTF-8: the
+// one a UTF-16 BOM at the start of head says, else the charset of the
+// Content-Type. It returns nil for UTF-8, unknown charsets and no charset.
+func bodyEncoding(contentType string, head []byte) (encoding.Encoding, string) {
+	if bytes.HasPrefix(head, []byte("\xff\xfe")) || bytes.HasPrefix(head, []byte("\xfe\xff")) {
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM), "utf-16"
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil || params["charset"] == "" {
+		return nil, ""
+	}
+	enc, name := charset.Lookup(params["charset"])
+	if enc == nil || name == "utf-8" {
+		return nil, ""
+	}
+	return enc, name
+}
+
+// decodeText converts the body to UTF-8 when it is in another encoding (see
+// bodyEncoding), so that scripts are scanned and maps parsed as text rather
+// than as mojibake.
+func (r *fetchResult) decodeText() error {
+	head := make([]byte, 2)
+	n, _ := io.ReadFull(r.reader(), head)
+	enc, name := bodyEncoding(r.ContentType, head[:n])
+	if enc == nil {
+		return nil
+	}
+	logger.Debug(msgCharsetDecoded.String(), "charset", name, "content_type", r.ContentType)
+	dec := enc.NewDecoder().Reader(r.reader())
+	if r.Spool != nil {
+		old := r.Spool
+		var err error
+		r.Body, r.Spool, err = readSpooled(dec)
+		old.close()
+		return err
+	}
+	body, err := io.ReadAll(dec)
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	return nil
+}
+
+// decodeLatin1 converts data from Windows-1252, the common superset of
+// latin-1, when it is not UTF-8: some invalid sequence and no valid one beyond
+// ASCII, so that UTF-8 with a damaged character is left alone.
+func decodeLatin1(data []byte) []byte {
+	if utf8.Valid(data) {
+		return data
+	}
+	for i := 0; i < len(data); {
+		if data[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		if r != utf8.RuneError {
+			return data
+		}
+		i += size
+	}
+	out, err := charmap.Windows1252.NewDecoder().Bytes(data)
+	if err != nil {
+		return data
+	}
+	return out
+}
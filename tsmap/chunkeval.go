@@ -0,0 +1,261 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// evalTimeout bounds the evaluation of the chunk filename functions of one
+// script, all chunk ids included.
+const evalTimeout = 2 * time.Second
+
+const (
+	maxChunkFuncSize = 1 << 20 // longer sources are not taken for a chunk filename function
+	maxChunkIDs      = 20000
+)
+
+var (
+	// webpack 5: __webpack_require__.u = function(e){...} or e => ...
+	reChunkFuncU = regexp.MustCompile(`[\w$]+\.u\s*=\s*(?:function\s*\(\s*[\w$]+\s*\)|\(?\s*[\w$]+\s*\)?\s*=>)`)
+	// webpack 4: function jsonpScriptSrc(e){return __webpack_require__.p+...}
+	reChunkFuncSrc = regexp.MustCompile(`function\s+[\w$]+\s*\(\s*[\w$]+\s*\)\s*\{\s*return\s+[\w$]+\.p\s*\+`)
+	reChunkKey     = regexp.MustCompile(`[{,]\s*(?:"([^"]+)"|'([^']+)'|([\w$]+))\s*:`)
+	reChunkCompare = regexp.MustCompile(`(?:(\d+)|"([^"]+)")\s*===?\s*[\w$]+|[\w$]+\s*===?\s*(?:(\d+)|"([^"]+)")`)
+	reNotDefined   = regexp.MustCompile(`^ReferenceError: ([\w$]+) is not defined`)
+
+	errTooManyStubs = errors.New("too many undefined variables")
+)
+
+// evalChunkDetector runs the chunk filename function of a webpack runtime in
+// a goja interpreter for every chunk id the function mentions (-eval-chunks).
+// It finds the chunks of runtimes building names with helpers and nested
+// ternaries, which returnPatternDetector cannot read. The interpreter has no
+// I/O; free variables are stubs whose p (the public path) is "".
+type evalChunkDetector struct{}
+
+func (evalChunkDetector) Name() string { return "eval" }
+
+func (evalChunkDetector) DetectChunks(js string, scriptURL, rootURL *url.URL) []*url.URL {
+	funcs := chunkFuncSources(js)
+	if len(funcs) == 0 {
+		return nil
+	}
+	base := rootURL
+	if pp := webpackPublicPath(js, scriptURL, rootURL); pp != nil {
+		base = pp
+	}
+	seen := make(map[string]bool)
+	var out []*url.URL
+	for _, src := range funcs {
+		names, err := evalChunkNames(src, chunkIDs(src))
+		if err != nil {
+			logger.Debug(msgChunkEvalFailed.String(), "script", scriptURL.String(), "err", err)
+		}
+		for _, name := range names {
+			u, err := base.Parse(name)
+			if err != nil || seen[u.String()] {
+				continue
+			}
+			seen[u.String()] = true
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// chunkFuncSources returns the sources of the functions of js that look like
+// chunk filename functions, as expressions.
+func chunkFuncSources(js string) []string {
+	var out []string
+	for _, re := range []*regexp.Regexp{reChunkFuncU, reChunkFuncSrc} {
+		for _, m := range re.FindAllStringIndex(js, -1) {
+			start := m[0]
+			if re == reChunkFuncU {
+				start += strings.IndexByte(js[m[0]:m[1]], '=') + 1
+			}
+			end := jsFunctionEnd(js, start)
+			if end < 0 {
+				continue
+			}
+			out = append(out, "("+strings.TrimSpace(js[start:end])+")")
+		}
+	}
+	return out
+}
+
+// jsFunctionEnd returns the end of the function starting at js[i:]: the brace
+// closing its body, or for an arrow function with an expression body, the
+// first ',' ';' or unmatched closing bracket. It returns -1 when the end is
+// not found within maxChunkFuncSize.
+func jsFunctionEnd(js string, i int) int {
+	limit := min(len(js), i+maxChunkFuncSize)
+	j := i
+	for j < limit && js[j] != '{' && !strings.HasPrefix(js[j:], "=>") {
+		j++
+	}
+	if strings.HasPrefix(js[j:], "=>") {
+		j += 2
+		for j < limit && (js[j] == ' ' || js[j] == '\t' || js[j] == '\n' || js[j] == '\r') {
+			j++
+		}
+	}
+	if j >= limit {
+		return -1
+	}
+	blockBody := js[j] == '{'
+	depth := 0
+	for ; j < limit; j++ {
+		switch c := js[j]; c {
+		case '"', '\'', '`':
+			end := jsStringEnd(js[:limit], j)
+			if end < 0 {
+				return -1
+			}
+			j = end - 1
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth == 0 {
+				return j
+			}
+			depth--
+			if depth == 0 && blockBody {
+				return j + 1
+			}
+		case ',', ';':
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// jsStringEnd returns the index after the string literal starting at js[i],
+// or -1. Template literals are taken whole, without looking into ${}.
+func jsStringEnd(js string, i int) int {
+	quote := js[i]
+	for j := i + 1; j < len(js); j++ {
+		switch js[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1
+		case '\n':
+			if quote != '`' {
+				return -1
+			}
+		}
+	}
+	return -1
+}
+
+// chunkIDs returns the chunk ids mentioned by a chunk filename function: the
+// keys of its object literals (name and hash maps) and the values compared to
+// a variable in its ternaries.
+func chunkIDs(src string) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	add := func(groups ...string) {
+		for _, id := range groups {
+			if id != "" && !seen[id] && len(ids) < maxChunkIDs {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	for _, m := range reChunkKey.FindAllStringSubmatch(src, -1) {
+		add(m[1:]...)
+	}
+	for _, m := range reChunkCompare.FindAllStringSubmatch(src, -1) {
+		add(m[1:]...)
+	}
+	return ids
+}
+
+// evalChunkNames calls the function src with every id, numeric ids as
+// numbers, and returns the script names it gives. An id whose call fails is
+// skipped; the error returned is the last failure.
+func evalChunkNames(src string, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	vm := goja.New()
+	timer := time.AfterFunc(evalTimeout, func() { vm.Interrupt("timeout") })
+	defer timer.Stop()
+	v, err := vm.RunString(src)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := goja.AssertFunction(v)
+	if !ok {
+		return nil, nil
+	}
+	var names []string
+	var lastErr error
+	for _, id := range ids {
+		var arg goja.Value = vm.ToValue(id)
+		if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+			arg = vm.ToValue(n)
+		}
+		res, err := callWithStubs(vm, fn, arg)
+		if err != nil {
+			if _, ok := err.(*goja.InterruptedError); ok {
+				return names, err
+			}
+			lastErr = err
+			continue
+		}
+		if name, ok := res.Export().(string); ok && isChunkName(name) {
+			names = append(names, name)
+		}
+	}
+	return names, lastErr
+}
+
+// callWithStubs calls fn, defining a stub for each variable reported as not
+// defined and calling again, up to a few times.
+func callWithStubs(vm *goja.Runtime, fn goja.Callable, arg goja.Value) (goja.Value, error) {
+	for range 8 {
+		res, err := fn(goja.Undefined(), arg)
+		if err == nil {
+			return res, nil
+		}
+		m := reNotDefined.FindStringSubmatch(err.Error())
+		if m == nil {
+			return nil, err
+		}
+		stub := vm.NewObject()
+		_ = stub.Set("p", "")
+		if err := vm.Set(m[1], stub); err != nil {
+			return nil, err
+		}
+	}
+	return nil, errTooManyStubs
+}
+
+// isChunkName reports whether a function result names a script.
+func isChunkName(name string) bool {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.Contains(name, "undefined") || strings.ContainsAny(name, " \t\n") {
+		return false
+	}
+	p := name
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+	switch path.Ext(p) {
+	case ".js", ".mjs", ".cjs":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"flag"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const commentsFile = "comments.json"
+
+// commentTextMax caps the text kept for one comment.
+const commentTextMax = 500
+
+var (
+	reCommentTodo   = regexp.MustCompile(`\b(?:TODO|FIXME|HACK|XXX|BUG|WORKAROUND|TEMP)\b`)
+	reCommentCreds  = regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|token|api[ _-]?key|credentials?|username|login)\b`)
+	reCommentURL    = regexp.MustCompile(`https?://[^\s'"<>)\]]+`)
+	reCommentTicket = regexp.MustCompile(`\b([A-Z][A-Z0-9]{1,9})-[0-9]{1,6}\b`)
+)
+
+// upper-case prefixes that look like ticket keys but are standards
+var ticketNoise = map[string]bool{
+	"UTF": true, "ISO": true, "SHA": true, "RFC": true, "ES": true, "ECMA": true, "AES": true, "TLS": true,
+	"HTTP": true, "CVE": true, "IE": true, "MD": true, "GPL": true, "LGPL": true, "BSD": true, "RGB": true,
+}
+
+// internal host suffixes and names for -comments internal URLs
+var internalHostParts = []string{".local", ".internal", ".corp", ".lan", ".intranet", ".intra", "localhost"}
+
+// commentFinding is one interesting comment of comments.json.
+type commentFinding struct {
+	Path    string   `json:"path"` // relative to the output directory, slash separated
+	Line    int      `json:"line"`
+	Kinds   []string `json:"kinds"` // todo, credentials, internal-url, ticket
+	Text    string   `json:"text"`
+	URLs    []string `json:"urls,omitempty"`
+	Tickets []string `json:"tickets,omitempty"`
+}
+
+// commentScanner keeps the developer comments of recovered first-party
+// sources worth reading (-comments); crawl workers call scan concurrently.
+// A nil scanner does nothing.
+type commentScanner struct {
+	enabled bool
+
+	mu       sync.Mutex
+	findings []commentFinding
+}
+
+func addCommentFlags(fs *flag.FlagSet) *commentScanner {
+	s := &commentScanner{}
+	fs.BoolVar(&s.enabled, "comments", false, "Report comments with TODO/FIXME/HACK, credential hints, internal URLs and ticket IDs in comments.json")
+	return s
+}
+
+// jsComment is one // or /* */ comment and the line it starts at.
+type jsComment struct {
+	line int
+	text string
+}
+
+// jsComments returns the comments of a JavaScript or TypeScript source,
+// skipping string and template literals. Regexp literals are not recognized,
+// so a "//" inside one may start a false comment.
+func jsComments(src string) []jsComment {
+	var out []jsComment
+	line := 1
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '\n':
+			line++
+		case c == '"' || c == '\'' || c == '`':
+			for i++; i < len(src) && src[i] != c; i++ {
+				if src[i] == '\\' {
+					i++
+				} else if src[i] == '\n' {
+					line++
+					if c != '`' {
+						break // unterminated string
+					}
+				}
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			out = append(out, jsComment{line: line, text: src[i+2 : i+end]})
+			i += end - 1
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				end = len(src) - i - 2
+			}
+			text := src[i+2 : i+2+end]
+			out = append(out, jsComment{line: line, text: text})
+			line += strings.Count(text, "\n")
+			i += end + 3
+		}
+	}
+	return out
+}
+
+// isInternalURL tells URLs of private addresses and intranet-looking hosts.
+func isInternalURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback() || ip.IsPrivate()
+	}
+	for _, p := range internalHostParts {
+		if strings.HasSuffix(host, p) || host == strings.TrimPrefix(p, ".") {
+			return true
+		}
+	}
+	for _, label := range strings.Split(host, ".")[:strings.Count(host, ".")] {
+		switch label {
+		case "dev", "staging", "stage", "preprod", "uat", "qa", "test", "internal", "intranet", "admin", "jenkins", "gitlab", "jira", "confluence":
+			return true
+		}
+	}
+	return false
+}
+
+// scan records the interesting comments of one written file; vendor code is skipped.
+func (s *commentScanner) scan(rel string, data []byte, meta FileMeta) {
+	if s == nil || !s.enabled || isVendorSource(filterPath(rel)) || (meta.Source != "" && isVendorSource(filterPath(meta.Source))) {
+		return
+	}
+	var found []commentFinding
+	for _, c := range jsComments(string(data)) {
+		text := strings.TrimSpace(c.text)
+		f := commentFinding{Path: rel, Line: c.line}
+		if reCommentTodo.MatchString(text) {
+			f.Kinds = append(f.Kinds, "todo")
+		}
+		if reCommentCreds.MatchString(text) {
+			f.Kinds = append(f.Kinds, "credentials")
+		}
+		for _, u := range reCommentURL.FindAllString(text, -1) {
+			if isInternalURL(u) {
+				f.URLs = addUnique(f.URLs, u)
+			}
+		}
+		if f.URLs != nil {
+			f.Kinds = append(f.Kinds, "internal-url")
+		}
+		for _, m := range reCommentTicket.FindAllStringSubmatch(text, -1) {
+			if !ticketNoise[m[1]] {
+				f.Tickets = addUnique(f.Tickets, m[0])
+			}
+		}
+		if f.Tickets != nil {
+			f.Kinds = append(f.Kinds, "ticket")
+		}
+		if f.Kinds == nil {
+			continue
+		}
+		if len(text) > commentTextMax {
+			text = text[:commentTextMax] + "..."
+		}
+		f.Text = text
+		found = append(found, f)
+	}
+	s.mu.Lock()
+	s.findings = append(s.findings, found...)
+	s.mu.Unlock()
+}
+
+// save writes comments.json and logs the comments per kind.
+func (s *commentScanner) save(o *outputOptions) {
+	if s == nil || !s.enabled {
+		return
+	}
+	s.mu.Lock()
+	list := append([]commentFinding{}, s.findings...)
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+		return list[i].Line < list[j].Line
+	})
+	byKind := make(map[string]int)
+	for _, f := range list {
+		for _, k := range f.Kinds {
+			byKind[k]++
+		}
+	}
+	args := []any{"comments", len(list)}
+	for _, k := range sortedKeys(byKind) {
+		args = append(args, k, byKind[k])
+	}
+	logger.Info(msgCommentSummary.String(), args...)
+	if o.dryRun {
+		return
+	}
+	data, _ := json.MarshalIndent(list, "", "  ")
+	if err := o.writeMeta(commentsFile, data); err != nil {
+		logger.Warn(msgCommentsError.String(), "err", err)
+	}
+}
@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command describes a subcommand for completion; flags is nil when it takes none.
+type command struct {
+	name  string
+	desc  string
+	flags func() *flag.FlagSet
+}
+
+var commands = []command{
+	{"extract", "Extract sources from a .map file", func() *flag.FlagSet { fs, _ := newExtractFlags(); return fs }},
+	{"crawl", "Crawl a page, find JS and extract .map sources", func() *flag.FlagSet { fs, _ := newCrawlFlags(); return fs }},
+	{"validate", "Check a .map file against the source map v3 format", func() *flag.FlagSet { fs, _ := newValidateFlags(); return fs }},
+	{"diff", "Compare two extraction outputs", func() *flag.FlagSet { fs, _ := newDiffFlags(); return fs }},
+	{"grep", "Search the recovered files of an output", func() *flag.FlagSet { fs, _ := newGrepFlags(); return fs }},
+	{"serve", "Browse an output in a local web UI", func() *flag.FlagSet { fs, _ := newServeFlags(); return fs }},
+	{"monitor", "Re-crawl a target periodically and alert on changes", func() *flag.FlagSet { fs, _ := newMonitorFlags(); return fs }},
+	{"server", "Run extractions and crawls as jobs behind an HTTP API", func() *flag.FlagSet { fs, _ := newServerFlags(); return fs }},
+	{"coordinate", "Shard a list of targets over several server workers", func() *flag.FlagSet { fs, _ := newCoordFlags(); return fs }},
+	{"selftest", "Crawl built-in fixture sites to check the setup", func() *flag.FlagSet { fs, _ := newSelftestFlags(); return fs }},
+	{"completion", "Print a shell completion script", nil},
+	{"help", "Show help", nil},
+}
+
+// flagValues lists the accepted values of enum flags.
+var flagValues = map[string][]string{
+	"eol":         {"unix", "dos"},
+	"log-format":  {"text", "json"},
+	"color":       {"auto", "always", "never"},
+	"layout":      {"per-map", "merged", "flat"},
+	"dedup":       {"off", "skip", "hardlink"},
+	"on-conflict": {"overwrite", "skip", "suffix", "newest"},
+	"report":      {"html", "md"},
+	"fail-on":     {"low", "medium", "high", "critical"},
+	"timestamps":  {"now", "source"},
+	"map-guess":   {"default", "aggressive"},
+}
+
+// fileFlags take a path as value.
+var fileFlags = map[string]bool{
+	"map": true, "map-dir": true, "js": true, "out": true, "config": true, "log-file": true,
+	"out-zip": true, "out-tar": true, "sign-key": true, "data": true, "targets": true,
+	"record": true, "replay": true, "resume": true, "secret-rules": true, "wordlist-out": true,
+}
+
+type flagInfo struct {
+	name   string
+	usage  string
+	isBool bool
+}
+
+func commandFlags(c command) []flagInfo {
+	if c.flags == nil {
+		return nil
+	}
+	var out []flagInfo
+	c.flags().VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		out = append(out, flagInfo{name: f.Name, usage: f.Usage, isBool: ok && b.IsBoolFlag()})
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out
+}
+
+func RunCompletion(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: tsmap-extract completion bash|zsh|fish|powershell")
+		return exitUsage
+	}
+	w := os.Stdout
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(w)
+	case "zsh":
+		writeZshCompletion(w)
+	case "fish":
+		writeFishCompletion(w)
+	case "powershell", "pwsh":
+		writePowershellCompletion(w)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown shell: %s (bash|zsh|fish|powershell)\n", args[0])
+		return exitUsage
+	}
+	return exitOK
+}
+
+func commandNames() []string {
+	names := make([]string, len(commands))
+	for i, c := range commands {
+		names[i] = c.name
+	}
+	return names
+}
+
+func writeBashCompletion(w io.Writer) {
+	fmt.Fprintln(w, "# bash completion for tsmap-extract; load with: source <(tsmap-extract completion bash)")
+	fmt.Fprintln(w, "_tsmap_extract() {")
+	fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" flags=""`)
+	fmt.Fprintln(w, `	if [ "$COMP_CWORD" -eq 1 ]; then`)
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(commandNames(), " "))
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, `	case "${COMP_WORDS[1]}" in`)
+	for _, c := range commands {
+		fl := commandFlags(c)
+		if c.name == "completion" {
+			fmt.Fprintln(w, "\tcompletion) COMPREPLY=($(compgen -W \"bash zsh fish powershell\" -- \"$cur\")); return ;;")
+			continue
+		}
+		if len(fl) == 0 {
+			continue
+		}
+		names := make([]string, len(fl))
+		for i, f := range fl {
+			names[i] = "-" + f.name
+		}
+		fmt.Fprintf(w, "\t%s) flags=%q ;;\n", c.name, strings.Join(names, " "))
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	case "$prev" in`)
+	for _, name := range sortedKeys(flagValues) {
+		fmt.Fprintf(w, "\t-%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", name, strings.Join(flagValues[name], " "))
+	}
+	var files []string
+	for _, name := range sortedKeys(fileFlags) {
+		files = append(files, "-"+name)
+	}
+	fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", strings.Join(files, "|"))
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	COMPREPLY=($(compgen -W "$flags" -- "$cur"))`)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -F _tsmap_extract tsmap-extract")
+}
+
+func writeZshCompletion(w io.Writer) {
+	esc := strings.NewReplacer("[", "\\[", "]", "\\]", "'", "'\\''")
+	fmt.Fprintln(w, "#compdef tsmap-extract")
+	fmt.Fprintln(w, "_tsmap_extract() {")
+	fmt.Fprintln(w, "\tlocal -a cmds")
+	fmt.Fprint(w, "\tcmds=(")
+	for _, c := range commands {
+		fmt.Fprintf(w, "'%s:%s' ", c.name, esc.Replace(c.desc))
+	}
+	fmt.Fprintln(w, ")")
+	fmt.Fprintln(w, "\tif (( CURRENT == 2 )); then")
+	fmt.Fprintln(w, "\t\t_describe 'command' cmds")
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tcase $words[2] in")
+	for _, c := range commands {
+		if c.name == "completion" {
+			fmt.Fprintln(w, "\tcompletion) _values 'shell' bash zsh fish powershell ;;")
+			continue
+		}
+		fl := commandFlags(c)
+		if len(fl) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\t%s)\n\t\t_arguments", c.name)
+		for _, f := range fl {
+			spec := fmt.Sprintf("-%s[%s]", f.name, esc.Replace(f.usage))
+			switch {
+			case f.isBool:
+			case flagValues[f.name] != nil:
+				spec += fmt.Sprintf(":%s:(%s)", f.name, strings.Join(flagValues[f.name], " "))
+			case fileFlags[f.name]:
+				spec += fmt.Sprintf(":%s:_files", f.name)
+			default:
+				spec += fmt.Sprintf(":%s:", f.name)
+			}
+			fmt.Fprintf(w, " \\\n\t\t\t'%s'", spec)
+		}
+		fmt.Fprintln(w, " ;;")
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, `_tsmap_extract "$@"`)
+}
+
+func writeFishCompletion(w io.Writer) {
+	esc := strings.NewReplacer("'", "\\'")
+	fmt.Fprintln(w, "# fish completion for tsmap-extract")
+	fmt.Fprintln(w, "complete -c tsmap-extract -f")
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c tsmap-extract -n '__fish_use_subcommand' -a %s -d '%s'\n", c.name, esc.Replace(c.desc))
+	}
+	fmt.Fprintln(w, "complete -c tsmap-extract -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish powershell'")
+	for _, c := range commands {
+		for _, f := range commandFlags(c) {
+			line := fmt.Sprintf("complete -c tsmap-extract -n '__fish_seen_subcommand_from %s' -o %s -d '%s'", c.name, f.name, esc.Replace(f.usage))
+			switch {
+			case f.isBool:
+			case flagValues[f.name] != nil:
+				line += fmt.Sprintf(" -x -a '%s'", strings.Join(flagValues[f.name], " "))
+			case fileFlags[f.name]:
+				line += " -r -F"
+			default:
+				line += " -x"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+func writePowershellCompletion(w io.Writer) {
+	fmt.Fprintln(w, "# PowerShell completion for tsmap-extract; add to $PROFILE:")
+	fmt.Fprintln(w, "#   tsmap-extract completion powershell | Out-String | Invoke-Expression")
+	fmt.Fprintln(w, "Register-ArgumentCompleter -Native -CommandName tsmap-extract -ScriptBlock {")
+	fmt.Fprintln(w, "\tparam($wordToComplete, $commandAst, $cursorPosition)")
+	fmt.Fprintf(w, "\t$commands = @(%s)\n", psList(commandNames()))
+	fmt.Fprintln(w, "\t$flags = @{")
+	for _, c := range commands {
+		fl := commandFlags(c)
+		if len(fl) == 0 {
+			continue
+		}
+		names := make([]string, len(fl))
+		for i, f := range fl {
+			names[i] = "-" + f.name
+		}
+		fmt.Fprintf(w, "\t\t'%s' = @(%s)\n", c.name, psList(names))
+	}
+	fmt.Fprintln(w, "\t\t'completion' = @('bash', 'zsh', 'fish', 'powershell')")
+	fmt.Fprintln(w, "\t}")
+	fmt.Fprintln(w, "\t$words = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })")
+	fmt.Fprintln(w, "\tif ($words.Count -lt 2 -or ($words.Count -eq 2 -and $wordToComplete -ne '')) {")
+	fmt.Fprintln(w, "\t\t$candidates = $commands")
+	fmt.Fprintln(w, "\t} else {")
+	fmt.Fprintln(w, "\t\t$candidates = $flags[$words[1]]")
+	fmt.Fprintln(w, "\t}")
+	fmt.Fprintln(w, "\t$candidates | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	fmt.Fprintln(w, "\t\t[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)")
+	fmt.Fprintln(w, "\t}")
+	fmt.Fprintln(w, "}")
+}
+
+func psList(items []string) string {
+	q := make([]string, len(items))
+	for i, s := range items {
+		q[i] = "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return strings.Join(q, ", ")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,251 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the YAML configuration file. Besides the sections below, any
+// top-level key named after a flag ("proxy", "user-agent", "header", ...) sets
+// that flag's default; a "crawl:" or "extract:" section does the same for one
+// subcommand only. Explicit command line flags always win.
+type fileConfig struct {
+	Hosts map[string]hostBudget `yaml:"hosts"`
+
+	defaults map[string]any
+}
+
+// hostBudget limits how a single host is crawled. Zero values mean unlimited.
+type hostBudget struct {
+	MaxBytes    byteSize `yaml:"max-bytes"`
+	MaxRequests int      `yaml:"max-requests"`
+	Concurrency int      `yaml:"concurrency"`
+	Delay       duration `yaml:"delay"`
+}
+
+// configAliases maps friendlier config/env names to flag names.
+var configAliases = map[string]string{
+	"headers": "header",
+}
+
+func loadConfig(path string) (*fileConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg fileConfig
+	if err := yaml.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(raw, &cfg.defaults); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// defaultConfigPath is ~/.config/tsmap-extract/config.yaml (or the OS equivalent).
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tsmap-extract", "config.yaml")
+}
+
+// configPathFromArgs finds "-config file" or "-config=file" before the flags are parsed.
+func configPathFromArgs(args []string) (string, bool) {
+	for i, a := range args {
+		name, val, hasVal := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || name != "config" {
+			continue
+		}
+		if hasVal {
+			return val, true
+		}
+		if i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+// loadDefaults applies config file values, then TSMAP_* environment variables,
+// as defaults of fs. It must run before fs.Parse so command line flags override
+// both. The config file is -config, else $TSMAP_CONFIG, else the default path
+// when it exists. The returned config is never nil.
+func loadDefaults(fs *flag.FlagSet, command string, args []string) *fileConfig {
+	path, explicit := configPathFromArgs(args)
+	if !explicit {
+		if path = os.Getenv("TSMAP_CONFIG"); path != "" {
+			explicit = true
+		} else {
+			path = defaultConfigPath()
+		}
+	}
+
+	cfg := &fileConfig{}
+	if path != "" {
+		loaded, err := loadConfig(path)
+		switch {
+		case err == nil:
+			cfg = loaded
+		case explicit || !errors.Is(err, os.ErrNotExist):
+			usageFail(msgConfigError, err)
+		}
+	}
+
+	// global keys first, then the subcommand section
+	apply := func(section map[string]any) {
+		for k, v := range section {
+			if name, ok := configAliases[k]; ok {
+				k = name
+			}
+			if fs.Lookup(k) == nil || k == "config" {
+				continue
+			}
+			for _, val := range configValues(v) {
+				if err := fs.Set(k, val); err != nil {
+					usageFail(msgConfigError, fmt.Errorf("%s: %w", k, err))
+				}
+			}
+		}
+	}
+	apply(cfg.defaults)
+	if sub, ok := cfg.defaults[command].(map[string]any); ok {
+		apply(sub)
+	}
+
+	// environment: TSMAP_USER_AGENT sets -user-agent, TSMAP_HEADER sets -header, ...
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		env := "TSMAP_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v, ok := os.LookupEnv(env); ok {
+			if r, ok := f.Value.(resettable); ok {
+				r.reset() // replaces the config file values
+			}
+			if err := fs.Set(f.Name, v); err != nil {
+				usageFail(msgConfigError, fmt.Errorf("%s: %w", env, err))
+			}
+		}
+	})
+
+	// repeatable flags given on the command line replace these defaults
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := f.Value.(resettable); ok {
+			f.Value = &overridable{Value: f.Value}
+		}
+	})
+	return cfg
+}
+
+// resettable is implemented by the values of repeatable flags (-header,
+// -include, -scope...), whose Set adds to the values already there.
+type resettable interface {
+	reset()
+}
+
+// overridable wraps a repeatable flag so that the first value given on the
+// command line drops those of the config file and the environment instead of
+// adding to them.
+type overridable struct {
+	flag.Value
+	replaced bool
+}
+
+// String is called on a zero overridable by flag.PrintDefaults.
+func (o *overridable) String() string {
+	if o.Value == nil {
+		return ""
+	}
+	return o.Value.String()
+}
+
+func (o *overridable) Set(v string) error {
+	if !o.replaced {
+		o.replaced = true
+		o.Value.(resettable).reset()
+	}
+	return o.Value.Set(v)
+}
+
+// configValues turns a YAML scalar or list into flag values.
+func configValues(v any) []string {
+	switch t := v.(type) {
+	case nil, map[string]any:
+		return nil
+	case []any:
+		var out []string
+		for _, e := range t {
+			out = append(out, configValues(e)...)
+		}
+		return out
+	default:
+		return []string{fmt.Sprint(t)}
+	}
+}
+
+// byteSize accepts plain byte counts or values like 512KB, 500MB, 2GB.
+type byteSize int64
+
+func (b *byteSize) UnmarshalText(text []byte) error {
+	n, err := parseByteSize(string(text))
+	if err != nil {
+		return err
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+// Set and String let byteSize be used as a flag value.
+func (b *byteSize) Set(s string) error { return b.UnmarshalText([]byte(s)) }
+
+func (b *byteSize) String() string {
+	if b == nil || *b == 0 {
+		return ""
+	}
+	return humanBytes(int64(*b))
+}
+
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// duration accepts time.ParseDuration strings such as 500ms or 2s.
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(strings.TrimSpace(string(text)))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// conflictTracker remembers what this run wrote at each path, so that two maps
+// providing different content for the same file are resolved with -on-conflict
+// instead of the later one silently overwriting the earlier.
+type conflictTracker struct {
+	policy string // overwrite, skip, suffix or newest
+
+	mu      sync.Mutex
+	written map[string]writtenFile
+	taken   map[string]bool   // every path used, suffixed ones included
+	folded  map[string]string // lower-cased path -> path actually used
+	records []conflictRecord
+}
+
+type writtenFile struct {
+	sum     [sha256.Size]byte
+	modTime time.Time
+}
+
+// conflictRecord is reported in the summary and report.json.
+type conflictRecord struct {
+	Path    string `json:"path"`
+	Action  string `json:"action"`          // what -on-conflict did
+	WroteTo string `json:"wrote,omitempty"` // suffixed path for "suffix"
+}
+
+func newConflictTracker() *conflictTracker {
+	return &conflictTracker{
+		policy:  "overwrite",
+		written: make(map[string]writtenFile),
+		taken:   make(map[string]bool),
+		folded:  make(map[string]string),
+	}
+}
+
+// String and Set let the tracker be the -on-conflict flag value.
+func (c *conflictTracker) String() string {
+	if c == nil {
+		return ""
+	}
+	return c.policy
+}
+
+func (c *conflictTracker) Set(v string) error {
+	switch v = strings.ToLower(v); v {
+	case "overwrite", "skip", "suffix", "newest":
+		c.policy = v
+		return nil
+	}
+	return fmt.Errorf("invalid -on-conflict %q (skip|overwrite|suffix|newest)", v)
+}
+
+// resolve returns the path data should be written to, or "" to drop it.
+// modTime is the date of the map providing data, zero when unknown. With
+// foldCase, a path differing from an earlier one only by case (Foo.ts and
+// foo.ts, which would overwrite each other on Windows and macOS) is renamed.
+func (c *conflictTracker) resolve(dst string, data []byte, modTime time.Time, foldCase bool) string {
+	sum := sha256.Sum256(data)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if foldCase {
+		dst = c.foldCase(dst)
+	}
+	prev, ok := c.written[dst]
+	if !ok || prev.sum == sum {
+		c.written[dst] = writtenFile{sum, modTime}
+		c.taken[dst] = true
+		return dst
+	}
+	rec := conflictRecord{Path: dst, Action: c.policy}
+	switch c.policy {
+	case "skip":
+		dst = ""
+	case "suffix":
+		// the same content as an earlier suffixed file goes to that file again
+		ext := filepath.Ext(dst)
+		stem := strings.TrimSuffix(dst, ext)
+		for n := 1; ; n++ {
+			alt := fmt.Sprintf("%s~%d%s", stem, n, ext)
+			if w, ok := c.written[alt]; ok && w.sum == sum {
+				dst = alt
+				break
+			}
+			if !c.taken[alt] && c.folded[strings.ToLower(alt)] == "" {
+				dst = alt
+				c.written[alt] = writtenFile{sum, modTime}
+				c.taken[alt] = true
+				c.folded[strings.ToLower(alt)] = alt
+				break
+			}
+		}
+		rec.WroteTo = dst
+	case "newest":
+		if !modTime.IsZero() && !prev.modTime.IsZero() && !modTime.After(prev.modTime) {
+			rec.Action = "kept newest"
+			dst = ""
+		} else {
+			rec.Action = "replaced by newest"
+			c.written[dst] = writtenFile{sum, modTime}
+		}
+	default:
+		c.written[dst] = writtenFile{sum, modTime}
+	}
+	c.records = append(c.records, rec)
+	logger.Warn(msgPathConflict.String(), "path", rec.Path, "action", rec.Action)
+	return dst
+}
+
+// foldCase returns dst, or "name~N.ext" when another path with the same
+// lower-cased form was already used. -on-conflict suffix names its files
+// the same way.
+func (c *conflictTracker) foldCase(dst string) string {
+	key := strings.ToLower(dst)
+	used, ok := c.folded[key]
+	if !ok {
+		c.folded[key] = dst
+		return dst
+	}
+	if used == dst {
+		return dst
+	}
+	ext := filepath.Ext(dst)
+	stem := strings.TrimSuffix(dst, ext)
+	alt := dst
+	for n := 1; ; n++ {
+		alt = fmt.Sprintf("%s~%d%s", stem, n, ext)
+		if prev, ok := c.folded[strings.ToLower(alt)]; !ok || prev == alt {
+			break
+		}
+	}
+	c.folded[strings.ToLower(alt)] = alt
+	c.records = append(c.records, conflictRecord{Path: dst, Action: "case collision", WroteTo: alt})
+	logger.Warn(msgCaseCollision.String(), "path", dst, "renamed", alt, "same_as", used)
+	return alt
+}
+
+func (c *conflictTracker) list() []conflictRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]conflictRecord(nil), c.records...)
+}
+
+func (c *conflictTracker) logSummary() {
+	if n := len(c.list()); n > 0 {
+		logger.Info(msgConflictSummary.String(), "conflicts", n, "policy", c.policy)
+	}
+}
@@ -0,0 +1,535 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const coordFile = "coordinator.json"
+
+// coordPending is the state of a target no worker has finished.
+const coordPending = "pending"
+
+type coordFlags struct {
+	targets   string
+	workers   hostList
+	token     string
+	out       string
+	perWorker int
+	retries   int
+	poll      time.Duration
+	job       string
+	keepJobs  bool
+	log       *logOptions
+}
+
+func newCoordFlags() (*flag.FlagSet, *coordFlags) {
+	f := &coordFlags{}
+	fs := flag.NewFlagSet("tsmap-extract coordinate", flag.ExitOnError)
+	fs.StringVar(&f.targets, "targets", "", "File listing the root URLs to crawl, one per line, - for stdin (required)")
+	fs.Var(&f.workers, "worker", "Base URL of a worker running 'tsmap-extract server' (repeatable or comma separated, required)")
+	fs.StringVar(&f.token, "token", "", "Bearer token of the workers (or TSMAP_TOKEN)")
+	fs.StringVar(&f.out, "out", "coordinated", "Output directory collecting the files of every target")
+	fs.IntVar(&f.perWorker, "per-worker", 2, "Jobs submitted to each worker at the same time")
+	fs.IntVar(&f.retries, "retries", 2, "Times a target is moved to another worker when its worker fails")
+	fs.DurationVar(&f.poll, "poll", 2*time.Second, "Interval between two status requests of a running job")
+	fs.StringVar(&f.job, "job", "", `Job options as the JSON of POST /jobs without url, e.g. '{"scan_secrets": true}'`)
+	fs.BoolVar(&f.keepJobs, "keep-jobs", false, "Leave finished jobs on the workers instead of deleting them once collected")
+	fs.String("config", "", "YAML config file (default ~/.config/tsmap-extract/config.yaml)")
+	f.log = addLogFlags(fs)
+	return fs, f
+}
+
+// coordReport is written as coordinator.json.
+type coordReport struct {
+	StartedAt      time.Time      `json:"started_at"`
+	DurationMS     int64          `json:"duration_ms"`
+	Workers        []*coordWorker `json:"workers"`
+	SourcesWritten int            `json:"sources_written"`
+	Targets        []*coordTarget `json:"targets"`
+	Secrets        []Secret       `json:"secrets,omitempty"`
+	Severity       riskLevel      `json:"severity,omitempty"`
+}
+
+// coordTarget is one root URL; State is pending until a worker finished it.
+type coordTarget struct {
+	URL      string         `json:"url"`
+	State    string         `json:"state"`
+	Worker   string         `json:"worker,omitempty"`
+	Job      string         `json:"job,omitempty"`
+	Attempts int            `json:"attempts"`
+	Files    int            `json:"files"`
+	Severity string         `json:"severity,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Scripts  []ScriptResult `json:"scripts,omitempty"`
+}
+
+// coordWorker is a server instance. It is dropped after coordMaxFailures
+// failures in a row, its targets going to the other workers.
+type coordWorker struct {
+	URL    string `json:"url"`
+	Jobs   int    `json:"jobs"`
+	Failed int    `json:"failed"`
+	Down   bool   `json:"dropped,omitempty"`
+
+	failures int // in a row
+}
+
+const coordMaxFailures = 3
+
+// workerError is a failure of the worker rather than of the target: the
+// target is retried elsewhere.
+type workerError struct{ err error }
+
+func (e workerError) Error() string { return e.err.Error() }
+func (e workerError) Unwrap() error { return e.err }
+
+// coordinator shards the targets over the workers and merges their results
+// below out.
+type coordinator struct {
+	client   *http.Client
+	token    string
+	template jobRequest
+	poll     time.Duration
+	retries  int
+	keepJobs bool
+	out      *DirSink
+	root     string
+	manifest *manifest
+
+	queue chan *coordTarget
+	left  sync.WaitGroup // targets not finished yet
+
+	mu      sync.Mutex // guards targets, workers and secrets
+	workers []*coordWorker
+	secrets []Secret
+}
+
+// RunCoordinate runs the "coordinate" subcommand: it crawls a list of targets
+// on several "server" instances and collects their files and reports in one
+// output directory, with one manifest.json.
+func RunCoordinate(args []string) int {
+	useConsoleLogger()
+	fs, f := newCoordFlags()
+	loadDefaults(fs, "coordinate", args)
+	fs.Parse(args)
+	defer f.log.setup()()
+
+	if f.targets == "" || len(f.workers) == 0 {
+		logger.Error(msgCoordArgs.String())
+		fs.Usage()
+		return exitUsage
+	}
+	if f.perWorker < 1 || f.retries < 0 || f.poll <= 0 {
+		usageFail(msgCoordLimits)
+	}
+	targets, err := readTargets(f.targets)
+	if err != nil {
+		usageFail(msgCoordTargets, err)
+	}
+	c := &coordinator{
+		client:   &http.Client{Timeout: 5 * time.Minute},
+		token:    f.token,
+		poll:     f.poll,
+		retries:  f.retries,
+		keepJobs: f.keepJobs,
+		out:      NewDirSink(f.out),
+		root:     f.out,
+		manifest: &manifest{},
+		queue:    make(chan *coordTarget, len(targets)),
+	}
+	if f.job != "" {
+		dec := json.NewDecoder(strings.NewReader(f.job))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&c.template); err != nil {
+			usageFail(msgCoordJob, err)
+		}
+		if c.template.URL != "" || len(c.template.Map) > 0 {
+			usageFail(msgCoordJob, "url and map are set per target")
+		}
+	}
+	rep := &coordReport{StartedAt: time.Now().UTC()}
+	for _, u := range targets {
+		t := &coordTarget{URL: u, State: coordPending}
+		rep.Targets = append(rep.Targets, t)
+		c.left.Add(1)
+		c.queue <- t
+	}
+	logger.Info(msgCoordStart.String(), "targets", len(targets), "workers", len(f.workers))
+
+	ctx, stop := interruptContext()
+	defer stop()
+	go func() {
+		c.left.Wait()
+		close(c.queue)
+	}()
+	var slots sync.WaitGroup
+	for _, u := range f.workers {
+		c.workers = append(c.workers, &coordWorker{URL: strings.TrimRight(u, "/")})
+	}
+	rep.Workers = c.workers
+	for _, w := range c.workers {
+		for range f.perWorker {
+			slots.Add(1)
+			go func() {
+				defer slots.Done()
+				c.serve(ctx, w)
+			}()
+		}
+	}
+	slots.Wait()
+
+	rep.DurationMS = time.Since(rep.StartedAt).Milliseconds()
+	rep.Secrets = c.secrets
+	return c.finish(rep)
+}
+
+// readTargets reads the root URLs of -targets, skipping blank lines,
+// # comments and duplicates.
+func readTargets(p string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if p != "-" {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var out []string
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || seen[line] {
+			continue
+		}
+		if !isHTTPURL(line) {
+			return nil, fmt.Errorf("line %d: %q is not an http(s) URL", n, line)
+		}
+		seen[line] = true
+		out = append(out, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no target")
+	}
+	return out, nil
+}
+
+// serve is one job slot of w: it runs targets until the queue is empty, the
+// run is interrupted or w is dropped.
+func (c *coordinator) serve(ctx context.Context, w *coordWorker) {
+	for {
+		var t *coordTarget
+		select {
+		case <-ctx.Done():
+			return
+		case t = <-c.queue:
+			if t == nil {
+				return
+			}
+		}
+		if c.dropped(w) {
+			c.queue <- t // left to the other workers
+			return
+		}
+		if c.failedHere(w, t) {
+			// give the other workers a chance to take it first
+			c.queue <- t
+			if !sleepCtx(ctx, c.poll) {
+				return
+			}
+			continue
+		}
+		err := c.run(ctx, w, t)
+		var we workerError
+		c.mu.Lock()
+		switch {
+		case err == nil:
+			w.failures = 0
+		case ctx.Err() != nil:
+			t.State = jobCanceled
+		case errors.As(err, &we):
+			w.Failed++
+			w.failures++
+			t.Error = err.Error()
+			if w.failures >= coordMaxFailures && !w.Down {
+				w.Down = true
+				logger.Warn(msgCoordWorkerDown.String(), "worker", w.URL, "err", err)
+			}
+			if t.Attempts <= c.retries {
+				t.State = coordPending
+				backoff := c.poll * time.Duration(min(w.failures, coordMaxFailures))
+				c.mu.Unlock()
+				c.queue <- t
+				if !sleepCtx(ctx, backoff) {
+					return
+				}
+				continue
+			}
+			t.State = jobFailed
+		default: // refused by the worker, e.g. invalid -job options
+			t.State, t.Error = jobFailed, err.Error()
+		}
+		state := t.State
+		c.mu.Unlock()
+		if state == jobFailed {
+			logger.Warn(msgCoordTargetFailed.String(), "url", t.URL, "worker", t.Worker, "err", t.Error)
+		} else {
+			logger.Info(msgCoordTargetDone.String(), "url", t.URL, "worker", t.Worker, "state", state, "files", t.Files)
+		}
+		c.left.Done()
+	}
+}
+
+func (c *coordinator) dropped(w *coordWorker) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return w.Down
+}
+
+// failedHere reports whether the last attempt of t failed on w while another
+// worker is still up.
+func (c *coordinator) failedHere(w *coordWorker, t *coordTarget) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.Attempts == 0 || t.Worker != w.URL {
+		return false
+	}
+	for _, o := range c.workers {
+		if o != w && !o.Down {
+			return true
+		}
+	}
+	return false
+}
+
+// sleepCtx waits for d, or reports false when ctx is done first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// run crawls t on w and collects its files; the error is a workerError when
+// the target may succeed on another worker.
+func (c *coordinator) run(ctx context.Context, w *coordWorker, t *coordTarget) error {
+	req := c.template
+	req.URL = t.URL
+	var st jobStatus
+	c.mu.Lock()
+	t.Attempts++
+	t.Worker, t.Job, t.Error = w.URL, "", ""
+	w.Jobs++
+	c.mu.Unlock()
+	if err := c.call(ctx, w, "POST", "/jobs", req, &st); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	t.Job = st.ID
+	c.mu.Unlock()
+
+	tick := time.NewTicker(c.poll)
+	defer tick.Stop()
+	for st.State == jobQueued || st.State == jobRunning {
+		select {
+		case <-ctx.Done():
+			// stop the remote job too, the collected files stay as they are
+			cancelCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_ = c.call(cancelCtx, w, "DELETE", "/jobs/"+st.ID, nil, nil)
+			return ctx.Err()
+		case <-tick.C:
+		}
+		if err := c.call(ctx, w, "GET", "/jobs/"+st.ID, nil, &st); err != nil {
+			return err
+		}
+	}
+
+	var m jobManifest
+	if err := c.call(ctx, w, "GET", "/jobs/"+st.ID+"/manifest", nil, &m); err != nil {
+		return err
+	}
+	n, err := c.collect(ctx, w, st.ID, m.Files)
+	if err != nil {
+		return err
+	}
+	if !c.keepJobs {
+		_ = c.call(ctx, w, "DELETE", "/jobs/"+st.ID, nil, nil)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.State, t.Files, t.Severity, t.Scripts = st.State, n, st.Severity, m.Scripts
+	if st.State == jobFailed {
+		t.Error = st.Error
+	}
+	c.secrets = append(c.secrets, m.Secrets...)
+	return nil
+}
+
+// collect downloads the files of a job and writes them below the output, with
+// their provenance in manifest.json. Jobs write host/path trees, so targets on
+// different hosts never overlap.
+func (c *coordinator) collect(ctx context.Context, w *coordWorker, id string, files []jobFile) (int, error) {
+	if len(files) == 0 {
+		return 0, nil
+	}
+	tmp, err := os.CreateTemp("", "tsmap-job-*.zip")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := c.download(ctx, w, "/jobs/"+id+"/zip", tmp); err != nil {
+		return 0, err
+	}
+	size, _ := tmp.Seek(0, io.SeekCurrent)
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return 0, workerError{err}
+	}
+	meta := make(map[string]jobFile, len(files))
+	for _, f := range files {
+		meta[f.Path] = f
+	}
+	n := 0
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		if err != nil {
+			return n, workerError{err}
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return n, workerError{err}
+		}
+		f := meta[zf.Name]
+		if err := c.out.WriteFile(zf.Name, data, FileMeta{Source: f.Source, Map: f.Map}); err != nil {
+			logger.Warn(msgSkippedBlocked.String(), "source", zf.Name, "err", err)
+			continue
+		}
+		c.manifest.record(c.root, filepath.Join(c.root, filepath.FromSlash(zf.Name)), data, provenance{source: f.Source, mapRef: f.Map})
+		n++
+	}
+	return n, nil
+}
+
+// call sends a JSON request to w and decodes the JSON answer into out.
+// Transport errors and 5xx answers are workerErrors.
+func (c *coordinator) call(ctx context.Context, w *coordWorker, method, p string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	resp, err := c.do(ctx, w, method, p, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return workerError{err}
+	}
+	return nil
+}
+
+func (c *coordinator) download(ctx context.Context, w *coordWorker, p string, dst io.Writer) error {
+	resp, err := c.do(ctx, w, "GET", p, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(dst, resp.Body); err != nil {
+		return workerError{err}
+	}
+	return nil
+}
+
+func (c *coordinator) do(ctx context.Context, w *coordWorker, method, p string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, w.URL+p, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, workerError{err}
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var e struct {
+		Error string `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&e)
+	err = fmt.Errorf("%s %s: HTTP %d %s", method, p, resp.StatusCode, e.Error)
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusUnauthorized {
+		return nil, workerError{err}
+	}
+	return nil, err
+}
+
+// finish writes manifest.json and coordinator.json and logs the summary.
+func (c *coordinator) finish(rep *coordReport) int {
+	counts := make(map[string]int)
+	for _, t := range rep.Targets {
+		counts[t.State]++
+		rep.SourcesWritten += t.Files
+		var r riskLevel
+		if r.Set(t.Severity) == nil {
+			rep.Severity = max(rep.Severity, r)
+		}
+	}
+	if len(rep.Secrets) > 0 {
+		rep.Severity = riskCritical
+	}
+	if data, err := c.manifest.marshal(nil); err == nil && data != nil {
+		err = c.out.WriteFile("manifest.json", data, FileMeta{})
+		if err != nil {
+			logger.Warn(msgManifestError.String(), "err", err)
+		}
+	}
+	data, _ := json.MarshalIndent(rep, "", "  ")
+	if err := c.out.WriteFile(coordFile, data, FileMeta{}); err != nil {
+		logger.Warn(msgCoordReportError.String(), "err", err)
+	}
+	logger.Info(msgCoordDone.String(), "done", counts[jobDone], "failed", counts[jobFailed], "canceled", counts[jobCanceled],
+		"pending", counts[coordPending], "sources", rep.SourcesWritten, "severity", rep.Severity, "out", c.root)
+	if rep.SourcesWritten == 0 {
+		return exitNoSources
+	}
+	return exitOK
+}
@@ -3,18 +3,24 @@
 package tsmap
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
-	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -22,135 +28,569 @@ import (
 	"golang.org/x/net/html"
 )
 
-var client = &http.Client{
-	Timeout: 25 * time.Second,
+const defaultUserAgent = "tsmap-crawl/1.0"
+
+// crawlSession holds the settings of one crawl pass and the maps it discovered.
+type crawlSession struct {
+	ctx         context.Context // stops the crawl when done
+	rootURL     *url.URL
+	outBase     string
+	output      *outputOptions
+	http        *crawler
+	saveJS      bool
+	saveMap     bool
+	rawMaps     *rawMapStore // -save-map-raw, nil otherwise
+	probeOnly   bool         // discover maps without extracting or saving anything
+	progress    *progressBar
+	tui         *crawlTUI
+	scope       hostList // allowed hosts, empty means any
+	watchdog    *memWatchdog
+	resumed     map[string]bool   // scripts done by an interrupted run (-resume)
+	perMap      bool              // -layout per-map: one folder per bundle
+	outTemplate outTemplate       // -out-template, replaces the layout's folders
+	day         time.Time         // of the crawl, for -out-template
+	evalChunks  bool              // -eval-chunks
+	harvest     bool              // -harvest
+	guessMaps   bool              // -map-guess aggressive
+	allMaps     bool              // -all-maps
+	templates   mapTemplates      // -map-template
+	imports     *importMap        // import map of the page, nil when none
+	scriptHosts []string          // other hosts the page's CSP allows scripts from
+	integrity   map[string]string // integrity attributes of the page's script tags, by URL
+
+	mu      sync.Mutex
+	maps    map[string]string // map URL -> script URL
+	claimed map[string]bool   // scripts of the pass, see claim
+	scripts []*scriptReport
+	written int // map groups extracted
 }
 
-var reSourceMapInline = regexp.MustCompile(`(?m)//[#@]\s*sourceMappingURL=data:application/json(?:;charset=[^;]+)?;base64,([A-Za-z0-9+/=]+)`)
-var reSourceMapComment = regexp.MustCompile(`(?m)//[#@]\s*sourceMappingURL\s*=\s*(.+)$`)
+func (s *crawlSession) recordMap(mapURL string, scriptURL *url.URL) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maps == nil {
+		s.maps = make(map[string]string)
+	}
+	s.maps[mapURL] = scriptURL.String()
+}
 
-func RunCrawl(args []string) {
+// outputDir is the directory below -out of the files of scriptURL: the one of
+// -out-template, else its host and path directory and, with bundle, its
+// -layout per-map folder.
+func (s *crawlSession) outputDir(scriptURL *url.URL, bundle bool) string {
+	if s.outTemplate != "" {
+		return s.outTemplate.dir(scriptURL, s.day)
+	}
+	dir := hostPathForURL(s.rootURL, scriptURL)
+	if bundle {
+		dir = filepath.Join(dir, bundleName(scriptURL))
+	}
+	return dir
+}
+
+// events returns the progress stream, which only reports the extracting pass.
+func (s *crawlSession) events() *eventStream {
+	if s.probeOnly {
+		return nil
+	}
+	return s.output.events
+}
+
+func (s *crawlSession) addScript(r *scriptReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts = append(s.scripts, r)
+}
+
+// crawlFlags holds the parsed "crawl" command line.
+type crawlFlags struct {
+	url         string
+	out         string
+	output      *outputOptions
+	concurrency int
+	http        *httpOptions
+	saveJS      bool
+	saveMap     bool
+	saveMapRaw  bool
+	evalChunks  bool
+	harvest     bool
+	mapGuess    string
+	templates   mapTemplates
+	allMaps     bool
+	authDiff    bool
+	scope       hostList
+	tui         bool
+	strict      bool
+	maxRSS      byteSize
+	resume      string
+	layout      string
+	outTemplate outTemplate
+	git         bool
+	notify      string
+	reportDoc   string
+	log         *logOptions
+}
+
+func newCrawlFlags() (*flag.FlagSet, *crawlFlags) {
+	f := &crawlFlags{}
 	fs := flag.NewFlagSet("tsmap-extract crawl", flag.ExitOnError)
-	urlRoot := fs.String("url", "", "Root page URL to crawl (required)")
-	outDir := fs.String("out", "recovered", "Output base directory")
-	beautify := fs.Bool("beautify", false, "Beautify minimal JS/TS")
-	eol := fs.String("eol", "", "Normalize EOL: unix|dos")
-	concurrency := fs.Int("concurrency", 4, "Parallel downloads")
-	userAgent := fs.String("user-agent", "tsmap-crawl/1.0", "User-Agent header")
-	saveJS := fs.Bool("save-js", false, "Save downloaded .js files alongside recovered sources")
-	saveMap := fs.Bool("save-map", false, "Save downloaded .map files alongside recovered sources")
-	proxy := fs.String("proxy", "", "Proxy URL (e.g. http://127.0.0.1:8080)")
-	insecure := fs.Bool("insecure", false, "Skip TLS verification, usefull with burpsuite")
+	fs.StringVar(&f.url, "url", "", "Root page URL to crawl (required)")
+	fs.StringVar(&f.out, "out", "recovered", "Output base directory, or s3://, gs://, az:// object store URL")
+	f.output = addOutputFlags(fs)
+	fs.IntVar(&f.concurrency, "concurrency", 4, "Parallel downloads")
+	f.http = addHTTPFlags(fs)
+	fs.BoolVar(&f.saveJS, "save-js", false, "Save downloaded .js files alongside recovered sources")
+	fs.BoolVar(&f.saveMap, "save-map", false, "Save downloaded .map files alongside recovered sources")
+	fs.BoolVar(&f.saveMapRaw, "save-map-raw", false, "Save every fetched map body under raw/, with its URL in raw/index.json, even when it fails to parse")
+	fs.BoolVar(&f.evalChunks, "eval-chunks", false, "Run the webpack chunk filename function in a sandboxed JS interpreter to find more chunks")
+	fs.BoolVar(&f.harvest, "harvest", false, "Also crawl .js, .mjs and .css paths found as string literals in scripts (same hosts, or -scope)")
+	fs.StringVar(&f.mapGuess, "map-guess", "default", "Where to look for maps no comment names: default (script.js.map) or aggressive (.min and hash stripped names, maps/ and sourcemaps/ directories)")
+	fs.Var(&f.templates, "map-template", "Map name to try for scripts with a content hash, comma separated or repeated: {name}, {hash} and {ext} are replaced (implies -map-guess aggressive)")
+	fs.BoolVar(&f.allMaps, "all-maps", false, "Extract the maps of every sourceMappingURL comment of a script (concatenated bundles), not only the last one")
+	fs.BoolVar(&f.authDiff, "auth-diff", false, "Probe anonymously first, then with credentials, and report auth-only maps")
+	fs.String("config", "", "YAML config file (default ~/.config/tsmap-extract/config.yaml)")
+	fs.Var(&f.scope, "scope", "Only fetch from these hosts, comma separated ('*.example.com' matches subdomains; root host is always allowed)")
+	fs.BoolVar(&f.tui, "tui", false, "Interactive live view (pause, skip hosts)")
+	fs.BoolVar(&f.strict, "strict", false, "Exit with code 3 if any script or map failed")
+	fs.Var(&f.maxRSS, "max-rss", "Stop taking new scripts and write a checkpoint when memory use exceeds this size (e.g. 4GB)")
+	fs.StringVar(&f.resume, "resume", "", "Skip the scripts listed in this checkpoint.json")
+	fs.Var(&f.outTemplate, "out-template", "Directory of each script's files below -out, from {host}, {port}, {path}, {bundle} and {date} (e.g. \"{host}/{date}/{bundle}\"); replaces -layout merged|per-map folders")
+	fs.BoolVar(&f.git, "git", false, "Commit the output directory to a git repository after the crawl (created if needed)")
+	fs.StringVar(&f.notify, "notify-url", "", "POST the maps found to this webhook (JSON, or a message for Slack and Discord webhooks)")
+	fs.StringVar(&f.reportDoc, "report", "", "Also write a deliverable report of the run: html (report.html) or md (report.md)")
+	fs.StringVar(&f.layout, "layout", "merged", "merged (one tree per host), per-map (one folder per bundle) or flat (one folder per host, encoded names)")
+	f.log = addLogFlags(fs)
+	return fs, f
+}
+
+// RunCrawl runs the "crawl" subcommand and returns the process exit code.
+func RunCrawl(args []string) int {
+	useConsoleLogger()
+	fs, f := newCrawlFlags()
+	logOpts := f.log
 
+	cfg := loadDefaults(fs, "crawl", args)
 	fs.Parse(args)
-	transport := &http.Transport{}
-	if *proxy != "" {
-		proxyURL, err := url.Parse(*proxy)
-		if err != nil {
-			fail("Invalid proxy URL: %v", err)
+	f.out = f.output.setRoot(f.out)
+	f.output.secrets.enabled = f.output.secrets.enabled || f.output.sarif.enabled
+	if err := f.output.secrets.load(); err != nil {
+		usageFail(msgSecretRules, err)
+	}
+	var tui *crawlTUI
+	switch f.layout {
+	case "merged", "per-map":
+	case "flat":
+		f.output.flat = true
+	default:
+		usageFail(msgInvalidLayout, f.layout)
+	}
+	if f.mapGuess != "default" && f.mapGuess != "aggressive" {
+		usageFail(msgInvalidMapGuess, f.mapGuess)
+	}
+	if len(f.templates) > 0 {
+		f.mapGuess = "aggressive"
+	}
+	if f.reportDoc != "" && f.reportDoc != "html" && f.reportDoc != "md" {
+		usageFail(msgInvalidReport, f.reportDoc)
+	}
+	if f.git {
+		if f.output.blobURL != "" || (f.output.outZip != "" || f.output.outTar != "") && !f.output.keepTree {
+			usageFail(msgGitNeedsTree)
 		}
+		// the tree mirrors the last crawl, so that git shows removed files too
+		f.output.prune = f.resume == ""
+	}
+	if f.tui && logOpts.ascii {
+		usageFail(msgTUIAscii)
+	}
+	if f.tui && f.output.ownsStdout() {
+		usageFail(msgTUIPathsOnly)
+	}
+	if f.output.ownsStdout() {
+		logOpts.console = os.Stderr
+	}
+	if f.tui {
+		var err error
+		if tui, err = newCrawlTUI(f.url); err != nil {
+			fail(msgTUIError, err)
+		}
+		logOpts.console = tui
+	}
+	defer logOpts.setup()()
+	f.output.events = events
+	ctx, stop := interruptContext()
+	defer stop()
+	if strings.TrimSpace(f.url) == "" {
+		logger.Error(msgMissingURL.String())
+		fs.Usage()
+		return exitUsage
+	}
 
-		transport.Proxy = http.ProxyURL(proxyURL)
-		transport.ForceAttemptHTTP2 = false
-		transport.TLSHandshakeTimeout = 30 * time.Second
-		fmt.Printf("%sUsing proxy:%s %s\n", cCyn, cRst, proxyURL.String())
-	} else {
-		transport.Proxy = http.ProxyFromEnvironment
+	rootURL, err := url.Parse(f.url)
+	if err != nil {
+		usageFail(msgInvalidURL, err)
 	}
 
-	// Option to skip TLS verification (for Burp/ZAP interception)
-	if *insecure {
-		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		fmt.Printf("%sWarning:%s TLS verification disabled (insecure mode)\n", cYel, cRst)
+	authHeaders := f.http.authHeaders()
+	if f.authDiff && len(authHeaders) == 0 {
+		usageFail(msgAuthDiffNeedsCreds)
 	}
-	// override client with proxy-enabled transport
-	client = &http.Client{
-		Timeout:   25 * time.Second,
-		Transport: transport,
+
+	var limits *hostLimiter
+	if len(cfg.Hosts) > 0 {
+		limits = newHostLimiter(cfg.Hosts)
 	}
-	if strings.TrimSpace(*urlRoot) == "" {
-		fmt.Fprintln(os.Stderr, "Missing -url")
-		flag.Usage()
-		os.Exit(2)
+	if len(f.scope) > 0 {
+		f.scope = append(f.scope, rootURL.Hostname())
 	}
+	var resumed map[string]bool
+	if f.resume != "" {
+		if resumed, err = loadCheckpoint(f.resume, "crawl", rootURL.String()); err != nil {
+			usageFail(msgResumeError, err)
+		}
+		logger.Info(msgResuming.String(), "done", len(resumed))
+	}
+	cr := f.http.newCrawler(limits)
+	cr.stats = &hostTally{}
+	watchdog := startWatchdog(int64(f.maxRSS))
+	defer watchdog.close()
+	f.output.openSinks()
+	defer f.output.closeSinks()
 
-	rootURL, err := url.Parse(*urlRoot)
-	if err != nil {
-		fail("Invalid url: %v", err)
+	day := time.Now() // of the crawl, for -out-template
+	var rawMaps *rawMapStore
+	if f.saveMapRaw {
+		rawMaps = &rawMapStore{}
+	}
+	newSession := func(h http.Header, probeOnly bool) *crawlSession {
+		return &crawlSession{
+			ctx:         ctx,
+			rootURL:     rootURL,
+			outBase:     f.out,
+			output:      f.output,
+			http:        cr.withHeaders(h),
+			saveJS:      f.saveJS,
+			saveMap:     f.saveMap,
+			rawMaps:     rawMaps,
+			probeOnly:   probeOnly,
+			tui:         tui,
+			scope:       f.scope,
+			watchdog:    watchdog,
+			resumed:     resumed,
+			perMap:      f.layout == "per-map",
+			outTemplate: f.outTemplate,
+			day:         day,
+			evalChunks:  f.evalChunks,
+			harvest:     f.harvest,
+			guessMaps:   f.mapGuess == "aggressive",
+			templates:   f.templates,
+			allMaps:     f.allMaps,
+		}
 	}
 
-	// fetch root
-	fmt.Printf("Fetching: %s\n", rootURL.String())
-	req, _ := http.NewRequestWithContext(context.Background(), "GET", rootURL.String(), nil)
-	req.Header.Set("User-Agent", *userAgent)
-	resp, err := client.Do(req)
+	var anon *crawlSession
+	if f.authDiff {
+		logger.Info(msgAnonPass.String())
+		anon = newSession(http.Header{}, true)
+		if _, _, err := runCrawlPass(anon, f.concurrency); err != nil {
+			fail(msgRootFetchFailed, err)
+		}
+		logger.Info(msgAuthPass.String())
+	}
+
+	started := time.Now()
+	sess := newSession(authHeaders, false)
+	if tui == nil {
+		sess.progress = startProgress(msgUnitScripts.String(), logOpts.progress())
+	}
+	f.output.events.emit("start", "command", "crawl", "url", rootURL.String())
+	scripts, writtenTotal, err := runCrawlPass(sess, f.concurrency)
 	if err != nil {
-		fail("Failed to fetch root URL: %v", err)
+		fail(msgRootFetchFailed, err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-		fail("HTTP error fetching root: %s", resp.Status)
+	sess.progress.finish()
+	tui.close()
+	lines, size := f.output.langs.totals()
+	logger.Info(msgCrawlDone.String(), "scripts", scripts, "written_groups", writtenTotal, "lines", lines, "bytes", humanBytes(size))
+	f.output.langs.logSummary()
+
+	rep := &crawlReport{
+		RootURL:      rootURL.String(),
+		StartedAt:    started,
+		DurationMS:   time.Since(started).Milliseconds(),
+		ScriptsTotal: len(sess.scripts),
+		Scripts:      sess.scripts,
+		ScriptHosts:  sess.scriptHosts,
+		PeakRSS:      watchdog.peakRSS(),
+		Languages:    f.output.langs.list(),
+		Hosts:        cr.stats.list(sess.scripts),
+	}
+	logHostSummary(rep.Hosts)
+	for _, sr := range sess.scripts {
+		rep.SourcesWritten += sr.Sources
+		if sr.Integrity != nil && !sr.Integrity.Match {
+			rep.SRIMismatches++
+		}
+		if sr.MapURL != "" {
+			f.output.sarif.addMap(sr.MapURL, sr.URL, sr.Sources)
+		}
+	}
+	if c := f.output.filter.counts(); c != (sourceCounts{}) {
+		rep.SourceCounts = &c
+	}
+	f.output.filter.logSummary()
+	rep.Dedup = f.output.dedup.counts()
+	rep.Conflicts = f.output.conflicts.list()
+	f.output.conflicts.logSummary()
+	rep.Scrubbed = f.output.scrubbed.list()
+	f.output.scrubbed.logSummary()
+	rep.Secrets = f.output.secrets.list()
+	f.output.scores.scoreReport(rep)
+	f.output.scores.logSummary(rep.Severity)
+	rep.Fingerprint = f.output.fingerprint.result()
+	f.output.fingerprint.logSummary()
+	f.output.saveAnalyzers()
+	f.output.sarif.save(f.output)
+	rawMaps.save(f.output)
+	f.output.dedup.logSummary()
+	if f.output.prune {
+		// only a complete run prunes, and never the files of what failed this time
+		var complete bool
+		f.output.pruneKeep, complete = failedRefs(sess.scripts)
+		f.output.prune = complete && !watchdog.exceeded() && ctx.Err() == nil
+	}
+	f.output.saveManifest()
+	if anon != nil {
+		rep.AuthDiff = diffSessions(anon, sess)
+		printAuthDiff(rep.AuthDiff, anon, sess)
 	}
-	body, err := io.ReadAll(resp.Body)
+	if f.output.dryRun {
+		logger.Info(msgWouldWrite.String(), "path", filepath.Join(f.out, "report.json"))
+	} else if err := writeReport(f.output, rep); err != nil {
+		logger.Warn(msgReportError.String(), "err", err)
+	}
+	if f.reportDoc != "" && !f.output.dryRun {
+		if err := writeReportDoc(f.output, rep, f.reportDoc); err != nil {
+			logger.Warn(msgReportError.String(), "err", err)
+		}
+	}
+	f.output.saveSums()
+	f.output.events.emit("done", "scripts", rep.ScriptsTotal, "sources", rep.SourcesWritten, "duration_ms", rep.DurationMS)
+
+	if watchdog.exceeded() || ctx.Err() != nil {
+		// in-flight scripts have drained; record them so a rerun can skip them
+		cp := &checkpoint{Command: "crawl", Target: rootURL.String(), CreatedAt: time.Now(), Done: []string{}}
+		for u := range resumed {
+			cp.Done = append(cp.Done, u)
+		}
+		for _, sr := range sess.scripts {
+			if !sr.interrupted {
+				cp.Done = append(cp.Done, sr.URL)
+			}
+		}
+		sort.Strings(cp.Done)
+		p, err := writeCheckpoint(f.out, cp)
+		if err != nil {
+			fail(msgCheckpointError, err)
+		}
+		if ctx.Err() != nil {
+			logger.Error(msgCheckpointStopped.String(), "path", p)
+		} else {
+			logger.Error(msgCheckpointWritten.String(), "path", p)
+		}
+		return exitFatal
+	}
+	if f.resume != "" {
+		_ = os.Remove(f.resume)
+	}
+	if f.git && !f.output.dryRun {
+		msg := fmt.Sprintf("crawl %s at %s\n\nscripts: %d, sources written: %d", rootURL, started.UTC().Format(time.RFC3339), rep.ScriptsTotal, rep.SourcesWritten)
+		if hash, err := gitCommit(f.out, msg); err != nil {
+			logger.Warn(msgGitError.String(), "err", err)
+		} else if hash == "" {
+			logger.Info(msgGitUnchanged.String(), "dir", f.out)
+		} else {
+			logger.Info(msgGitCommitted.String(), "dir", f.out, "commit", hash)
+		}
+	}
+
+	if f.notify != "" && len(sess.maps) > 0 {
+		n := &notification{Target: rootURL.String(), Time: time.Now().UTC(), Maps: sortedKeys(sess.maps), FilesRecovered: rep.SourcesWritten, Secrets: len(rep.Secrets)}
+		if err := sendNotification(f.notify, n); err != nil {
+			logger.Warn(msgNotifyFailed.String(), "url", f.notify, "err", err)
+		} else {
+			logger.Info(msgNotified.String(), "url", f.notify, "maps", len(n.Maps))
+		}
+	}
+
+	if f.strict {
+		for _, sr := range sess.scripts {
+			if len(sr.Errors) > 0 {
+				logger.Error(msgStrictErrors.String(), "script", sr.URL, "err", sr.Errors[0])
+				return exitFatal
+			}
+		}
+	}
+	if f.output.scores.failed(rep.Severity) {
+		logger.Error(msgSeverityFailed.String(), "severity", rep.Severity, "fail_on", f.output.scores.failOn)
+		return exitSeverity
+	}
+	if rep.SourcesWritten == 0 {
+		return exitNoSources
+	}
+	return exitOK
+}
+
+// failedRefs returns the URLs of the scripts and maps that had errors, whose
+// files -git must not prune. complete is false when a script itself could not
+// be fetched or was cut short: the chunks it would have led to are unknown.
+func failedRefs(scripts []*scriptReport) (refs map[string]bool, complete bool) {
+	refs, complete = make(map[string]bool), true
+	for _, sr := range scripts {
+		if sr.interrupted || sr.Status == 0 && len(sr.Errors) > 0 {
+			complete = false
+		}
+		if len(sr.Errors) == 0 {
+			continue
+		}
+		refs[sr.URL] = true
+		if sr.MapURL != "" && sr.MapURL != "inline" {
+			refs[sr.MapURL] = true
+		}
+		for _, a := range sr.Attempts {
+			refs[a.URL] = true
+		}
+		for _, m := range sr.ExtraMaps {
+			refs[m] = true
+		}
+	}
+	return refs, complete
+}
+
+// runCrawlPass fetches the root page and processes every script it references.
+// It returns the number of scripts processed and the number of map groups
+// written, or the error of the root page.
+func runCrawlPass(sess *crawlSession, concurrency int) (int, int, error) {
+	// fetch root
+	logger.Info(msgFetching.String(), "url", sess.rootURL.String())
+	res, err := sess.fetch(sess.rootURL.String())
 	if err != nil {
-		fail("Read body: %v", err)
+		return 0, 0, err
 	}
 
 	// parse HTML scripts with x/net/html
-	scripts := parseScriptsHTML(string(body), rootURL)
+	scripts, imports, integrity := parseScriptsHTML(string(res.Body), sess.rootURL)
+	sess.imports, sess.integrity = imports, integrity
+	// assets the response headers preload or the CSP names, and WebAssembly
+	// modules preloaded or instantiated by inline scripts
+	hosts, assets := cspScriptSources(res.Header, sess.rootURL)
+	for _, h := range hosts {
+		logger.Info(msgScriptHost.String(), "host", h)
+	}
+	sess.scriptHosts = hosts
+	assets = append(append(assets, linkPreloads(res.Header, sess.rootURL)...), wasmRefs(string(res.Body), sess.rootURL)...)
+	for _, u := range assets {
+		if !slices.ContainsFunc(scripts, func(s *url.URL) bool { return s.String() == u.String() }) {
+			scripts = append(scripts, u)
+		}
+	}
 	if len(scripts) == 0 {
-		fmt.Println("No external script src found on page.")
+		logger.Warn(msgNoScripts.String())
 	}
+	sess.progress.addTotal(len(scripts))
+	sess.events().addTotal(len(scripts))
 
 	// worker pool
-	sem := make(chan struct{}, *concurrency)
+	sem := make(chan struct{}, concurrency)
 	var wg sync.WaitGroup
-	results := make(chan string, len(scripts))
-	endWrite := make(chan struct{})
-	writtenTotal := 0
-	go func() {
-		for r := range results {
-			fmt.Println(r)
-			if strings.HasPrefix(r, "WRITTEN:") {
-				writtenTotal++
-			}
-		}
-		endWrite <- struct{}{}
-	}()
 
+	for _, s := range scripts {
+		sess.claim(s.String())
+	}
 	for _, s := range scripts {
 		wg.Add(1)
 		go func(scriptURL *url.URL) {
 			defer wg.Done()
 			sem <- struct{}{}
 			defer func() { <-sem }()
-			processScript(scriptURL, rootURL, *outDir, *beautify, *eol, *userAgent, *saveJS, *saveMap, results)
+			processScript(scriptURL, nil, sess)
 		}(s)
 	}
 
 	wg.Wait()
-	close(results)
-	<-endWrite
-	fmt.Printf("\nDone. Scripts processed: %d. Sources written groups: %d\n", len(scripts), writtenTotal)
+	return len(scripts), sess.written, nil
 }
 
-// parseScriptsHTML uses golang.org/x/net/html to find <script src=...>
-func parseScriptsHTML(src string, base *url.URL) []*url.URL {
+// diffSessions compares the maps found by an anonymous and an authenticated pass.
+func diffSessions(anon, authed *crawlSession) *authDiffReport {
+	d := &authDiffReport{Public: []string{}, AuthOnly: []string{}, AnonOnly: []string{}}
+	for m := range authed.maps {
+		if _, ok := anon.maps[m]; ok {
+			d.Public = append(d.Public, m)
+		} else {
+			d.AuthOnly = append(d.AuthOnly, m)
+		}
+	}
+	for m := range anon.maps {
+		if _, ok := authed.maps[m]; !ok {
+			d.AnonOnly = append(d.AnonOnly, m)
+		}
+	}
+	sort.Strings(d.Public)
+	sort.Strings(d.AuthOnly)
+	sort.Strings(d.AnonOnly)
+	return d
+}
+
+// printAuthDiff reports which maps were only reachable with credentials.
+func printAuthDiff(d *authDiffReport, anon, authed *crawlSession) {
+	logger.Info(msgAuthDiff.String(), "public", len(d.Public), "auth_only", len(d.AuthOnly), "anonymous_only", len(d.AnonOnly))
+	for _, m := range d.AuthOnly {
+		logger.Warn(msgAuthOnlyMap.String(), "map", m, "script", authed.maps[m])
+	}
+	for _, m := range d.AnonOnly {
+		logger.Info(msgAnonOnlyMap.String(), "map", m, "script", anon.maps[m])
+	}
+}
+
+// parseScriptsHTML uses golang.org/x/net/html to find <script src=...>, the
+// <script type="importmap"> blocks and the modules imported by inline
+// <script type="module"> blocks, resolved with them. The import map is nil
+// when the page has none; integrity holds the integrity attributes of the
+// script tags, by URL.
+func parseScriptsHTML(src string, base *url.URL) (scripts []*url.URL, im *importMap, integrity map[string]string) {
 	doc, err := html.Parse(strings.NewReader(src))
 	if err != nil {
 		// fallback to simple regex if parse fails
-		return parseScriptsRegex(src, base)
+		return parseScriptsRegex(src, base), nil, nil
 	}
 	var out []*url.URL
+	integrity = make(map[string]string)
+	var modules []string
 	var f func(*html.Node)
 	f = func(n *html.Node) {
 		if n.Type == html.ElementNode && strings.EqualFold(n.Data, "script") {
+			switch typ := strings.ToLower(strings.TrimSpace(scriptAttr(n, "type"))); {
+			case typ == "importmap":
+				if im == nil {
+					im = &importMap{}
+				}
+				if err := im.add(scriptText(n), base); err != nil {
+					logger.Warn(msgBadImportMap.String(), "url", base.String(), "err", err)
+				}
+			case typ == "module" && strings.TrimSpace(scriptAttr(n, "src")) == "":
+				modules = append(modules, scriptText(n))
+			}
 			for _, a := range n.Attr {
 				if strings.EqualFold(a.Key, "src") && strings.TrimSpace(a.Val) != "" {
 					raw := strings.TrimSpace(a.Val)
 					u, err := url.Parse(raw)
 					if err == nil {
-						out = append(out, base.ResolveReference(u))
+						u = base.ResolveReference(u)
+						out = append(out, u)
+						if sri := strings.TrimSpace(scriptAttr(n, "integrity")); sri != "" {
+							integrity[u.String()] = sri
+						}
 					}
 					break
 				}
@@ -161,6 +601,12 @@ func parseScriptsHTML(src string, base *url.URL) []*url.URL {
 		}
 	}
 	f(doc)
+	if im != nil {
+		logger.Info(msgImportMap.String(), "entries", im.size())
+	}
+	for _, js := range modules {
+		out = append(out, im.resolveImports(js, base)...)
+	}
 	// dedupe
 	seen := make(map[string]bool)
 	var dedup []*url.URL
@@ -173,7 +619,28 @@ func parseScriptsHTML(src string, base *url.URL) []*url.URL {
 			dedup = append(dedup, u)
 		}
 	}
-	return dedup
+	return dedup, im, integrity
+}
+
+// scriptAttr returns the value of attribute key of n, or "".
+func scriptAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// scriptText returns the inline text of a <script> element.
+func scriptText(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		}
+	}
+	return b.String()
 }
 
 // fallback regex parser
@@ -202,112 +669,551 @@ func parseScriptsRegex(htmlSrc string, base *url.URL) []*url.URL {
 	return dedup
 }
 
-func processScript(scriptURL *url.URL, rootURL *url.URL, outBase string, beautify bool, eol string, userAgent string, saveJS, saveMap bool, results chan<- string) {
-	results <- fmt.Sprintf("Processing: %s", scriptURL.String())
+func processScript(scriptURL *url.URL, parent *url.URL, sess *crawlSession) {
+	if sess.resumed[scriptURL.String()] || sess.watchdog.exceeded() || sess.ctx.Err() != nil {
+		logger.Debug(msgScriptSkipped.String(), "url", scriptURL.String())
+		sess.progress.incDone()
+		sess.events().incDone()
+		return
+	}
+	logger.Info(msgProcessing.String(), "url", scriptURL.String())
+	sess.claim(scriptURL.String())
+	rootURL := sess.rootURL
+	rep := &scriptReport{URL: scriptURL.String()}
+	if parent != nil {
+		rep.Parent = parent.String()
+	}
+	sess.addScript(rep)
+	defer func() {
+		// cut short: a resumed crawl must do it again
+		rep.interrupted = sess.ctx.Err() != nil
+	}()
+	defer sess.progress.incDone()
+	sess.events().script(rep.URL, rep.Parent)
+	defer sess.events().incDone()
+	defer func() {
+		sess.events().emit("script_done", "url", rep.URL, "status", rep.Status, "map_url", rep.MapURL, "sources", rep.Sources, "errors", rep.Errors)
+	}()
+	host := scriptURL.Hostname()
+	sess.tui.scriptState(rep.URL, host, "fetching")
+	defer func() {
+		state := "no map"
+		switch {
+		case rep.Status == 0 && len(rep.Errors) > 0:
+			state = "failed"
+		case rep.NotJS != "":
+			state = "not js"
+		case rep.MapURL != "":
+			state = "map"
+		}
+		sess.tui.scriptState(rep.URL, host, state)
+	}()
 
 	// fetch .js
-	jsBytes, err := fetchURLBytes(scriptURL.String(), userAgent)
+	res, err := sess.fetchScript(scriptURL.String())
+	defer res.Spool.close()
+	rep.Status, rep.Bytes, rep.DurationMS = res.Status, res.size(), res.Duration.Milliseconds()
 	if err != nil {
-		results <- fmt.Sprintf("%sFailed to fetch script: %v%s", cYel, err, cRst)
+		rep.Errors = append(rep.Errors, err.Error())
+		logger.Warn(msgScriptFetchFailed.String(), "url", scriptURL.String(), "err", err)
+		sess.events().failed(rep.URL, err)
 		return
 	}
-	jsText := string(jsBytes)
+	if sri := sess.integrity[scriptURL.String()]; sri != "" {
+		checkIntegrity(sri, res, rep)
+	}
+	js := scriptBody{spool: res.Spool}
+	var kind string
+	if js.spool != nil {
+		kind, err = js.spool.sniff(res.ContentType)
+	} else {
+		kind = sniffNonJS(res.ContentType, res.Body)
+	}
+	if err != nil {
+		rep.Errors = append(rep.Errors, err.Error())
+		logger.Warn(msgScriptSpoolError.String(), "url", scriptURL.String(), "err", err)
+		return
+	}
+	if kind == "application/wasm" {
+		processWasm(scriptURL, res, rep, sess)
+		return
+	}
+	if kind != "" {
+		// analytics pixels, JSON, SPA fallback pages: nothing to look for
+		rep.NotJS = kind
+		logger.Info(msgNotJavaScript.String(), "url", scriptURL.String(), "type", kind)
+		return
+	}
+	if js.spool == nil {
+		js.text = string(res.Body)
+	} else {
+		logger.Debug(msgScriptSpooled.String(), "url", scriptURL.String(), "bytes", res.size())
+	}
 
-	// Detect chunk names built via 'return "..."+var+"."+{...}[var]+".chunk.js"'
-	chunkURLs := findChunkURLsReturnPattern(jsText, scriptURL, rootURL)
-	for _, cu := range chunkURLs {
-		results <- fmt.Sprintf("Discovered chunk via return(): %s", cu.String())
-		// Traiter le chunk comme un script normal (sequentiel pour ne pas exploser la concurrence)
-		processScript(cu, rootURL, outBase, beautify, eol, userAgent, saveJS, saveMap, results)
+	// chunk detectors (built-in and registered, then -eval-chunks, -harvest
+	// and the imports of pages having an import map)
+	detectors := registeredChunkDetectors()
+	if sess.evalChunks {
+		detectors = append(detectors, evalChunkDetector{})
+	}
+	if sess.harvest {
+		detectors = append(detectors, literalDetector{scope: sess.literalScope(scriptURL)})
+	}
+	if sess.imports != nil {
+		detectors = append(detectors, importDetector{m: sess.imports})
+	}
+	found := make(map[string]bool)
+	for _, d := range detectors {
+		chunkURLs, err := js.detectChunks(d, scriptURL, rootURL)
+		if err != nil {
+			rep.Errors = append(rep.Errors, err.Error())
+			logger.Warn(msgScriptSpoolError.String(), "url", scriptURL.String(), "err", err)
+		}
+		// a chunk found by an earlier detector is not crawled twice
+		chunkURLs = slices.DeleteFunc(chunkURLs, func(u *url.URL) bool {
+			if found[u.String()] {
+				return true
+			}
+			found[u.String()] = true
+			return false
+		})
+		switch d.(type) {
+		case literalDetector, importDetector, wasmDetector:
+			// literals and imports often name scripts crawled already, or
+			// each other, and chunks share their wasm modules
+			chunkURLs = slices.DeleteFunc(chunkURLs, func(u *url.URL) bool { return !sess.claim(u.String()) })
+		}
+		sess.progress.addTotal(len(chunkURLs))
+		sess.tui.chunks(host, len(chunkURLs))
+		sess.events().addTotal(len(chunkURLs))
+		for _, cu := range chunkURLs {
+			logger.Info(msgChunkDiscovered.String(), "url", cu.String(), "detector", d.Name())
+			// Traiter le chunk comme un script normal (sequentiel pour ne pas exploser la concurrence)
+			processScript(cu, scriptURL, sess)
+		}
 	}
 
 	// optional save js
-	if saveJS {
-		hostPath := hostPathForURL(rootURL, scriptURL)
-		outDir := filepath.Join(outBase, hostPath)
-		_ = os.MkdirAll(outDir, 0755)
-		jsName := filepath.Base(scriptURL.Path)
-		if jsName == "" {
-			jsName = "script.js"
+	if sess.saveJS && !sess.probeOnly {
+		outDir := filepath.Join(sess.outBase, sess.outputDir(scriptURL, false))
+		jsName := assetFileName(scriptURL, "script.js")
+		data := res.Body
+		if js.spool != nil {
+			data, err = js.spool.bytes()
+		}
+		if dst := filepath.Join(outDir, jsName); err == nil && sess.output.writeFile(dst, data) == nil {
+			sess.output.writeAssetMeta(dst, data, &res)
 		}
-		_ = os.WriteFile(filepath.Join(outDir, jsName), jsBytes, 0644)
 	}
 
-	// 1) inline base64 map
-	if m := reSourceMapInline.FindStringSubmatch(jsText); len(m) > 1 {
-		b64 := m[1]
-		data, err := base64.StdEncoding.DecodeString(b64)
+	// generated script for -reconstruct: a spooled one is only read whole then
+	jsText := js.text
+	if js.spool != nil && sess.output.reconstructs() {
+		var data []byte
+		data, err = js.spool.bytes()
+		jsText = string(data)
+	}
+	if err != nil {
+		rep.Errors = append(rep.Errors, err.Error())
+		logger.Warn(msgScriptSpoolError.String(), "url", scriptURL.String(), "err", err)
+	}
+
+	// map locators: inline data URL, sourceMappingURL comment, script.js.map,
+	// then registered ones and -map-guess aggressive
+	tried := make(map[string]bool)
+	locators := registeredMapLocators()
+	if sess.guessMaps {
+		locators = append(locators, guessMapLocator{templates: sess.templates})
+	}
+	if sess.allMaps {
+		// the last comment is the locators' one
+		defer processEarlierMaps(js, tried, jsText, scriptURL, rep, sess)
+	}
+	for _, loc := range locators {
+		cands, err := js.locateMaps(loc, scriptURL)
 		if err != nil {
-			results <- fmt.Sprintf("%sInline map decode error: %v%s", cYel, err, cRst)
-		} else {
-			hostPath := hostPathForURL(rootURL, scriptURL)
-			nwritten, err := processMapBytes(data, outBase, hostPath, beautify, eol, saveMap, "")
-			if err != nil {
-				results <- fmt.Sprintf("%sError processing inline map: %v%s", cYel, err, cRst)
-			} else {
-				results <- fmt.Sprintf("WRITTEN:%d inline map for %s", nwritten, scriptURL.String())
-			}
+			rep.Errors = append(rep.Errors, err.Error())
+			logger.Warn(msgLocatorError.String(), "script", scriptURL.String(), "locator", loc.Name(), "err", err)
+			sess.events().failed(rep.URL, err)
+			continue
+		}
+		if tryMapCandidates(cands, loc.Name(), tried, jsText, res.LastModified, scriptURL, rep, sess) {
 			return
 		}
 	}
 
-	// 2) sourceMappingURL comment
-	if m := reSourceMapComment.FindStringSubmatch(jsText); len(m) > 1 {
-		ref := strings.TrimSpace(m[1])
-		ref = strings.Trim(ref, "\"'")
-		// Map ref can be relative; resolve against scriptURL
-		mapURL, err := scriptURL.Parse(ref)
-		if err == nil {
-			data, err := fetchURLBytes(mapURL.String(), userAgent)
+	logger.Warn(msgNoSourcemap.String(), "script", scriptURL.String())
+}
+
+// processEarlierMaps extracts the maps named by the sourceMappingURL comments
+// of a script before its last one (-all-maps), as concatenated bundles have.
+// They are listed as extra_maps in the report; tried holds the map URLs
+// fetched already for the script.
+func processEarlierMaps(js scriptBody, tried map[string]bool, jsText string, scriptURL *url.URL, rep *scriptReport, sess *crawlSession) {
+	comments, err := js.mapComments()
+	if err != nil {
+		rep.Errors = append(rep.Errors, err.Error())
+		logger.Warn(msgScriptSpoolError.String(), "url", scriptURL.String(), "err", err)
+	}
+	if len(comments) < 2 {
+		return
+	}
+	for i, c := range comments[:len(comments)-1] {
+		if inlineMapPrefix(c.ref) >= 0 {
+			data, err := js.inlineMap(c)
 			if err != nil {
-				results <- fmt.Sprintf("%sFailed to fetch map %s: %v%s", cYel, mapURL.String(), err, cRst)
+				rep.Errors = append(rep.Errors, err.Error())
+				logger.Warn(msgInvalidMap.String(), "map", "inline", "script", scriptURL.String(), "err", err)
+			}
+			if data == nil {
+				continue
+			}
+			key := fmt.Sprintf("inline:%s#%d", scriptURL, i)
+			rep.ExtraMaps = append(rep.ExtraMaps, key)
+			handleMap(data, key, mapOrigin{base: scriptURL.String(), js: jsText, script: scriptURL.String()}, scriptURL, rep, sess)
+			continue
+		}
+		ref := strings.Trim(strings.TrimSpace(c.ref), "\"'")
+		u, err := scriptURL.Parse(ref)
+		if ref == "" || strings.HasPrefix(ref, "data:") || err != nil || tried[u.String()] {
+			continue
+		}
+		mapURL := u.String()
+		tried[mapURL] = true
+		res, err := sess.fetch(mapURL)
+		rep.Attempts = append(rep.Attempts, newAttempt(mapURL, res, err))
+		if err != nil {
+			rep.Errors = append(rep.Errors, err.Error())
+			logger.Warn(msgMapFetchFailed.String(), "url", mapURL, "locator", "all-maps", "err", err)
+			sess.events().failed(mapURL, err)
+			continue
+		}
+		rep.ExtraMaps = append(rep.ExtraMaps, mapURL)
+		handleMap(res.Body, mapURL, mapOrigin{mapURL: mapURL, base: mapURL, js: jsText, modTime: res.LastModified, script: scriptURL.String(), res: &res}, scriptURL, rep, sess)
+	}
+}
+
+// checkIntegrity verifies a script against the integrity attribute of its tag
+// and records the outcome in rep.
+func checkIntegrity(sri string, res fetchResult, rep *scriptReport) {
+	check, err := verifyIntegrity(sri, res.reader())
+	if err != nil {
+		rep.Errors = append(rep.Errors, err.Error())
+		logger.Warn(msgScriptSpoolError.String(), "url", rep.URL, "err", err)
+		return
+	}
+	rep.Integrity = check
+	if check != nil && !check.Match {
+		logger.Warn(msgIntegrityMismatch.String(), "url", rep.URL, "declared", check.Declared, "actual", check.Actual)
+	}
+}
+
+// tryMapCandidates handles the first candidate of a locator that is inline or
+// can be fetched, and reports whether there was one. tried holds the map URLs
+// fetched already for the script; modTime is its Last-Modified, for inline maps.
+func tryMapCandidates(cands []MapCandidate, locator string, tried map[string]bool, jsText string, modTime time.Time, scriptURL *url.URL, rep *scriptReport, sess *crawlSession) bool {
+	for _, c := range cands {
+		if c.Data != nil {
+			rep.MapURL = "inline"
+			handleMap(c.Data, "inline:"+scriptURL.String(), mapOrigin{base: scriptURL.String(), js: jsText, modTime: modTime, script: scriptURL.String()}, scriptURL, rep, sess)
+			return true
+		}
+		if c.URL == nil || tried[c.URL.String()] {
+			continue
+		}
+		mapURL := c.URL.String()
+		tried[mapURL] = true
+		res, err := sess.fetch(mapURL)
+		rep.Attempts = append(rep.Attempts, newAttempt(mapURL, res, err))
+		if err != nil {
+			if c.Guess {
+				logger.Debug(msgMapFetchFailed.String(), "url", mapURL, "locator", locator, "err", err)
 			} else {
-				hostPath := hostPathForURL(rootURL, scriptURL)
-				nwritten, err := processMapBytes(data, outBase, hostPath, beautify, eol, saveMap, mapURL.String())
-				if err != nil {
-					results <- fmt.Sprintf("%sError processing map %s: %v%s", cYel, mapURL.String(), err, cRst)
-				} else {
-					results <- fmt.Sprintf("WRITTEN:%d map for %s", nwritten, mapURL.String())
-				}
-				return
+				rep.Errors = append(rep.Errors, err.Error())
+				logger.Warn(msgMapFetchFailed.String(), "url", mapURL, "locator", locator, "err", err)
+				sess.events().failed(mapURL, err)
 			}
+			continue
 		}
+		rep.MapURL = mapURL
+		handleMap(res.Body, mapURL, mapOrigin{mapURL: mapURL, base: mapURL, js: jsText, modTime: res.LastModified, script: scriptURL.String(), res: &res}, scriptURL, rep, sess)
+		return true
 	}
+	return false
+}
 
-	// 3) try script.js.map
-	tryMapURL := scriptURL.ResolveReference(&url.URL{Path: scriptURL.Path + ".map"})
-	data, err := fetchURLBytes(tryMapURL.String(), userAgent)
+// processWasm extracts the map of a WebAssembly module: the one its
+// sourceMappingURL custom section names, else module.wasm.map when it exists.
+func processWasm(wasmURL *url.URL, res fetchResult, rep *scriptReport, sess *crawlSession) {
+	var ref string
+	var err error
+	if res.Spool != nil {
+		ref, err = wasmSourceMapURL(res.Spool.f, res.Spool.size)
+	} else {
+		ref, err = wasmSourceMapURL(bytes.NewReader(res.Body), int64(len(res.Body)))
+	}
+	if err != nil {
+		rep.Errors = append(rep.Errors, err.Error())
+		logger.Warn(msgWasmError.String(), "url", wasmURL.String(), "err", err)
+		return
+	}
+	var cands []MapCandidate
+	if ref != "" {
+		if u, err := wasmURL.Parse(ref); err == nil {
+			cands = append(cands, MapCandidate{URL: u})
+		}
+	}
+	guess, _ := suffixMapLocator{}.LocateMaps("", wasmURL)
+	if !tryMapCandidates(append(cands, guess...), "wasm", make(map[string]bool), "", res.LastModified, wasmURL, rep, sess) {
+		logger.Warn(msgNoSourcemap.String(), "script", wasmURL.String())
+	}
+}
+
+// handleMap records a discovered map and, unless the session only probes, extracts it.
+// key identifies the map in reports. Both -auth-diff passes record a map once
+// it decodes, whether it extracts or not, and only the extracting one scores it.
+func handleMap(data []byte, key string, origin mapOrigin, scriptURL *url.URL, rep *scriptReport, sess *crawlSession) {
+	sm, err := decodeSourceMap(data, origin.base, sess.fetchBody)
 	if err == nil {
-		hostPath := hostPathForURL(rootURL, scriptURL)
-		nwritten, err := processMapBytes(data, outBase, hostPath, beautify, eol, saveMap, tryMapURL.String())
+		sess.recordMap(key, scriptURL)
+	}
+	if sess.probeOnly {
 		if err != nil {
-			results <- fmt.Sprintf("%sError processing map %s: %v%s", cYel, tryMapURL.String(), err, cRst)
-		} else {
-			results <- fmt.Sprintf("WRITTEN:%d map for %s", nwritten, tryMapURL.String())
+			rep.Errors = append(rep.Errors, err.Error())
+			logger.Warn(msgInvalidMap.String(), "map", key, "err", err)
+			return
 		}
+		logger.Info(msgMapFound.String(), "map", key, "script", scriptURL.String())
 		return
 	}
+	rep.MapBytes += int64(len(data))
+	nwritten := 0
+	if err == nil {
+		hostPath := sess.outputDir(scriptURL, sess.perMap)
+		nwritten, err = processMap(sess.ctx, sm, data, sess.outBase, hostPath, sess.output, sess.saveMap, origin)
+	}
+	sess.rawMaps.add(data, key, scriptURL, origin.res, err, sess.output, sess.outBase)
+	rep.Sources += nwritten
+	sess.progress.addWritten(nwritten)
+	sess.tui.written(scriptURL.String(), scriptURL.Hostname(), nwritten)
+	sess.events().addWritten(nwritten)
+	sess.events().mapFound(key, scriptURL.String(), nwritten)
+	if err != nil {
+		rep.Errors = append(rep.Errors, err.Error())
+		logger.Warn(msgMapError.String(), "map", key, "err", err)
+		sess.events().failed(key, err)
+		return
+	}
+	sess.mu.Lock()
+	sess.written++
+	sess.mu.Unlock()
+	if !sess.output.dryRun {
+		logger.Info(msgWritten.String(), "sources", nwritten, "map", key)
+	}
+}
 
-	results <- fmt.Sprintf("%sNo sourcemap for %s%s", cYel, scriptURL.String(), cRst)
+// sniffNonJS returns the detected type of a script body that is clearly not
+// JavaScript (image, HTML page, JSON document, binary), or "" otherwise.
+func sniffNonJS(contentType string, body []byte) string {
+	ct, _, _ := mime.ParseMediaType(contentType)
+	if ct == "application/wasm" || bytes.HasPrefix(body, wasmMagic) {
+		return "application/wasm"
+	}
+	for _, p := range []string{"image/", "audio/", "video/", "font/"} {
+		if strings.HasPrefix(ct, p) {
+			return ct
+		}
+	}
+	b := bytes.TrimLeft(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(b) == 0 {
+		return ""
+	}
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(b))
+	if detected != "text/plain" {
+		return detected
+	}
+	if (b[0] == '{' || b[0] == '[') && json.Valid(b) {
+		return "application/json"
+	}
+	return ""
 }
 
-func fetchURLBytes(u string, userAgent string) ([]byte, error) {
+// fetchResult is the outcome of a single GET, kept for reporting.
+type fetchResult struct {
+	URL          string // after redirects
+	Status       int
+	ContentType  string
+	LastModified time.Time // zero when the header is absent
+	Header       http.Header
+	Body         []byte
+	Spool        *spool // instead of Body for a script of spoolSize or more; the caller closes it
+	Duration     time.Duration
+	Cached       bool // 304 Not Modified, Body comes from -http-cache
+	FetchedAt    time.Time
+	TLS          *tls.ConnectionState // nil over plain HTTP
+}
 
-	req, _ := http.NewRequestWithContext(context.Background(), "GET", u, nil)
-	req.Header.Set("User-Agent", userAgent)
-	resp, err := client.Do(req)
+// size is the length of the body, in memory or spooled.
+func (r fetchResult) size() int64 {
+	if r.Spool != nil {
+		return r.Spool.size
+	}
+	return int64(len(r.Body))
+}
+
+func (r fetchResult) reader() io.Reader {
+	if r.Spool != nil {
+		return r.Spool.reader()
+	}
+	return bytes.NewReader(r.Body)
+}
+
+func (s *crawlSession) fetch(u string) (fetchResult, error) {
+	return s.download(u, false)
+}
+
+// fetchScript is fetch keeping a large body on disk, in res.Spool.
+func (s *crawlSession) fetchScript(u string) (fetchResult, error) {
+	return s.download(u, true)
+}
+
+func (s *crawlSession) download(u string, spooled bool) (res fetchResult, err error) {
+	if pu, err := url.Parse(u); err == nil {
+		if len(s.scope) > 0 && !s.scope.match(pu.Hostname()) {
+			return fetchResult{}, errOutOfScope
+		}
+		if err := s.tui.gate(pu.Hostname()); err != nil {
+			return fetchResult{}, err
+		}
+		defer func() { s.tui.fetched(pu.Hostname(), int(res.size())) }()
+	}
+	res, err = s.http.download(s.ctx, u, spooled)
+	s.progress.addBytes(int(res.size()))
+	s.events().addBytes(int(res.size()))
+	logger.Debug(msgHTTPGet.String(), "url", u, "status", res.Status, "bytes", res.size(), "duration", res.Duration, "cached", res.Cached, "spooled", res.Spool != nil, "err", err)
+	if err == nil {
+		err = res.decodeText()
+	}
+	return res, err
+}
+
+// fetchBody fetches u within the session and returns only the body.
+func (s *crawlSession) fetchBody(u string) ([]byte, error) {
+	res, err := s.fetch(u)
+	return res.Body, err
+}
+
+// get downloads u with the client, headers and host budgets of c.
+func (c *crawler) get(ctx context.Context, u string) (fetchResult, error) {
+	return c.download(ctx, u, false)
+}
+
+// download is get; with spooled set, a body of spoolSize or more goes to
+// res.Spool instead of res.Body.
+func (c *crawler) download(ctx context.Context, u string, spooled bool) (fetchResult, error) {
+	var res fetchResult
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
-		return nil, err
+		return res, err
+	}
+	host := req.URL.Hostname()
+	release, remaining, err := c.limits.acquire(host)
+	if err != nil {
+		return res, err
+	}
+	if remaining >= 0 {
+		req = req.WithContext(withBodyLimit(ctx, remaining+1)) // what is read below
+	}
+	defer func() {
+		if res.Cached {
+			release(0) // not downloaded again
+			c.metrics.request(host, http.StatusNotModified, 0, res.Duration)
+			c.stats.request(host, http.StatusNotModified, 0, res.Duration, true)
+		} else {
+			release(res.size())
+			c.metrics.request(host, res.Status, res.size(), res.Duration)
+			c.stats.request(host, res.Status, res.size(), res.Duration, false)
+		}
+	}()
+
+	start := time.Now()
+	setRequestHeaders(req, c.userAgent, c.headers)
+	cached, cachedPath := c.cache.lookup(u, c.headers)
+	if cached != nil {
+		cached.revalidate(req)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		res.Duration = time.Since(start)
+		return res, err
 	}
 	defer resp.Body.Close()
+	res.URL, res.FetchedAt, res.TLS = u, start, resp.TLS
+	if resp.Request != nil {
+		res.URL = resp.Request.URL.String()
+	}
+	res.Status = resp.StatusCode
+	res.Header = resp.Header
+	res.ContentType = resp.Header.Get("Content-Type")
+	res.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		res.Status = http.StatusOK
+		res.ContentType = cached.ContentType
+		res.LastModified, _ = http.ParseTime(cached.LastModified)
+		if spooled {
+			res.Body, res.Spool, err = openSpooled(cachedPath)
+		} else {
+			res.Body, err = os.ReadFile(cachedPath)
+		}
+		res.Cached = err == nil
+		res.Duration = time.Since(start)
+		return res, err
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("HTTP %s", resp.Status)
+		res.Duration = time.Since(start)
+		return res, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	var body io.Reader = resp.Body
+	if remaining >= 0 {
+		body = io.LimitReader(resp.Body, remaining+1)
+	}
+	if spooled {
+		res.Body, res.Spool, err = readSpooled(body)
+	} else {
+		res.Body, err = io.ReadAll(body)
+	}
+	res.Duration = time.Since(start)
+	if err == nil && remaining >= 0 && res.size() > remaining {
+		if res.Spool != nil {
+			res.Spool.size = remaining
+		} else {
+			res.Body = res.Body[:remaining]
+		}
+		return res, errBudgetExhausted
+	}
+	if err == nil {
+		c.cache.store(u, c.headers, resp, res.reader())
+	}
+	return res, err
+}
+
+func setRequestHeaders(req *http.Request, userAgent string, headers http.Header) {
+	req.Header.Set("User-Agent", userAgent)
+	for k, vs := range headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
 	}
-	return io.ReadAll(resp.Body)
 }
 
+// hostPathForURL is the directory of a script below the output: its host, with
+// the port when it is not the one of the root page ("example.com_8443"), then
+// the directory of its path.
 func hostPathForURL(rootURL, scriptURL *url.URL) string {
 	host := scriptURL.Hostname()
+	if p := scriptURL.Port(); p != "" && p != rootURL.Port() {
+		host += "_" + p
+	}
 	dir := filepath.Dir(scriptURL.Path)
 	if dir == "." || dir == "/" {
 		dir = ""
@@ -320,31 +1226,134 @@ func hostPathForURL(rootURL, scriptURL *url.URL) string {
 	return filepath.Join(host, dir)
 }
 
-func processMapBytes(mapData []byte, outBase, hostPath string, beautify bool, eol string, saveMap bool, mapURL string) (int, error) {
-	var sm sourceMap
-	if err := json.Unmarshal(mapData, &sm); err != nil {
+// bundleName is the folder of a script with -layout per-map: its file name
+// without the .js extension and the urlSuffix of its URL ("main.3f2a.js"
+// gives "main.3f2a~5d41402a").
+func bundleName(scriptURL *url.URL) string {
+	name := strings.TrimSuffix(path.Base(scriptURL.Path), ".js")
+	if name == "" || name == "." || name == "/" {
+		name = "script"
+	}
+	return sanitizeSegments(name, nil) + urlSuffix(scriptURL)
+}
+
+// urlSuffix returns "~" and a short hash of the full URL u. Files saved from u
+// are named after its base name, which alone does not tell URLs apart: not
+// http from https, "/a/app.js?v=2" from "/a/app.js", nor "/A/app.js" from
+// "/a/app.js" on a case-insensitive filesystem. The hash always goes with it,
+// so that every URL gets its own name, the same from one run to the next.
+func urlSuffix(u *url.URL) string {
+	sum := sha256.Sum256([]byte(u.String()))
+	return "~" + hex.EncodeToString(sum[:4])
+}
+
+// assetFileName names a script or map saved from u (-save-js, -save-map): its
+// base name, fallback when it has none, with the urlSuffix of u before the
+// extension ("app~3f2a9c1b.js").
+func assetFileName(u *url.URL, fallback string) string {
+	return withNameSuffix(urlBaseName(u.Path, fallback), urlSuffix(u))
+}
+
+// urlBaseName returns the sanitized base name of a URL or URL path, or fallback.
+func urlBaseName(p, fallback string) string {
+	if pu, err := url.Parse(p); err == nil && pu.Scheme != "" {
+		p = pu.Path
+	}
+	name := path.Base(p)
+	if name == "" || name == "." || name == "/" || strings.HasSuffix(p, "/") {
+		name = fallback
+	}
+	return sanitizeSegments(name, nil)
+}
+
+// withNameSuffix inserts suffix before the extension of name, a double one for
+// maps ("app.js.map" gives "app<suffix>.js.map").
+func withNameSuffix(name, suffix string) string {
+	ext := path.Ext(name)
+	if ext == ".map" {
+		ext = path.Ext(strings.TrimSuffix(name, ext)) + ext
+	}
+	if ext == name {
+		ext = ""
+	}
+	return strings.TrimSuffix(name, ext) + suffix + ext
+}
+
+// mapOrigin describes where a map being extracted comes from.
+type mapOrigin struct {
+	mapURL  string       // empty for inline maps
+	base    string       // location section URLs are resolved against
+	js      string       // generated script, for -reconstruct
+	modTime time.Time    // Last-Modified of the map, zero when unknown
+	script  string       // URL of the script referencing the map
+	res     *fetchResult // response of the map, nil for inline maps
+}
+
+// processMapBytes extracts one map under outBase/hostPath; fetch loads the
+// sections of an index map.
+func processMapBytes(ctx context.Context, mapData []byte, outBase, hostPath string, out *outputOptions, saveMap bool, origin mapOrigin, fetch func(string) ([]byte, error)) (int, error) {
+	sm, err := decodeSourceMap(mapData, origin.base, fetch)
+	if err != nil {
 		return 0, err
 	}
+	return processMap(ctx, sm, mapData, outBase, hostPath, out, saveMap, origin)
+}
+
+// processMap is processMapBytes once mapData is decoded into sm.
+func processMap(ctx context.Context, sm SourceMap, mapData []byte, outBase, hostPath string, out *outputOptions, saveMap bool, origin mapOrigin) (int, error) {
+	if out.reconstructs() {
+		if n, err := reconstructMissing(&sm, origin.js, out.prettyBuild); err != nil {
+			logger.Warn(msgReconstructError.String(), "map", origin.base, "err", err)
+		} else if n > 0 {
+			logger.Info(msgReconstructed.String(), "map", origin.base, "sources", n)
+		}
+	}
 	outRoot := filepath.Join(outBase, hostPath)
-	_ = os.MkdirAll(outRoot, 0755)
 
 	// optional: save map file
 	if saveMap {
-		mapName := "sourcemap.json"
-		if mapURL != "" {
-			mapName = filepath.Base(mapURL)
-			if mapName == "" {
-				mapName = "sourcemap.json"
-			}
+		var mapName string
+		if u, err := url.Parse(origin.mapURL); err == nil && origin.mapURL != "" {
+			mapName = assetFileName(u, "sourcemap.json")
+		} else {
+			// inline maps, several with -all-maps, are told apart by content
+			sum := sha256.Sum256(mapData)
+			mapName = withNameSuffix(urlBaseName(origin.script, "script.js")+".map", ".inline~"+hex.EncodeToString(sum[:4]))
+		}
+		if dst := filepath.Join(outRoot, mapName); out.writeFile(dst, mapData) == nil {
+			out.writeAssetMeta(dst, mapData, origin.res)
 		}
-		_ = os.WriteFile(filepath.Join(outRoot, mapName), mapData, 0644)
 	}
 
-	maxUp := computeMaxLeadingUpsFiltered(sm)
-	baseAnchor, subAnchor := buildAnchors(outRoot, maxUp)
+	return writeMapSources(ctx, sm, outRoot, out, origin)
+}
+
+// writeMapSources writes the sources of a decoded map under outRoot and
+// returns the number of files written; it stops with ctx.Err() when ctx is done.
+func writeMapSources(ctx context.Context, sm SourceMap, outRoot string, out *outputOptions, origin mapOrigin) (int, error) {
+	out.fingerprint.addMap(&sm)
+	out.deps.addMap(&sm)
+	if origin.mapURL != "" {
+		out.scores.addMap(origin.mapURL, &sm)
+	} else {
+		out.scores.addMap("inline:"+origin.script, &sm)
+	}
+	if filtered := out.filter.apply(&sm); len(filtered) > 0 {
+		logger.Debug(msgSkippedFiltered.String(), "map", origin.base, "sources", len(filtered))
+	}
+	if out.namespaces {
+		keepNamespaces(&sm)
+	}
+	if out.inferExt {
+		inferExtensions(&sm)
+	}
+	anchor := newSourceAnchor(outRoot, &sm)
 
 	written := 0
 	for i, src := range sm.Sources {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
 		content := ""
 		if i < len(sm.SourcesContent) {
 			content = sm.SourcesContent[i]
@@ -352,22 +1361,25 @@ func processMapBytes(mapData []byte, outBase, hostPath string, beautify bool, eo
 		if strings.TrimSpace(content) == "" {
 			continue
 		}
-		norm := normalizeKeepDots(joinMaybe(sm.SourceRoot, src))
-		_, abs, err := resolveUnderAnchor(outRoot, baseAnchor, subAnchor, norm)
+		abs, err := anchor.place(out, &sm, i)
 		if err != nil {
-			// skip problematic path
+			logger.Warn(msgSkippedBlocked.String(), "source", src, "err", err)
 			continue
 		}
-		if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
-			return written, err
-		}
-		if beautify {
-			content = beautifyBasic(content)
+		mapRef := origin.mapURL
+		if mapRef == "" {
+			mapRef = "inline"
 		}
-		content = normalizeEOL(content, eol)
-		if err := os.WriteFile(abs, []byte(content), 0644); err != nil {
+		data := []byte(out.render(abs, content))
+		if abs, err = out.writeSource(abs, data, FileMeta{Source: sm.sourceName(i), Map: mapRef, ModTime: origin.modTime}); err != nil {
 			return written, err
 		}
+		if abs == "" {
+			continue
+		}
+		out.manifest.record(out.root, abs, data, provenance{source: sm.sourceName(i), index: i, mapRef: mapRef, script: origin.script})
+		out.events.fileWritten(abs, FileMeta{Source: sm.sourceName(i), Map: mapRef, ModTime: origin.modTime})
+		out.filter.wrote(sm.ResolvedSource(i))
 		written++
 	}
 	return written, nil
@@ -392,9 +1404,30 @@ func parseWeirdJSON(input string) (map[int]string, error) {
 	return out, nil
 }
 
+// rePublicPath matches a literal publicPath assignment of a webpack runtime,
+// __webpack_require__.p="https://cdn.example.com/static/" or, minified,
+// r.p="/". Public paths end with a slash, which keeps other .p properties out.
+var rePublicPath = regexp.MustCompile(`[\w$]+\.p\s*=\s*["']([^"'\s]*/)["']`)
+
+// webpackPublicPath returns the publicPath set by the webpack runtime in js,
+// resolved against the page, or nil when there is none. The automatic
+// publicPath of webpack 5 is the directory of the script.
+func webpackPublicPath(js string, scriptURL, rootURL *url.URL) *url.URL {
+	if m := rePublicPath.FindStringSubmatch(js); m != nil {
+		if u, err := rootURL.Parse(m[1]); err == nil {
+			return u
+		}
+	}
+	if strings.Contains(js, "Automatic publicPath is not supported") {
+		return scriptURL.ResolveReference(&url.URL{Path: "./"})
+	}
+	return nil
+}
+
 // findChunkURLsReturnPattern looks for patterns like:
 // return "static/js/"+e+"."+{20:"493d026d",21:"5f0ee513",...}[e]+".chunk.js"
-// It extracts the prefix, the index variable name, the {id:"hash"} object, and builds full chunk URLs.
+// It extracts the prefix, the index variable name, the {id:"hash"} object, and builds full chunk URLs,
+// relative to the webpack publicPath when the runtime sets one, else to the page.
 func findChunkURLsReturnPattern(jsText string, scriptURL *url.URL, rootURL *url.URL) []*url.URL {
 	if !strings.Contains(jsText, ".chunk.js") {
 		return nil
@@ -404,6 +1437,10 @@ func findChunkURLsReturnPattern(jsText string, scriptURL *url.URL, rootURL *url.
 	if len(matches) == 0 {
 		return nil
 	}
+	base := rootURL
+	if pp := webpackPublicPath(jsText, scriptURL, rootURL); pp != nil {
+		base = pp
+	}
 
 	var out []*url.URL
 
@@ -440,7 +1477,7 @@ func findChunkURLsReturnPattern(jsText string, scriptURL *url.URL, rootURL *url.
 				continue
 			}
 
-			resolved := rootURL.ResolveReference(u)
+			resolved := base.ResolveReference(u)
 
 			// Si schema/host absents, batir depuis le dossier du script
 			if resolved.Scheme == "" || resolved.Host == "" {
@@ -480,23 +1517,3 @@ func findChunkURLsReturnPattern(jsText string, scriptURL *url.URL, rootURL *url.
 	}
 	return out
 }
-
-// ------------------------------------------------------------------
-// Path / anchor helpers (same logic as earlier safe version)
-// ------------------------------------------------------------------
-
-func computeMaxLeadingUpsFiltered(sm sourceMap) int {
-	maxUp := 0
-	for i, s := range sm.Sources {
-		if i < len(sm.SourcesContent) {
-			if strings.TrimSpace(sm.SourcesContent[i]) == "" {
-				continue
-			}
-		}
-		p := normalizeKeepDots(joinMaybe(sm.SourceRoot, s))
-		if n := countLeadingUps(p); n > maxUp {
-			maxUp = n
-		}
-	}
-	return maxUp
-}
@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// dedupStore remembers the first path written for each content hash, so that a
+// module repeated in many chunk maps is stored once (-dedup).
+type dedupStore struct {
+	mode string // "skip" or "hardlink"
+
+	mu     sync.Mutex
+	first  map[[sha256.Size]byte]string
+	unique int
+	dups   int
+	saved  int64
+}
+
+// dedupCounts is the summary written to the log and report.json.
+type dedupCounts struct {
+	Mode       string `json:"mode"`
+	Unique     int    `json:"unique"`
+	Duplicates int    `json:"duplicates"`
+	BytesSaved int64  `json:"bytes_saved"`
+}
+
+// dedupMode is the -dedup flag value; it creates the store when enabled.
+type dedupMode struct{ store **dedupStore }
+
+func (d dedupMode) String() string {
+	if d.store == nil || *d.store == nil {
+		return ""
+	}
+	return (*d.store).mode
+}
+
+func (d dedupMode) Set(v string) error {
+	switch strings.ToLower(v) {
+	case "", "off":
+		*d.store = nil
+	case "skip", "hardlink":
+		*d.store = &dedupStore{mode: strings.ToLower(v), first: make(map[[sha256.Size]byte]string)}
+	default:
+		return fmt.Errorf("invalid -dedup %q (off|skip|hardlink)", v)
+	}
+	return nil
+}
+
+// seen records data for dst and returns the path already holding the same
+// content, or "" when dst is the first one.
+func (d *dedupStore) seen(dst string, data []byte) string {
+	sum := sha256.Sum256(data)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if prev, ok := d.first[sum]; ok && prev != dst {
+		d.dups++
+		d.saved += int64(len(data))
+		return prev
+	}
+	if _, ok := d.first[sum]; !ok {
+		d.first[sum] = dst
+		d.unique++
+	}
+	return ""
+}
+
+func (d *dedupStore) counts() *dedupCounts {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return &dedupCounts{Mode: d.mode, Unique: d.unique, Duplicates: d.dups, BytesSaved: d.saved}
+}
+
+func (d *dedupStore) logSummary() {
+	if c := d.counts(); c != nil {
+		logger.Info(msgDedupSummary.String(), "mode", c.Mode, "unique", c.Unique, "duplicates", c.Duplicates, "saved", humanBytes(c.BytesSaved))
+	}
+}
+
+// writeSource writes a recovered source after applying -on-conflict, then the
+// -dedup store: a content already written elsewhere is skipped or hardlinked to
+// the first copy (copied when the sink or filesystem refuses links). It returns
+// the path used, which differs from dst with -on-conflict suffix, or "" when the
+// conflict policy dropped the file. meta.ModTime is the date of the map, if known.
+// Only files actually written or linked go to the analyzers.
+func (o *outputOptions) writeSource(dst string, data []byte, meta FileMeta) (string, error) {
+	if dst = o.conflicts.resolve(dst, data, meta.ModTime, o.portable || caseInsensitiveFS()); dst == "" {
+		return "", nil
+	}
+	prev := ""
+	if o.dedup != nil {
+		prev = o.dedup.seen(dst, data)
+	}
+	if prev == "" {
+		if err := o.writeFileMeta(dst, data, meta); err != nil {
+			return dst, err
+		}
+		o.analyze(dst, data, meta)
+		return dst, nil
+	}
+	logger.Debug(msgDuplicateSource.String(), "path", dst, "same_as", prev)
+	if o.dedup.mode == "skip" {
+		return dst, nil
+	}
+	if o.dryRun {
+		o.analyze(dst, data, meta)
+		return dst, nil
+	}
+	relPrev, err := filepath.Rel(o.root, prev)
+	if err != nil {
+		return dst, err
+	}
+	rel, err := filepath.Rel(o.root, dst)
+	if err != nil {
+		return dst, err
+	}
+	if err := o.putLinked(rel, relPrev, data, meta); err != nil {
+		return dst, err
+	}
+	o.printPath(dst)
+	o.analyze(dst, data, meta)
+	return dst, nil
+}
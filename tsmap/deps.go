@@ -0,0 +1,222 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"flag"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const depsFile = "package.json"
+
+// weight of each way of finding a version; the heaviest version wins.
+const (
+	depVersionEmbedded    = 1    // version string in the package code
+	depVersionPnpm        = 100  // node_modules/.pnpm/name@version/ path
+	depVersionPackageJSON = 1000 // node_modules/name/package.json bundled in the map
+)
+
+var (
+	reNpmName = regexp.MustCompile(`^(?:@[a-z0-9-~][a-z0-9-._~]*/)?[a-z0-9-~][a-z0-9-._~]*$`)
+	// import x from "m", export * from "m", import "m", import("m"), require("m")
+	reImport = regexp.MustCompile(`(?:\bfrom\s*|\bimport\s*\(?\s*|\brequire\(\s*)["']([^"'\s]+)["']`)
+	// var ReactVersion = '18.2.0', exports.version = "3.3.4", VERSION: "1.2.3"
+	reEmbeddedVersion = regexp.MustCompile(`\b[A-Za-z_$]*(?:version|Version|VERSION)\b["']?\s*[:=]\s*["'](\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?)["']`)
+)
+
+// dependency is one package with the versions seen for it.
+type dependency struct {
+	versions map[string]int // version -> weight
+	imported bool
+}
+
+// depScanner rebuilds an approximate package.json of the target (-deps) from
+// the node_modules paths of every map and the imports of first-party code.
+// Crawl workers call addMap and scan concurrently. A nil scanner does nothing.
+type depScanner struct {
+	enabled bool
+
+	mu   sync.Mutex
+	deps map[string]*dependency
+}
+
+func addDepsFlags(fs *flag.FlagSet) *depScanner {
+	s := &depScanner{}
+	fs.BoolVar(&s.enabled, "deps", false, "Rebuild an approximate package.json of the dependencies seen in node_modules paths and imports, with versions when found")
+	return s
+}
+
+func (s *depScanner) get(name string) *dependency {
+	if s.deps == nil {
+		s.deps = make(map[string]*dependency)
+	}
+	d := s.deps[name]
+	if d == nil {
+		d = &dependency{versions: make(map[string]int)}
+		s.deps[name] = d
+	}
+	return d
+}
+
+// packageOf splits a source path under node_modules into the package name and
+// the path inside the package; pnpm paths also give the version.
+func packageOf(src string) (name, inner, version string) {
+	i := strings.LastIndex(src, "node_modules/")
+	if i < 0 {
+		return "", "", ""
+	}
+	var pnpmName string
+	if j := strings.Index(src, "node_modules/.pnpm/"); j >= 0 {
+		// "@scope+pkg@1.2.3_peer@4.5.6" or "@scope+pkg@1.2.3(peer@4.5.6)"
+		seg := strings.SplitN(src[j+len("node_modules/.pnpm/"):], "/", 2)[0]
+		seg = strings.SplitN(seg, "(", 2)[0]
+		if at := strings.Index(seg[1:], "@"); at >= 0 {
+			pnpmName = strings.ReplaceAll(seg[:at+1], "+", "/")
+			version = strings.SplitN(seg[at+2:], "_", 2)[0]
+		}
+	}
+	parts := strings.SplitN(src[i+len("node_modules/"):], "/", 3)
+	switch {
+	case strings.HasPrefix(parts[0], "@") && len(parts) >= 2:
+		name = parts[0] + "/" + parts[1]
+		if len(parts) == 3 {
+			inner = parts[2]
+		}
+	default:
+		name = parts[0]
+		inner = strings.Join(parts[1:], "/")
+	}
+	if !reNpmName.MatchString(name) {
+		return "", "", ""
+	}
+	if name != pnpmName {
+		version = ""
+	}
+	return name, inner, version
+}
+
+// addMap records the packages of every source of sm, filtered ones included.
+func (s *depScanner) addMap(sm *SourceMap) {
+	if s == nil || !s.enabled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, src := range sm.Sources {
+		name, inner, version := packageOf(sourcePathOnly(joinMaybe(sm.SourceRoot, src)))
+		if name == "" {
+			continue
+		}
+		d := s.get(name)
+		if version != "" {
+			d.versions[version] += depVersionPnpm
+		}
+		if !sm.HasContent(i) {
+			continue
+		}
+		content, err := sm.content(i)
+		if err != nil {
+			continue
+		}
+		if inner == "package.json" {
+			var pkg struct {
+				Version string `json:"version"`
+			}
+			if json.Unmarshal([]byte(content), &pkg) == nil && pkg.Version != "" {
+				d.versions[pkg.Version] += depVersionPackageJSON
+			}
+			continue
+		}
+		for _, m := range reEmbeddedVersion.FindAllStringSubmatch(content, -1) {
+			d.versions[m[1]] += depVersionEmbedded
+		}
+	}
+}
+
+// scan records the bare imports of one written file; vendor code is skipped.
+func (s *depScanner) scan(rel string, data []byte, meta FileMeta) {
+	if s == nil || !s.enabled || isVendorSource(filterPath(rel)) || (meta.Source != "" && isVendorSource(filterPath(meta.Source))) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range reImport.FindAllStringSubmatch(string(data), -1) {
+		if name := importedPackage(m[1]); name != "" {
+			s.get(name).imported = true
+		}
+	}
+}
+
+// importedPackage returns the package of a bare module specifier ("lodash/get"
+// gives "lodash"), or "" for relative paths, URLs, node: builtins and aliases
+// such as "@/components" or "~/utils".
+func importedPackage(spec string) string {
+	if strings.Contains(spec, ":") {
+		return ""
+	}
+	parts := strings.SplitN(spec, "/", 3)
+	name := parts[0]
+	if strings.HasPrefix(name, "@") {
+		if len(parts) < 2 {
+			return ""
+		}
+		name += "/" + parts[1]
+	}
+	if !reNpmName.MatchString(name) || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~") {
+		return ""
+	}
+	return name
+}
+
+// version returns the best version of d, or "*" when none was found.
+func (d *dependency) version() string {
+	best, weight := "*", 0
+	for _, v := range sortedKeys(d.versions) {
+		if d.versions[v] > weight {
+			best, weight = v, d.versions[v]
+		}
+	}
+	return best
+}
+
+// save writes package.json in the output root.
+func (s *depScanner) save(o *outputOptions) {
+	if s == nil || !s.enabled {
+		return
+	}
+	s.mu.Lock()
+	deps := make(map[string]string, len(s.deps))
+	versioned, imported := 0, 0
+	for name, d := range s.deps {
+		deps[name] = d.version()
+		if deps[name] != "*" {
+			versioned++
+		}
+		if d.imported {
+			imported++
+		}
+	}
+	s.mu.Unlock()
+	logger.Info(msgDepsSummary.String(), "dependencies", len(deps), "with_version", versioned, "imported", imported)
+	if o.dryRun {
+		return
+	}
+	pkg := struct {
+		Name         string            `json:"name"`
+		Private      bool              `json:"private"`
+		Description  string            `json:"description"`
+		Dependencies map[string]string `json:"dependencies"`
+	}{
+		Name:         "recovered-sources",
+		Private:      true,
+		Description:  "Approximate dependencies rebuilt by tsmap-extract from source maps",
+		Dependencies: deps,
+	}
+	data, _ := json.MarshalIndent(pkg, "", "  ")
+	if err := o.writeMeta(depsFile, append(data, '\n')); err != nil {
+		logger.Warn(msgDepsError.String(), "err", err)
+	}
+}
@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ChunkDetector finds additional scripts (lazy chunks, workers...) referenced by a
+// downloaded script. Each returned URL is crawled like a script found on the page.
+type ChunkDetector interface {
+	Name() string
+	DetectChunks(js string, scriptURL, rootURL *url.URL) []*url.URL
+}
+
+// MapLocator proposes sourcemap locations for a downloaded script. Candidates are
+// tried in locator registration order; the first one that can be fetched is used.
+type MapLocator interface {
+	Name() string
+	LocateMaps(js string, scriptURL *url.URL) ([]MapCandidate, error)
+}
+
+// MapCandidate is either a URL to fetch or the map content itself (inline maps).
+type MapCandidate struct {
+	URL   *url.URL
+	Data  []byte
+	Guess bool // speculative location: a failed fetch is only logged at debug level
+}
+
+var (
+	detectorsMu    sync.RWMutex
+	chunkDetectors []ChunkDetector
+	mapLocators    []MapLocator
+)
+
+// RegisterChunkDetector adds d after the built-in detectors. It is safe to call
+// from init functions of packages embedding tsmap.
+func RegisterChunkDetector(d ChunkDetector) {
+	detectorsMu.Lock()
+	defer detectorsMu.Unlock()
+	chunkDetectors = append(chunkDetectors, d)
+}
+
+// RegisterMapLocator adds l after the built-in locators.
+func RegisterMapLocator(l MapLocator) {
+	detectorsMu.Lock()
+	defer detectorsMu.Unlock()
+	mapLocators = append(mapLocators, l)
+}
+
+func registeredChunkDetectors() []ChunkDetector {
+	detectorsMu.RLock()
+	defer detectorsMu.RUnlock()
+	return append([]ChunkDetector(nil), chunkDetectors...)
+}
+
+func registeredMapLocators() []MapLocator {
+	detectorsMu.RLock()
+	defer detectorsMu.RUnlock()
+	return append([]MapLocator(nil), mapLocators...)
+}
+
+func init() {
+	RegisterChunkDetector(returnPatternDetector{})
+	RegisterChunkDetector(wasmDetector{})
+	RegisterMapLocator(inlineMapLocator{})
+	RegisterMapLocator(commentMapLocator{})
+	RegisterMapLocator(suffixMapLocator{})
+}
+
+// ---------- built-ins ----------
+
+// returnPatternDetector handles 'return "..."+e+"."+{...}[e]+".chunk.js"' (webpack 4 / CRA).
+type returnPatternDetector struct{}
+
+func (returnPatternDetector) Name() string { return "return-pattern" }
+
+func (returnPatternDetector) DetectChunks(js string, scriptURL, rootURL *url.URL) []*url.URL {
+	return findChunkURLsReturnPattern(js, scriptURL, rootURL)
+}
+
+// inlineMapLocator decodes "sourceMappingURL=data:application/json;base64,...".
+type inlineMapLocator struct{}
+
+func (inlineMapLocator) Name() string { return "inline" }
+
+func (inlineMapLocator) LocateMaps(js string, _ *url.URL) ([]MapCandidate, error) {
+	ref, at := sourceMapComment(js)
+	if at < 0 {
+		return nil, nil
+	}
+	data, err := decodeInlineMap(ref)
+	if data == nil {
+		return nil, err
+	}
+	return []MapCandidate{{Data: data}}, nil
+}
+
+// decodeInlineMap decodes the map of a "data:application/json;base64,"
+// sourceMappingURL value; it returns nil for other values.
+func decodeInlineMap(ref string) ([]byte, error) {
+	n := inlineMapPrefix(ref)
+	if n < 0 {
+		return nil, nil
+	}
+	payload := ref[n:]
+	if i := strings.IndexFunc(payload, func(r rune) bool { return r > 0x7f || !isBase64Char(byte(r)) }); i >= 0 {
+		payload = payload[:i]
+	}
+	if payload == "" {
+		return nil, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("inline map: %w", err)
+	}
+	return data, nil
+}
+
+// commentMapLocator resolves a "//# sourceMappingURL=..." comment against the script URL.
+type commentMapLocator struct{}
+
+func (commentMapLocator) Name() string { return "comment" }
+
+func (commentMapLocator) LocateMaps(js string, scriptURL *url.URL) ([]MapCandidate, error) {
+	ref, at := sourceMapComment(js)
+	if at < 0 {
+		return nil, nil
+	}
+	ref = strings.Trim(strings.TrimSpace(ref), "\"'")
+	if ref == "" || strings.HasPrefix(ref, "data:") {
+		return nil, nil // handled (or rejected) by the inline locator
+	}
+	mapURL, err := scriptURL.Parse(ref)
+	if err != nil {
+		return nil, nil
+	}
+	return []MapCandidate{{URL: mapURL}}, nil
+}
+
+const sourceMapMarker = "sourceMappingURL"
+
+// sourceMapTail is the end of a script searched first for its sourceMappingURL
+// comment, which bundlers write on the last line.
+const sourceMapTail = 4 << 10
+
+// sourceMapComment returns the value of the last "//# sourceMappingURL=" (or
+// "//@", or "/*# ... */" in CSS) comment of js, up to the end of its line, and the offset of that
+// value; -1 when there is none. The tail is searched backwards first; only a
+// script without comment there is searched whole, with strings.Index rather
+// than a regexp.
+func sourceMapComment(js string) (string, int) {
+	tail := max(0, len(js)-sourceMapTail)
+	for end := len(js); end > tail; {
+		i := strings.LastIndex(js[tail:end], sourceMapMarker)
+		if i < 0 {
+			break
+		}
+		if ref, at := sourceMapCommentAt(js, tail+i); at >= 0 {
+			return ref, at
+		}
+		end = tail + i
+	}
+	// markers starting before the tail; those in it were rejected above
+	ref, at := "", -1
+	for from := 0; from < tail; {
+		i := strings.Index(js[from:], sourceMapMarker)
+		if i < 0 || from+i >= tail {
+			break
+		}
+		if r, a := sourceMapCommentAt(js, from+i); a >= 0 {
+			ref, at = r, a
+		}
+		from += i + len(sourceMapMarker)
+	}
+	return ref, at
+}
+
+// mapComment is one sourceMappingURL comment of a script: its value and
+// where that starts.
+type mapComment struct {
+	ref string
+	at  int64
+}
+
+// sourceMapComments returns every sourceMappingURL comment of js, in order.
+func sourceMapComments(js string) []mapComment {
+	var out []mapComment
+	for from := 0; ; {
+		i := strings.Index(js[from:], sourceMapMarker)
+		if i < 0 {
+			return out
+		}
+		if ref, at := sourceMapCommentAt(js, from+i); at >= 0 {
+			out = append(out, mapComment{ref: ref, at: int64(at)})
+		}
+		from += i + len(sourceMapMarker)
+	}
+}
+
+// sourceMapCommentAt checks that the marker at js[i:] is preceded by "//#",
+// "//@" or "/*#" and followed by "=", blanks allowed around them, and returns
+// the rest of the line, up to "*/" for a block comment, and its offset, or -1.
+func sourceMapCommentAt(js string, i int) (string, int) {
+	j := i
+	for j > 0 && (js[j-1] == ' ' || js[j-1] == '\t') {
+		j--
+	}
+	if j < 3 || (js[j-3:j-1] != "//" && js[j-3:j-1] != "/*") || (js[j-1] != '#' && js[j-1] != '@') {
+		return "", -1
+	}
+	block := js[j-2] == '*'
+	k := i + len(sourceMapMarker)
+	for k < len(js) && (js[k] == ' ' || js[k] == '\t') {
+		k++
+	}
+	if k == len(js) || js[k] != '=' {
+		return "", -1
+	}
+	k++
+	for k < len(js) && (js[k] == ' ' || js[k] == '\t') {
+		k++
+	}
+	end := len(js)
+	if n := strings.IndexByte(js[k:], '\n'); n >= 0 {
+		end = k + n
+	}
+	if n := strings.Index(js[k:end], "*/"); block && n >= 0 {
+		end = k + len(strings.TrimRight(js[k:k+n], " \t"))
+	}
+	if k == end {
+		return "", -1
+	}
+	return js[k:end], k
+}
+
+// inlineMapPrefix returns the length of the "data:application/json;base64,"
+// prefix of a sourceMappingURL value, charset parameter included, or -1.
+func inlineMapPrefix(ref string) int {
+	const mediaType, charset, base64Param = "data:application/json", ";charset=", ";base64,"
+	if !strings.HasPrefix(ref, mediaType) {
+		return -1
+	}
+	n := len(mediaType)
+	if strings.HasPrefix(ref[n:], charset) {
+		end := strings.IndexByte(ref[n+len(charset):], ';')
+		if end <= 0 {
+			return -1
+		}
+		n += len(charset) + end
+	}
+	if !strings.HasPrefix(ref[n:], base64Param) {
+		return -1
+	}
+	return n + len(base64Param)
+}
+
+// suffixMapLocator tries script.js.map.
+type suffixMapLocator struct{}
+
+func (suffixMapLocator) Name() string { return "suffix" }
+
+func (suffixMapLocator) LocateMaps(_ string, scriptURL *url.URL) ([]MapCandidate, error) {
+	return []MapCandidate{{URL: scriptURL.ResolveReference(&url.URL{Path: scriptURL.Path + ".map"}), Guess: true}}, nil
+}
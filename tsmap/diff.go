@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type diffFlags struct {
+	unified bool
+	context int
+	json    bool
+	log     *logOptions
+}
+
+func newDiffFlags() (*flag.FlagSet, *diffFlags) {
+	f := &diffFlags{}
+	fs := flag.NewFlagSet("tsmap-extract diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tsmap-extract diff [flags] OLD NEW")
+		fmt.Fprintln(fs.Output(), "OLD and NEW are output directories or manifest.json files.")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&f.unified, "u", false, "Print a unified diff of every modified file")
+	fs.IntVar(&f.context, "context", 3, "Lines of context in unified diffs")
+	fs.BoolVar(&f.json, "json", false, "Print the changes as a JSON array")
+	fs.String("config", "", "YAML config file (default ~/.config/tsmap-extract/config.yaml)")
+	f.log = addLogFlags(fs)
+	return fs, f
+}
+
+// diffChange is one file that differs between two outputs.
+type diffChange struct {
+	Status string `json:"status"` // "added", "removed" or "modified"
+	Path   string `json:"path"`
+	Old    string `json:"old_sha256,omitempty"`
+	New    string `json:"new_sha256,omitempty"`
+}
+
+// diffSide is one output being compared: the SHA-256 of every file, by path,
+// and the directory holding their content.
+type diffSide struct {
+	root   string
+	hashes map[string]string
+}
+
+// RunDiff runs the "diff" subcommand: it exits 0 when both outputs hold the same
+// files, 1 when files were added, removed or modified and 3 when an output
+// cannot be read.
+func RunDiff(args []string) int {
+	useConsoleLogger()
+	fs, f := newDiffFlags()
+	loadDefaults(fs, "diff", args)
+	fs.Parse(args)
+	if f.json {
+		f.log.console = os.Stderr
+	}
+	defer f.log.setup()()
+
+	if fs.NArg() != 2 {
+		logger.Error(msgDiffArgs.String())
+		fs.Usage()
+		return exitUsage
+	}
+	oldSide, err := loadDiffSide(fs.Arg(0))
+	if err != nil {
+		fail(msgDiffRead, err)
+	}
+	newSide, err := loadDiffSide(fs.Arg(1))
+	if err != nil {
+		fail(msgDiffRead, err)
+	}
+	changes := compareSides(oldSide, newSide)
+
+	if f.json {
+		if changes == nil {
+			changes = []diffChange{}
+		}
+		data, _ := json.MarshalIndent(changes, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		counts := map[string]int{}
+		for _, c := range changes {
+			counts[c.Status]++
+			switch c.Status {
+			case "added":
+				fmt.Printf("%sA%s %s\n", cGrn, cRst, c.Path)
+			case "removed":
+				fmt.Printf("%sD%s %s\n", cRed, cRst, c.Path)
+			default:
+				fmt.Printf("%sM%s %s\n", cYel, cRst, c.Path)
+				if f.unified {
+					printFileDiff(oldSide, newSide, c.Path, f.context)
+				}
+			}
+		}
+		logger.Info(msgDiffSummary.String(), "added", counts["added"], "removed", counts["removed"], "modified", counts["modified"])
+	}
+	if len(changes) > 0 {
+		return exitNoSources
+	}
+	return exitOK
+}
+
+// loadDiffSide reads an output directory, or a manifest.json whose directory
+// supplies the file contents when it is still there.
+func loadDiffSide(p string) (*diffSide, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var entries []manifestEntry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("%s: not a manifest.json: %v", p, err)
+		}
+		side := &diffSide{root: filepath.Dir(p), hashes: make(map[string]string, len(entries))}
+		for _, e := range entries {
+			side.hashes[e.Path] = e.SHA256
+		}
+		return side, nil
+	}
+	side := &diffSide{root: p, hashes: make(map[string]string)}
+	err = filepath.WalkDir(p, func(f string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(p, f)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || isRunFile(rel) {
+			return nil
+		}
+		sum, err := fileSHA256(f)
+		if err != nil {
+			return err
+		}
+		side.hashes[rel] = sum
+		return nil
+	})
+	return side, err
+}
+
+// isRunFile reports whether rel is a file describing the run rather than a
+// recovered file; those always differ between two runs.
+func isRunFile(rel string) bool {
+	switch rel {
+	case "manifest.json", "report.json", "report.html", "report.md", checkpointName, secretsFile, "endpoints.txt", "endpoints.json", envFile, depsFile, tsconfigFile, srcIndexFile, licensesFile, graphDOTFile, graphJSONFile, commentsFile, sarifFile, coordFile:
+		return true
+	}
+	return strings.HasPrefix(rel, sumsFile)
+}
+
+// compareSides lists the changes from old to new, sorted by path.
+func compareSides(oldSide, newSide *diffSide) []diffChange {
+	var out []diffChange
+	for p, sum := range oldSide.hashes {
+		switch n, ok := newSide.hashes[p]; {
+		case !ok:
+			out = append(out, diffChange{Status: "removed", Path: p, Old: sum})
+		case n != sum:
+			out = append(out, diffChange{Status: "modified", Path: p, Old: sum, New: n})
+		}
+	}
+	for p, sum := range newSide.hashes {
+		if _, ok := oldSide.hashes[p]; !ok {
+			out = append(out, diffChange{Status: "added", Path: p, New: sum})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// printFileDiff prints the unified diff of one modified file, when both
+// contents are still on disk and are text.
+func printFileDiff(oldSide, newSide *diffSide, rel string, context int) {
+	oldData, err1 := os.ReadFile(filepath.Join(oldSide.root, filepath.FromSlash(rel)))
+	newData, err2 := os.ReadFile(filepath.Join(newSide.root, filepath.FromSlash(rel)))
+	if err1 != nil || err2 != nil {
+		logger.Warn(msgDiffNoContent.String(), "path", rel)
+		return
+	}
+	if bytes.IndexByte(oldData, 0) >= 0 || bytes.IndexByte(newData, 0) >= 0 {
+		fmt.Println("Binary files differ")
+		return
+	}
+	if !writeUnified(os.Stdout, path.Join("a", rel), path.Join("b", rel), string(oldData), string(newData), context) {
+		logger.Warn(msgDiffTooLarge.String(), "path", rel)
+	}
+}
@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"flag"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// endpointPattern finds one kind of endpoint; the last capture group is the
+// URL or path, and a group named "method" gives the HTTP method when known.
+type endpointPattern struct {
+	kind string
+	re   *regexp.Regexp
+}
+
+// a string literal, template literals included ("/api/users/${id}")
+const endpointLit = "[\"'`]([^\"'`\\s]+)[\"'`]"
+
+var endpointPatterns = []endpointPattern{
+	{"fetch", regexp.MustCompile(`\bfetch\(\s*` + endpointLit)},
+	{"axios", regexp.MustCompile(`\baxios(?:\.(?P<method>get|post|put|patch|delete|head|options|request))?\(\s*` + endpointLit)},
+	{"xhr", regexp.MustCompile(`\.open\(\s*["'](?P<method>GET|POST|PUT|PATCH|DELETE|HEAD|OPTIONS)["']\s*,\s*` + endpointLit)},
+	{"http-client", regexp.MustCompile(`\.(?P<method>get|post|put|patch|delete)\(\s*["'` + "`" + `](/[^"'` + "`" + `\s]*)["'` + "`" + `]`)},
+	{"route", regexp.MustCompile(`\bpath\s*:\s*["'` + "`" + `](/?[A-Za-z0-9_:*./${}-]*)["'` + "`" + `]`)},
+	{"route", regexp.MustCompile(`<Route\b[^>]*\bpath=\{?["'` + "`" + `]([^"'` + "`" + `]+)["'` + "`" + `]`)},
+	{"string", regexp.MustCompile("[\"'`]((?:https?:|wss?:)?//[A-Za-z0-9.-]+\\.[A-Za-z]{2,}(?::\\d+)?(?:/[^\"'`\\s]*)?)[\"'`]")},
+	{"string", regexp.MustCompile("[\"'`](/(?:api|v[0-9]+|graphql|rest|auth|oauth|admin|internal|private|rpc)(?:[/?.][^\"'`\\s]*)?)[\"'`]")},
+}
+
+// endpointNoise are URL prefixes found in nearly every bundle (XML namespaces,
+// spec links) that say nothing about the target.
+var endpointNoise = []string{
+	"http://www.w3.org/", "https://www.w3.org/", "http://www.w3.org", "https://reactjs.org/", "https://react.dev/",
+	"https://fb.me/", "https://github.com/", "https://developer.mozilla.org/", "http://json-schema.org/",
+}
+
+// endpoint is one distinct URL or path with where it was seen.
+type endpoint struct {
+	Value   string   `json:"endpoint"`
+	Kinds   []string `json:"kinds"`             // fetch, axios, xhr, http-client, route, string
+	Methods []string `json:"methods,omitempty"` // HTTP methods when the call names one
+	Files   []string `json:"files"`             // path:line, relative to the output directory
+}
+
+// endpointScanner collects the endpoints of recovered first-party sources
+// (-endpoints); crawl workers call scan concurrently. A nil scanner does nothing.
+type endpointScanner struct {
+	enabled bool
+
+	mu    sync.Mutex
+	found map[string]*endpoint
+}
+
+func addEndpointFlags(fs *flag.FlagSet) *endpointScanner {
+	s := &endpointScanner{}
+	fs.BoolVar(&s.enabled, "endpoints", false, "List fetch/axios/XHR URLs, routes and API paths of recovered sources in endpoints.txt and endpoints.json")
+	return s
+}
+
+// scan records the endpoints of one written file; vendor code is skipped.
+func (s *endpointScanner) scan(rel string, data []byte, meta FileMeta) {
+	if s == nil || !s.enabled || isVendorSource(filterPath(rel)) || (meta.Source != "" && isVendorSource(filterPath(meta.Source))) {
+		return
+	}
+	text := string(data)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.found == nil {
+		s.found = make(map[string]*endpoint)
+	}
+	for _, p := range endpointPatterns {
+		methodIdx := p.re.SubexpIndex("method")
+		for _, m := range p.re.FindAllStringSubmatchIndex(text, -1) {
+			n := len(m) / 2
+			value := text[m[2*(n-1)]:m[2*(n-1)+1]]
+			if !isEndpoint(value) {
+				continue
+			}
+			e := s.found[value]
+			if e == nil {
+				e = &endpoint{Value: value}
+				s.found[value] = e
+			}
+			e.Kinds = addUnique(e.Kinds, p.kind)
+			if methodIdx > 0 && m[2*methodIdx] >= 0 {
+				method := strings.ToUpper(text[m[2*methodIdx]:m[2*methodIdx+1]])
+				if method != "REQUEST" {
+					e.Methods = addUnique(e.Methods, method)
+				}
+			}
+			line := strings.Count(text[:m[0]], "\n") + 1
+			e.Files = addUnique(e.Files, rel+":"+strconv.Itoa(line))
+		}
+	}
+}
+
+// isEndpoint drops values that cannot be an endpoint: single characters,
+// relative module paths, well-known documentation URLs.
+func isEndpoint(v string) bool {
+	if len(v) < 2 || strings.HasPrefix(v, "./") || strings.HasPrefix(v, "../") {
+		return false
+	}
+	for _, n := range endpointNoise {
+		if strings.HasPrefix(v, n) {
+			return false
+		}
+	}
+	return true
+}
+
+func addUnique(list []string, v string) []string {
+	for _, x := range list {
+		if x == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+// list returns the endpoints sorted by value.
+func (s *endpointScanner) list() []*endpoint {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*endpoint, 0, len(s.found))
+	for _, e := range s.found {
+		sort.Strings(e.Kinds)
+		sort.Strings(e.Methods)
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Value < out[j].Value })
+	return out
+}
+
+// save writes endpoints.txt, one endpoint per line, and endpoints.json.
+func (s *endpointScanner) save(o *outputOptions) {
+	if s == nil || !s.enabled {
+		return
+	}
+	list := s.list()
+	logger.Info(msgEndpointSummary.String(), "endpoints", len(list))
+	if o.dryRun {
+		return
+	}
+	var txt strings.Builder
+	for _, e := range list {
+		txt.WriteString(e.Value + "\n")
+	}
+	data, _ := json.MarshalIndent(list, "", "  ")
+	if err := o.writeMeta("endpoints.txt", []byte(txt.String())); err != nil {
+		logger.Warn(msgEndpointsError.String(), "err", err)
+		return
+	}
+	if err := o.writeMeta("endpoints.json", data); err != nil {
+		logger.Warn(msgEndpointsError.String(), "err", err)
+	}
+}
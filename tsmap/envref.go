@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"flag"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const envFile = "env.json"
+
+// a quoted JS string, captured without its quotes
+const envQuoted = "[\"'`]([^\"'`\\n]*)[\"'`]"
+
+var (
+	// process.env.X, process.env["X"], import.meta.env.X, optionally with a
+	// default value: process.env.X || "v", import.meta.env.X ?? "v"
+	reEnvRef = regexp.MustCompile(`\b(process\.env|import\.meta\.env)(?:\.([A-Za-z_][A-Za-z0-9_]*)|\[\s*["']([A-Za-z_][A-Za-z0-9_]*)["']\s*\])(?:\s*(?:\|\||\?\?)\s*` + envQuoted + `)?`)
+	// build-time variables inlined as keys: VITE_API_URL: "https://..."
+	reEnvInlined = regexp.MustCompile(`["']?\b((?:REACT_APP|VITE|NEXT_PUBLIC|VUE_APP|NUXT_ENV|NUXT_PUBLIC|GATSBY|PUBLIC|EXPO_PUBLIC)_[A-Za-z0-9_]+)["']?\s*[:=]\s*` + envQuoted)
+	// client-side keys of feature flag SDKs
+	reFlagSDKKey = regexp.MustCompile(`\b(clientSideID|clientSideId|sdkKey|clientKey|authorizationKey|envKey|apiKey)\s*[:=]\s*` + envQuoted)
+)
+
+// flagCall finds the flag keys read through one feature flag SDK.
+type flagCall struct {
+	sdk string
+	re  *regexp.Regexp
+}
+
+var flagCalls = []flagCall{
+	{"launchdarkly", regexp.MustCompile(`\.(?:variation|variationDetail|boolVariation|stringVariation|jsonVariation)\(\s*` + envQuoted)},
+	{"unleash", regexp.MustCompile(`\b(?:isEnabled|useFlag|getVariant|useVariant)\(\s*` + envQuoted)},
+	{"split", regexp.MustCompile(`\.getTreatment(?:WithConfig)?\(\s*` + envQuoted)},
+	{"configcat", regexp.MustCompile(`\.getValue(?:Async)?\(\s*` + envQuoted + `\s*,`)},
+	{"optimizely", regexp.MustCompile(`\.(?:isFeatureEnabled|decide|getFeatureVariable)\(\s*` + envQuoted)},
+	{"growthbook", regexp.MustCompile(`\b(?:useFeatureIsOn|useFeatureValue|isOn|isOff|getFeatureValue|evalFeature)\(\s*` + envQuoted)},
+	{"flagsmith", regexp.MustCompile(`\.(?:hasFeature|getTrait)\(\s*` + envQuoted)},
+	{"statsig", regexp.MustCompile(`\b(?:checkGate|useGate|getExperiment|useExperiment|getDynamicConfig)\(\s*` + envQuoted)},
+}
+
+// envRef is one variable, flag or SDK key with the values and places it was seen at.
+type envRef struct {
+	Name   string   `json:"name"`
+	Kinds  []string `json:"kinds"` // process.env, import.meta.env, inlined, or the SDK name
+	Values []string `json:"values,omitempty"`
+	Files  []string `json:"files"` // path:line, relative to the output directory
+}
+
+// envReport is env.json.
+type envReport struct {
+	Variables    []*envRef `json:"variables"`
+	FeatureFlags []*envRef `json:"feature_flags"`
+	SDKKeys      []*envRef `json:"sdk_keys"`
+}
+
+// envScanner collects the environment variables and feature flags referenced by
+// recovered first-party sources (-env-report); crawl workers call scan
+// concurrently. A nil scanner does nothing.
+type envScanner struct {
+	enabled bool
+
+	mu    sync.Mutex
+	vars  map[string]*envRef
+	flags map[string]*envRef
+	keys  map[string]*envRef
+}
+
+func addEnvFlags(fs *flag.FlagSet) *envScanner {
+	s := &envScanner{}
+	fs.BoolVar(&s.enabled, "env-report", false, "List process.env / import.meta.env variables, their inlined values and feature flag keys in env.json")
+	return s
+}
+
+func (s *envScanner) record(set map[string]*envRef, name, kind, value, where string) {
+	r := set[name]
+	if r == nil {
+		r = &envRef{Name: name}
+		set[name] = r
+	}
+	r.Kinds = addUnique(r.Kinds, kind)
+	if value != "" {
+		r.Values = addUnique(r.Values, value)
+	}
+	r.Files = addUnique(r.Files, where)
+}
+
+// scan records the references of one written file; vendor code is skipped.
+func (s *envScanner) scan(rel string, data []byte, meta FileMeta) {
+	if s == nil || !s.enabled || isVendorSource(filterPath(rel)) || (meta.Source != "" && isVendorSource(filterPath(meta.Source))) {
+		return
+	}
+	text := string(data)
+	where := func(off int) string { return rel + ":" + strconv.Itoa(strings.Count(text[:off], "\n")+1) }
+	group := func(m []int, i int) string {
+		if m[2*i] < 0 {
+			return ""
+		}
+		return text[m[2*i]:m[2*i+1]]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.vars == nil {
+		s.vars, s.flags, s.keys = make(map[string]*envRef), make(map[string]*envRef), make(map[string]*envRef)
+	}
+	for _, m := range reEnvRef.FindAllStringSubmatchIndex(text, -1) {
+		name := group(m, 2)
+		if name == "" {
+			name = group(m, 3)
+		}
+		s.record(s.vars, name, group(m, 1), group(m, 4), where(m[0]))
+	}
+	for _, m := range reEnvInlined.FindAllStringSubmatchIndex(text, -1) {
+		s.record(s.vars, group(m, 1), "inlined", group(m, 2), where(m[0]))
+	}
+	for _, m := range reFlagSDKKey.FindAllStringSubmatchIndex(text, -1) {
+		if v := group(m, 2); v != "" {
+			s.record(s.keys, group(m, 1), "sdk-key", v, where(m[0]))
+		}
+	}
+	for _, c := range flagCalls {
+		for _, m := range c.re.FindAllStringSubmatchIndex(text, -1) {
+			if name := group(m, 1); name != "" {
+				s.record(s.flags, name, c.sdk, "", where(m[0]))
+			}
+		}
+	}
+}
+
+func envRefList(set map[string]*envRef) []*envRef {
+	out := make([]*envRef, 0, len(set))
+	for _, r := range set {
+		sort.Strings(r.Kinds)
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// save writes env.json.
+func (s *envScanner) save(o *outputOptions) {
+	if s == nil || !s.enabled {
+		return
+	}
+	s.mu.Lock()
+	rep := envReport{Variables: envRefList(s.vars), FeatureFlags: envRefList(s.flags), SDKKeys: envRefList(s.keys)}
+	s.mu.Unlock()
+	inlined := 0
+	for _, v := range rep.Variables {
+		if len(v.Values) > 0 {
+			inlined++
+		}
+	}
+	logger.Info(msgEnvSummary.String(), "variables", len(rep.Variables), "with_values", inlined, "feature_flags", len(rep.FeatureFlags), "sdk_keys", len(rep.SDKKeys))
+	if o.dryRun {
+		return
+	}
+	data, _ := json.MarshalIndent(rep, "", "  ")
+	if err := o.writeMeta(envFile, data); err != nil {
+		logger.Warn(msgEnvError.String(), "err", err)
+	}
+}
@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// events is the NDJSON stream opened with -progress-fd; nil when disabled.
+var events *eventStream
+
+// Observer receives the events of a crawl or an extraction, to drive a UI,
+// metrics or storage from a program embedding tsmap. Calls are serialized
+// and made from the workers, so they should return quickly. Embed
+// NopObserver to implement only some of the methods.
+type Observer interface {
+	OnScript(url, parent string)                      // a script is about to be fetched; parent is the script referencing a chunk
+	OnMapFound(mapURL, scriptURL string, sources int) // a map was extracted; mapURL is "inline:<script>" for inline maps
+	OnFileWritten(path string, meta FileMeta)         // a recovered source was written
+	OnError(url string, err error)                    // a script or map failed; the run goes on
+	OnProgress(p Progress)                            // a script or source was handled
+}
+
+// Progress counts the work of a run so far.
+type Progress struct {
+	Done    int64 // scripts (crawl) or sources (extract) handled
+	Total   int64 // known so far, grows as chunks are discovered
+	Bytes   int64 // downloaded
+	Written int64 // sources written
+}
+
+// NopObserver implements Observer with methods that do nothing.
+type NopObserver struct{}
+
+func (NopObserver) OnScript(url, parent string)                      {}
+func (NopObserver) OnMapFound(mapURL, scriptURL string, sources int) {}
+func (NopObserver) OnFileWritten(path string, meta FileMeta)         {}
+func (NopObserver) OnError(url string, err error)                    {}
+func (NopObserver) OnProgress(p Progress)                            {}
+
+// eventStream dispatches the events of a run to observers and, with
+// -progress-fd, writes them as one JSON object per line for wrappers and GUIs:
+//
+//	{"ts":"...","event":"script","url":"..."}
+//	{"ts":"...","event":"progress","done":3,"total":10,"bytes":123456,"written":42}
+//
+// A nil *eventStream is valid and does nothing.
+type eventStream struct {
+	mu        sync.Mutex
+	f         *os.File // nil when only observers listen
+	enc       *json.Encoder
+	observers []Observer
+
+	total, done, bytes, written int64
+}
+
+// observe returns a stream for o alone, nil when o is nil.
+func observe(o Observer) *eventStream {
+	if o == nil {
+		return nil
+	}
+	return &eventStream{observers: []Observer{o}}
+}
+
+func openEventStream(fd int) (*eventStream, error) {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+	if f == nil {
+		return nil, fmt.Errorf("invalid fd %d", fd)
+	}
+	if _, err := f.Stat(); err != nil {
+		return nil, fmt.Errorf("fd %d is not open: %w", fd, err)
+	}
+	return &eventStream{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// emit writes an event; fields are alternating key/value pairs.
+func (e *eventStream) emit(kind string, kv ...any) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emitLocked(kind, kv...)
+}
+
+func (e *eventStream) emitLocked(kind string, kv ...any) {
+	if e.enc == nil {
+		return
+	}
+	ev := map[string]any{"ts": time.Now().UTC().Format(time.RFC3339Nano), "event": kind}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if k, ok := kv[i].(string); ok {
+			if err, isErr := kv[i+1].(error); isErr {
+				ev[k] = err.Error()
+			} else {
+				ev[k] = kv[i+1]
+			}
+		}
+	}
+	_ = e.enc.Encode(ev)
+}
+
+// script reports a script about to be fetched.
+func (e *eventStream) script(url, parent string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emitLocked("script", "url", url, "parent", parent)
+	for _, o := range e.observers {
+		o.OnScript(url, parent)
+	}
+}
+
+// mapFound reports an extracted map; script is empty when unknown.
+func (e *eventStream) mapFound(mapURL, script string, sources int) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if script == "" {
+		e.emitLocked("map", "map", mapURL, "sources", sources)
+	} else {
+		e.emitLocked("map", "map", mapURL, "script", script, "sources", sources)
+	}
+	for _, o := range e.observers {
+		o.OnMapFound(mapURL, script, sources)
+	}
+}
+
+// fileWritten reports a written source.
+func (e *eventStream) fileWritten(path string, meta FileMeta) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emitLocked("file", "path", path, "source", meta.Source)
+	for _, o := range e.observers {
+		o.OnFileWritten(path, meta)
+	}
+}
+
+// failed reports the error of a script or map.
+func (e *eventStream) failed(url string, err error) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emitLocked("error", "url", url, "err", err)
+	for _, o := range e.observers {
+		o.OnError(url, err)
+	}
+}
+
+func (e *eventStream) addTotal(n int) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.total += int64(n)
+	e.mu.Unlock()
+}
+
+func (e *eventStream) addBytes(n int) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.bytes += int64(n)
+	e.mu.Unlock()
+}
+
+func (e *eventStream) addWritten(n int) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.written += int64(n)
+	e.mu.Unlock()
+}
+
+// incDone counts one finished unit and emits a progress event.
+func (e *eventStream) incDone() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.done++
+	e.emitLocked("progress", "done", e.done, "total", e.total, "bytes", e.bytes, "written", e.written)
+	for _, o := range e.observers {
+		o.OnProgress(Progress{Done: e.done, Total: e.total, Bytes: e.bytes, Written: e.written})
+	}
+}
+
+func (e *eventStream) close() {
+	if e == nil || e.f == nil {
+		return
+	}
+	_ = e.f.Close()
+}
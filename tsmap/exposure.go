@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// riskLevel scores an exposure; the zero value means nothing was exposed.
+type riskLevel int
+
+const (
+	riskNone riskLevel = iota
+	riskLow
+	riskMedium
+	riskHigh
+	riskCritical
+)
+
+var riskNames = []string{"none", "low", "medium", "high", "critical"}
+
+func (s riskLevel) String() string { return riskNames[s] }
+
+func (s riskLevel) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// UnmarshalText reads a level back from a report, e.g. by monitor.
+func (s *riskLevel) UnmarshalText(b []byte) error { return s.Set(string(b)) }
+
+// Set parses -fail-on.
+func (s *riskLevel) Set(v string) error {
+	for i, n := range riskNames {
+		if strings.EqualFold(v, n) {
+			*s = riskLevel(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid -fail-on %q (low|medium|high|critical)", v)
+}
+
+// mapRisk scores one map before filtering: high when a first-party source
+// ships its content, medium when first-party sources are only named in the
+// mappings, low when every source is vendor code.
+func mapRisk(sm *SourceMap) riskLevel {
+	sev := riskNone
+	for i := range sm.Sources {
+		if isVendorSource(filterPath(sm.sourceName(i))) {
+			sev = max(sev, riskLow)
+		} else if sm.HasContent(i) {
+			return riskHigh
+		} else {
+			sev = riskMedium
+		}
+	}
+	return sev
+}
+
+// exposureScores keeps the risk level of every map of a run; crawl workers call
+// addMap concurrently. A nil tracker scores nothing.
+type exposureScores struct {
+	failOn riskLevel // -fail-on, riskNone when unset
+
+	mu   sync.Mutex
+	maps map[string]riskLevel // map URL or path, "inline:<script>" for inline maps
+}
+
+func addSeverityFlags(fs *flag.FlagSet) *exposureScores {
+	s := &exposureScores{maps: make(map[string]riskLevel)}
+	fs.Var(&s.failOn, "fail-on", "Exit with code 4 when the exposure severity reaches low|medium|high|critical")
+	return s
+}
+
+// addMap scores the map recorded under key.
+func (s *exposureScores) addMap(key string, sm *SourceMap) {
+	if s == nil {
+		return
+	}
+	sev := mapRisk(sm)
+	s.mu.Lock()
+	s.maps[key] = max(s.maps[key], sev)
+	s.mu.Unlock()
+}
+
+// of returns the risk level of the map recorded under key.
+func (s *exposureScores) of(key string) riskLevel {
+	if s == nil {
+		return riskNone
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maps[key]
+}
+
+// overall returns the risk level of the run: the worst map, or critical when a
+// secret was found.
+func (s *exposureScores) overall(secrets int) riskLevel {
+	if s == nil {
+		return riskNone
+	}
+	if secrets > 0 {
+		return riskCritical
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sev := riskNone
+	for _, v := range s.maps {
+		sev = max(sev, v)
+	}
+	return sev
+}
+
+// scoreReport sets the severity of every script with a map and of the crawl;
+// rep.Secrets must be filled.
+func (s *exposureScores) scoreReport(rep *crawlReport) {
+	for _, sr := range rep.Scripts {
+		switch {
+		case sr.MapURL == "inline":
+			sr.Severity = s.of("inline:" + sr.URL)
+		case sr.MapURL != "":
+			sr.Severity = s.of(sr.MapURL)
+		}
+		for _, f := range rep.Secrets {
+			if sr.MapURL != "" && f.Map == sr.MapURL {
+				sr.Severity = riskCritical
+			}
+		}
+	}
+	rep.Severity = s.overall(len(rep.Secrets))
+}
+
+// logSummary logs the overall risk level and the number of maps per level.
+func (s *exposureScores) logSummary(overall riskLevel) {
+	if s == nil {
+		return
+	}
+	counts := make(map[riskLevel]int)
+	s.mu.Lock()
+	for _, v := range s.maps {
+		counts[v]++
+	}
+	s.mu.Unlock()
+	args := []any{"severity", overall}
+	for sev := riskHigh; sev >= riskLow; sev-- {
+		if counts[sev] > 0 {
+			args = append(args, sev.String(), counts[sev])
+		}
+	}
+	logger.Info(msgSeverity.String(), args...)
+}
+
+// failed tells whether overall reaches -fail-on.
+func (s *exposureScores) failed(overall riskLevel) bool {
+	return s != nil && s.failOn != riskNone && overall >= s.failOn
+}
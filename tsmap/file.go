@@ -3,101 +3,652 @@
 package tsmap
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
-func RunExtract(args []string) {
+// extractFlags holds the parsed "extract" command line.
+type extractFlags struct {
+	mapPath   string
+	mapDir    string
+	recursive bool
+	layout    string
+	out       string
+	output    *outputOptions
+	strict    bool
+	path      string
+	stdout    bool
+	http      *httpOptions
+	maxRSS    byteSize
+	workers   int
+	resume    string
+	baseURL   string
+	js        string
+	log       *logOptions
+}
+
+func newExtractFlags() (*flag.FlagSet, *extractFlags) {
+	f := &extractFlags{}
 	fs := flag.NewFlagSet("tsmap-extract extract", flag.ExitOnError)
-	mapPath := fs.String("map", "", "Path to .map file")
-	outDir := fs.String("out", "extracted_sources", "Output directory")
-	beautify := fs.Bool("beautify", false, "Beautify minimal JS/TS")
-	eol := fs.String("eol", "", "Line endings: unix|dos")
+	fs.StringVar(&f.mapPath, "map", "", "Path or http(s) URL of the .map file")
+	fs.StringVar(&f.mapDir, "map-dir", "", "Extract every *.map file found in this directory")
+	fs.BoolVar(&f.recursive, "recursive", false, "With -map-dir, also walk subdirectories")
+	fs.StringVar(&f.layout, "layout", "per-map", "per-map (with -map-dir, one folder per map), merged (single tree) or flat (one folder, encoded names)")
+	fs.StringVar(&f.baseURL, "base-url", "", "URL a .js -map input was served from, to resolve its relative sourceMappingURL")
+	fs.StringVar(&f.js, "js", "", "Generated bundle (file or URL) used by -reconstruct, default the map's \"file\"")
+	fs.StringVar(&f.out, "out", "extracted_sources", "Output directory, or s3://bucket/prefix, gs://bucket/prefix, az://account/container/prefix")
+	f.output = addOutputFlags(fs)
+	fs.StringVar(&f.path, "path", "", "Only extract this source (e.g. src/config.ts)")
+	fs.BoolVar(&f.stdout, "stdout", false, "Print the -path source to stdout instead of writing files")
+	fs.BoolVar(&f.strict, "strict", false, "Exit with code 3 if any source was blocked or any map failed")
+	fs.Var(&f.maxRSS, "max-rss", "Stop and write a checkpoint when memory use exceeds this size (e.g. 4GB)")
+	fs.IntVar(&f.workers, "workers", 4, "Sources written at the same time; 1 with -dedup, -dry-run, -paths-only and -print0")
+	fs.StringVar(&f.resume, "resume", "", "Skip the sources listed in this checkpoint.json")
+	fs.String("config", "", "YAML config file (default ~/.config/tsmap-extract/config.yaml)")
+	f.http = addHTTPFlags(fs)
+	f.log = addLogFlags(fs)
+	return fs, f
+}
+
+// mapInput is one map to extract and the directory its sources go to.
+type mapInput struct {
+	path    string
+	outDir  string
+	prefix  string    // prepended to source names in the checkpoint (-map-dir)
+	modTime time.Time // map file date, for -on-conflict newest
+	script  string    // generated script the map belongs to, when known
+}
+
+// RunExtract runs the "extract" subcommand and returns the process exit code.
+func RunExtract(args []string) int {
+	useConsoleLogger()
+	fs, f := newExtractFlags()
+	logOpts := f.log
+	loadDefaults(fs, "extract", args)
 	fs.Parse(args)
+	f.out = f.output.setRoot(f.out)
+	f.output.secrets.enabled = f.output.secrets.enabled || f.output.sarif.enabled
+	if err := f.output.secrets.load(); err != nil {
+		usageFail(msgSecretRules, err)
+	}
+	if f.stdout || f.output.ownsStdout() {
+		// keep stdout for the source itself or the list of paths
+		logOpts.console = os.Stderr
+	}
+	defer logOpts.setup()()
+	f.output.events = events
+	ctx, stop := interruptContext()
+	defer stop()
 
-	if strings.TrimSpace(*mapPath) == "" {
+	if strings.TrimSpace(f.mapPath) == "" && strings.TrimSpace(f.mapDir) == "" {
+		logger.Error(msgMissingMap.String())
 		fs.Usage()
+		return exitUsage
 	}
-
-	raw, err := os.ReadFile(*mapPath)
-	if err != nil {
-		fail("Read .map: %v", err)
+	if f.mapPath != "" && f.mapDir != "" {
+		usageFail(msgMapAndMapDir)
 	}
-	var sm sourceMap
-	if err := json.Unmarshal(raw, &sm); err != nil {
-		fail("Invalid sourcemap JSON: %v", err)
+	if f.mapDir != "" && (f.path != "" || f.stdout) {
+		usageFail(msgMapDirPath)
 	}
-	if len(sm.Sources) == 0 {
-		fail("No 'sources' in sourcemap")
+	switch f.layout {
+	case "per-map", "merged":
+	case "flat":
+		f.output.flat = true
+	default:
+		usageFail(msgInvalidLayout, f.layout)
+	}
+	if f.stdout && f.path == "" {
+		usageFail(msgStdoutNeedsPath)
+	}
+	if f.workers < 1 {
+		usageFail(msgExtractWorkers)
+	}
+	if f.output.dedup != nil || f.output.dryRun || f.output.scripted() {
+		f.workers = 1 // the first copy of a source, and stdout, follow the source order
 	}
-	_ = os.MkdirAll(*outDir, 0755)
-
-	// Calcul ancrage
-	maxUp := computeMaxLeadingUps(sm)
-	baseAnchor, subAnchor := buildAnchors(*outDir, maxUp)
 
-	written, skipped := 0, 0
+	target := f.mapPath
+	inputs := []mapInput{{path: f.mapPath, outDir: f.out}}
+	if f.mapDir != "" {
+		target = f.mapDir
+		files, err := findMapFiles(f.mapDir, f.recursive)
+		if err != nil {
+			fail(msgReadMapDir, err)
+		}
+		if len(files) == 0 {
+			logger.Warn(msgNoMapFiles.String(), "dir", f.mapDir)
+			return exitNoSources
+		}
+		inputs = inputs[:0]
+		for _, rel := range files {
+			in := mapInput{path: filepath.Join(f.mapDir, rel), outDir: f.out, prefix: filepath.ToSlash(rel) + ":"}
+			if f.layout == "per-map" {
+				in.outDir = filepath.Join(f.out, strings.TrimSuffix(rel, ".map"))
+			}
+			inputs = append(inputs, in)
+		}
+	}
 
-	for i, s := range sm.Sources {
-		content := ""
-		if i < len(sm.SourcesContent) {
-			content = sm.SourcesContent[i]
+	// a single map is parsed up front so that errors are fatal and -path can be resolved
+	var first SourceMap
+	only := -1
+	if f.mapDir == "" {
+		var script []byte
+		var err error
+		if first, script, err = loadSourceMap(ctx, f.mapPath, f.baseURL, f.http); err != nil {
+			fail(msgLoadMap, err)
+		}
+		if f.output.reconstructs() {
+			reconstructExtract(ctx, &first, f.mapPath, script, f.js, f.http, f.output.prettyBuild)
+		}
+		if inputs[0].script = f.js; script != nil {
+			inputs[0].script = f.mapPath
+		}
+		if f.path != "" {
+			if only, err = findSource(first, f.path); err != nil {
+				usageFail(msgSourceNotFound, err)
+			}
+		}
+	}
+	if f.stdout {
+		content, err := first.content(only)
+		if err != nil {
+			fail(msgLoadMap, err)
 		}
 		if strings.TrimSpace(content) == "" {
-			fmt.Printf("%sSkipped%s (no content): %s\n", cYel, cRst, s)
-			skipped++
-			continue
+			logger.Warn(msgSkippedNoContent.String(), "source", first.Sources[only])
+			return exitNoSources
+		}
+		if _, err := os.Stdout.WriteString(f.output.render(first.Sources[only], content)); err != nil {
+			fail(msgWriteFile, err)
+		}
+		return exitOK
+	}
+	f.output.openSinks()
+	defer f.output.closeSinks()
+	if f.output.tree != nil {
+		_ = os.MkdirAll(f.out, 0755)
+	}
+	run := &extractRun{ctx: ctx, output: f.output, workers: f.workers, done: []string{}}
+	if f.resume != "" {
+		var err error
+		if run.resumed, err = loadCheckpoint(f.resume, "extract", target); err != nil {
+			usageFail(msgResumeError, err)
+		}
+		logger.Info(msgResuming.String(), "done", len(run.resumed))
+	}
+	run.watchdog = startWatchdog(int64(f.maxRSS))
+	defer run.watchdog.close()
+
+	run.bar = startProgress(msgUnitSources.String(), logOpts.progress())
+	if f.mapDir == "" {
+		f.output.events.emit("start", "command", "extract", "map", f.mapPath)
+	} else {
+		f.output.events.emit("start", "command", "extract", "map_dir", f.mapDir, "maps", len(inputs))
+	}
+
+	maps, failed := 0, 0
+	for _, in := range inputs {
+		if run.watchdog.exceeded() || ctx.Err() != nil {
+			run.stopped = true
+			break
+		}
+		sm := first
+		if f.mapDir != "" {
+			var err error
+			if sm, _, err = loadSourceMap(ctx, in.path, "", f.http); err != nil {
+				logger.Warn(msgMapError.String(), "map", in.path, "err", err)
+				f.output.events.failed(in.path, err)
+				failed++
+				continue
+			}
+			if f.output.reconstructs() {
+				reconstructExtract(ctx, &sm, in.path, nil, "", f.http, f.output.prettyBuild)
+			}
+		}
+		if fi, err := os.Stat(in.path); err == nil {
+			in.modTime = fi.ModTime()
+		}
+		before := run.written
+		run.extractMap(sm, in, only)
+		maps++
+		if isHTTPURL(in.path) {
+			f.output.sarif.addMap(in.path, in.script, run.written-before)
 		}
+		if f.mapDir != "" {
+			logger.Info(msgMapExtracted.String(), "map", in.path, "written", run.written-before)
+			f.output.events.mapFound(in.path, in.script, run.written-before)
+		}
+	}
+	run.bar.finish()
+	f.output.events.emit("done", "written", run.written, "skipped", run.skipped)
 
-		// Normaliser en conservant les ../
-		norm := normalizeKeepDots(joinMaybe(sm.SourceRoot, s))
+	lines, size := f.output.langs.totals()
+	if f.mapDir == "" {
+		logger.Info(msgSummary.String(), "written", run.written, "skipped", run.skipped, "filtered", run.filtered, "lines", lines, "bytes", humanBytes(size))
+	} else {
+		logger.Info(msgSummary.String(), "maps", maps, "failed_maps", failed, "written", run.written, "skipped", run.skipped, "filtered", run.filtered, "lines", lines, "bytes", humanBytes(size))
+	}
+	f.output.langs.logSummary()
+
+	f.output.filter.logSummary()
+	f.output.dedup.logSummary()
+	f.output.conflicts.logSummary()
+	f.output.scrubbed.logSummary()
+	f.output.fingerprint.logSummary()
+	risk := f.output.scores.overall(len(f.output.secrets.list()))
+	f.output.scores.logSummary(risk)
+	f.output.saveAnalyzers()
+	f.output.sarif.save(f.output)
+	f.output.saveManifest()
+	f.output.saveSums()
 
-		// Résoudre via ancrage
-		rel, abs, err := resolveUnderAnchor(*outDir, baseAnchor, subAnchor, norm)
+	if run.stopped {
+		cp := &checkpoint{Command: "extract", Target: target, CreatedAt: time.Now(), Done: run.done}
+		for s := range run.resumed {
+			cp.Done = append(cp.Done, s)
+		}
+		p, err := writeCheckpoint(f.out, cp)
 		if err != nil {
-			fmt.Printf("%sSkipped%s (path blocked): %s\n", cYel, cRst, s)
-			skipped++
+			fail(msgCheckpointError, err)
+		}
+		if ctx.Err() != nil {
+			logger.Error(msgCheckpointStopped.String(), "path", p)
+		} else {
+			logger.Error(msgCheckpointWritten.String(), "path", p)
+		}
+		return exitFatal
+	}
+	if f.resume != "" {
+		_ = os.Remove(f.resume)
+	}
+	if f.strict && (run.blocked > 0 || failed > 0) {
+		logger.Error(msgStrictErrors.String(), "blocked", run.blocked, "failed_maps", failed)
+		return exitFatal
+	}
+	if f.output.scores.failed(risk) {
+		logger.Error(msgSeverityFailed.String(), "severity", risk, "fail_on", f.output.scores.failOn)
+		return exitSeverity
+	}
+	if run.written == 0 {
+		return exitNoSources
+	}
+	return exitOK
+}
+
+// extractRun holds the state shared by every map of one extract invocation.
+type extractRun struct {
+	ctx      context.Context
+	output   *outputOptions
+	bar      *progressBar
+	watchdog *memWatchdog
+	workers  int // sources written at the same time
+	resumed  map[string]bool
+	done     []string // checkpoint keys handled by this run
+	stopped  bool
+
+	written, skipped, blocked, filtered int
+}
+
+// sourceJob is one source of a map. Jobs are planned in source order, written
+// by the workers, and reported in source order again.
+type sourceJob struct {
+	i       int
+	key     string // checkpoint key
+	abs     string // placed path, then the written one ("" when -on-conflict skipped it)
+	reason  string // why a filtered source was not written
+	blocked error  // the placed path leaves the output directory
+	err     error  // of the write, fatal
+	after   *sourceJob
+	done    chan struct{}
+}
+
+// extractMap writes the sources of sm under in.outDir; only >= 0 restricts it to one source.
+// The sources are written by r.workers goroutines; two sources placed at the same path
+// are written one after the other, in source order, so that -on-conflict decides as
+// with a single worker.
+func (r *extractRun) extractMap(sm SourceMap, in mapInput, only int) {
+	r.output.fingerprint.addMap(&sm)
+	r.output.deps.addMap(&sm)
+	r.output.scores.addMap(in.path, &sm)
+	filtered := r.output.filter.apply(&sm)
+	if r.output.namespaces {
+		keepNamespaces(&sm)
+	}
+	if r.output.inferExt {
+		inferExtensions(&sm)
+	}
+
+	anchor := newSourceAnchor(in.outDir, &sm)
+
+	total := len(sm.Sources)
+	if only >= 0 {
+		total = 1
+	}
+	r.bar.addTotal(total)
+	r.output.events.addTotal(total)
+
+	queue := make(chan *sourceJob, r.workers)
+	ordered := make(chan *sourceJob, 16*r.workers)
+	var wg sync.WaitGroup
+	for range r.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				r.write(&sm, in, j)
+			}
+		}()
+	}
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		for j := range ordered {
+			<-j.done
+			r.report(&sm, in, j)
+		}
+	}()
+
+	last := make(map[string]*sourceJob) // by folded path
+	for i, s := range sm.Sources {
+		if only >= 0 && i != only {
+			continue
+		}
+		if r.watchdog.exceeded() || r.ctx.Err() != nil {
+			r.stopped = true
+			break
+		}
+		r.bar.incDone()
+		r.output.events.incDone()
+		key := in.prefix + s
+		if r.resumed[key] {
+			continue
+		}
+		j := &sourceJob{i: i, key: key, done: make(chan struct{})}
+		ordered <- j
+		if reason, ok := filtered[i]; ok {
+			j.reason = reason
+			close(j.done)
+			continue
+		}
+		if !sm.HasContent(i) {
+			close(j.done)
+			continue
+		}
+		if j.abs, j.blocked = anchor.place(r.output, &sm, i); j.blocked != nil {
+			close(j.done)
 			continue
 		}
+		folded := strings.ToLower(j.abs)
+		j.after, last[folded] = last[folded], j
+		queue <- j
+	}
+	close(queue)
+	close(ordered)
+	wg.Wait()
+	<-reported
+}
 
-		if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
-			fail("Create dir: %v", err)
+// write renders and writes the source of j, once the previous source placed
+// at the same path is written.
+func (r *extractRun) write(sm *SourceMap, in mapInput, j *sourceJob) {
+	defer close(j.done)
+	if j.after != nil {
+		<-j.after.done
+	}
+	content, err := sm.content(j.i) // read back from the file when streamed
+	if err != nil {
+		j.err = err
+		return
+	}
+	data := []byte(r.output.render(j.abs, content))
+	if j.abs, j.err = r.output.writeSource(j.abs, data, FileMeta{Source: sm.sourceName(j.i), Map: in.path, ModTime: in.modTime}); j.err == nil && j.abs != "" {
+		r.output.manifest.record(r.output.root, j.abs, data, provenance{source: sm.sourceName(j.i), index: j.i, mapRef: in.path, script: in.script})
+	}
+}
+
+// report logs and counts a finished job; it runs on one goroutine, in source order.
+func (r *extractRun) report(sm *SourceMap, in mapInput, j *sourceJob) {
+	s := sm.Sources[j.i]
+	r.done = append(r.done, j.key)
+	switch {
+	case j.reason != "":
+		logger.Debug(msgSkippedFiltered.String(), "source", s, "reason", j.reason)
+		r.filtered++
+	case j.blocked != nil:
+		logger.Warn(msgSkippedBlocked.String(), "source", s, "err", j.blocked)
+		r.skipped++
+		r.blocked++
+	case j.err != nil:
+		fail(msgWriteFile, j.err)
+	case !sm.HasContent(j.i):
+		logger.Info(msgSkippedNoContent.String(), "source", s)
+		r.skipped++
+	case j.abs == "": // dropped by -on-conflict
+		r.skipped++
+	default:
+		if !r.output.dryRun {
+			logger.Info(msgWritten.String(), "path", j.abs)
 		}
+		r.written++
+		r.output.filter.wrote(sm.ResolvedSource(j.i))
+		r.bar.addWritten(1)
+		r.output.events.addWritten(1)
+		r.output.events.fileWritten(j.abs, FileMeta{Source: sm.sourceName(j.i), Map: in.path, ModTime: in.modTime})
+	}
+}
 
-		if *beautify {
-			content = beautifyBasic(content)
+// findMapFiles lists the *.map files under dir, relative to it, in lexical order.
+// Subdirectories are only walked when recursive is set.
+func findMapFiles(dir string, recursive bool) ([]string, error) {
+	var out []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
 		}
-		content = normalizeEOL(content, *eol)
+		if !strings.HasSuffix(strings.ToLower(d.Name()), ".map") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		out = append(out, rel)
+		return nil
+	})
+	return out, err
+}
 
-		if err := os.WriteFile(abs, []byte(content), 0644); err != nil {
-			fail("Write file: %v", err)
+// loadSourceMap reads and decodes a map file or URL; a map without sources is an error.
+// A large map file is streamed, see streamSourceMap.
+// A minified bundle is accepted too: the map it references is loaded instead and
+// the bundle is returned as second result.
+func loadSourceMap(ctx context.Context, p, baseURL string, h *httpOptions) (SourceMap, []byte, error) {
+	sm, streamed, err := streamSourceMap(p)
+	switch {
+	case streamed && err != nil:
+		return sm, nil, errors.New(msgInvalidMapJSON.format(err))
+	case streamed && len(sm.Sources) == 0:
+		return sm, nil, errors.New(msgNoSources.String())
+	case streamed:
+		logger.Debug(msgMapStreamed.String(), "map", p, "sources", len(sm.Sources))
+		return sm, nil, nil
+	}
+	var script []byte
+	raw, err := readMapFile(ctx, p, h)
+	if err != nil {
+		return sm, nil, errors.New(msgReadMap.format(err))
+	}
+	if isScriptInput(p, raw) {
+		script = raw
+		if raw, p, err = mapFromScript(ctx, raw, p, baseURL, h); err != nil {
+			return sm, nil, errors.New(msgReadMap.format(err))
 		}
-		fmt.Printf("%sWritten%s: %s\n", cGrn, cRst, filepath.Join(*outDir, rel))
-		written++
 	}
+	fetch := func(ref string) ([]byte, error) { return readMapFile(ctx, ref, h) }
+	if sm, err = decodeSourceMap(raw, p, fetch); err != nil {
+		return sm, nil, errors.New(msgInvalidMapJSON.format(err))
+	}
+	if len(sm.Sources) == 0 {
+		return sm, nil, errors.New(msgNoSources.String())
+	}
+	return sm, script, nil
+}
+
+// reconstructExtract runs -reconstruct for extract. The bundle is the -js flag,
+// else the script given as -map, else the map's "file" next to the map.
+func reconstructExtract(ctx context.Context, sm *SourceMap, mapPath string, script []byte, js string, h *httpOptions, pretty bool) {
+	var err error
+	switch {
+	case js != "":
+		script, err = readMapFile(ctx, js, h)
+	case script == nil && sm.File != "":
+		script, err = readMapFile(ctx, resolveRef(mapPath, sm.File), h)
+	}
+	if err != nil || script == nil {
+		logger.Warn(msgNoGenerated.String(), "map", mapPath, "err", err)
+		return
+	}
+	n, err := reconstructMissing(sm, string(script), pretty)
+	if err != nil {
+		logger.Warn(msgReconstructError.String(), "map", mapPath, "err", err)
+		return
+	}
+	if n > 0 {
+		logger.Info(msgReconstructed.String(), "map", mapPath, "sources", n)
+	}
+}
 
-	fmt.Printf("\n%sSummary%s: %d written, %d skipped\n", cCyn, cRst, written, skipped)
+// readMapFile reads a local .map file or downloads it, with the crawl HTTP
+// options, when given an http(s) URL.
+func readMapFile(ctx context.Context, p string, h *httpOptions) ([]byte, error) {
+	if !isHTTPURL(p) {
+		return os.ReadFile(p)
+	}
+	if h.crawler == nil {
+		h.crawler = h.newCrawler(nil)
+	}
+	logger.Info(msgFetching.String(), "url", p)
+	res, err := h.crawler.get(ctx, p)
+	return res.Body, err
 }
 
-// ---------- Anchoring & path logic ----------
+// isScriptInput reports whether an extract input is a JavaScript bundle rather
+// than a map: a .js name, or a body that is not a JSON object and carries a
+// sourceMappingURL comment.
+func isScriptInput(p string, raw []byte) bool {
+	if u, err := url.Parse(p); err == nil && isHTTPURL(p) {
+		p = u.Path
+	}
+	switch strings.ToLower(path.Ext(p)) {
+	case ".js", ".mjs", ".cjs":
+		return true
+	}
+	b := unwrapMapJSON(raw)
+	return len(b) > 0 && b[0] != '{' && bytes.Contains(raw, []byte("sourceMappingURL"))
+}
 
-// Calcule le nombre max de "../" en ignorant les fichiers vides
-func computeMaxLeadingUps(sm sourceMap) int {
-	maxUp := 0
-	for i, s := range sm.Sources {
-		if i < len(sm.SourcesContent) {
-			if strings.TrimSpace(sm.SourcesContent[i]) == "" {
-				continue // on ignore les fichiers sans contenu
+// mapFromScript returns the map referenced by a bundle, using the same locators
+// as crawl. Relative references are resolved against baseURL when given, else
+// against the bundle URL or the bundle's directory on disk. The second result is
+// where the map was found, the bundle itself for an inline map.
+func mapFromScript(ctx context.Context, js []byte, p, baseURL string, h *httpOptions) ([]byte, string, error) {
+	var scriptURL *url.URL
+	var err error
+	switch {
+	case baseURL != "":
+		if scriptURL, err = url.Parse(baseURL); err == nil && !isHTTPURL(p) && strings.HasSuffix(scriptURL.Path, "/") {
+			scriptURL, err = scriptURL.Parse(filepath.Base(p))
+		}
+	case isHTTPURL(p):
+		scriptURL, err = url.Parse(p)
+	default:
+		var abs string
+		if abs, err = filepath.Abs(p); err == nil {
+			scriptURL = &url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	text := string(js)
+	for _, loc := range registeredMapLocators() {
+		cands, err := loc.LocateMaps(text, scriptURL)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, c := range cands {
+			if c.Data != nil {
+				logger.Info(msgMapFromScript.String(), "script", p, "map", "inline")
+				return c.Data, p, nil
 			}
+			if c.URL == nil {
+				continue
+			}
+			where := c.URL.String()
+			if c.URL.Scheme == "file" {
+				where = filepath.FromSlash(c.URL.Path)
+			}
+			data, err := readMapFile(ctx, where, h)
+			if err != nil {
+				if !c.Guess {
+					return nil, "", err
+				}
+				logger.Debug(msgMapFetchFailed.String(), "url", where, "err", err)
+				continue
+			}
+			logger.Info(msgMapFromScript.String(), "script", p, "map", where)
+			return data, where, nil
 		}
-		p := normalizeKeepDots(joinMaybe(sm.SourceRoot, s))
-		if n := countLeadingUps(p); n > maxUp {
-			maxUp = n
+	}
+	return nil, "", errors.New(msgNoSourcemap.String())
+}
+
+// findSource returns the index of the source matching want: an exact match of the
+// raw or normalized name first, else a unique match on the trailing path segments.
+func findSource(sm SourceMap, want string) (int, error) {
+	want = strings.TrimPrefix(normalizeKeepDots(want), "./")
+	var suffix []int
+	for i, s := range sm.Sources {
+		norm := normalizeKeepDots(joinMaybe(sm.SourceRoot, s))
+		for strings.HasPrefix(norm, "../") {
+			norm = norm[3:]
 		}
+		norm = strings.TrimPrefix(norm, "./")
+		if s == want || norm == want {
+			return i, nil
+		}
+		if strings.HasSuffix(norm, "/"+want) {
+			suffix = append(suffix, i)
+		}
+	}
+	switch len(suffix) {
+	case 0:
+		return -1, fmt.Errorf("no source matches %q", want)
+	case 1:
+		return suffix[0], nil
+	}
+	names := make([]string, len(suffix))
+	for j, i := range suffix {
+		names[j] = sm.Sources[i]
 	}
-	return maxUp
+	return -1, fmt.Errorf("%q matches several sources: %s", want, strings.Join(names, ", "))
 }
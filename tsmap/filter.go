@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// sourceFilter decides which sources of a map are written; shared by extract and crawl.
+type sourceFilter struct {
+	skipIgnored bool
+	onlyIgnored bool
+	skipVendor  bool
+	include     globList
+	exclude     globList
+	minSize     byteSize
+	maxSize     byteSize
+
+	stats filterStats
+}
+
+// filterStats counts written and filtered sources, split between vendor and
+// first-party code. Crawl workers update it concurrently.
+type filterStats struct {
+	vendorWritten, vendorSkipped atomic.Int64
+	appWritten, appSkipped       atomic.Int64
+}
+
+// sourceCounts is the JSON form of filterStats.
+type sourceCounts struct {
+	VendorWritten int64 `json:"vendor_written"`
+	VendorSkipped int64 `json:"vendor_skipped"`
+	AppWritten    int64 `json:"first_party_written"`
+	AppSkipped    int64 `json:"first_party_skipped"`
+}
+
+func addFilterFlags(fs *flag.FlagSet) *sourceFilter {
+	f := &sourceFilter{}
+	fs.BoolVar(&f.skipIgnored, "skip-ignored", false, "Skip sources listed in the map's ignoreList (third-party code)")
+	fs.BoolVar(&f.onlyIgnored, "only-ignored", false, "Only write sources listed in the map's ignoreList")
+	fs.BoolVar(&f.skipVendor, "skip-vendor", false, "Skip node_modules, bower_components and webpack externals")
+	fs.Var(&f.include, "include", "Only write sources matching this glob, e.g. 'src/**' (repeatable)")
+	fs.Var(&f.exclude, "exclude", "Skip sources matching this glob, e.g. '**/node_modules/**' (repeatable)")
+	fs.Var(&f.minSize, "min-size", "Skip sources smaller than this (e.g. 64B)")
+	fs.Var(&f.maxSize, "max-size", "Skip sources larger than this (e.g. 2MB)")
+	return f
+}
+
+// apply drops the filtered sources of sm by emptying their content, so that they
+// are neither written nor counted when anchoring paths, and returns why each
+// dropped source was filtered, by index.
+func (f *sourceFilter) apply(sm *SourceMap) map[int]string {
+	if f == nil {
+		return nil
+	}
+	ignored := sm.ignored()
+	out := make(map[int]string)
+	for i := range sm.Sources {
+		if !sm.HasContent(i) {
+			continue
+		}
+		reason := ""
+		p := filterPath(sm.ResolvedSource(i))
+		switch {
+		case f.skipVendor && isVendorSource(p):
+			reason = "vendor"
+		case f.skipIgnored && ignored[i]:
+			reason = "ignore-list"
+		case f.onlyIgnored && !ignored[i]:
+			reason = "not in ignore-list"
+		case len(f.include) > 0 && !f.include.match(p):
+			reason = "not included"
+		case f.exclude.match(p):
+			reason = "excluded"
+		case f.minSize > 0 && sm.contentSize(i) < int(f.minSize):
+			reason = "smaller than -min-size"
+		case f.maxSize > 0 && sm.contentSize(i) > int(f.maxSize):
+			reason = "larger than -max-size"
+		}
+		if reason != "" {
+			sm.setContent(i, "")
+			out[i] = reason
+			if isVendorSource(p) {
+				f.stats.vendorSkipped.Add(1)
+			} else {
+				f.stats.appSkipped.Add(1)
+			}
+		}
+	}
+	return out
+}
+
+// wrote counts a written source for the vendor / first-party summary.
+func (f *sourceFilter) wrote(src string) {
+	if f == nil {
+		return
+	}
+	if isVendorSource(filterPath(src)) {
+		f.stats.vendorWritten.Add(1)
+	} else {
+		f.stats.appWritten.Add(1)
+	}
+}
+
+func (f *sourceFilter) counts() sourceCounts {
+	return sourceCounts{
+		VendorWritten: f.stats.vendorWritten.Load(),
+		VendorSkipped: f.stats.vendorSkipped.Load(),
+		AppWritten:    f.stats.appWritten.Load(),
+		AppSkipped:    f.stats.appSkipped.Load(),
+	}
+}
+
+// logSummary prints the vendor / first-party split once something was counted.
+func (f *sourceFilter) logSummary() {
+	c := f.counts()
+	if c.VendorWritten+c.VendorSkipped+c.AppSkipped == 0 {
+		return
+	}
+	logger.Info(msgVendorSummary.String(), "first_party_written", c.AppWritten, "first_party_skipped", c.AppSkipped,
+		"vendor_written", c.VendorWritten, "vendor_skipped", c.VendorSkipped)
+}
+
+// vendorDirs are path segments holding third-party packages.
+var vendorDirs = map[string]bool{"node_modules": true, "bower_components": true, "jspm_packages": true}
+
+// isVendorSource reports whether a normalized source path is third-party code:
+// anything under node_modules or bower_components, and webpack externals
+// (`external "react"`, `webpack/external ...`).
+func isVendorSource(p string) bool {
+	if strings.HasPrefix(p, "external ") || strings.HasPrefix(p, "webpack/external") {
+		return true
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if vendorDirs[seg] {
+			return true
+		}
+	}
+	return false
+}
+
+// filterPath is the path globs are matched against: the normalized source path
+// without leading "../" and "./" segments.
+func filterPath(src string) string {
+	p := normalizeKeepDots(src)
+	for strings.HasPrefix(p, "../") || strings.HasPrefix(p, "./") {
+		p = p[strings.Index(p, "/")+1:]
+	}
+	return p
+}
+
+// globList collects repeatable glob flags. Patterns use path.Match syntax per
+// segment, plus "**" for any number of segments.
+type globList []string
+
+func (g *globList) String() string { return strings.Join(*g, ", ") }
+
+func (g *globList) reset() { *g = nil }
+
+func (g *globList) Set(v string) error {
+	for _, seg := range strings.Split(v, "/") {
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", v, err)
+		}
+	}
+	*g = append(*g, v)
+	return nil
+}
+
+func (g globList) match(p string) bool {
+	for _, pat := range g {
+		if globMatch(strings.Split(pat, "/"), strings.Split(p, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func globMatch(pat, segs []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for k := 0; k <= len(segs); k++ {
+				if globMatch(pat[1:], segs[k:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], segs[0]); !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// techSignal recognizes a framework or bundler from the name of one source of a
+// map, sourceRoot included. esbuild leaves no marker in source names, so a map
+// built with it shows no bundler.
+type techSignal struct {
+	category string // "framework" or "bundler"
+	tech     string
+	match    func(src string) bool
+}
+
+func hasPathPart(part string) func(string) bool {
+	return func(src string) bool { return strings.Contains(src, part) }
+}
+
+func hasExt(exts ...string) func(string) bool {
+	return func(src string) bool {
+		ext := path.Ext(strings.SplitN(src, "?", 2)[0])
+		for _, e := range exts {
+			if ext == e {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func hasPrefix(prefix string) func(string) bool {
+	return func(src string) bool { return strings.HasPrefix(src, prefix) }
+}
+
+var techSignals = []techSignal{
+	{category: "framework", tech: "React", match: hasPathPart("node_modules/react-dom/")},
+	{category: "framework", tech: "React", match: hasPathPart("node_modules/react/")},
+	{category: "framework", tech: "Vue", match: hasExt(".vue")},
+	{category: "framework", tech: "Vue", match: hasPathPart("node_modules/vue/")},
+	{category: "framework", tech: "Vue", match: hasPathPart("node_modules/@vue/")},
+	{category: "framework", tech: "Angular", match: hasPathPart("node_modules/@angular/core/")},
+	{category: "framework", tech: "Angular", match: hasPathPart(".component.ts")},
+	{category: "framework", tech: "Svelte", match: hasExt(".svelte")},
+	{category: "framework", tech: "Svelte", match: hasPathPart("node_modules/svelte/")},
+	{category: "framework", tech: "Next.js", match: hasPathPart("node_modules/next/")},
+	{category: "framework", tech: "Next.js", match: hasPrefix("webpack://_N_E/")},
+	{category: "framework", tech: "Nuxt", match: hasPathPart("node_modules/nuxt/")},
+	{category: "framework", tech: "Nuxt", match: hasPathPart(".nuxt/")},
+	{category: "framework", tech: "SvelteKit", match: hasPathPart("node_modules/@sveltejs/kit/")},
+	{category: "framework", tech: "Remix", match: hasPathPart("node_modules/@remix-run/")},
+	{category: "framework", tech: "Preact", match: hasPathPart("node_modules/preact/")},
+	{category: "framework", tech: "Solid", match: hasPathPart("node_modules/solid-js/")},
+	{category: "framework", tech: "Ember", match: hasPathPart("node_modules/ember-source/")},
+	{category: "bundler", tech: "webpack", match: hasPrefix("webpack://")},
+	{category: "bundler", tech: "webpack", match: hasPathPart("webpack/bootstrap")},
+	{category: "bundler", tech: "webpack", match: hasPathPart("webpack/runtime/")},
+	{category: "bundler", tech: "Vite", match: hasPathPart("vite/modulepreload-polyfill")},
+	{category: "bundler", tech: "Vite", match: hasPathPart("node_modules/.vite/")},
+	{category: "bundler", tech: "Vite", match: hasPathPart("vite/preload-helper")},
+	{category: "bundler", tech: "Rollup", match: hasPathPart("commonjsHelpers")},
+	{category: "bundler", tech: "Rollup", match: hasPathPart("rollupPluginBabelHelpers")},
+	{category: "bundler", tech: "Parcel", match: hasPathPart("node_modules/@parcel/")},
+	{category: "bundler", tech: "Parcel", match: hasPrefix("/__parcel_source_root/")},
+	{category: "bundler", tech: "Turbopack", match: hasPrefix("turbopack://")},
+	{category: "bundler", tech: "Browserify", match: hasPathPart("node_modules/browser-pack/")},
+}
+
+// techHit is one detected technology with a few of the sources that show it.
+type techHit struct {
+	Name     string   `json:"name"`
+	Sources  int      `json:"sources"`  // number of matching sources
+	Evidence []string `json:"evidence"` // first matching sources, at most fingerprintEvidence
+}
+
+// fingerprint is the "fingerprint" section of report.json.
+type fingerprint struct {
+	Frameworks []techHit `json:"frameworks"`
+	Bundlers   []techHit `json:"bundlers"`
+	TypeScript bool      `json:"typescript"`
+	TSSources  int       `json:"typescript_sources"`
+}
+
+const fingerprintEvidence = 3
+
+// fingerprinter accumulates the signals of every map of a run; crawl workers
+// call addMap concurrently. A nil fingerprinter does nothing.
+type fingerprinter struct {
+	mu   sync.Mutex
+	hits map[string]map[string]*techHit // category -> tech -> hit
+	ts   int
+}
+
+// addMap looks at every source of sm, filtered ones included: vendor code
+// tells the most about the stack.
+func (f *fingerprinter) addMap(sm *SourceMap) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.hits == nil {
+		f.hits = make(map[string]map[string]*techHit)
+	}
+	for _, src := range sm.Sources {
+		full := joinMaybe(sm.SourceRoot, src)
+		if hasExt(".ts", ".tsx", ".mts", ".cts")(full) && !strings.HasSuffix(full, ".d.ts") {
+			f.ts++
+		}
+		seen := make(map[string]bool)
+		for _, s := range techSignals {
+			key := s.category + "\x00" + s.tech
+			if seen[key] || !s.match(full) {
+				continue
+			}
+			seen[key] = true
+			if f.hits[s.category] == nil {
+				f.hits[s.category] = make(map[string]*techHit)
+			}
+			h := f.hits[s.category][s.tech]
+			if h == nil {
+				h = &techHit{Name: s.tech}
+				f.hits[s.category][s.tech] = h
+			}
+			h.Sources++
+			if len(h.Evidence) < fingerprintEvidence {
+				h.Evidence = append(h.Evidence, full)
+			}
+		}
+	}
+}
+
+func techList(m map[string]*techHit) []techHit {
+	out := make([]techHit, 0, len(m))
+	for _, h := range m {
+		out = append(out, *h)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Sources != out[j].Sources {
+			return out[i].Sources > out[j].Sources
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// result returns the fingerprint, or nil when no map was seen.
+func (f *fingerprinter) result() *fingerprint {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.hits == nil {
+		return nil
+	}
+	return &fingerprint{
+		Frameworks: techList(f.hits["framework"]),
+		Bundlers:   techList(f.hits["bundler"]),
+		TypeScript: f.ts > 0,
+		TSSources:  f.ts,
+	}
+}
+
+// logSummary prints the detected stack in one line.
+func (f *fingerprinter) logSummary() {
+	fp := f.result()
+	if fp == nil || len(fp.Frameworks)+len(fp.Bundlers) == 0 && !fp.TypeScript {
+		return
+	}
+	names := func(hits []techHit) string {
+		var out []string
+		for _, h := range hits {
+			out = append(out, h.Name)
+		}
+		return strings.Join(out, ",")
+	}
+	logger.Info(msgFingerprint.String(), "frameworks", names(fp.Frameworks), "bundlers", names(fp.Bundlers), "typescript", fp.TypeScript)
+}
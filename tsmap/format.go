@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// formatStderrMax caps the formatter output kept in an error.
+const formatStderrMax = 300
+
+// formatCommand pipes every recovered source through an external formatter
+// (-format-cmd), at most jobs at a time. {path} in the arguments is replaced by
+// the output path of the source, for formatters choosing a parser from the
+// file name. A nil or empty formatCommand leaves sources alone.
+type formatCommand struct {
+	argv    []string
+	timeout time.Duration
+	jobs    int
+
+	once sync.Once
+	sem  chan struct{}
+}
+
+func addFormatFlags(fs *flag.FlagSet) *formatCommand {
+	f := &formatCommand{}
+	fs.Var(f, "format-cmd", "Pipe every recovered file through this formatter command, {path} being the file path (e.g. \"prettier --stdin-filepath {path}\")")
+	fs.DurationVar(&f.timeout, "format-timeout", 30*time.Second, "Time limit of one -format-cmd run")
+	fs.IntVar(&f.jobs, "format-jobs", runtime.NumCPU(), "-format-cmd runs at the same time")
+	return f
+}
+
+func (f *formatCommand) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.argv, " ")
+}
+
+func (f *formatCommand) Set(v string) error {
+	argv, err := splitCommand(v)
+	if err != nil {
+		return err
+	}
+	if len(argv) == 0 {
+		return errors.New("empty command")
+	}
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return err
+	}
+	f.argv = argv
+	return nil
+}
+
+// format returns content as rewritten by the formatter for the file at path,
+// or content unchanged, with a warning, when the formatter fails.
+func (f *formatCommand) format(path, content string) string {
+	if f == nil || len(f.argv) == 0 {
+		return content
+	}
+	f.once.Do(func() { f.sem = make(chan struct{}, max(f.jobs, 1)) })
+	f.sem <- struct{}{}
+	defer func() { <-f.sem }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	args := make([]string, len(f.argv)-1)
+	for i, a := range f.argv[1:] {
+		args[i] = strings.ReplaceAll(a, "{path}", path)
+	}
+	cmd := exec.CommandContext(ctx, f.argv[0], args...)
+	cmd.WaitDelay = time.Second // a child left holding the pipes
+	var out, errOut bytes.Buffer
+	cmd.Stdin = strings.NewReader(content)
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err := cmd.Run()
+	switch {
+	case ctx.Err() != nil:
+		err = fmt.Errorf("no result after %s", f.timeout)
+	case err != nil:
+		if msg := strings.TrimSpace(errOut.String()); msg != "" {
+			if len(msg) > formatStderrMax {
+				msg = msg[:formatStderrMax] + "..."
+			}
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+	case out.Len() == 0 && strings.TrimSpace(content) != "":
+		err = errors.New("empty output")
+	}
+	if err != nil {
+		logger.Warn(msgFormatFailed.String(), "path", path, "err", err)
+		return content
+	}
+	return out.String()
+}
+
+// splitCommand splits a command line into arguments on blanks, as a POSIX
+// shell would without expansions: single quotes keep everything, double
+// quotes and backslashes escape.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ' ', '\t', '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+			continue
+		case '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated ' quote")
+			}
+			cur.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case '"':
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\$`+"`", s[i+1]) >= 0 {
+					i++
+				}
+				cur.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, errors.New(`unterminated " quote`)
+			}
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				c = s[i]
+			}
+			cur.WriteByte(c)
+		default:
+			cur.WriteByte(c)
+		}
+		inArg = true
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
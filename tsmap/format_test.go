@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"prettier --stdin-filepath {}", []string{"prettier", "--stdin-filepath", "{}"}},
+		{"  a\t b\n", []string{"a", "b"}},
+		{`a 'b c' "d e"`, []string{"a", "b c", "d e"}},
+		{`'it'\''s'`, []string{"it's"}},
+		{`"a\"b\\c\$d\` + "`" + `e\f"`, []string{"a\"b\\c$d`e\\f"}},
+		{`a\ b \'c`, []string{"a b", "'c"}},
+		{`x'' "" y`, []string{"x", "", "y"}},
+		{`pre"mid"'post'`, []string{"premidpost"}},
+		{`trailing\`, []string{`trailing\`}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.in)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{`a 'b`, `a "b`, `"a\"`} {
+		if got, err := splitCommand(in); err == nil {
+			t.Errorf("splitCommand(%q) = %q, want an error", in, got)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// gitCommit commits everything in dir (-git). dir gets its own repository unless
+// it already is the top of one, so a parent repository is never touched. It
+// returns the short hash of the commit, or "" when nothing changed.
+func gitCommit(dir, msg string) (string, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", errors.New("git not found in PATH")
+	}
+	// a .git that is not a repository, or one of a parent directory, does not count
+	if gitDir, err := runGit(dir, "rev-parse", "--git-dir"); err != nil || gitDir != ".git" {
+		if _, err := runGit(dir, "init", "-q"); err != nil {
+			return "", err
+		}
+	}
+	if _, err := runGit(dir, "add", "-A", "."); err != nil {
+		return "", err
+	}
+	if _, err := runGit(dir, "diff", "--cached", "--quiet"); err == nil {
+		return "", nil
+	}
+	args := []string{"commit", "-q", "-m", msg}
+	if name, _ := runGit(dir, "config", "user.email"); name == "" {
+		// fresh containers have no identity; commits must still work
+		args = append([]string{"-c", "user.name=tsmap-extract", "-c", "user.email=tsmap-extract@localhost"}, args...)
+	}
+	if _, err := runGit(dir, args...); err != nil {
+		return "", err
+	}
+	return runGit(dir, "rev-parse", "--short", "HEAD")
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	// the repository is the one of dir, whatever the environment points to
+	for _, e := range os.Environ() {
+		if k, _, _ := strings.Cut(e, "="); k != "GIT_DIR" && k != "GIT_WORK_TREE" && k != "GIT_INDEX_FILE" {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(errOut.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
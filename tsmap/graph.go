@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	graphDOTFile  = "graph.dot"
+	graphJSONFile = "graph.json"
+)
+
+// extensions tried, in order, for an import without one
+var graphResolveExts = []string{".ts", ".tsx", ".js", ".jsx", ".mjs", ".cjs", ".mts", ".cts", ".vue", ".svelte", ".json"}
+
+// graphNode is one module (a recovered file), package or unresolved import.
+type graphNode struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"` // module, package or unresolved
+}
+
+type graphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// moduleGraph is graph.json.
+type moduleGraph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges"`
+}
+
+// graphBuilder collects the imports of recovered first-party sources and
+// exports the module dependency graph (-graph); crawl workers call scan
+// concurrently. A nil builder does nothing.
+type graphBuilder struct {
+	enabled bool
+
+	mu      sync.Mutex
+	imports map[string][]string // module -> import specifiers
+}
+
+func addGraphFlags(fs *flag.FlagSet) *graphBuilder {
+	g := &graphBuilder{}
+	fs.BoolVar(&g.enabled, "graph", false, "Export the import graph of recovered first-party sources as graph.dot (Graphviz) and graph.json")
+	return g
+}
+
+// scan records the imports of one written file; vendor code is skipped.
+func (g *graphBuilder) scan(rel string, data []byte, meta FileMeta) {
+	if g == nil || !g.enabled || isVendorSource(filterPath(rel)) || (meta.Source != "" && isVendorSource(filterPath(meta.Source))) {
+		return
+	}
+	var specs []string
+	for _, m := range reImport.FindAllStringSubmatch(string(data), -1) {
+		specs = addUnique(specs, m[1])
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.imports == nil {
+		g.imports = make(map[string][]string)
+	}
+	g.imports[rel] = specs
+}
+
+// resolve returns the node an import of from points to: a recovered module,
+// a package, or an unresolved node (the joined path of a relative import, the
+// specifier otherwise) when neither matches.
+func (g *graphBuilder) resolve(from, spec string, stems map[string]string) graphNode {
+	if strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../") {
+		target := path.Join(path.Dir(from), spec)
+		if _, ok := g.imports[target]; ok {
+			return graphNode{ID: target, Kind: "module"}
+		}
+		for _, ext := range graphResolveExts {
+			for _, c := range []string{target + ext, target + "/index" + ext} {
+				if _, ok := g.imports[c]; ok {
+					return graphNode{ID: c, Kind: "module"}
+				}
+			}
+		}
+		return graphNode{ID: target, Kind: "unresolved"}
+	}
+	for _, p := range aliasPrefixes {
+		if !strings.HasPrefix(spec, p) {
+			continue
+		}
+		// "@/lib/api" is the shortest recovered module whose path ends with lib/api
+		rest, best := strings.TrimPrefix(spec, p), ""
+		if p == "src/" {
+			rest = spec
+		}
+		for stem, file := range stems {
+			if (stem == rest || strings.HasSuffix(stem, "/"+rest)) && (best == "" || len(file) < len(best) || len(file) == len(best) && file < best) {
+				best = file
+			}
+		}
+		if best != "" {
+			return graphNode{ID: best, Kind: "module"}
+		}
+		return graphNode{ID: spec, Kind: "unresolved"}
+	}
+	if pkg := importedPackage(spec); pkg != "" {
+		return graphNode{ID: pkg, Kind: "package"}
+	}
+	return graphNode{ID: spec, Kind: "unresolved"}
+}
+
+// build resolves every import into the graph, sorted for stable output.
+func (g *graphBuilder) build() moduleGraph {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stems := make(map[string]string, len(g.imports))
+	for f := range g.imports {
+		stem := strings.TrimSuffix(f, path.Ext(f))
+		stems[stem] = f
+		if dir := strings.TrimSuffix(stem, "/index"); dir != stem {
+			stems[dir] = f
+		}
+	}
+	nodes := make(map[string]string) // id -> kind
+	edges := make(map[graphEdge]bool)
+	for from, specs := range g.imports {
+		nodes[from] = "module"
+		for _, spec := range specs {
+			to := g.resolve(from, spec, stems)
+			if nodes[to.ID] != "module" {
+				nodes[to.ID] = to.Kind
+			}
+			edges[graphEdge{From: from, To: to.ID}] = true
+		}
+	}
+	out := moduleGraph{Nodes: []graphNode{}, Edges: []graphEdge{}}
+	for _, id := range sortedKeys(nodes) {
+		out.Nodes = append(out.Nodes, graphNode{ID: id, Kind: nodes[id]})
+	}
+	for e := range edges {
+		out.Edges = append(out.Edges, e)
+	}
+	sort.Slice(out.Edges, func(i, j int) bool {
+		if out.Edges[i].From != out.Edges[j].From {
+			return out.Edges[i].From < out.Edges[j].From
+		}
+		return out.Edges[i].To < out.Edges[j].To
+	})
+	return out
+}
+
+// dot renders the graph for Graphviz: modules as ellipses, packages as boxes,
+// unresolved imports dashed.
+func (mg moduleGraph) dot() string {
+	var sb strings.Builder
+	sb.WriteString("digraph modules {\n\trankdir=LR;\n\tnode [fontsize=10];\n")
+	for _, n := range mg.Nodes {
+		switch n.Kind {
+		case "package":
+			fmt.Fprintf(&sb, "\t%q [shape=box, style=filled, fillcolor=lightgrey];\n", n.ID)
+		case "unresolved":
+			fmt.Fprintf(&sb, "\t%q [style=dashed];\n", n.ID)
+		default:
+			fmt.Fprintf(&sb, "\t%q;\n", n.ID)
+		}
+	}
+	for _, e := range mg.Edges {
+		fmt.Fprintf(&sb, "\t%q -> %q;\n", e.From, e.To)
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// save writes graph.dot and graph.json.
+func (g *graphBuilder) save(o *outputOptions) {
+	if g == nil || !g.enabled {
+		return
+	}
+	mg := g.build()
+	kinds := make(map[string]int)
+	for _, n := range mg.Nodes {
+		kinds[n.Kind]++
+	}
+	logger.Info(msgGraphSummary.String(), "modules", kinds["module"], "packages", kinds["package"], "unresolved", kinds["unresolved"], "edges", len(mg.Edges))
+	if o.dryRun {
+		return
+	}
+	data, _ := json.MarshalIndent(mg, "", "  ")
+	if err := o.writeMeta(graphJSONFile, data); err != nil {
+		logger.Warn(msgGraphError.String(), "err", err)
+		return
+	}
+	if err := o.writeMeta(graphDOTFile, []byte(mg.dot())); err != nil {
+		logger.Warn(msgGraphError.String(), "err", err)
+	}
+}
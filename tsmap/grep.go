@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type grepFlags struct {
+	out        string
+	pattern    string
+	ignoreCase bool
+	fixed      bool
+	context    int
+	json       bool
+	types      string
+	log        *logOptions
+}
+
+func newGrepFlags() (*flag.FlagSet, *grepFlags) {
+	f := &grepFlags{}
+	fs := flag.NewFlagSet("tsmap-extract grep", flag.ExitOnError)
+	fs.StringVar(&f.out, "out", "", "Output directory of extract or crawl to search (required)")
+	fs.StringVar(&f.pattern, "pattern", "", "Regular expression to search for, RE2 syntax (required)")
+	fs.BoolVar(&f.ignoreCase, "i", false, "Case-insensitive match")
+	fs.BoolVar(&f.fixed, "F", false, "Treat -pattern as a literal string")
+	fs.IntVar(&f.context, "context", 0, "Lines of context around each match")
+	fs.BoolVar(&f.json, "json", false, "Print the matches as a JSON array")
+	fs.StringVar(&f.types, "type", "", "Comma-separated file types to search, by extension (e.g. ts,tsx,vue)")
+	fs.String("config", "", "YAML config file (default ~/.config/tsmap-extract/config.yaml)")
+	f.log = addLogFlags(fs)
+	return fs, f
+}
+
+// grepMatch is one matching line, with the provenance of its file when the
+// output has a manifest.
+type grepMatch struct {
+	Path   string   `json:"path"` // relative to the output directory, slash separated
+	Line   int      `json:"line"`
+	Text   string   `json:"text"`
+	Source string   `json:"source,omitempty"`
+	Map    string   `json:"map,omitempty"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// RunGrep runs the "grep" subcommand: it exits 0 when a line matched, 1 when
+// none did and 3 when the output cannot be read.
+func RunGrep(args []string) int {
+	useConsoleLogger()
+	fs, f := newGrepFlags()
+	loadDefaults(fs, "grep", args)
+	fs.Parse(args)
+	if f.json {
+		f.log.console = os.Stderr
+	}
+	defer f.log.setup()()
+
+	if f.out == "" || f.pattern == "" {
+		logger.Error(msgGrepArgs.String())
+		fs.Usage()
+		return exitUsage
+	}
+	expr := f.pattern
+	if f.fixed {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if f.ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		usageFail(msgGrepPattern, err)
+	}
+	types := make(map[string]bool)
+	for _, t := range strings.Split(f.types, ",") {
+		if t = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(t), ".")); t != "" {
+			types[languageOf("x."+t)] = true
+		}
+	}
+	entries, err := grepFiles(f.out)
+	if err != nil {
+		fail(msgGrepRead, err)
+	}
+
+	var matches []grepMatch
+	files, searched, printed := 0, 0, false
+	for _, e := range entries {
+		if len(types) > 0 && !types[languageOf(e.Path)] {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.out, filepath.FromSlash(e.Path)))
+		if err != nil {
+			logger.Warn(msgGrepFileError.String(), "path", e.Path, "err", err)
+			continue
+		}
+		searched++
+		found := grepLines(re, e, strings.Split(string(data), "\n"), f.context)
+		if len(found) == 0 {
+			continue
+		}
+		files++
+		matches = append(matches, found...)
+		if !f.json {
+			printGrepMatches(re, found, f.context, &printed)
+		}
+	}
+
+	if f.json {
+		if matches == nil {
+			matches = []grepMatch{}
+		}
+		data, _ := json.MarshalIndent(matches, "", "  ")
+		fmt.Println(string(data))
+	}
+	logger.Info(msgGrepSummary.String(), "matches", len(matches), "files", files, "searched", searched)
+	if len(matches) == 0 {
+		return exitNoSources
+	}
+	return exitOK
+}
+
+// grepFiles lists the files of an output from its manifest.json or, for
+// outputs without one, by walking the directory.
+func grepFiles(out string) ([]manifestEntry, error) {
+	data, err := os.ReadFile(filepath.Join(out, "manifest.json"))
+	if err == nil {
+		var entries []manifestEntry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Join(out, "manifest.json"), err)
+		}
+		return entries, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	var entries []manifestEntry
+	err = filepath.WalkDir(out, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(out, p)
+		if rel = filepath.ToSlash(rel); d.Type().IsRegular() && !isRunFile(rel) {
+			entries = append(entries, manifestEntry{Path: rel})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// grepLines returns the matching lines of one file with their context.
+func grepLines(re *regexp.Regexp, e manifestEntry, lines []string, context int) []grepMatch {
+	var out []grepMatch
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if !re.MatchString(line) {
+			continue
+		}
+		m := grepMatch{Path: e.Path, Line: i + 1, Text: line, Source: e.Source, Map: e.Map}
+		if context > 0 {
+			for j := max(0, i-context); j < i; j++ {
+				m.Before = append(m.Before, strings.TrimSuffix(lines[j], "\r"))
+			}
+			for j := i + 1; j < len(lines) && j <= i+context; j++ {
+				m.After = append(m.After, strings.TrimSuffix(lines[j], "\r"))
+			}
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// printGrepMatches prints the matches of one file as grep does: "path:line:text"
+// for matches, "path-line-text" for context and "--" between separate groups;
+// printed tells whether an earlier file printed a group.
+func printGrepMatches(re *regexp.Regexp, found []grepMatch, context int, printed *bool) {
+	last := 0 // last line printed
+	for _, m := range found {
+		first := m.Line - len(m.Before)
+		if context > 0 && *printed && (last == 0 || first > last+1) {
+			fmt.Println("--")
+		}
+		*printed = true
+		for i, l := range m.Before {
+			if n := first + i; n > last {
+				fmt.Printf("%s%s%s-%d-%s\n", cCyn, m.Path, cRst, n, l)
+			}
+		}
+		if m.Line > last {
+			text := re.ReplaceAllStringFunc(m.Text, func(s string) string { return cRed + s + cRst })
+			fmt.Printf("%s%s%s:%s%d%s:%s\n", cCyn, m.Path, cRst, cGrn, m.Line, cRst, text)
+		}
+		last = max(last, m.Line)
+		for i, l := range m.After {
+			if n := m.Line + 1 + i; n > last {
+				// a following match is printed as a match, not as context
+				if re.MatchString(l) {
+					break
+				}
+				fmt.Printf("%s%s%s-%d-%s\n", cCyn, m.Path, cRst, n, l)
+				last = n
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// guessMapLocator tries the names and places deployments often give maps
+// besides script.js.map (-map-guess aggressive): script.map, the name
+// without .min, the names of templates for a script with a content hash, and
+// maps/ and sourcemaps/ directories inside and next to the script's. The
+// query string is dropped, as by suffixMapLocator.
+type guessMapLocator struct {
+	templates mapTemplates // empty for defaultMapTemplates
+}
+
+func (guessMapLocator) Name() string { return "guess" }
+
+func (l guessMapLocator) LocateMaps(_ string, scriptURL *url.URL) ([]MapCandidate, error) {
+	dir, file := path.Split(scriptURL.Path)
+	if file == "" {
+		return nil, nil
+	}
+	ext := path.Ext(file)
+	stem := strings.TrimSuffix(file, ext)
+	names := []string{file + ".map", stem + ".map"}
+	if base, ok := strings.CutSuffix(stem, ".min"); ok {
+		names = append(names, base+ext+".map")
+	}
+	// elsewhere, only the usual name and the first template
+	others := []string{file + ".map"}
+	if name, hash := splitContentHash(stem); hash != "" {
+		templates := l.templates
+		if len(templates) == 0 {
+			templates = defaultMapTemplates
+		}
+		expanded := templates.expand(name, hash, ext)
+		names = append(names, expanded...)
+		others = append(others, expanded[0])
+	}
+	parent := path.Dir(path.Clean(dir)) + "/"
+	if parent == "//" {
+		parent = "/"
+	}
+	var paths []string
+	for _, n := range names {
+		paths = append(paths, dir+n)
+	}
+	for _, d := range []string{dir + "maps/", dir + "sourcemaps/", parent + "maps/", parent + "sourcemaps/"} {
+		for _, n := range others {
+			paths = append(paths, d+n)
+		}
+	}
+	seen := make(map[string]bool)
+	var out []MapCandidate
+	for _, p := range paths {
+		u := scriptURL.ResolveReference(&url.URL{Path: path.Clean(p)})
+		if !seen[u.String()] {
+			seen[u.String()] = true
+			out = append(out, MapCandidate{URL: u, Guess: true})
+		}
+	}
+	return out, nil
+}
+
+// splitContentHash removes the content hashes of a file name without its
+// extension, dot separated parts (main.3f2a1b9c.chunk) or dash suffixes
+// (index-BxK3a9Zq), and returns the first one; hash is "" when there is none.
+func splitContentHash(stem string) (name, hash string) {
+	parts := strings.Split(stem, ".")
+	kept := parts[:0]
+	for i, p := range parts {
+		if i > 0 && isContentHash(p) {
+			if hash == "" {
+				hash = p
+			}
+			continue
+		}
+		if j := strings.LastIndexByte(p, '-'); j > 0 && isContentHash(p[j+1:]) {
+			if hash == "" {
+				hash = p[j+1:]
+			}
+			p = p[:j]
+		}
+		kept = append(kept, p)
+	}
+	return strings.Join(kept, "."), hash
+}
+
+// isContentHash reports whether s looks like a content hash: 8 characters or
+// more of hex or base64url, with a digit so that words are left alone.
+func isContentHash(s string) bool {
+	if len(s) < 8 {
+		return false
+	}
+	digit := false
+	for _, c := range s {
+		switch {
+		case '0' <= c && c <= '9':
+			digit = true
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', c == '_':
+		default:
+			return false
+		}
+	}
+	return digit
+}
+
+// mapTemplates are the map names tried for a script with a content hash
+// (-map-template): {name} is the file name without hash nor extension, {hash}
+// the hash and {ext} the extension, dot included. A template may hold a
+// relative directory, resolved against the script's.
+type mapTemplates []string
+
+// defaultMapTemplates turn main.3f9ab2c1.js into main.js.map and
+// main.3f9ab2c1.min.js.map.
+var defaultMapTemplates = mapTemplates{"{name}{ext}.map", "{name}.{hash}.min{ext}.map"}
+
+var mapTemplateVars = strings.NewReplacer("{name}", "", "{hash}", "", "{ext}", "")
+
+func (t *mapTemplates) String() string { return strings.Join(*t, ",") }
+
+func (t *mapTemplates) reset() { *t = nil }
+
+func (t *mapTemplates) Set(v string) error {
+	for _, tmpl := range strings.Split(v, ",") {
+		if err := checkMapTemplate(strings.TrimSpace(tmpl)); err != nil {
+			return err
+		}
+		*t = append(*t, strings.TrimSpace(tmpl))
+	}
+	return nil
+}
+
+// checkMapTemplate refuses an empty template and unknown placeholders.
+func checkMapTemplate(tmpl string) error {
+	if tmpl == "" {
+		return errors.New("empty map template")
+	}
+	if rest := mapTemplateVars.Replace(tmpl); strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("map template %q: only {name}, {hash} and {ext} can be used", tmpl)
+	}
+	return nil
+}
+
+func (t mapTemplates) expand(name, hash, ext string) []string {
+	r := strings.NewReplacer("{name}", name, "{hash}", hash, "{ext}", ext)
+	out := make([]string, len(t))
+	for i, tmpl := range t {
+		out[i] = r.Replace(tmpl)
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxLiteralURLs caps the URLs harvested from one script.
+const maxLiteralURLs = 200
+
+// reURLLiteral matches a quoted path or http(s) URL ending in .js, .mjs or
+// .css, query string allowed. A '/' is required, so that package names such as
+// "chart.js" are left alone.
+var reURLLiteral = regexp.MustCompile("[\"'`]((?:https?:)?[\\w./~@%+-]*/[\\w./~@%+-]*\\.(?:m?js|css)(?:\\?[\\w=&.%-]*)?)[\"'`]")
+
+// literalDetector collects the string literals of a script naming other
+// scripts or stylesheets (-harvest), for assets loaded lazily by hand-written
+// code that no bundler pattern describes. Paths starting with ./ or ../ are
+// resolved against the script, others against the public path when the
+// runtime sets one, else the script too. Only URLs on hosts of scope are kept.
+type literalDetector struct {
+	scope hostList
+}
+
+func (literalDetector) Name() string { return "literal" }
+
+func (d literalDetector) DetectChunks(js string, scriptURL, rootURL *url.URL) []*url.URL {
+	base := scriptURL
+	if pp := webpackPublicPath(js, scriptURL, rootURL); pp != nil {
+		base = pp
+	}
+	seen := map[string]bool{scriptURL.String(): true}
+	var out []*url.URL
+	for _, m := range reURLLiteral.FindAllStringSubmatch(js, -1) {
+		lit := m[1]
+		// module ids of development builds, not served files
+		if strings.Contains(lit, "node_modules/") || strings.HasPrefix(lit, "./src/") {
+			continue
+		}
+		from := base
+		if strings.HasPrefix(lit, "./") || strings.HasPrefix(lit, "../") {
+			from = scriptURL
+		}
+		u, err := from.Parse(lit)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !d.scope.match(u.Hostname()) || seen[u.String()] {
+			continue
+		}
+		seen[u.String()] = true
+		out = append(out, u)
+		if len(out) == maxLiteralURLs {
+			break
+		}
+	}
+	return out
+}
+
+// literalScope returns the hosts harvested URLs may point to: -scope when
+// set, else those of the page, of the script and the script hosts of the
+// page's CSP.
+func (s *crawlSession) literalScope(scriptURL *url.URL) hostList {
+	if len(s.scope) > 0 {
+		return s.scope
+	}
+	return append(hostList{s.rootURL.Hostname(), scriptURL.Hostname()}, s.scriptHosts...)
+}
+
+// claim reports whether u was not crawled nor claimed yet in this pass, and
+// claims it.
+func (s *crawlSession) claim(u string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.claimed == nil {
+		s.claimed = make(map[string]bool)
+	}
+	if s.claimed[u] {
+		return false
+	}
+	s.claimed[u] = true
+	return true
+}
@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// linkPreloads returns the scripts, stylesheets and WebAssembly modules the
+// Link headers of a response preload (rel=preload or modulepreload), resolved
+// against base.
+func linkPreloads(h http.Header, base *url.URL) []*url.URL {
+	var out []*url.URL
+	for _, v := range h.Values("Link") {
+		for _, l := range parseLinks(v) {
+			rels := strings.Fields(strings.ToLower(l.params["rel"]))
+			switch as := strings.ToLower(l.params["as"]); {
+			case slices.Contains(rels, "modulepreload"):
+			case slices.Contains(rels, "preload") && (as == "script" || as == "style" || as == "worker"):
+			case slices.Contains(rels, "preload") && as == "fetch" && path.Ext(l.target) == ".wasm":
+			default:
+				continue
+			}
+			if u, err := base.Parse(l.target); err == nil {
+				out = append(out, u)
+			}
+		}
+	}
+	return out
+}
+
+// link is one entry of a Link header: <target>; param=value...
+type link struct {
+	target string
+	params map[string]string
+}
+
+// parseLinks splits a Link header value into its entries. Commas inside the
+// target or a quoted parameter do not separate entries.
+func parseLinks(v string) []link {
+	var out []link
+	for {
+		i := strings.IndexByte(v, '<')
+		if i < 0 {
+			return out
+		}
+		j := strings.IndexByte(v[i:], '>')
+		if j < 0 {
+			return out
+		}
+		l := link{target: strings.TrimSpace(v[i+1 : i+j]), params: make(map[string]string)}
+		v = v[i+j+1:]
+		// parameters run to the next comma outside quotes
+		end, quoted := len(v), false
+		for k := 0; k < len(v); k++ {
+			if v[k] == '"' {
+				quoted = !quoted
+			} else if v[k] == ',' && !quoted {
+				end = k
+				break
+			}
+		}
+		for _, p := range strings.Split(v[:end], ";") {
+			key, val, _ := strings.Cut(p, "=")
+			key = strings.ToLower(strings.TrimSpace(key))
+			if key != "" {
+				l.params[key] = strings.Trim(strings.TrimSpace(val), `"`)
+			}
+		}
+		out = append(out, l)
+		v = v[end:]
+	}
+}
+
+// cspScriptSources returns what the Content-Security-Policy headers of a
+// response allow scripts from, script-src-elem, script-src or else default-src:
+// the hosts of its host sources, and the URLs of those naming a file. Keywords,
+// nonces, hashes, schemes alone and wildcard hosts are skipped, as is the
+// host of base.
+func cspScriptSources(h http.Header, base *url.URL) (hosts []string, assets []*url.URL) {
+	seen := make(map[string]bool)
+	for _, name := range []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"} {
+		for _, policy := range h.Values(name) {
+			for _, src := range cspDirective(policy, "script-src-elem", "script-src", "default-src") {
+				if strings.HasPrefix(src, "'") || strings.HasSuffix(src, ":") || strings.Contains(src, "*") {
+					continue
+				}
+				raw := src
+				if !strings.Contains(raw, "://") {
+					raw = base.Scheme + "://" + raw
+				}
+				u, err := url.Parse(raw)
+				if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+					continue
+				}
+				if u.Hostname() != base.Hostname() && !seen[u.Hostname()] {
+					seen[u.Hostname()] = true
+					hosts = append(hosts, u.Hostname())
+				}
+				// a path not ending in '/' names a single file
+				if u.Path != "" && !strings.HasSuffix(u.Path, "/") {
+					assets = append(assets, u)
+				}
+			}
+		}
+	}
+	return hosts, assets
+}
+
+// cspDirective returns the source list of the first of names a policy has.
+func cspDirective(policy string, names ...string) []string {
+	directives := make(map[string][]string)
+	for _, d := range strings.Split(policy, ";") {
+		fields := strings.Fields(d)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if _, ok := directives[name]; !ok {
+			directives[name] = fields[1:]
+		}
+	}
+	for _, n := range names {
+		if srcs, ok := directives[n]; ok {
+			return srcs
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// hostTraffic is what a crawl did with one host, as listed in report.json.
+type hostTraffic struct {
+	Host      string `json:"host"`
+	Requests  int    `json:"requests"`
+	Errors    int    `json:"errors,omitempty"` // no response or HTTP error
+	Cached    int    `json:"cached,omitempty"` // answered by -http-cache
+	Bytes     int64  `json:"bytes"`            // downloaded, -http-cache answers excluded
+	Maps      int    `json:"maps"`             // maps served by the host, inline ones by the script's
+	Sources   int    `json:"sources_written"`
+	LatencyMS int64  `json:"avg_latency_ms"` // mean request duration, body included
+}
+
+// hostTally counts the requests of a crawl by host; downloads call request
+// concurrently. A nil hostTally counts nothing.
+type hostTally struct {
+	mu    sync.Mutex
+	hosts map[string]*hostTraffic
+	spent map[string]time.Duration
+}
+
+// request records a request made to host; status 0 means it got no response.
+func (h *hostTally) request(host string, status int, bytes int64, d time.Duration, cached bool) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hosts == nil {
+		h.hosts = make(map[string]*hostTraffic)
+		h.spent = make(map[string]time.Duration)
+	}
+	t := h.hosts[host]
+	if t == nil {
+		t = &hostTraffic{Host: host}
+		h.hosts[host] = t
+	}
+	t.Requests++
+	if status == 0 || status >= 400 {
+		t.Errors++
+	}
+	if cached {
+		t.Cached++
+	} else {
+		t.Bytes += bytes
+	}
+	h.spent[host] += d
+}
+
+// list returns the counters by host, with the maps and sources of scripts,
+// the hosts downloading the most first.
+func (h *hostTally) list(scripts []*scriptReport) []hostTraffic {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]hostTraffic, 0, len(h.hosts))
+	index := make(map[string]int)
+	for host, t := range h.hosts {
+		s := *t
+		s.LatencyMS = (h.spent[host] / time.Duration(t.Requests)).Milliseconds()
+		index[host] = len(out)
+		out = append(out, s)
+	}
+	count := func(mapURL, scriptURL string, sources int) {
+		if u, err := url.Parse(mapURL); err == nil && u.Hostname() != "" {
+			scriptURL = mapURL
+		}
+		if i, ok := index[urlHost(scriptURL)]; ok {
+			out[i].Maps++
+			out[i].Sources += sources
+		}
+	}
+	for _, sr := range scripts {
+		if sr.MapURL != "" {
+			count(sr.MapURL, sr.URL, sr.Sources)
+		}
+		for _, m := range sr.ExtraMaps {
+			count(m, sr.URL, 0)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bytes != out[j].Bytes {
+			return out[i].Bytes > out[j].Bytes
+		}
+		return out[i].Host < out[j].Host
+	})
+	return out
+}
+
+// logHostSummary prints one line per host.
+func logHostSummary(hosts []hostTraffic) {
+	for _, t := range hosts {
+		logger.Info(msgHostSummary.String(), "host", t.Host, "requests", t.Requests, "errors", t.Errors, "bytes", humanBytes(t.Bytes),
+			"maps", t.Maps, "sources", t.Sources, "avg_latency", time.Duration(t.LatencyMS)*time.Millisecond)
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"crypto/tls"
+	"flag"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// httpOptions holds the HTTP flags shared by every subcommand that downloads something.
+type httpOptions struct {
+	userAgent string
+	proxy     string
+	insecure  bool
+	headers   headerList
+	cookie    string
+	cacheDir  string
+	record    string
+	replay    string
+
+	crawler *crawler // built on the first download (extract may fetch twice)
+}
+
+func addHTTPFlags(fs *flag.FlagSet) *httpOptions {
+	o := &httpOptions{}
+	fs.StringVar(&o.userAgent, "user-agent", defaultUserAgent, "User-Agent header")
+	fs.StringVar(&o.proxy, "proxy", "", "Proxy URL (e.g. http://127.0.0.1:8080)")
+	fs.BoolVar(&o.insecure, "insecure", false, "Skip TLS verification, usefull with burpsuite")
+	fs.Var(&o.headers, "header", "Extra request header 'Name: value' (repeatable)")
+	fs.StringVar(&o.cookie, "cookie", "", "Cookie header value sent with every request")
+	fs.StringVar(&o.cacheDir, "http-cache", "", "Keep responses in this directory and revalidate them (ETag, Last-Modified) on later runs")
+	fs.StringVar(&o.record, "record", "", "Record every HTTP response in this directory, for -replay")
+	fs.StringVar(&o.replay, "replay", "", "Answer every request from a -record directory, without network access")
+	return o
+}
+
+// fetchTimeout bounds every request, body included.
+const fetchTimeout = 25 * time.Second
+
+// crawler performs the downloads of one run with its own client, cache, user
+// agent and headers, so that runs in the same process do not interfere.
+type crawler struct {
+	client    *http.Client
+	cache     *httpCache // nil without -http-cache
+	userAgent string
+	headers   http.Header
+	limits    *hostLimiter // per-host budgets, nil when no config
+	metrics   *metricSet   // nil outside server
+	stats     *hostTally   // per-host counters, crawl command only
+}
+
+// newCrawler returns a crawler using the proxy, TLS, cache and header options.
+func (o *httpOptions) newCrawler(limits *hostLimiter) *crawler {
+	transport := &http.Transport{}
+	if o.proxy != "" {
+		proxyURL, err := url.Parse(o.proxy)
+		if err != nil {
+			usageFail(msgInvalidProxy, err)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSHandshakeTimeout = 30 * time.Second
+		logger.Info(msgUsingProxy.String(), "url", proxyURL.String())
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	// Option to skip TLS verification (for Burp/ZAP interception)
+	if o.insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		logger.Warn(msgInsecureTLS.String())
+	}
+	var rt http.RoundTripper = transport
+	switch {
+	case o.record != "" && o.replay != "":
+		usageFail(msgRecordReplay)
+	case o.record != "":
+		rt = &cassette{dir: o.record, next: transport}
+		logger.Info(msgRecording.String(), "dir", o.record)
+	case o.replay != "":
+		if _, err := os.Stat(o.replay); err != nil {
+			usageFail(msgInvalidReplay, err)
+		}
+		rt = &cassette{dir: o.replay, replay: true}
+		logger.Info(msgReplaying.String(), "dir", o.replay)
+	}
+	c := &crawler{
+		client:    &http.Client{Timeout: fetchTimeout, Transport: rt},
+		userAgent: o.userAgent,
+		headers:   o.authHeaders(),
+		limits:    limits,
+	}
+	if o.cacheDir != "" {
+		c.cache = &httpCache{dir: o.cacheDir}
+	}
+	return c
+}
+
+// withHeaders returns a copy of c sending h instead of its headers; the
+// client and the cache are shared.
+func (c *crawler) withHeaders(h http.Header) *crawler {
+	cp := *c
+	cp.headers = h
+	return &cp
+}
+
+// authHeaders returns the -header and -cookie values as request headers.
+func (o *httpOptions) authHeaders() http.Header {
+	h := o.headers.Header()
+	if o.cookie != "" {
+		h.Set("Cookie", o.cookie)
+	}
+	return h
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
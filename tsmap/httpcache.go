@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// httpCache keeps the responses carrying an ETag or Last-Modified header below
+// a directory (-http-cache), so that a later run revalidates them with a
+// conditional request and reuses the body on 304 Not Modified.
+type httpCache struct {
+	dir string
+}
+
+// cacheEntry is the metadata stored next to a cached body.
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	StoredAt     time.Time `json:"stored_at"`
+}
+
+// key identifies a response by URL and request headers: anonymous and
+// authenticated responses (-auth-diff) are kept apart.
+func (c *httpCache) key(u string, headers http.Header) string {
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	h.Write([]byte(u))
+	for _, k := range names {
+		h.Write([]byte("\n" + k + ": " + strings.Join(headers[k], ", ")))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *httpCache) paths(key string) (meta, body string) {
+	base := filepath.Join(c.dir, key[:2], key)
+	return base + ".json", base + ".body"
+}
+
+// lookup returns the cached entry for u and the path of its body, or nil.
+func (c *httpCache) lookup(u string, headers http.Header) (*cacheEntry, string) {
+	if c == nil {
+		return nil, ""
+	}
+	metaPath, bodyPath := c.paths(c.key(u, headers))
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, ""
+	}
+	var e cacheEntry
+	if json.Unmarshal(data, &e) != nil || e.URL != u {
+		return nil, ""
+	}
+	if _, err := os.Stat(bodyPath); err != nil {
+		return nil, ""
+	}
+	return &e, bodyPath
+}
+
+// store saves a 200 response that can be revalidated later; errors only cost
+// a full download next time.
+func (c *httpCache) store(u string, headers http.Header, resp *http.Response, body io.Reader) {
+	if c == nil || resp.StatusCode != http.StatusOK {
+		return
+	}
+	e := cacheEntry{
+		URL:          u,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+		StoredAt:     time.Now().UTC(),
+	}
+	if e.ETag == "" && e.LastModified == "" {
+		return
+	}
+	metaPath, bodyPath := c.paths(c.key(u, headers))
+	if err := os.MkdirAll(filepath.Dir(metaPath), 0755); err != nil {
+		logger.Debug(msgHTTPCacheError.String(), "url", u, "err", err)
+		return
+	}
+	data, _ := json.Marshal(e)
+	// body first: a meta file always points to a complete body
+	if err := writeAtomicFrom(bodyPath, body, false); err != nil {
+		logger.Debug(msgHTTPCacheError.String(), "url", u, "err", err)
+		return
+	}
+	if err := writeAtomic(metaPath, data, false); err != nil {
+		logger.Debug(msgHTTPCacheError.String(), "url", u, "err", err)
+	}
+}
+
+// revalidate adds the conditional headers of a cached entry to req.
+func (e *cacheEntry) revalidate(req *http.Request) {
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+}
@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// importMap is the <script type="importmap"> of a page: specifiers such as
+// "react" or "controllers/" mapped to module URLs, at the top level and by
+// scope, a URL prefix of the importing module.
+type importMap struct {
+	imports map[string]*url.URL
+	scopes  map[string]map[string]*url.URL
+}
+
+// add merges the JSON of one import map block, resolving addresses against
+// the page; an entry already defined by an earlier block is kept, as browsers
+// do.
+func (m *importMap) add(text string, base *url.URL) error {
+	var doc struct {
+		Imports map[string]string            `json:"imports"`
+		Scopes  map[string]map[string]string `json:"scopes"`
+	}
+	if err := json.Unmarshal([]byte(text), &doc); err != nil {
+		return err
+	}
+	if m.imports == nil {
+		m.imports = make(map[string]*url.URL)
+		m.scopes = make(map[string]map[string]*url.URL)
+	}
+	addAll(m.imports, doc.Imports, base)
+	for prefix, table := range doc.Scopes {
+		u, err := base.Parse(prefix)
+		if err != nil {
+			continue
+		}
+		scope := m.scopes[u.String()]
+		if scope == nil {
+			scope = make(map[string]*url.URL)
+			m.scopes[u.String()] = scope
+		}
+		addAll(scope, table, base)
+	}
+	return nil
+}
+
+func addAll(dst map[string]*url.URL, src map[string]string, base *url.URL) {
+	for spec, addr := range src {
+		u, err := base.Parse(addr)
+		if err != nil {
+			continue
+		}
+		spec = normalizeSpecifier(spec, base)
+		if _, ok := dst[spec]; !ok {
+			dst[spec] = u
+		}
+	}
+}
+
+// size is the number of entries, scopes included.
+func (m *importMap) size() int {
+	n := len(m.imports)
+	for _, s := range m.scopes {
+		n += len(s)
+	}
+	return n
+}
+
+// isURLSpecifier reports whether an import specifier is a URL or a path rather
+// than a bare name.
+func isURLSpecifier(spec string) bool {
+	if strings.HasPrefix(spec, "/") || strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../") {
+		return true
+	}
+	u, err := url.Parse(spec)
+	return err == nil && u.Scheme != ""
+}
+
+// normalizeSpecifier resolves a URL specifier against base; bare ones are kept.
+func normalizeSpecifier(spec string, base *url.URL) string {
+	if !isURLSpecifier(spec) {
+		return spec
+	}
+	if u, err := base.Parse(spec); err == nil {
+		return u.String()
+	}
+	return spec
+}
+
+// resolve returns the URL of spec imported by the module at referrer: mapped
+// by the most specific scope of referrer, then by the top-level imports, else
+// resolved against referrer for a URL specifier. A bare specifier the map does
+// not list gives nil. A nil *importMap maps nothing.
+func (m *importMap) resolve(spec string, referrer *url.URL) *url.URL {
+	key := normalizeSpecifier(spec, referrer)
+	if m != nil {
+		scopes := make([]string, 0, len(m.scopes))
+		for prefix := range m.scopes {
+			if strings.HasPrefix(referrer.String(), prefix) {
+				scopes = append(scopes, prefix)
+			}
+		}
+		sort.Slice(scopes, func(i, j int) bool { return len(scopes[i]) > len(scopes[j]) })
+		for _, prefix := range scopes {
+			if u := matchSpecifier(m.scopes[prefix], key); u != nil {
+				return u
+			}
+		}
+		if u := matchSpecifier(m.imports, key); u != nil {
+			return u
+		}
+	}
+	if isURLSpecifier(spec) {
+		u, err := referrer.Parse(spec)
+		if err == nil {
+			return u
+		}
+	}
+	return nil
+}
+
+// matchSpecifier looks key up in table: an exact entry, else the longest
+// entry ending in "/" that prefixes key, with the rest of key appended.
+func matchSpecifier(table map[string]*url.URL, key string) *url.URL {
+	if u, ok := table[key]; ok {
+		return u
+	}
+	best := ""
+	for k := range table {
+		if strings.HasSuffix(k, "/") && strings.HasPrefix(key, k) && len(k) > len(best) {
+			best = k
+		}
+	}
+	if best == "" || !strings.HasSuffix(table[best].Path, "/") {
+		return nil
+	}
+	u, err := table[best].Parse(key[len(best):])
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+// reImportSpecifier matches the module specifiers of import declarations,
+// export ... from and dynamic imports of a string literal.
+var reImportSpecifier = regexp.MustCompile(`\bimport\s*(?:[\w$*{}\s,]*?\bfrom\s*)?["']([^"'\s]+)["']|\bexport\s*(?:\*(?:\s*as\s+[\w$]+)?|\{[^}]*\})\s*from\s*["']([^"'\s]+)["']|\bimport\(\s*["']([^"'\s]+)["']\s*\)`)
+
+// importSpecifiers returns the specifiers imported by a module, in order.
+func importSpecifiers(js string) []string {
+	var out []string
+	for _, m := range reImportSpecifier.FindAllStringSubmatch(js, -1) {
+		for _, s := range m[1:] {
+			if s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// resolveImports returns the URLs of the modules js imports, for the module
+// at referrer, without duplicates.
+func (m *importMap) resolveImports(js string, referrer *url.URL) []*url.URL {
+	seen := make(map[string]bool)
+	var out []*url.URL
+	for _, spec := range importSpecifiers(js) {
+		u := m.resolve(spec, referrer)
+		if u == nil || (u.Scheme != "http" && u.Scheme != "https") || seen[u.String()] {
+			continue
+		}
+		seen[u.String()] = true
+		out = append(out, u)
+	}
+	return out
+}
+
+// importDetector follows the imports of module scripts on a page having an
+// import map: bare specifiers through the map, paths against the script.
+type importDetector struct {
+	m *importMap
+}
+
+func (importDetector) Name() string { return "import" }
+
+func (d importDetector) DetectChunks(js string, scriptURL, _ *url.URL) []*url.URL {
+	return d.m.resolveImports(js, scriptURL)
+}
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// inferSniffMax is how much of a source -infer-ext looks at.
+const inferSniffMax = 64 << 10
+
+// extensions -infer-ext leaves alone
+var knownSourceExts = map[string]bool{
+	".js": true, ".mjs": true, ".cjs": true, ".jsx": true, ".ts": true, ".mts": true, ".cts": true, ".tsx": true,
+	".vue": true, ".svelte": true, ".astro": true, ".json": true, ".css": true, ".scss": true, ".sass": true,
+	".less": true, ".styl": true, ".html": true, ".htm": true, ".md": true, ".mdx": true, ".graphql": true,
+	".gql": true, ".coffee": true, ".elm": true, ".dart": true, ".yml": true, ".yaml": true, ".hbs": true,
+	".wasm": true, ".wat": true, ".svg": true, ".txt": true,
+}
+
+var (
+	reInferVue    = regexp.MustCompile(`(?m)^<(?:template|script)(?:\s[^>]*)?>[\s\S]*^</(?:template|script)>`)
+	reInferAtRule = regexp.MustCompile(`^@(?:charset|import|media|font-face|keyframes|supports|layer|tailwind)\b`)
+	reInferCSS    = regexp.MustCompile(`^[*.#:\[\w-][^{};=()]*\{\s*(?:[\w-]+\s*:|\})`)
+	reInferJS     = regexp.MustCompile(`\b(?:function|const|let|var|import|export|require|module\.exports|return|class)\b|=>`)
+	reInferTS     = regexp.MustCompile(`(?m)^\s*(?:export\s+)?(?:declare\s+)?(?:interface\s+\w+|type\s+\w+(?:<[^>]*>)?\s*=|enum\s+\w+\s*\{|abstract\s+class\b)|\bimport\s+type\b|\bas\s+const\b|(?:private|public|protected|readonly)\s+\w+\s*[:;=]|[\w)]\s*:\s*(?:string|number|boolean|any|unknown)\b|\(\s*\w+\??\s*:\s*[\w{\[]`)
+	reInferJSX    = regexp.MustCompile(`(?:return|=>|[=(,?:&|])\s*<[A-Za-z][\w.]*(?:\s[^<>]*)?(?:/>|>[\s\S]*?</[\w.]*>)`)
+)
+
+// inferExtensions appends an extension to the sources of sm that have none
+// or an unknown one ("module", "lodash.debounce"), query strings included
+// since they end up in the file name, picked from their content
+// (-infer-ext): .vue, .json, .css, and for code .ts, .tsx, .jsx or .js.
+// Sources that look like none of them are left alone, as are sources without
+// content. The names of the map are kept for reports and the manifest.
+func inferExtensions(sm *SourceMap) {
+	for i, s := range sm.Sources {
+		file, _, _ := strings.Cut(s, "?")
+		if knownSourceExts[strings.ToLower(path.Ext(file))] || strings.HasSuffix(file, "/") || !sm.HasContent(i) {
+			continue
+		}
+		content, err := sm.content(i)
+		if err != nil {
+			continue
+		}
+		ext := sniffSourceExt(content)
+		if ext == "" {
+			continue
+		}
+		if sm.original == nil {
+			sm.original = make([]string, len(sm.Sources))
+			for k := range sm.Sources {
+				sm.original[k] = sm.ResolvedSource(k)
+			}
+		}
+		sm.Sources[i] = s + ext
+		logger.Debug(msgInferredExt.String(), "source", sm.original[i], "ext", ext)
+	}
+}
+
+// sniffSourceExt returns the extension content looks like it has, "" when
+// unsure.
+func sniffSourceExt(content string) string {
+	if len(content) > inferSniffMax {
+		content = content[:inferSniffMax]
+	}
+	trimmed := strings.TrimSpace(content)
+	switch {
+	case trimmed == "":
+		return ""
+	case reInferVue.MatchString(content):
+		return ".vue"
+	case (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid([]byte(trimmed)):
+		return ".json"
+	}
+	code := jsCode(content)
+	switch start := strings.TrimSpace(code); {
+	case reInferAtRule.MatchString(start):
+		return ".css"
+	case !reInferJS.MatchString(code):
+		if reInferCSS.MatchString(start) {
+			return ".css"
+		}
+		return ""
+	}
+	ts, jsx := reInferTS.MatchString(code), reInferJSX.MatchString(code)
+	switch {
+	case ts && jsx:
+		return ".tsx"
+	case ts:
+		return ".ts"
+	case jsx:
+		return ".jsx"
+	}
+	return ".js"
+}
+
+// jsCode returns src with comments removed and literals emptied, so that
+// words inside them do not count.
+func jsCode(src string) string {
+	var b strings.Builder
+	for _, t := range jsTokens(src) {
+		switch t.kind {
+		case tokComment:
+			b.WriteByte(' ')
+		case tokLiteral:
+			b.WriteString(`""`)
+		default:
+			b.WriteString(t.text)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// extensions counted under another language name
+var langAliases = map[string]string{
+	"mjs": "js", "cjs": "js", "mts": "ts", "cts": "ts", "htm": "html", "sass": "scss", "yml": "yaml",
+}
+
+// langStat is the size of the recovered files of one language, in report.json.
+type langStat struct {
+	Language string `json:"language"` // file extension, "none" when the file has none
+	Files    int    `json:"files"`
+	Lines    int    `json:"lines"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// langStats counts the recovered files by language; crawl workers call add
+// concurrently. A nil langStats counts nothing.
+type langStats struct {
+	mu    sync.Mutex
+	langs map[string]*langStat
+}
+
+func languageOf(rel string) string {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(rel), "."))
+	if ext == "" {
+		return "none"
+	}
+	if a, ok := langAliases[ext]; ok {
+		return a
+	}
+	return ext
+}
+
+// scan counts one written file under its language.
+func (l *langStats) scan(rel string, data []byte, meta FileMeta) {
+	if l == nil {
+		return
+	}
+	lines := bytes.Count(data, []byte{'\n'})
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		lines++
+	}
+	lang := languageOf(rel)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.langs == nil {
+		l.langs = make(map[string]*langStat)
+	}
+	s := l.langs[lang]
+	if s == nil {
+		s = &langStat{Language: lang}
+		l.langs[lang] = s
+	}
+	s.Files++
+	s.Lines += lines
+	s.Bytes += int64(len(data))
+}
+
+// save does nothing: the languages go to the summary and report.json.
+func (l *langStats) save(*outputOptions) {}
+
+// list returns the languages, largest first.
+func (l *langStats) list() []langStat {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]langStat, 0, len(l.langs))
+	for _, s := range l.langs {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bytes != out[j].Bytes {
+			return out[i].Bytes > out[j].Bytes
+		}
+		return out[i].Language < out[j].Language
+	})
+	return out
+}
+
+// totals returns the lines and bytes of all languages.
+func (l *langStats) totals() (lines int, size int64) {
+	for _, s := range l.list() {
+		lines += s.Lines
+		size += s.Bytes
+	}
+	return lines, size
+}
+
+// logSummary prints one line per language.
+func (l *langStats) logSummary() {
+	for _, s := range l.list() {
+		logger.Info(msgLanguage.String(), "language", s.Language, "files", s.Files, "lines", s.Lines, "bytes", humanBytes(s.Bytes))
+	}
+}
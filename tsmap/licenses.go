@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"flag"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const licensesFile = "licenses.json"
+
+// licenseHeaderBytes is how much of the start of a file is searched for
+// license texts; SPDX identifiers and @license tags are searched everywhere.
+const licenseHeaderBytes = 4096
+
+var (
+	reSPDX       = regexp.MustCompile(`SPDX-License-Identifier:\s*\(?([A-Za-z0-9.+-]+(?:\s+(?:OR|AND|WITH)\s+[A-Za-z0-9.+-]+)*)`)
+	reLicenseTag = regexp.MustCompile(`@(?:license|preserve)\s+([A-Za-z0-9.+-]+(?:\s+(?:OR|AND)\s+[A-Za-z0-9.+-]+)*)`)
+	// "Copyright (c) Facebook", "Copyright 2021 Example", not "copyright notice"
+	reCopyright = regexp.MustCompile(`\b(?:Copyright|COPYRIGHT)\s+(?:\([cC]\)|©|\d{4}|[A-Z])[^\n*]{0,120}`)
+)
+
+// licenseTexts recognizes license headers that carry no SPDX identifier.
+var licenseTexts = []struct {
+	id string
+	re *regexp.Regexp
+}{
+	{"Apache-2.0", regexp.MustCompile(`Apache License,?\s+Version 2\.0`)},
+	{"MPL-2.0", regexp.MustCompile(`Mozilla Public\s+License,?\s+v(?:ersion)?\.?\s*2\.0`)},
+	{"LGPL", regexp.MustCompile(`GNU Lesser General Public License`)},
+	{"AGPL", regexp.MustCompile(`GNU Affero General Public License`)},
+	{"GPL", regexp.MustCompile(`GNU General Public License`)},
+	{"BSD", regexp.MustCompile(`Redistribution and use in source and binary forms`)},
+	{"MIT", regexp.MustCompile(`Permission is hereby granted, free of charge|\bMIT [Ll]icen[sc]e\b|[Ll]icensed under the MIT`)},
+	{"ISC", regexp.MustCompile(`Permission to use, copy, modify, and/or distribute|\bISC License\b`)},
+	{"Unlicense", regexp.MustCompile(`This is free and unencumbered software`)},
+	{"0BSD", regexp.MustCompile(`Zero-Clause BSD`)},
+}
+
+// licenseEntry is one file of licenses.json.
+type licenseEntry struct {
+	Path      string   `json:"path"` // relative to the output directory, slash separated
+	Package   string   `json:"package,omitempty"`
+	Licenses  []string `json:"licenses"`
+	Evidence  string   `json:"evidence"` // spdx, tag or text
+	Copyright []string `json:"copyright,omitempty"`
+}
+
+// licenseReport is licenses.json.
+type licenseReport struct {
+	Files      []licenseEntry `json:"files"`
+	Unlicensed int            `json:"unlicensed_files"` // files with no license found
+	Summary    map[string]int `json:"summary"`          // license -> files
+	Packages   map[string]int `json:"packages"`         // license -> node_modules packages
+}
+
+// licenseScanner inventories the license headers of every recovered file,
+// vendor code included (-licenses); crawl workers call scan concurrently.
+// A nil scanner does nothing.
+type licenseScanner struct {
+	enabled bool
+
+	mu         sync.Mutex
+	files      []licenseEntry
+	unlicensed int
+}
+
+func addLicenseFlags(fs *flag.FlagSet) *licenseScanner {
+	s := &licenseScanner{}
+	fs.BoolVar(&s.enabled, "licenses", false, "Inventory SPDX identifiers, license headers and copyright lines of recovered sources in licenses.json")
+	return s
+}
+
+// detectLicense returns the licenses declared in text and how they were found.
+func detectLicense(text string) (ids []string, evidence string) {
+	for _, m := range reSPDX.FindAllStringSubmatch(text, -1) {
+		ids = addUnique(ids, m[1])
+	}
+	if ids != nil {
+		return ids, "spdx"
+	}
+	for _, m := range reLicenseTag.FindAllStringSubmatch(text, -1) {
+		// "@license React" names the product, not the license
+		for _, t := range licenseTexts {
+			if strings.EqualFold(m[1], t.id) || strings.HasPrefix(m[1], t.id+"-") {
+				ids = addUnique(ids, m[1])
+			}
+		}
+	}
+	if ids != nil {
+		return ids, "tag"
+	}
+	header := text
+	if len(header) > licenseHeaderBytes {
+		header = header[:licenseHeaderBytes]
+	}
+	for _, t := range licenseTexts {
+		if t.re.MatchString(header) {
+			ids = addUnique(ids, t.id)
+		}
+	}
+	if ids != nil {
+		return ids, "text"
+	}
+	return nil, ""
+}
+
+// scan records the licenses of one written file.
+func (s *licenseScanner) scan(rel string, data []byte, meta FileMeta) {
+	if s == nil || !s.enabled {
+		return
+	}
+	text := string(data)
+	ids, evidence := detectLicense(text)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ids == nil {
+		s.unlicensed++
+		return
+	}
+	e := licenseEntry{Path: rel, Licenses: ids, Evidence: evidence}
+	src := rel
+	if meta.Source != "" {
+		src = sourcePathOnly(meta.Source)
+	}
+	e.Package, _, _ = packageOf(src)
+	for _, m := range reCopyright.FindAllString(text, -1) {
+		e.Copyright = addUnique(e.Copyright, strings.TrimSpace(m))
+	}
+	s.files = append(s.files, e)
+}
+
+// save writes licenses.json and logs the files per license.
+func (s *licenseScanner) save(o *outputOptions) {
+	if s == nil || !s.enabled {
+		return
+	}
+	s.mu.Lock()
+	rep := licenseReport{Files: append([]licenseEntry{}, s.files...), Unlicensed: s.unlicensed, Summary: make(map[string]int), Packages: make(map[string]int)}
+	s.mu.Unlock()
+	sort.Slice(rep.Files, func(i, j int) bool { return rep.Files[i].Path < rep.Files[j].Path })
+	packages := make(map[string]map[string]bool) // license -> packages
+	for _, e := range rep.Files {
+		for _, id := range e.Licenses {
+			rep.Summary[id]++
+			if e.Package != "" {
+				if packages[id] == nil {
+					packages[id] = make(map[string]bool)
+				}
+				packages[id][e.Package] = true
+			}
+		}
+	}
+	for id, p := range packages {
+		rep.Packages[id] = len(p)
+	}
+	args := []any{"files", len(rep.Files), "unlicensed", rep.Unlicensed}
+	for _, id := range sortedKeys(rep.Summary) {
+		args = append(args, id, rep.Summary[id])
+	}
+	logger.Info(msgLicenseSummary.String(), args...)
+	if o.dryRun {
+		return
+	}
+	data, _ := json.MarshalIndent(rep, "", "  ")
+	if err := o.writeMeta(licensesFile, data); err != nil {
+		logger.Warn(msgLicensesError.String(), "err", err)
+	}
+}
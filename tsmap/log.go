@@ -0,0 +1,282 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// logger is used by every subcommand; RunExtract/RunCrawl reconfigure it from flags.
+// It discards everything until a subcommand installs the console one, so that
+// Extract, Crawl and ExtractMap print nothing into the embedding program
+// unless it calls SetLogger.
+var logger = slog.New(slog.DiscardHandler)
+
+// SetLogger routes the logs of the library functions (Extract, Crawl,
+// ExtractMap) to l, or discards them when l is nil. The logger is shared by
+// every run of the process, so it should be set once, before the first one.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.New(slog.DiscardHandler)
+	}
+	logger = l
+}
+
+// useConsoleLogger installs the console logger of the subcommands, used until
+// setup applies their log flags, e.g. for configuration errors.
+func useConsoleLogger() {
+	logger = slog.New(newConsoleHandler(os.Stdout, slog.LevelInfo))
+}
+
+type logOptions struct {
+	verbose    bool
+	quiet      bool
+	format     string
+	file       string
+	noProgress bool
+	ascii      bool
+	progressFD int
+	color      string
+
+	console io.Writer // replaces stdout for console logs (used by -tui)
+}
+
+func addLogFlags(fs *flag.FlagSet) *logOptions {
+	o := &logOptions{}
+	fs.BoolVar(&o.verbose, "v", false, "Verbose output (debug level)")
+	fs.BoolVar(&o.quiet, "q", false, "Quiet output (warnings and errors only)")
+	fs.StringVar(&o.format, "log-format", "text", "Log format: text|json")
+	fs.StringVar(&o.file, "log-file", "", "Also write logs to this file")
+	fs.BoolVar(&o.noProgress, "no-progress", false, "Disable the progress display")
+	// No stream unless asked: an unused fd 3 is often the Go runtime's own
+	// poller by the time flags are parsed, so "fd 3 when open" cannot be told.
+	fs.IntVar(&o.progressFD, "progress-fd", 0, "Write NDJSON progress events to this file descriptor (e.g. 3); none by default")
+	fs.StringVar(&o.color, "color", "auto", "Colored output: auto|always|never (NO_COLOR is honored in auto)")
+	fs.BoolVar(&o.ascii, "ascii", false, "Plain ASCII output: no colors, no progress line, non-ASCII escaped")
+	return o
+}
+
+// progress reports whether a progress bar may be shown (it also needs a terminal).
+func (o *logOptions) progress() bool {
+	return !o.noProgress && !o.quiet && !o.ascii
+}
+
+// setup installs the package logger and returns a function closing the log file.
+func (o *logOptions) setup() func() {
+	if err := applyColorMode(o.color); err != nil {
+		usageFail(msgInvalidColor, err)
+	}
+	if o.ascii {
+		setColor(false)
+	}
+	level := slog.LevelInfo
+	if o.verbose {
+		level = slog.LevelDebug
+	} else if o.quiet {
+		level = slog.LevelWarn
+	}
+
+	out := o.console
+	if out == nil {
+		out = os.Stdout
+	}
+	var console slog.Handler
+	switch strings.ToLower(o.format) {
+	case "", "text":
+		ch := newConsoleHandler(out, level)
+		ch.ascii = o.ascii
+		console = ch
+	case "json":
+		console = slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})
+	default:
+		usageFail(msgInvalidLogFormat, o.format)
+	}
+
+	closeFn := func() {}
+	if o.progressFD > 0 {
+		ev, err := openEventStream(o.progressFD)
+		if err != nil {
+			fail(msgProgressFD, err)
+		}
+		events = ev
+		closeFn = ev.close
+	}
+	handlers := []slog.Handler{console}
+	if o.file != "" {
+		f, err := os.OpenFile(o.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fail(msgOpenLogFile, err)
+		}
+		// the log file always keeps debug details for later audit
+		opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+		if strings.ToLower(o.format) == "json" {
+			handlers = append(handlers, slog.NewJSONHandler(f, opts))
+		} else {
+			handlers = append(handlers, slog.NewTextHandler(f, opts))
+		}
+		prev := closeFn
+		closeFn = func() { _ = f.Close(); prev() }
+	}
+
+	if len(handlers) == 1 {
+		logger = slog.New(console)
+	} else {
+		logger = slog.New(multiHandler(handlers))
+	}
+	return closeFn
+}
+
+// ---------- console handler ----------
+
+// consoleHandler renders records as short human lines: "Message key=value ...",
+// colored by level when stdout is a terminal.
+type consoleHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  slog.Leveler
+	prefix string // accumulated from WithGroup
+	attrs  []slog.Attr
+	ascii  bool // escape non-ASCII runes
+}
+
+func newConsoleHandler(w io.Writer, level slog.Leveler) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString(cRed + "Error:" + cRst + " ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString(cYel + "Warning:" + cRst + " ")
+	case r.Level < slog.LevelInfo:
+		b.WriteString(cCyn + "debug:" + cRst + " ")
+	}
+	b.WriteString(r.Message)
+	for _, a := range h.attrs {
+		writeConsoleAttr(&b, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		writeConsoleAttr(&b, h.prefix, a)
+		return true
+	})
+	line := b.String()
+	if h.ascii {
+		line = toASCII(line)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p := activeProgress.Load(); p != nil {
+		p.clearLine()
+	}
+	_, err := io.WriteString(h.w, line+"\n")
+	return err
+}
+
+// toASCII replaces every non-ASCII rune with a \uXXXX escape (µs becomes \u00b5s).
+func toASCII(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			b.WriteRune(r)
+		case r <= 0xFFFF:
+			fmt.Fprintf(&b, "\\u%04x", r)
+		default:
+			fmt.Fprintf(&b, "\\U%08x", r)
+		}
+	}
+	return b.String()
+}
+
+func writeConsoleAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			writeConsoleAttr(b, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	v := a.Value.String()
+	if strings.ContainsAny(v, " \t\"") {
+		v = fmt.Sprintf("%q", v)
+	}
+	fmt.Fprintf(b, " %s=%s", prefix+a.Key, v)
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		c.attrs = append(c.attrs, a)
+	}
+	return &c
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+// ---------- fan-out ----------
+
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var first error
+	for _, h := range m {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
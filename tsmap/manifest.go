@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// manifestEntry records where one written file comes from.
+type manifestEntry struct {
+	Path      string    `json:"path"` // relative to the output directory, slash separated
+	Source    string    `json:"source"`
+	Index     int       `json:"source_index"`
+	Map       string    `json:"map"` // map file or URL, "inline" for data: URLs
+	Script    string    `json:"script,omitempty"`
+	SHA256    string    `json:"sha256"`
+	Size      int       `json:"size"`
+	WrittenAt time.Time `json:"written_at"`
+	Exec      bool      `json:"executable,omitempty"` // starts with #!
+}
+
+// manifest collects the entries of a run; crawl workers add to it concurrently.
+type manifest struct {
+	mu      sync.Mutex
+	entries map[string]manifestEntry
+}
+
+// provenance is what the caller knows about a source when writing it.
+type provenance struct {
+	source string
+	index  int
+	mapRef string
+	script string
+}
+
+// record adds the file written at abs; a later write of the same path replaces it.
+func (m *manifest) record(root, abs string, data []byte, p provenance) {
+	if m == nil {
+		return
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		rel = abs
+	}
+	sum := sha256.Sum256(data)
+	e := manifestEntry{
+		Path:      filepath.ToSlash(rel),
+		Source:    p.source,
+		Index:     p.index,
+		Map:       p.mapRef,
+		Script:    p.script,
+		SHA256:    hex.EncodeToString(sum[:]),
+		Size:      len(data),
+		WrittenAt: time.Now().UTC(),
+		Exec:      isExecutable(data),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries == nil {
+		m.entries = make(map[string]manifestEntry)
+	}
+	m.entries[e.Path] = e
+}
+
+// marshal encodes the manifest, keeping the entries of old, an earlier
+// manifest.json (e.g. before -resume), for paths not written again. It
+// returns nil when nothing was recorded.
+func (m *manifest) marshal(old []byte) ([]byte, error) {
+	if m == nil || len(m.entries) == 0 {
+		return nil, nil
+	}
+	all := make(map[string]manifestEntry)
+	var prev []manifestEntry
+	if json.Unmarshal(old, &prev) == nil {
+		for _, e := range prev {
+			all[e.Path] = e
+		}
+	}
+	m.mu.Lock()
+	for k, e := range m.entries {
+		all[k] = e
+	}
+	m.mu.Unlock()
+	list := make([]manifestEntry, 0, len(all))
+	for _, e := range all {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return json.MarshalIndent(list, "", "  ")
+}
+
+// stale lists the entries of old, an earlier manifest.json, whose path this
+// run did not write again.
+func (m *manifest) stale(old []byte) []manifestEntry {
+	var prev []manifestEntry
+	if json.Unmarshal(old, &prev) != nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []manifestEntry
+	for _, e := range prev {
+		if _, ok := m.entries[e.Path]; !ok {
+			out = append(out, e)
+		}
+	}
+	return out
+}
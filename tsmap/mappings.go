@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import "fmt"
+
+// Segment is one decoded mapping: a position in the generated file and, when
+// present, the original position and name it comes from. Lines and columns are
+// zero-based, as in the source map format.
+type Segment struct {
+	GeneratedLine   int
+	GeneratedColumn int
+	Source          int // index in sources, -1 for an unmapped segment
+	OriginalLine    int
+	OriginalColumn  int
+	Name            int // index in names, -1 when absent
+}
+
+// HasSource reports whether the segment maps back to an original position.
+func (s Segment) HasSource() bool { return s.Source >= 0 }
+
+// MappingError reports an undecodable "mappings" field and where it failed.
+type MappingError struct {
+	Line, Segment int
+	Msg           string
+}
+
+func (e *MappingError) Error() string {
+	return fmt.Sprintf("mappings: line %d, segment %d: %s", e.Line+1, e.Segment+1, e.Msg)
+}
+
+// DecodeMappings parses a source map v3 "mappings" string into segments,
+// ordered by generated line and in file order within a line. Source and name
+// indices are not checked against the sources and names arrays.
+func DecodeMappings(mappings string) ([]Segment, error) {
+	var (
+		out                    []Segment
+		line, seg              int
+		src, origLine, origCol int
+		name                   int
+		genCol                 int
+		fields                 [5]int
+	)
+	for i := 0; i < len(mappings); {
+		switch mappings[i] {
+		case ';':
+			line++
+			seg = 0
+			genCol = 0
+			i++
+			continue
+		case ',':
+			seg++
+			i++
+			continue
+		}
+		n := 0
+		for i < len(mappings) && mappings[i] != ',' && mappings[i] != ';' {
+			if n == len(fields) {
+				return out, &MappingError{line, seg, "more than 5 fields"}
+			}
+			v, next, err := decodeVLQ(mappings, i)
+			if err != nil {
+				return out, &MappingError{line, seg, err.Error()}
+			}
+			fields[n] = v
+			n++
+			i = next
+		}
+		if n != 1 && n != 4 && n != 5 {
+			return out, &MappingError{line, seg, fmt.Sprintf("%d fields, expected 1, 4 or 5", n)}
+		}
+		genCol += fields[0]
+		s := Segment{GeneratedLine: line, GeneratedColumn: genCol, Source: -1, Name: -1}
+		if n >= 4 {
+			src += fields[1]
+			origLine += fields[2]
+			origCol += fields[3]
+			s.Source, s.OriginalLine, s.OriginalColumn = src, origLine, origCol
+		}
+		if n == 5 {
+			name += fields[4]
+			s.Name = name
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+const vlqChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+var vlqValues = func() [256]int8 {
+	var t [256]int8
+	for i := range t {
+		t[i] = -1
+	}
+	for i := 0; i < len(vlqChars); i++ {
+		t[vlqChars[i]] = int8(i)
+	}
+	return t
+}()
+
+// decodeVLQ reads one base64 VLQ value starting at s[i] and returns it with the
+// index just after it.
+func decodeVLQ(s string, i int) (int, int, error) {
+	var v, shift int
+	for {
+		if i >= len(s) {
+			return 0, i, fmt.Errorf("truncated VLQ value")
+		}
+		d := vlqValues[s[i]]
+		if d < 0 {
+			return 0, i, fmt.Errorf("invalid character %q", s[i])
+		}
+		i++
+		if shift > 60 {
+			return 0, i, fmt.Errorf("VLQ value overflows")
+		}
+		v |= int(d&31) << shift
+		if d&32 == 0 {
+			break
+		}
+		shift += 5
+	}
+	if v&1 != 0 {
+		return -(v >> 1), i, nil
+	}
+	return v >> 1, i, nil
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDecodeVLQ(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"A", 0},
+		{"B", 0}, // -0
+		{"C", 1},
+		{"D", -1},
+		{"e", 15},
+		{"f", -15},
+		{"gB", 16},
+		{"hB", -16},
+		{"2H", 123},
+		{"w+B", 1000},
+		{"x+B", -1000},
+		{"+/////D", 1<<31 - 1},
+		{"//////D", -(1<<31 - 1)},
+	}
+	for _, tt := range tests {
+		got, next, err := decodeVLQ(tt.in+",", 0)
+		if err != nil || got != tt.want || next != len(tt.in) {
+			t.Errorf("decodeVLQ(%q) = %d, %d, %v, want %d, %d", tt.in, got, next, err, tt.want, len(tt.in))
+		}
+	}
+	errs := []struct {
+		in string
+		i  int
+	}{
+		{"", 0},                 // truncated
+		{"w+", 0},               // truncated
+		{"A*", 1},               // invalid character
+		{"A=", 1},               // padding is not a digit
+		{"gggggggggggggggA", 0}, // overflows
+	}
+	for _, tt := range errs {
+		if v, _, err := decodeVLQ(tt.in, tt.i); err == nil {
+			t.Errorf("decodeVLQ(%q, %d) = %d, want an error", tt.in, tt.i, v)
+		}
+	}
+}
+
+func TestDecodeMappings(t *testing.T) {
+	seg := func(gl, gc, src, ol, oc, name int) Segment {
+		return Segment{gl, gc, src, ol, oc, name}
+	}
+	tests := []struct {
+		name     string
+		mappings string
+		want     []Segment
+	}{
+		{"empty", "", nil},
+		{"one", "AAAA", []Segment{seg(0, 0, 0, 0, 0, -1)}},
+		{"name", "AAAAA", []Segment{seg(0, 0, 0, 0, 0, 0)}},
+		{"unmapped", "E", []Segment{seg(0, 2, -1, 0, 0, -1)}},
+		{
+			name:     "relative within a line",
+			mappings: "AAAA,IAAI,CCCC",
+			want:     []Segment{seg(0, 0, 0, 0, 0, -1), seg(0, 4, 0, 0, 4, -1), seg(0, 5, 1, 1, 5, -1)},
+		},
+		{
+			// the generated column restarts on each line, the other fields do not
+			name:     "across lines",
+			mappings: "KAAKA;;KACAC",
+			want:     []Segment{seg(0, 5, 0, 0, 5, 0), seg(2, 5, 0, 1, 5, 1)},
+		},
+		{
+			name:     "negative deltas",
+			mappings: "gBAgBG,DAAD",
+			want:     []Segment{seg(0, 16, 0, 16, 3, -1), seg(0, 15, 0, 16, 2, -1)},
+		},
+		{"empty segments", ",;A,", []Segment{seg(1, 0, -1, 0, 0, -1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeMappings(tt.mappings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got  %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMappingsErrors(t *testing.T) {
+	tests := []struct {
+		mappings      string
+		line, segment int
+	}{
+		{"AA", 0, 0},
+		{"AAAA,AAA", 0, 1},
+		{"AAAA;AAAAAA", 1, 0},
+		{"A;;AA*A", 2, 0},
+		{"AAAg", 0, 0},
+	}
+	for _, tt := range tests {
+		_, err := DecodeMappings(tt.mappings)
+		var me *MappingError
+		if !errors.As(err, &me) || me.Line != tt.line || me.Segment != tt.segment {
+			t.Errorf("DecodeMappings(%q) = %v, want an error at line %d, segment %d", tt.mappings, err, tt.line, tt.segment)
+		}
+	}
+}
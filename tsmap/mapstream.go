@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// streamMapSize is the size from which a local map file is not read whole:
+// its sourcesContent entries are only located, then read back one at a time
+// when written, so memory stays close to the largest source.
+const streamMapSize = 32 << 20
+
+// streamedContent stands for the sourcesContent of a map file decoded by
+// streamSourceMap. Entries dropped by a filter or filled by -reconstruct
+// live in memory; the others are read back from the file.
+type streamedContent struct {
+	path   string
+	offset []int64 // where each entry starts in the file, -1 for null
+	size   []int   // decoded length
+	blank  []bool  // null, empty or whitespace only
+	filled map[int]string
+}
+
+// streamSourceMap decodes the map file p without keeping sourcesContent in
+// memory. It reports false, and the caller reads the file whole, for URLs,
+// bundles, files under streamMapSize, UTF-16 or JSONP bodies and index maps.
+func streamSourceMap(p string) (SourceMap, bool, error) {
+	var sm SourceMap
+	if isHTTPURL(p) {
+		return sm, false, nil
+	}
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".js", ".mjs", ".cjs":
+		return sm, false, nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return sm, false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.Size() < streamMapSize {
+		return sm, false, err
+	}
+	head := make([]byte, 64)
+	n, _ := io.ReadFull(f, head)
+	start := jsonObjectStart(head[:n])
+	if start < 0 {
+		return sm, false, nil
+	}
+	if _, err := f.Seek(int64(start), io.SeekStart); err != nil {
+		return sm, false, err
+	}
+
+	c := &streamedContent{path: p}
+	dec := json.NewDecoder(f)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return sm, false, nil
+	}
+	fields := make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return sm, true, err
+		}
+		key, _ := tok.(string)
+		switch key {
+		case "sections":
+			return sm, false, nil // sections hold maps of their own
+		case "sourcesContent":
+			if err := c.locate(dec, int64(start)); err != nil {
+				return sm, true, err
+			}
+		default:
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return sm, true, err
+			}
+			fields[key] = raw
+		}
+	}
+	data, _ := json.Marshal(fields)
+	if err := json.Unmarshal(data, &sm); err != nil {
+		return sm, true, err
+	}
+	sm.SourcesContent = nil
+	sm.stream = c
+	return sm, true, nil
+}
+
+// jsonObjectStart returns the offset of the opening brace of a map, after a
+// UTF-8 BOM and an XSSI prefix, or -1 when the file does not start that way.
+func jsonObjectStart(head []byte) int {
+	b := bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	b = bytes.TrimLeft(b, " \t\r\n")
+	for _, p := range xssiPrefixes {
+		if bytes.HasPrefix(b, p) {
+			b = bytes.TrimLeft(b[len(p):], ", \t\r\n")
+			break
+		}
+	}
+	if len(b) == 0 || b[0] != '{' {
+		return -1
+	}
+	return len(head) - len(b)
+}
+
+// locate walks the sourcesContent array, recording where each entry starts;
+// base is the file offset dec started at. Each string is decoded once, to
+// learn its size, and dropped.
+func (c *streamedContent) locate(dec *json.Decoder, base int64) error {
+	tok, err := dec.Token()
+	if err != nil || tok == nil {
+		return err
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("sourcesContent: expected an array")
+	}
+	for dec.More() {
+		// the offset is the end of the previous token: a separator may follow
+		off := base + dec.InputOffset()
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch v := tok.(type) {
+		case string:
+			c.offset = append(c.offset, off)
+			c.size = append(c.size, len(v))
+			c.blank = append(c.blank, strings.TrimSpace(v) == "")
+		case nil:
+			c.offset = append(c.offset, -1)
+			c.size = append(c.size, 0)
+			c.blank = append(c.blank, true)
+		default:
+			return fmt.Errorf("sourcesContent[%d]: expected a string", len(c.offset))
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// read returns entry i, from memory or from the file; blank entries read as "".
+func (c *streamedContent) read(i int) (string, error) {
+	if s, ok := c.filled[i]; ok {
+		return s, nil
+	}
+	if i >= len(c.offset) || c.blank[i] {
+		return "", nil
+	}
+	f, err := os.Open(c.path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	r := bufio.NewReader(io.NewSectionReader(f, c.offset[i], math.MaxInt64-c.offset[i]))
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if !strings.ContainsRune(", \t\r\n", rune(b)) {
+			_ = r.UnreadByte()
+			break
+		}
+	}
+	var s string
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return "", fmt.Errorf("%s: sourcesContent[%d]: %w", c.path, i, err)
+	}
+	return s, nil
+}
+
+// pad lists entries up to n, as null ones.
+func (c *streamedContent) pad(n int) {
+	for len(c.offset) < n {
+		c.offset = append(c.offset, -1)
+		c.size = append(c.size, 0)
+		c.blank = append(c.blank, true)
+	}
+}
+
+// set replaces entry i in memory; "" drops it.
+func (c *streamedContent) set(i int, s string) {
+	c.pad(i + 1)
+	c.size[i], c.blank[i] = len(s), strings.TrimSpace(s) == ""
+	if c.blank[i] {
+		delete(c.filled, i)
+		return
+	}
+	if c.filled == nil {
+		c.filled = make(map[int]string)
+	}
+	c.filled[i] = s
+}
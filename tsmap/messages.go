@@ -0,0 +1,429 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import "fmt"
+
+// message identifies a user-facing text. Every log line and error shown to the
+// user goes through this catalog so that a translation can be swapped in later.
+type message string
+
+const (
+	msgTUIError           message = "tui_error"
+	msgInvalidProxy       message = "invalid_proxy"
+	msgUsingProxy         message = "using_proxy"
+	msgInsecureTLS        message = "insecure_tls"
+	msgMissingURL         message = "missing_url"
+	msgInvalidURL         message = "invalid_url"
+	msgAuthDiffNeedsCreds message = "auth_diff_needs_creds"
+	msgConfigError        message = "config_error"
+	msgAnonPass           message = "anon_pass"
+	msgAuthPass           message = "auth_pass"
+	msgCrawlDone          message = "crawl_done"
+	msgReportError        message = "report_error"
+	msgFetching           message = "fetching"
+	msgRootFetchFailed    message = "root_fetch_failed"
+	msgNoScripts          message = "no_scripts"
+	msgAuthDiff           message = "auth_diff"
+	msgAuthOnlyMap        message = "auth_only_map"
+	msgAnonOnlyMap        message = "anon_only_map"
+	msgProcessing         message = "processing"
+	msgScriptFetchFailed  message = "script_fetch_failed"
+	msgChunkDiscovered    message = "chunk_discovered"
+	msgInlineMapDecode    message = "inline_map_decode"
+	msgMapFetchFailed     message = "map_fetch_failed"
+	msgNoSourcemap        message = "no_sourcemap"
+	msgInvalidMap         message = "invalid_map"
+	msgMapFound           message = "map_found"
+	msgMapError           message = "map_error"
+	msgWritten            message = "written"
+	msgHTTPGet            message = "http_get"
+	msgReadMap            message = "read_map"
+	msgInvalidMapJSON     message = "invalid_map_json"
+	msgNoSources          message = "no_sources"
+	msgSkippedNoContent   message = "skipped_no_content"
+	msgSkippedBlocked     message = "skipped_blocked"
+	msgCreateDir          message = "create_dir"
+	msgWriteFile          message = "write_file"
+	msgSummary            message = "summary"
+	msgInvalidLogFormat   message = "invalid_log_format"
+	msgOpenLogFile        message = "open_log_file"
+	msgTUIAscii           message = "tui_ascii"
+	msgUnitScripts        message = "unit_scripts"
+	msgUnitSources        message = "unit_sources"
+	msgProgressSources    message = "progress_sources"
+	msgProgressETA        message = "progress_eta"
+	msgTUIRunning         message = "tui_running"
+	msgTUIPaused          message = "tui_paused"
+	msgTUIQuitting        message = "tui_quitting"
+	msgTUISkipped         message = "tui_skipped"
+	msgTUIKeys            message = "tui_keys"
+	msgProgressFD         message = "progress_fd"
+	msgInvalidColor       message = "invalid_color"
+	msgLocatorError       message = "locator_error"
+	msgScriptSpooled      message = "script_spooled"
+	msgScriptSpoolError   message = "script_spool_error"
+	msgChunkEvalFailed    message = "chunk_eval_failed"
+	msgImportMap          message = "import_map"
+	msgBadImportMap       message = "bad_import_map"
+	msgWasmError          message = "wasm_error"
+	msgScriptHost         message = "script_host"
+	msgIntegrityMismatch  message = "integrity_mismatch"
+	msgSelftestListen     message = "selftest_listen"
+	msgSelftestServing    message = "selftest_serving"
+	msgSelftestPass       message = "selftest_pass"
+	msgSelftestFail       message = "selftest_fail"
+	msgSelftestMissing    message = "selftest_missing"
+	msgSelftestKept       message = "selftest_kept"
+	msgMissingMap         message = "missing_map"
+	msgStrictErrors       message = "strict_errors"
+	msgStdoutNeedsPath    message = "stdout_needs_path"
+	msgSourceNotFound     message = "source_not_found"
+	msgNotJavaScript      message = "not_javascript"
+	msgWouldWrite         message = "would_write"
+	msgTUIPathsOnly       message = "tui_paths_only"
+	msgMemoryLimit        message = "memory_limit"
+	msgResumeError        message = "resume_error"
+	msgResuming           message = "resuming"
+	msgCheckpointError    message = "checkpoint_error"
+	msgCheckpointWritten  message = "checkpoint_written"
+	msgScriptSkipped      message = "script_skipped"
+	msgMapAndMapDir       message = "map_and_map_dir"
+	msgMapDirPath         message = "map_dir_path"
+	msgInvalidLayout      message = "invalid_layout"
+	msgInvalidReport      message = "invalid_report"
+	msgInvalidMapGuess    message = "invalid_map_guess"
+	msgRecordReplay       message = "record_replay"
+	msgInvalidReplay      message = "invalid_replay"
+	msgRecording          message = "recording"
+	msgReplaying          message = "replaying"
+	msgReadMapDir         message = "read_map_dir"
+	msgNoMapFiles         message = "no_map_files"
+	msgLoadMap            message = "load_map"
+	msgMapStreamed        message = "map_streamed"
+	msgExtractWorkers     message = "extract_workers"
+	msgMapExtracted       message = "map_extracted"
+	msgMapFromScript      message = "map_from_script"
+	msgNoGenerated        message = "no_generated"
+	msgReconstructError   message = "reconstruct_error"
+	msgReconstructed      message = "reconstructed"
+	msgValidateSummary    message = "validate_summary"
+	msgSkippedFiltered    message = "skipped_filtered"
+	msgVendorSummary      message = "vendor_summary"
+	msgDedupSummary       message = "dedup_summary"
+	msgDuplicateSource    message = "duplicate_source"
+	msgPathConflict       message = "path_conflict"
+	msgConflictSummary    message = "conflict_summary"
+	msgCaseCollision      message = "case_collision"
+	msgNameScrubbed       message = "name_scrubbed"
+	msgScrubSummary       message = "scrub_summary"
+	msgManifestError      message = "manifest_error"
+	msgSumsWritten        message = "sums_written"
+	msgSumsSigned         message = "sums_signed"
+	msgSumsError          message = "sums_error"
+	msgArchiveError       message = "archive_error"
+	msgArchiveWritten     message = "archive_written"
+	msgZipAndTar          message = "zip_and_tar"
+	msgArchiveStdout      message = "archive_stdout"
+	msgBlobError          message = "blob_error"
+	msgPrunedStale        message = "pruned_stale"
+	msgGitNeedsTree       message = "git_needs_tree"
+	msgGitError           message = "git_error"
+	msgGitUnchanged       message = "git_unchanged"
+	msgGitCommitted       message = "git_committed"
+	msgDiffArgs           message = "diff_args"
+	msgDiffRead           message = "diff_read"
+	msgDiffSummary        message = "diff_summary"
+	msgDiffNoContent      message = "diff_no_content"
+	msgDiffTooLarge       message = "diff_too_large"
+	msgHTTPCacheError     message = "http_cache_error"
+	msgMonitorInterval    message = "monitor_interval"
+	msgMonitorCrawlFailed message = "monitor_crawl_failed"
+	msgMonitorBaseline    message = "monitor_baseline"
+	msgMonitorUnchanged   message = "monitor_unchanged"
+	msgMonitorChanged     message = "monitor_changed"
+	msgMonitorNewMap      message = "monitor_new_map"
+	msgMonitorSleeping    message = "monitor_sleeping"
+	msgMonitorAlertFailed message = "monitor_alert_failed"
+	msgMonitorMetrics     message = "monitor_metrics"
+	msgNotifyFailed       message = "notify_failed"
+	msgNotified           message = "notified"
+	msgSecretRules        message = "secret_rules"
+	msgSecretFound        message = "secret_found"
+	msgSecretSummary      message = "secret_summary"
+	msgSecretsError       message = "secrets_error"
+	msgEndpointSummary    message = "endpoint_summary"
+	msgEndpointsError     message = "endpoints_error"
+	msgWordlistWritten    message = "wordlist_written"
+	msgWordlistError      message = "wordlist_error"
+	msgEnvSummary         message = "env_summary"
+	msgEnvError           message = "env_error"
+	msgFingerprint        message = "fingerprint"
+	msgDepsSummary        message = "deps_summary"
+	msgDepsError          message = "deps_error"
+	msgTsconfigSummary    message = "tsconfig_summary"
+	msgTsconfigError      message = "tsconfig_error"
+	msgLicenseSummary     message = "license_summary"
+	msgLicensesError      message = "licenses_error"
+	msgLanguage           message = "language"
+	msgHostSummary        message = "host_summary"
+	msgFormatFailed       message = "format_failed"
+	msgInferredExt        message = "inferred_ext"
+	msgCharsetDecoded     message = "charset_decoded"
+	msgRawMapsSaved       message = "raw_maps_saved"
+	msgRawMapError        message = "raw_map_error"
+	msgAssetMetaError     message = "asset_meta_error"
+	msgGraphSummary       message = "graph_summary"
+	msgGraphError         message = "graph_error"
+	msgCommentSummary     message = "comment_summary"
+	msgCommentsError      message = "comments_error"
+	msgSarifSummary       message = "sarif_summary"
+	msgSarifError         message = "sarif_error"
+	msgSeverity           message = "severity"
+	msgSeverityFailed     message = "severity_failed"
+	msgInterrupted        message = "interrupted"
+	msgCheckpointStopped  message = "checkpoint_stopped"
+	msgGrepArgs           message = "grep_args"
+	msgGrepPattern        message = "grep_pattern"
+	msgGrepRead           message = "grep_read"
+	msgGrepFileError      message = "grep_file_error"
+	msgGrepSummary        message = "grep_summary"
+	msgServeArgs          message = "serve_args"
+	msgServeListen        message = "serve_listen"
+	msgServeRead          message = "serve_read"
+	msgServing            message = "serving"
+	msgServerArgs         message = "server_args"
+	msgServerNoToken      message = "server_no_token"
+	msgServerListening    message = "server_listening"
+	msgServerLoaded       message = "server_loaded"
+	msgJobDone            message = "job_done"
+	msgJobFailed          message = "job_failed"
+	msgJobSaveError       message = "job_save_error"
+	msgJobsLoad           message = "jobs_load"
+	msgCoordArgs          message = "coord_args"
+	msgCoordLimits        message = "coord_limits"
+	msgCoordTargets       message = "coord_targets"
+	msgCoordJob           message = "coord_job"
+	msgCoordStart         message = "coord_start"
+	msgCoordTargetDone    message = "coord_target_done"
+	msgCoordTargetFailed  message = "coord_target_failed"
+	msgCoordWorkerDown    message = "coord_worker_down"
+	msgCoordReportError   message = "coord_report_error"
+	msgCoordDone          message = "coord_done"
+)
+
+// catalog holds the English texts; printf verbs are allowed for fail() messages.
+var catalog = map[message]string{
+	msgTUIError:           "TUI: %v",
+	msgInvalidProxy:       "Invalid proxy URL: %v",
+	msgUsingProxy:         "Using proxy",
+	msgInsecureTLS:        "TLS verification disabled (insecure mode)",
+	msgMissingURL:         "Missing -url",
+	msgInvalidURL:         "Invalid url: %v",
+	msgAuthDiffNeedsCreds: "-auth-diff requires credentials (-header or -cookie)",
+	msgConfigError:        "Config: %v",
+	msgAnonPass:           "Anonymous pass (probe only)",
+	msgAuthPass:           "Authenticated pass",
+	msgCrawlDone:          "Done",
+	msgReportError:        "Cannot write report",
+	msgFetching:           "Fetching",
+	msgRootFetchFailed:    "Failed to fetch root URL: %v",
+	msgNoScripts:          "No external script src found on page",
+	msgAuthDiff:           "Auth diff",
+	msgAuthOnlyMap:        "Map only exposed to authenticated users",
+	msgAnonOnlyMap:        "Map only exposed anonymously",
+	msgProcessing:         "Processing",
+	msgScriptFetchFailed:  "Failed to fetch script",
+	msgChunkDiscovered:    "Discovered chunk",
+	msgInlineMapDecode:    "Inline map decode error",
+	msgMapFetchFailed:     "Failed to fetch map",
+	msgNoSourcemap:        "No sourcemap",
+	msgInvalidMap:         "Invalid map",
+	msgMapFound:           "Found map",
+	msgMapError:           "Error processing map",
+	msgWritten:            "Written",
+	msgHTTPGet:            "GET",
+	msgReadMap:            "Read .map: %v",
+	msgInvalidMapJSON:     "Invalid sourcemap JSON: %v",
+	msgNoSources:          "No 'sources' in sourcemap",
+	msgSkippedNoContent:   "Skipped (no content)",
+	msgSkippedBlocked:     "Skipped (path blocked)",
+	msgCreateDir:          "Create dir: %v",
+	msgWriteFile:          "Write file: %v",
+	msgSummary:            "Summary",
+	msgInvalidLogFormat:   "Invalid -log-format %q (text|json)",
+	msgOpenLogFile:        "Open log file: %v",
+	msgTUIAscii:           "-tui cannot be combined with -ascii",
+	msgUnitScripts:        "scripts",
+	msgUnitSources:        "sources",
+	msgProgressSources:    "%d sources",
+	msgProgressETA:        "ETA %s",
+	msgTUIRunning:         "running",
+	msgTUIPaused:          "PAUSED",
+	msgTUIQuitting:        "quitting",
+	msgTUISkipped:         "skipped",
+	msgTUIKeys:            "p pause  up/down select host  s skip host  q quit",
+	msgProgressFD:         "-progress-fd: %v",
+	msgInvalidColor:       "%v",
+	msgLocatorError:       "Map locator error",
+	msgScriptSpooled:      "Large script, scanning it from disk",
+	msgScriptSpoolError:   "Cannot read spooled script",
+	msgChunkEvalFailed:    "Cannot evaluate chunk filename function",
+	msgImportMap:          "Import map found",
+	msgBadImportMap:       "Cannot parse import map",
+	msgWasmError:          "Cannot read WebAssembly module",
+	msgScriptHost:         "Script host allowed by the page's Content-Security-Policy",
+	msgIntegrityMismatch:  "Script does not match the integrity attribute of its tag",
+	msgSelftestListen:     "Fixture server: %v",
+	msgSelftestServing:    "Serving fixtures, press Ctrl-C to stop",
+	msgSelftestPass:       "PASS",
+	msgSelftestFail:       "FAIL",
+	msgSelftestMissing:    "missing",
+	msgSelftestKept:       "Output kept in",
+	msgMissingMap:         "Missing -map",
+	msgStrictErrors:       "Errors occurred (-strict)",
+	msgStdoutNeedsPath:    "-stdout requires -path",
+	msgSourceNotFound:     "%v",
+	msgNotJavaScript:      "Skipped, not JavaScript",
+	msgWouldWrite:         "Would write",
+	msgTUIPathsOnly:       "-tui cannot be combined with -paths-only, -print0 or an archive on stdout",
+	msgMemoryLimit:        "Memory limit reached, finishing in-flight work",
+	msgResumeError:        "-resume: %v",
+	msgResuming:           "Resuming from checkpoint",
+	msgCheckpointError:    "Write checkpoint: %v",
+	msgCheckpointWritten:  "Stopped on memory limit, rerun with -resume",
+	msgScriptSkipped:      "Skipped (already done or stopping)",
+	msgMapAndMapDir:       "-map and -map-dir are mutually exclusive",
+	msgMapDirPath:         "-path and -stdout cannot be used with -map-dir",
+	msgInvalidLayout:      "Invalid -layout %q (per-map|merged|flat)",
+	msgInvalidReport:      "Invalid -report %q (html|md)",
+	msgInvalidMapGuess:    "Invalid -map-guess %q (default|aggressive)",
+	msgRecordReplay:       "-record and -replay cannot be used together",
+	msgInvalidReplay:      "-replay: %v",
+	msgRecording:          "Recording HTTP exchanges",
+	msgReplaying:          "Replaying recorded HTTP exchanges, no network access",
+	msgReadMapDir:         "Read -map-dir: %v",
+	msgNoMapFiles:         "No .map files found",
+	msgLoadMap:            "%v",
+	msgMapStreamed:        "Large map, reading sources one at a time",
+	msgExtractWorkers:     "-workers must be at least 1",
+	msgMapExtracted:       "Map extracted",
+	msgMapFromScript:      "Using map referenced by script",
+	msgNoGenerated:        "Cannot reconstruct, generated bundle not found (use -js)",
+	msgReconstructError:   "Cannot reconstruct from mappings",
+	msgReconstructed:      "Reconstructed sources from mappings",
+	msgValidateSummary:    "Validation done",
+	msgSkippedFiltered:    "Skipped (filtered)",
+	msgVendorSummary:      "Vendor split",
+	msgDedupSummary:       "Dedup",
+	msgDuplicateSource:    "Duplicate content",
+	msgPathConflict:       "Path conflict",
+	msgConflictSummary:    "Conflicts",
+	msgCaseCollision:      "Renamed (case collision)",
+	msgNameScrubbed:       "Renamed (unicode normalization or invisible characters)",
+	msgScrubSummary:       "Scrubbed names",
+	msgManifestError:      "Cannot write manifest",
+	msgSumsWritten:        "Checksums written",
+	msgSumsSigned:         "Checksums signed",
+	msgSumsError:          "Cannot write or sign SHA256SUMS: %v",
+	msgArchiveError:       "Archive: %v",
+	msgArchiveWritten:     "Archive written",
+	msgZipAndTar:          "-out-zip and -out-tar are mutually exclusive",
+	msgArchiveStdout:      "An archive written to stdout cannot be combined with -paths-only or -print0",
+	msgBlobError:          "Object store: %v",
+	msgPrunedStale:        "Removed (not in this crawl)",
+	msgGitNeedsTree:       "-git needs a local -out directory",
+	msgGitError:           "Git commit failed",
+	msgGitUnchanged:       "Nothing changed since the last commit",
+	msgGitCommitted:       "Committed",
+	msgDiffArgs:           "Expected two outputs to compare",
+	msgDiffRead:           "Cannot read output: %v",
+	msgDiffSummary:        "Diff done",
+	msgDiffNoContent:      "No content to diff (manifest without its files)",
+	msgDiffTooLarge:       "Too many changes for a unified diff",
+	msgHTTPCacheError:     "Cannot write to the HTTP cache",
+	msgMonitorInterval:    "Invalid -interval %s",
+	msgMonitorCrawlFailed: "Crawl failed, keeping the previous state",
+	msgMonitorBaseline:    "Baseline recorded",
+	msgMonitorUnchanged:   "No change",
+	msgMonitorChanged:     "Changes detected",
+	msgMonitorNewMap:      "New source map exposed",
+	msgMonitorSleeping:    "Waiting for the next crawl",
+	msgMonitorAlertFailed: "Alert failed",
+	msgMonitorMetrics:     "Serving metrics",
+	msgNotifyFailed:       "Notification failed",
+	msgNotified:           "Notification sent",
+	msgSecretRules:        "-secret-rules: %v",
+	msgSecretFound:        "Possible secret",
+	msgSecretSummary:      "Secret scan",
+	msgSecretsError:       "Cannot write secrets.json",
+	msgEndpointSummary:    "Endpoints",
+	msgEndpointsError:     "Cannot write endpoints.txt or endpoints.json",
+	msgWordlistWritten:    "Wordlists written",
+	msgWordlistError:      "Cannot write wordlists",
+	msgEnvSummary:         "Environment references",
+	msgEnvError:           "Cannot write env.json",
+	msgFingerprint:        "Stack",
+	msgDepsSummary:        "Dependencies",
+	msgDepsError:          "Cannot write package.json",
+	msgTsconfigSummary:    "Project configuration",
+	msgTsconfigError:      "Cannot write tsconfig.json",
+	msgLicenseSummary:     "Licenses",
+	msgLicensesError:      "Cannot write licenses.json",
+	msgLanguage:           "Language",
+	msgHostSummary:        "Host",
+	msgFormatFailed:       "Formatter failed, source kept as is",
+	msgInferredExt:        "Extension inferred",
+	msgCharsetDecoded:     "Body converted to UTF-8",
+	msgRawMapsSaved:       "Raw maps saved",
+	msgRawMapError:        "Cannot save the raw map",
+	msgAssetMetaError:     "Cannot write the .meta.json of a saved file",
+	msgGraphSummary:       "Import graph",
+	msgGraphError:         "Cannot write the import graph",
+	msgCommentSummary:     "Comments",
+	msgCommentsError:      "Cannot write comments.json",
+	msgSarifSummary:       "SARIF findings",
+	msgSarifError:         "Cannot write findings.sarif",
+	msgSeverity:           "Exposure severity",
+	msgSeverityFailed:     "Exposure severity reached -fail-on",
+	msgInterrupted:        "Interrupted, stopping (Ctrl+C again to quit now)",
+	msgCheckpointStopped:  "Interrupted, rerun with -resume",
+	msgGrepArgs:           "-out and -pattern are required",
+	msgGrepPattern:        "Invalid -pattern: %v",
+	msgGrepRead:           "Cannot read output: %v",
+	msgGrepFileError:      "Cannot read file",
+	msgGrepSummary:        "Grep done",
+	msgServeArgs:          "-out is required",
+	msgServeListen:        "Cannot listen: %v",
+	msgServeRead:          "Cannot read the -out tree: %v",
+	msgServing:            "Serving, Ctrl+C to stop",
+	msgServerArgs:         "-workers must be at least 1 and -queue not negative",
+	msgServerNoToken:      "Listening beyond loopback without -token: anyone reaching the port can start crawls",
+	msgServerListening:    "Server listening, Ctrl+C to stop",
+	msgServerLoaded:       "Jobs of a previous run loaded",
+	msgJobDone:            "Job finished",
+	msgJobFailed:          "Job failed",
+	msgJobSaveError:       "Cannot save job files",
+	msgJobsLoad:           "Cannot read the jobs of the -data directory: %v",
+	msgCoordArgs:          "-targets and -worker are required",
+	msgCoordLimits:        "-per-worker must be at least 1, -retries not negative and -poll positive",
+	msgCoordTargets:       "Invalid -targets: %v",
+	msgCoordJob:           "Invalid -job, expected the JSON options of POST /jobs without url or map: %v",
+	msgCoordStart:         "Coordinating",
+	msgCoordTargetDone:    "Target collected",
+	msgCoordTargetFailed:  "Target failed",
+	msgCoordWorkerDown:    "Worker failing, its targets go to the other workers",
+	msgCoordReportError:   "Cannot write coordinator.json",
+	msgCoordDone:          "Coordination done",
+}
+
+func (m message) String() string {
+	if s, ok := catalog[m]; ok {
+		return s
+	}
+	return string(m)
+}
+
+func (m message) format(a ...any) string {
+	return fmt.Sprintf(m.String(), a...)
+}
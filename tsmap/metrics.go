@@ -0,0 +1,241 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricFamily describes one metric of GET /metrics; samples are written in
+// the order of metricFamilies.
+type metricFamily struct {
+	name, kind, help string
+}
+
+var metricFamilies = []metricFamily{
+	{"tsmap_http_requests_total", "counter", "Requests made to targets, by host and status code (error when no response came)"},
+	{"tsmap_http_downloaded_bytes_total", "counter", "Bytes downloaded from targets, by host; revalidated cache entries count 0"},
+	{"tsmap_http_request_duration_seconds", "histogram", "Duration of the requests made to targets, body included, by host"},
+	{"tsmap_maps_found_total", "counter", "Source maps extracted"},
+	{"tsmap_sources_written_total", "counter", "Sources written"},
+	{"tsmap_extraction_errors_total", "counter", "Scripts and maps that could not be fetched or extracted"},
+	{"tsmap_api_requests_total", "counter", "API requests answered by the server, by route and status code"},
+	{"tsmap_jobs_total", "counter", "Jobs finished by the server, by kind and state"},
+	{"tsmap_jobs", "gauge", "Jobs of the server waiting or running, by state"},
+	{"tsmap_monitor_runs_total", "counter", "Crawls of the monitor, by result (ok or failed)"},
+	{"tsmap_monitor_alerts_total", "counter", "Crawls of the monitor that found changes"},
+	{"tsmap_monitor_last_success_timestamp_seconds", "gauge", "Unix time of the last successful crawl of the monitor"},
+}
+
+// latencyBuckets are the upper bounds, in seconds, of the duration histograms.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	n      uint64
+}
+
+// metricSet keeps the counters of a long-running server or monitor and writes
+// them in the Prometheus text format. Samples are keyed by family, then by
+// their rendered labels. A nil *metricSet records nothing.
+type metricSet struct {
+	mu     sync.Mutex
+	values map[string]map[string]float64
+	hists  map[string]map[string]*histogram
+}
+
+// newMetricSet returns a set where the counters without labels start at 0.
+func newMetricSet() *metricSet {
+	m := &metricSet{values: make(map[string]map[string]float64), hists: make(map[string]map[string]*histogram)}
+	for _, name := range []string{"tsmap_maps_found_total", "tsmap_sources_written_total", "tsmap_extraction_errors_total"} {
+		m.add(name, 0)
+	}
+	return m
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricLabels renders alternating label names and values as name="value",...
+func metricLabels(kv []string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(kv); i += 2 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, kv[i], labelEscaper.Replace(kv[i+1]))
+	}
+	return b.String()
+}
+
+func (m *metricSet) sample(name string, kv []string) (map[string]float64, string) {
+	s := m.values[name]
+	if s == nil {
+		s = make(map[string]float64)
+		m.values[name] = s
+	}
+	return s, metricLabels(kv)
+}
+
+// add increments a counter.
+func (m *metricSet) add(name string, v float64, kv ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, key := m.sample(name, kv)
+	s[key] += v
+}
+
+// set sets a gauge.
+func (m *metricSet) set(name string, v float64, kv ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, key := m.sample(name, kv)
+	s[key] = v
+}
+
+// observe records a duration in a histogram.
+func (m *metricSet) observe(name string, d time.Duration, kv ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.hists[name]
+	if s == nil {
+		s = make(map[string]*histogram)
+		m.hists[name] = s
+	}
+	key := metricLabels(kv)
+	h := s[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		s[key] = h
+	}
+	sec := d.Seconds()
+	if i := sort.SearchFloat64s(latencyBuckets, sec); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.sum += sec
+	h.n++
+}
+
+// request records a request made to host; status 0 means it got no response.
+func (m *metricSet) request(host string, status int, bytes int64, d time.Duration) {
+	if m == nil {
+		return
+	}
+	code := "error"
+	if status != 0 {
+		code = strconv.Itoa(status)
+	}
+	m.add("tsmap_http_requests_total", 1, "host", host, "code", code)
+	m.add("tsmap_http_downloaded_bytes_total", float64(bytes), "host", host)
+	m.observe("tsmap_http_request_duration_seconds", d, "host", host)
+}
+
+// addReport records the requests, maps, sources and errors of a crawl from
+// its report.json, for the crawls run in a child process.
+func (m *metricSet) addReport(rep *crawlReport) {
+	for _, s := range rep.Scripts {
+		m.request(urlHost(s.URL), s.Status, s.Bytes, time.Duration(s.DurationMS)*time.Millisecond)
+		for _, a := range s.Attempts {
+			m.request(urlHost(a.URL), a.Status, a.Bytes, time.Duration(a.DurationMS)*time.Millisecond)
+		}
+		if s.MapURL != "" {
+			m.add("tsmap_maps_found_total", 1)
+		}
+		m.add("tsmap_sources_written_total", float64(s.Sources))
+		m.add("tsmap_extraction_errors_total", float64(len(s.Errors)))
+	}
+}
+
+func urlHost(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// observer returns an Observer counting the maps, sources and errors of a run.
+func (m *metricSet) observer() Observer {
+	return metricObserver{m: m}
+}
+
+type metricObserver struct {
+	NopObserver
+	m *metricSet
+}
+
+func (o metricObserver) OnMapFound(mapURL, scriptURL string, sources int) {
+	o.m.add("tsmap_maps_found_total", 1)
+}
+
+func (o metricObserver) OnFileWritten(path string, meta FileMeta) {
+	o.m.add("tsmap_sources_written_total", 1)
+}
+
+func (o metricObserver) OnError(url string, err error) {
+	o.m.add("tsmap_extraction_errors_total", 1)
+}
+
+// write writes every family having samples, in the text exposition format.
+func (m *metricSet) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range metricFamilies {
+		values, hists := m.values[f.name], m.hists[f.name]
+		if len(values) == 0 && len(hists) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+		for _, key := range sortedKeys(values) {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, braced(key), strconv.FormatFloat(values[key], 'f', -1, 64))
+		}
+		for _, key := range sortedKeys(hists) {
+			h := hists[key]
+			var cum uint64
+			for i, le := range latencyBuckets {
+				cum += h.counts[i]
+				fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, braced(joinLabels(key, "le="+strconv.Quote(strconv.FormatFloat(le, 'g', -1, 64)))), cum)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, braced(joinLabels(key, `le="+Inf"`)), h.n)
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.name, braced(key), strconv.FormatFloat(h.sum, 'g', -1, 64))
+			fmt.Fprintf(w, "%s_count%s %d\n", f.name, braced(key), h.n)
+		}
+	}
+}
+
+func braced(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+// ServeHTTP answers GET /metrics.
+func (m *metricSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
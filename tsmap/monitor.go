@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+type monitorFlags struct {
+	url      string
+	out      string
+	interval time.Duration
+	runs     int
+	notify   string
+	alertCmd string
+	metrics  string
+	log      *logOptions
+}
+
+func newMonitorFlags() (*flag.FlagSet, *monitorFlags) {
+	f := &monitorFlags{}
+	fs := flag.NewFlagSet("tsmap-extract monitor", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tsmap-extract monitor [flags] [-- crawl flags]")
+		fmt.Fprintln(fs.Output(), "Crawl flags after -- are passed to every crawl, e.g. -- -scope '*.example.com' -cookie 'sid=...'")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&f.url, "url", "", "Root page URL to crawl (required)")
+	fs.StringVar(&f.out, "out", "monitor", "State directory: latest/ and previous/ crawls, HTTP cache")
+	fs.DurationVar(&f.interval, "interval", 6*time.Hour, "Time between two crawls")
+	fs.IntVar(&f.runs, "runs", 0, "Stop after this many crawls (0: run until interrupted)")
+	fs.StringVar(&f.notify, "notify-url", "", "POST the changes to this webhook (JSON, or a message for Slack and Discord webhooks)")
+	fs.StringVar(&f.alertCmd, "alert-cmd", "", "Run this shell command on changes, with the JSON on stdin")
+	fs.StringVar(&f.metrics, "metrics-listen", "", "Serve Prometheus metrics on this address at /metrics (e.g. 127.0.0.1:9091)")
+	fs.String("config", "", "YAML config file (default ~/.config/tsmap-extract/config.yaml)")
+	f.log = addLogFlags(fs)
+	return fs, f
+}
+
+// RunMonitor runs the "monitor" subcommand: it crawls the target every
+// -interval and reports, and alerts on, the sources and maps that changed since
+// the previous crawl. Downloads go through an HTTP cache kept in the state
+// directory, so unchanged scripts and maps cost a conditional request only.
+// With -metrics-listen, the crawls are counted for Prometheus.
+func RunMonitor(args []string) int {
+	useConsoleLogger()
+	fs, f := newMonitorFlags()
+	loadDefaults(fs, "monitor", args)
+	fs.Parse(args)
+	crawlArgs := fs.Args()
+	if len(crawlArgs) > 0 && crawlArgs[0] == "--" {
+		crawlArgs = crawlArgs[1:]
+	}
+	defer f.log.setup()()
+
+	if strings.TrimSpace(f.url) == "" {
+		logger.Error(msgMissingURL.String())
+		fs.Usage()
+		return exitUsage
+	}
+	if f.interval <= 0 {
+		usageFail(msgMonitorInterval, f.interval)
+	}
+	if err := os.MkdirAll(f.out, 0755); err != nil {
+		fail(msgCreateDir, err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var m *metricSet
+	if f.metrics != "" {
+		m = newMetricSet()
+		ln, err := net.Listen("tcp", f.metrics)
+		if err != nil {
+			fail(msgServeListen, err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", m)
+		srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() { _ = srv.Serve(ln) }()
+		defer srv.Close()
+		logger.Info(msgMonitorMetrics.String(), "url", "http://"+ln.Addr().String()+"/metrics")
+	}
+
+	for run := 1; f.runs == 0 || run <= f.runs; run++ {
+		if code := monitorRound(f, crawlArgs, m); code == exitUsage {
+			return code
+		}
+		if f.runs != 0 && run == f.runs {
+			break
+		}
+		logger.Info(msgMonitorSleeping.String(), "next", time.Now().Add(f.interval).Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			return exitOK
+		case <-time.After(f.interval):
+		}
+	}
+	return exitOK
+}
+
+// monitorRound crawls into next/, compares it with latest/ and rotates the
+// directories: latest/ becomes previous/ and next/ becomes latest/. A failed
+// crawl leaves latest/ untouched.
+func monitorRound(f *monitorFlags, crawlArgs []string, m *metricSet) int {
+	latest := filepath.Join(f.out, "latest")
+	next := filepath.Join(f.out, "next")
+	if err := os.RemoveAll(next); err != nil {
+		fail(msgCreateDir, err)
+	}
+	code, err := runChildCrawl(append([]string{
+		"-url", f.url, "-out", next, "-q", "-no-progress",
+		"-http-cache", filepath.Join(f.out, "http-cache"),
+	}, crawlArgs...))
+	switch {
+	case err != nil:
+		logger.Error(msgMonitorCrawlFailed.String(), "err", err)
+		m.add("tsmap_monitor_runs_total", 1, "result", "failed")
+		return exitFatal
+	case code == exitUsage:
+		return code
+	case code == exitFatal:
+		logger.Error(msgMonitorCrawlFailed.String(), "exit_code", code)
+		m.add("tsmap_monitor_runs_total", 1, "result", "failed")
+		return code
+	}
+	if m != nil {
+		if data, err := os.ReadFile(filepath.Join(next, "report.json")); err == nil {
+			var rep crawlReport
+			if json.Unmarshal(data, &rep) == nil {
+				m.addReport(&rep)
+			}
+		}
+		m.add("tsmap_monitor_runs_total", 1, "result", "ok")
+		m.add("tsmap_monitor_alerts_total", 0)
+		m.set("tsmap_monitor_last_success_timestamp_seconds", float64(time.Now().Unix()))
+	}
+	if err := os.MkdirAll(next, 0755); err != nil { // a crawl finding nothing writes nothing
+		fail(msgCreateDir, err)
+	}
+
+	newSide, err := loadDiffSide(next)
+	if err != nil {
+		fail(msgDiffRead, err)
+	}
+	if _, err := os.Stat(latest); err != nil {
+		logger.Info(msgMonitorBaseline.String(), "files", len(newSide.hashes), "maps", len(reportMaps(next)))
+	} else {
+		oldSide, err := loadDiffSide(latest)
+		if err != nil {
+			fail(msgDiffRead, err)
+		}
+		c := &fileChanges{Added: []string{}, Removed: []string{}, Modified: []string{}}
+		for _, d := range compareSides(oldSide, newSide) {
+			switch d.Status {
+			case "added":
+				c.Added = append(c.Added, d.Path)
+			case "removed":
+				c.Removed = append(c.Removed, d.Path)
+			default:
+				c.Modified = append(c.Modified, d.Path)
+			}
+		}
+		alert := &notification{Target: f.url, Time: time.Now().UTC(), Maps: []string{}, FilesRecovered: len(newSide.hashes), Changes: c}
+		oldMaps := reportMaps(latest)
+		for m := range reportMaps(next) {
+			if !oldMaps[m] {
+				alert.Maps = append(alert.Maps, m)
+			}
+		}
+		sort.Strings(alert.Maps)
+		oldSecrets := reportSecrets(latest)
+		for key := range reportSecrets(next) {
+			if !oldSecrets[key] {
+				alert.Secrets++
+			}
+		}
+		if len(c.Added)+len(c.Removed)+len(c.Modified)+len(alert.Maps)+alert.Secrets == 0 {
+			logger.Info(msgMonitorUnchanged.String(), "files", len(newSide.hashes))
+		} else {
+			for _, m := range alert.Maps {
+				logger.Warn(msgMonitorNewMap.String(), "map", m)
+			}
+			logger.Warn(msgMonitorChanged.String(), "added", len(c.Added), "removed", len(c.Removed), "modified", len(c.Modified), "new_maps", len(alert.Maps), "new_secrets", alert.Secrets)
+			m.add("tsmap_monitor_alerts_total", 1)
+			sendAlert(f, alert)
+		}
+	}
+
+	previous := filepath.Join(f.out, "previous")
+	if err := os.RemoveAll(previous); err != nil {
+		fail(msgCreateDir, err)
+	}
+	if err := os.Rename(latest, previous); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fail(msgCreateDir, err)
+	}
+	if err := os.Rename(next, latest); err != nil {
+		fail(msgCreateDir, err)
+	}
+	return exitOK
+}
+
+// runChildCrawl runs "crawl" in a child process: a fatal error of one crawl
+// (e.g. the root page is down) must not stop the monitor.
+func runChildCrawl(args []string) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(exe, append([]string{"crawl"}, args...)...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+// reportMaps returns the map URLs found by the crawl written to dir, from its
+// report.json; inline maps are named after their script.
+func reportMaps(dir string) map[string]bool {
+	maps := make(map[string]bool)
+	data, err := os.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil {
+		return maps
+	}
+	var rep crawlReport
+	if json.Unmarshal(data, &rep) != nil {
+		return maps
+	}
+	for _, s := range rep.Scripts {
+		switch s.MapURL {
+		case "":
+		case "inline":
+			maps[s.URL+" (inline)"] = true
+		default:
+			maps[s.MapURL] = true
+		}
+	}
+	return maps
+}
+
+// reportSecrets returns the rule and value of the secrets in dir/secrets.json
+// (crawl -scan-secrets), wherever they were found.
+func reportSecrets(dir string) map[string]bool {
+	keys := make(map[string]bool)
+	data, err := os.ReadFile(filepath.Join(dir, secretsFile))
+	if err != nil {
+		return keys
+	}
+	var list []secretFinding
+	if json.Unmarshal(data, &list) != nil {
+		return keys
+	}
+	for _, f := range list {
+		keys[f.Rule+"\x00"+f.Match] = true
+	}
+	return keys
+}
+
+// sendAlert sends the changes to -notify-url and pipes them to -alert-cmd;
+// failures are logged and the monitor goes on.
+func sendAlert(f *monitorFlags, alert *notification) {
+	if f.notify != "" {
+		if err := sendNotification(f.notify, alert); err != nil {
+			logger.Error(msgNotifyFailed.String(), "url", f.notify, "err", err)
+		}
+	}
+	if f.alertCmd != "" {
+		data, _ := json.MarshalIndent(alert, "", "  ")
+		shell, opt := "sh", "-c"
+		if runtime.GOOS == "windows" {
+			shell, opt = "cmd", "/C"
+		}
+		cmd := exec.Command(shell, opt, f.alertCmd)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			logger.Error(msgMonitorAlertFailed.String(), "cmd", f.alertCmd, "err", err)
+		}
+	}
+}
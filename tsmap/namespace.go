@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// keepNamespaces rewrites the sources of sm for -keep-namespace:
+//
+//	webpack://my-app/./src/x.ts                 -> my-app/src/x.ts
+//	webpack://my-app/../lib/y.ts                -> my-app/lib/y.ts
+//	src/App.vue?vue&type=script&lang=ts         -> src/App.vue/script.ts
+//	src/App.vue?vue&type=style&index=0&lang=css -> src/App.vue/style.css
+//	src/App.vue (when parts exist)              -> src/App.vue/App.vue
+//
+// The namespace always stays the top directory: leading "../" of the path
+// inside it are dropped. sourceRoot is folded into the sources.
+func keepNamespaces(sm *SourceMap) {
+	sources := make([]string, len(sm.Sources))
+	original := make([]string, len(sm.Sources))
+	split := make(map[string]bool) // SFC files that have query parts
+	for i, s := range sm.Sources {
+		p := joinMaybe(sm.SourceRoot, s)
+		original[i] = p
+		if rest, ok := strings.CutPrefix(p, "webpack://"); ok && !strings.HasPrefix(rest, "/") {
+			ns, inner, _ := strings.Cut(rest, "/")
+			inner = normalizeKeepDots(inner)
+			for strings.HasPrefix(inner, "../") || strings.HasPrefix(inner, "./") {
+				inner = inner[strings.Index(inner, "/")+1:]
+			}
+			p = ns + "/" + inner
+		}
+		if file, query, ok := strings.Cut(p, "?"); ok {
+			if part := sfcPart(file, query); part != "" {
+				p = file + "/" + part
+				split[normalizeKeepDots(file)] = true
+			}
+		}
+		sources[i] = p
+	}
+	for i, p := range sources {
+		if split[normalizeKeepDots(p)] {
+			sources[i] = p + "/" + path.Base(p)
+		}
+	}
+	sm.Sources = sources
+	sm.SourceRoot = ""
+	sm.original = original
+}
+
+// sfcPart names the block of a Vue or Svelte single-file component that a
+// loader query selects: "script.ts", "style.css", "style.1.scss",
+// "template.html". It returns "" for other files and unknown queries.
+func sfcPart(file, query string) string {
+	ext := path.Ext(file)
+	if ext != ".vue" && ext != ".svelte" {
+		return ""
+	}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return ""
+	}
+	kind := q.Get("type")
+	lang := q.Get("lang")
+	for k := range q {
+		// vue-loader and svelte-loader also spell it "lang.ts"
+		if l, ok := strings.CutPrefix(k, "lang."); ok && lang == "" {
+			lang = l
+		}
+	}
+	switch kind {
+	case "script":
+		if lang == "" {
+			lang = "js"
+		}
+	case "style":
+		if lang == "" {
+			lang = "css"
+		}
+		if n, _ := strconv.Atoi(q.Get("index")); n > 0 {
+			kind += "." + strconv.Itoa(n)
+		}
+	case "template":
+		if lang == "" {
+			lang = "html"
+		}
+	default:
+		return ""
+	}
+	return kind + "." + lang
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// notification is the finding sent to -notify-url by crawl and monitor, and
+// piped to monitor -alert-cmd.
+type notification struct {
+	Target         string       `json:"target"`
+	Time           time.Time    `json:"time"`
+	Maps           []string     `json:"new_maps"`
+	FilesRecovered int          `json:"files_recovered"`
+	Secrets        int          `json:"secrets_matched"`   // new ones only for monitor
+	Changes        *fileChanges `json:"changes,omitempty"` // monitor only
+}
+
+// fileChanges lists the sources that changed since the previous monitor crawl.
+type fileChanges struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// notifyListMax bounds the maps listed in a chat message.
+const notifyListMax = 10
+
+// sendNotification posts n to a webhook: Slack and Discord webhook URLs get a
+// formatted chat message, any other URL the JSON payload.
+func sendNotification(webhook string, n *notification) error {
+	var body any = n
+	switch notifyKind(webhook) {
+	case "slack":
+		body = map[string]string{"text": n.text("*", "•")}
+	case "discord":
+		text := n.text("**", "-")
+		if len(text) > 2000 { // Discord rejects longer messages
+			text = text[:1997] + "..."
+		}
+		body = map[string]string{"content": text}
+	}
+	data, _ := json.Marshal(body)
+	c := &http.Client{Timeout: 30 * time.Second}
+	resp, err := c.Post(webhook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+func notifyKind(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "hooks.slack.com":
+		return "slack"
+	case (host == "discord.com" || host == "discordapp.com" || strings.HasSuffix(host, ".discord.com")) && strings.HasPrefix(u.Path, "/api/webhooks/"):
+		return "discord"
+	}
+	return ""
+}
+
+// text renders n as a chat message; bold and bullet are the markup of the chat.
+func (n *notification) text(bold, bullet string) string {
+	var b strings.Builder
+	if c := n.Changes; c != nil {
+		fmt.Fprintf(&b, "%stsmap-extract%s: changes on %s: %d added, %d removed, %d modified",
+			bold, bold, n.Target, len(c.Added), len(c.Removed), len(c.Modified))
+		if len(n.Maps) > 0 {
+			fmt.Fprintf(&b, ", %d new source map(s)", len(n.Maps))
+		}
+		if n.Secrets > 0 {
+			fmt.Fprintf(&b, ", %d new secret(s)", n.Secrets)
+		}
+	} else {
+		fmt.Fprintf(&b, "%stsmap-extract%s: %d source map(s) exposed on %s, %d file(s) recovered",
+			bold, bold, len(n.Maps), n.Target, n.FilesRecovered)
+		if n.Secrets > 0 {
+			fmt.Fprintf(&b, ", %d secret(s) matched", n.Secrets)
+		}
+	}
+	for i, m := range n.Maps {
+		if i == notifyListMax {
+			fmt.Fprintf(&b, "\n%s ... and %d more", bullet, len(n.Maps)-notifyListMax)
+			break
+		}
+		fmt.Fprintf(&b, "\n%s %s", bullet, m)
+	}
+	return b.String()
+}
@@ -2,10 +2,276 @@
 // Author: Michel Prunet - Safe Pic Technologies
 package tsmap
 
-type sourceMap struct {
-	Version        int      `json:"version"`
-	File           string   `json:"file"`
-	Sources        []string `json:"sources"`
-	SourcesContent []string `json:"sourcesContent"`
-	SourceRoot     string   `json:"sourceRoot"`
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// SourceMap is a source map v3 as defined by the ECMA-426 specification.
+// Every subcommand works on this model; decoded by ParseSourceMap, the
+// sections of an index map are already flattened into Sources.
+type SourceMap struct {
+	Version        int          `json:"version"`
+	File           string       `json:"file"`
+	Sources        []string     `json:"sources"`
+	SourcesContent []string     `json:"sourcesContent"` // may be shorter than Sources; see HasContent
+	SourceRoot     string       `json:"sourceRoot"`
+	Names          []string     `json:"names,omitempty"`
+	Mappings       string       `json:"mappings,omitempty"` // decode with DecodeMappings
+	IgnoreList     []int        `json:"ignoreList,omitempty"`
+	GoogleIgnore   []int        `json:"x_google_ignoreList,omitempty"` // pre-standard name of ignoreList
+	DebugID        string       `json:"debugId,omitempty"`
+	Sections       []MapSection `json:"sections,omitempty"`
+
+	original []string         // sources as found in the map, once rewritten by -keep-namespace
+	stream   *streamedContent // replaces SourcesContent for a large map file
+}
+
+// MapSection is one entry of an index map: an embedded map or the URL of one.
+type MapSection struct {
+	Offset struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	} `json:"offset"`
+	Map *SourceMap `json:"map,omitempty"`
+	URL string     `json:"url,omitempty"`
+}
+
+// maxSectionDepth bounds nested index maps, which could otherwise reference each other.
+const maxSectionDepth = 8
+
+// decodeSourceMap parses a map and flattens index map sections into plain
+// sources. base is the location of the map (URL or file path), used to resolve
+// section URLs, which are loaded with fetch.
+func decodeSourceMap(data []byte, base string, fetch func(string) ([]byte, error)) (SourceMap, error) {
+	var sm SourceMap
+	if err := json.Unmarshal(unwrapMapJSON(data), &sm); err != nil {
+		return sm, err
+	}
+	err := flattenSections(&sm, base, fetch, 0)
+	return sm, err
+}
+
+// flattenSections appends the sources of every section to sm. Section sources
+// are stored with their own sourceRoot applied, so sm.SourceRoot is cleared.
+// Section mappings are relative to their own offsets and are not merged.
+func flattenSections(sm *SourceMap, base string, fetch func(string) ([]byte, error), depth int) error {
+	if len(sm.Sections) == 0 {
+		return nil
+	}
+	if depth >= maxSectionDepth {
+		return errors.New("index map sections nested too deeply")
+	}
+	sections := sm.Sections
+	sm.Sections = nil
+	for len(sm.SourcesContent) < len(sm.Sources) {
+		sm.SourcesContent = append(sm.SourcesContent, "")
+	}
+	for i, s := range sm.Sources {
+		sm.Sources[i] = joinMaybe(sm.SourceRoot, s)
+	}
+	sm.SourceRoot = ""
+
+	for i, sec := range sections {
+		var sub SourceMap
+		subBase := base
+		switch {
+		case sec.Map != nil:
+			sub = *sec.Map
+		case sec.URL != "":
+			if fetch == nil {
+				return fmt.Errorf("section %d: url %q cannot be loaded here", i, sec.URL)
+			}
+			subBase = resolveRef(base, sec.URL)
+			data, err := fetch(subBase)
+			if err != nil {
+				return fmt.Errorf("section %d: %w", i, err)
+			}
+			if err := json.Unmarshal(unwrapMapJSON(data), &sub); err != nil {
+				return fmt.Errorf("section %d: %w", i, err)
+			}
+		default:
+			continue
+		}
+		if err := flattenSections(&sub, subBase, fetch, depth+1); err != nil {
+			return err
+		}
+		offset := len(sm.Sources)
+		for j := range sub.ignored() {
+			sm.IgnoreList = append(sm.IgnoreList, offset+j)
+		}
+		for j, s := range sub.Sources {
+			content := ""
+			if j < len(sub.SourcesContent) {
+				content = sub.SourcesContent[j]
+			}
+			sm.Sources = append(sm.Sources, joinMaybe(sub.SourceRoot, s))
+			sm.SourcesContent = append(sm.SourcesContent, content)
+		}
+	}
+	return nil
+}
+
+// HasContent reports whether source i is shipped with a non-blank content.
+func (sm *SourceMap) HasContent(i int) bool {
+	if sm.stream != nil {
+		return i >= 0 && i < len(sm.stream.blank) && !sm.stream.blank[i]
+	}
+	return i >= 0 && i < len(sm.SourcesContent) && strings.TrimSpace(sm.SourcesContent[i]) != ""
+}
+
+// listsContent reports whether sourcesContent has an entry, maybe blank, for source i.
+func (sm *SourceMap) listsContent(i int) bool {
+	if sm.stream != nil {
+		return i < len(sm.stream.offset)
+	}
+	return i < len(sm.SourcesContent)
+}
+
+// content returns the content of source i, "" when it has none. Only a
+// streamed map, read back from its file, may fail.
+func (sm *SourceMap) content(i int) (string, error) {
+	if sm.stream != nil {
+		return sm.stream.read(i)
+	}
+	if i < len(sm.SourcesContent) {
+		return sm.SourcesContent[i], nil
+	}
+	return "", nil
+}
+
+// contentSize is the length of the content of source i.
+func (sm *SourceMap) contentSize(i int) int {
+	if sm.stream != nil {
+		if i < len(sm.stream.size) {
+			return sm.stream.size[i]
+		}
+		return 0
+	}
+	if i < len(sm.SourcesContent) {
+		return len(sm.SourcesContent[i])
+	}
+	return 0
+}
+
+// setContent replaces the content of source i; "" drops it.
+func (sm *SourceMap) setContent(i int, s string) {
+	if sm.stream != nil {
+		sm.stream.set(i, s)
+		return
+	}
+	sm.padContent(i + 1)
+	sm.SourcesContent[i] = s
+}
+
+// padContent lists a blank content for every source up to n.
+func (sm *SourceMap) padContent(n int) {
+	if sm.stream != nil {
+		sm.stream.pad(n)
+		return
+	}
+	for len(sm.SourcesContent) < n {
+		sm.SourcesContent = append(sm.SourcesContent, "")
+	}
+}
+
+// ResolvedSource returns source i with sourceRoot applied.
+func (sm *SourceMap) ResolvedSource(i int) string {
+	return joinMaybe(sm.SourceRoot, sm.Sources[i])
+}
+
+// sourceName is source i as found in the map, sourceRoot included.
+func (sm *SourceMap) sourceName(i int) string {
+	if sm.original != nil {
+		return sm.original[i]
+	}
+	return sm.ResolvedSource(i)
+}
+
+// ignored returns the indices of sources listed in ignoreList or x_google_ignoreList.
+func (sm *SourceMap) ignored() map[int]bool {
+	out := make(map[int]bool, len(sm.IgnoreList)+len(sm.GoogleIgnore))
+	for _, i := range sm.IgnoreList {
+		out[i] = true
+	}
+	for _, i := range sm.GoogleIgnore {
+		out[i] = true
+	}
+	return out
+}
+
+// resolveRef resolves ref against base, which is either a URL or a file path.
+func resolveRef(base, ref string) string {
+	if u, err := url.Parse(ref); err == nil && u.IsAbs() {
+		return ref
+	}
+	if isHTTPURL(base) {
+		if u, err := url.Parse(base); err == nil {
+			if r, err := u.Parse(ref); err == nil {
+				return r.String()
+			}
+		}
+	}
+	return filepath.Join(filepath.Dir(base), filepath.FromSlash(ref))
+}
+
+// xssiPrefixes are the anti-JSON-hijacking guards servers put in front of JSON.
+var xssiPrefixes = [][]byte{[]byte(")]}'"), []byte(")]}"), []byte("while(1);"), []byte("for(;;);")}
+
+// reJSONP matches a JSONP call opening: "cb(", "/**/ cb(", "typeof cb === 'function' && cb(".
+var reJSONP = regexp.MustCompile(`^(?:/\*\*/\s*)?(?:typeof\s+[\w$.]+\s*===?\s*['"]function['"]\s*&&\s*)?[\w$.]+\s*\(`)
+
+// unwrapMapJSON strips what commonly surrounds a served map so that it decodes:
+// a UTF-8 or UTF-16 BOM, an XSSI prefix such as )]}' and a JSONP callback.
+// A map in latin-1 is converted to UTF-8. Data that is already plain JSON is
+// returned unchanged.
+func unwrapMapJSON(data []byte) []byte {
+	data = decodeLatin1(decodeBOM(data))
+	b := bytes.TrimLeft(data, " \t\r\n")
+	for _, p := range xssiPrefixes {
+		if bytes.HasPrefix(b, p) {
+			b = bytes.TrimLeft(b[len(p):], ", \t\r\n")
+			break
+		}
+	}
+	if loc := reJSONP.FindIndex(b); loc != nil {
+		inner := bytes.TrimRight(b[loc[1]:], " \t\r\n;")
+		if bytes.HasSuffix(inner, []byte(")")) {
+			b = inner[:len(inner)-1]
+		}
+	}
+	return b
+}
+
+// decodeBOM removes a UTF-8 BOM and converts UTF-16 text with a BOM to UTF-8.
+func decodeBOM(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xef\xbb\xbf")):
+		return data[3:]
+	case bytes.HasPrefix(data, []byte("\xff\xfe")), bytes.HasPrefix(data, []byte("\xfe\xff")):
+		le := data[0] == 0xff
+		data = data[2:]
+		u := make([]uint16, len(data)/2)
+		for i := range u {
+			if le {
+				u[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+			} else {
+				u[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+			}
+		}
+		out := make([]byte, 0, len(u))
+		for _, r := range utf16.Decode(u) {
+			out = utf8.AppendRune(out, r)
+		}
+		return out
+	}
+	return data
 }
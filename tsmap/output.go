@@ -0,0 +1,529 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputOptions controls how recovered files are rendered and written; shared by extract and crawl.
+type outputOptions struct {
+	beautify  bool
+	format    *formatCommand // -format-cmd
+	eol       string
+	dryRun    bool
+	rename    renameRules
+	pathsOnly bool
+	print0    bool
+
+	reconstruct bool
+	prettyBuild bool // -reconstruct-pretty
+	inferExt    bool
+	timestamps  timestampMode
+	filter      *sourceFilter
+	secrets     *secretScanner
+	endpoints   *endpointScanner
+	wordlist    *wordlistBuilder
+	env         *envScanner
+	fingerprint *fingerprinter
+	deps        *depScanner
+	tsconfig    *tsconfigBuilder
+	licenses    *licenseScanner
+	langs       *langStats
+	graph       *graphBuilder
+	comments    *commentScanner
+	sarif       *sarifReport
+	analyzers   []analyzer // the scanners above, in the order they run
+	scores      *exposureScores
+	dedup       *dedupStore // nil unless -dedup
+	conflicts   *conflictTracker
+	scrubbed    *scrubLog // path segments changed by sanitizing
+	fsync       bool
+	root        string // output directory; no symlink is followed below it
+	portable    bool   // apply case collision renaming on any OS
+	namespaces  bool   // -keep-namespace
+	flat        bool   // -layout flat: one directory, encoded file names
+	manifest    *manifest
+	noManifest  bool
+	events      *eventStream // -progress-fd stream or library observer, nil when none
+	sums        bool         // -sums: SHA256SUMS of the output directory
+	signKey     string       // minisign secret key signing SHA256SUMS
+	outZip      string
+	outTar      string
+	keepTree    bool // with -out-zip or -out-tar, also write the directory tree
+
+	sinks       []Sink       // where files go; none in dry-run mode
+	tree        *DirSink     // the -out directory, nil when only an archive is written
+	archive     *ArchiveSink // nil unless -out-zip or -out-tar
+	archiveFile io.Closer
+	archivePath string
+	blobURL     string          // -out s3://, gs:// or az://
+	prune       bool            // remove files of the previous manifest not written again
+	pruneKeep   map[string]bool // scripts and maps that failed in this run, whose files are not pruned
+	hashMu      sync.Mutex
+	hashes      map[string]string // path -> SHA-256 of every file written, for -sums
+}
+
+// analyzer looks at every recovered source once it is written (secrets,
+// endpoints, wordlists...) and saves what it found at the end of the run.
+type analyzer interface {
+	scan(rel string, data []byte, meta FileMeta) // rel is slash separated, relative to the output
+	save(o *outputOptions)
+}
+
+// analyze hands the file written at dst to every analyzer.
+func (o *outputOptions) analyze(dst string, data []byte, meta FileMeta) {
+	rel, err := filepath.Rel(o.root, dst)
+	if err != nil {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+	for _, a := range o.analyzers {
+		a.scan(rel, data, meta)
+	}
+}
+
+// saveAnalyzers writes the results of every analyzer.
+func (o *outputOptions) saveAnalyzers() {
+	for _, a := range o.analyzers {
+		a.save(o)
+	}
+}
+
+// pathsMu serializes -paths-only lines written by concurrent crawl workers.
+var pathsMu sync.Mutex
+
+func addOutputFlags(fs *flag.FlagSet) *outputOptions {
+	o := &outputOptions{conflicts: newConflictTracker(), scrubbed: &scrubLog{}, manifest: &manifest{}, fingerprint: &fingerprinter{}, langs: &langStats{}}
+	o.timestamps.started = time.Now()
+	fs.BoolVar(&o.beautify, "beautify", false, "Split and indent minified lines of JS/TS sources")
+	fs.StringVar(&o.eol, "eol", "", "Normalize line endings: unix|dos")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Fetch and parse everything but only print the paths that would be written")
+	fs.BoolVar(&o.pathsOnly, "paths-only", false, "Print only the written file paths to stdout, logs go to stderr")
+	fs.BoolVar(&o.print0, "print0", false, "Like -paths-only but NUL-separated, for xargs -0")
+	fs.BoolVar(&o.reconstruct, "reconstruct", false, "Rebuild sources without sourcesContent from the mappings and the generated bundle")
+	fs.BoolVar(&o.inferExt, "infer-ext", false, "Add an extension guessed from the content (.ts, .tsx, .jsx, .js, .vue, .json, .css) to sources without one")
+	fs.BoolVar(&o.prettyBuild, "reconstruct-pretty", false, "Rebuild sources without sourcesContent as renamed and beautified code in bundle order rather than at their original lines (implies -reconstruct)")
+	o.format = addFormatFlags(fs)
+	o.filter = addFilterFlags(fs)
+	o.secrets = addSecretFlags(fs)
+	o.endpoints = addEndpointFlags(fs)
+	o.wordlist = addWordlistFlags(fs)
+	o.env = addEnvFlags(fs)
+	o.deps = addDepsFlags(fs)
+	o.tsconfig = addTsconfigFlags(fs)
+	o.licenses = addLicenseFlags(fs)
+	o.graph = addGraphFlags(fs)
+	o.comments = addCommentFlags(fs)
+	o.sarif = addSarifFlags(fs)
+	o.scores = addSeverityFlags(fs)
+	o.analyzers = []analyzer{o.secrets, o.endpoints, o.wordlist, o.env, o.deps, o.tsconfig, o.licenses, o.graph, o.comments, o.langs}
+	fs.Var(dedupMode{&o.dedup}, "dedup", "Store identical sources once: off|skip|hardlink")
+	fs.BoolVar(&o.namespaces, "keep-namespace", false, "Keep the webpack:// namespace as top directory and split Vue/Svelte SFC parts (App.vue/script.ts)")
+	fs.BoolVar(&o.portable, "portable-paths", false, "Rename case-only path collisions (Foo.ts/foo.ts) even on case-sensitive filesystems")
+	fs.BoolVar(&o.noManifest, "no-manifest", false, "Do not write manifest.json (origin, index and SHA-256 of every written file)")
+	fs.BoolVar(&o.sums, "sums", false, "Write SHA256SUMS covering every file of the output directory")
+	fs.StringVar(&o.signKey, "sign-key", "", "Sign SHA256SUMS with this minisign secret key (implies -sums)")
+	fs.StringVar(&o.outZip, "out-zip", "", "Stream recovered files into this ZIP archive instead of the -out tree")
+	fs.StringVar(&o.outTar, "out-tar", "", "Stream recovered files into this tar.gz archive instead of the -out tree ('-' for stdout)")
+	fs.BoolVar(&o.keepTree, "keep-tree", false, "With -out-zip or -out-tar, also write the directory tree")
+	fs.BoolVar(&o.fsync, "fsync", false, "Flush every written file and its directory to disk")
+	fs.Var(o.conflicts, "on-conflict", "Same path, different content from another map: overwrite|skip|suffix|newest")
+	fs.Var(&o.timestamps, "timestamps", "Date of written sources: now, or source (the map's Last-Modified or file date, else the start of the run; #! scripts made executable)")
+	fs.Var(&o.rename, "rename", "Rewrite output paths with a sed-style rule 's#regexp#replacement#[g]' (repeatable)")
+	return o
+}
+
+// saveManifest writes manifest.json in the output directory unless disabled.
+func (o *outputOptions) saveManifest() {
+	if o.noManifest || o.dryRun {
+		return
+	}
+	var old []byte
+	if o.tree != nil {
+		old, _ = os.ReadFile(filepath.Join(o.root, "manifest.json"))
+	}
+	if o.prune && old != nil {
+		var kept []manifestEntry
+		for _, e := range o.manifest.stale(old) {
+			if o.pruneKeep[e.Script] || o.pruneKeep[e.Map] {
+				kept = append(kept, e)
+				continue
+			}
+			dst := filepath.Join(o.root, filepath.FromSlash(e.Path))
+			if fi, err := os.Lstat(dst); err == nil && fi.Mode().IsRegular() && mustBeUnder(o.root, dst) == nil {
+				_ = os.Remove(dst)
+				logger.Debug(msgPrunedStale.String(), "path", dst)
+			}
+		}
+		old, _ = json.Marshal(kept)
+	}
+	data, err := o.manifest.marshal(old)
+	if err == nil && data != nil {
+		err = o.writeMeta("manifest.json", data)
+	}
+	if err != nil {
+		logger.Warn(msgManifestError.String(), "err", err)
+	}
+}
+
+// saveSums writes SHA256SUMS and its signature; it must run after every other file.
+func (o *outputOptions) saveSums() {
+	if (!o.sums && o.signKey == "") || o.dryRun {
+		return
+	}
+	if err := o.writeSums(); err != nil {
+		fail(msgSumsError, err)
+	}
+}
+
+// setRoot records the -out value and returns the local output directory: ""
+// when -out is an object store URL, whose files are then uploaded.
+func (o *outputOptions) setRoot(out string) string {
+	if isBlobURL(out) {
+		o.blobURL, out = out, ""
+	}
+	o.root = out
+	return out
+}
+
+// openSinks sets up the -out tree or object store and the -out-zip or -out-tar archive;
+// closeSinks must be called once every file, report included, was written.
+func (o *outputOptions) openSinks() {
+	path, newSink := o.outZip, NewZipSink
+	if o.outTar != "" {
+		if o.outZip != "" {
+			usageFail(msgZipAndTar)
+		}
+		path, newSink = o.outTar, NewTarSink
+	}
+	if path == "-" && o.scripted() {
+		usageFail(msgArchiveStdout)
+	}
+	if o.dryRun {
+		return
+	}
+	switch {
+	case o.blobURL != "":
+		blob, err := NewBlobSink(o.blobURL)
+		if err != nil {
+			usageFail(msgBlobError, err)
+		}
+		o.sinks = append(o.sinks, blob)
+	case path == "" || o.keepTree:
+		o.tree = NewDirSink(o.root)
+		o.tree.Fsync = o.fsync
+		o.sinks = append(o.sinks, o.tree)
+	}
+	if path == "" {
+		return
+	}
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			fail(msgArchiveError, err)
+		}
+		w, o.archiveFile = f, f
+	}
+	o.archive, o.archivePath = newSink(w), path
+	o.sinks = append(o.sinks, o.archive)
+}
+
+func (o *outputOptions) closeSinks() {
+	for _, s := range o.sinks {
+		if err := s.Close(); err != nil {
+			fail(msgArchiveError, err)
+		}
+	}
+	if o.archiveFile != nil {
+		if err := o.archiveFile.Close(); err != nil {
+			fail(msgArchiveError, err)
+		}
+	}
+	if o.archive != nil {
+		logger.Info(msgArchiveWritten.String(), "path", o.archivePath)
+	}
+}
+
+// put hands a file, path relative to the output root, to every sink.
+func (o *outputOptions) put(rel string, data []byte, meta FileMeta) error {
+	return o.putLinked(rel, "", data, meta)
+}
+
+// putLinked is put for a content already written at linkTo: sinks that support
+// it hardlink the file instead of writing it again.
+func (o *outputOptions) putLinked(rel, linkTo string, data []byte, meta FileMeta) error {
+	rel = filepath.ToSlash(rel)
+	if inGitDir("", rel) {
+		return errGitDir
+	}
+	meta = o.timestamps.apply(meta, data)
+	for _, s := range o.sinks {
+		if l, ok := s.(linker); ok && linkTo != "" && l.Link(filepath.ToSlash(linkTo), rel) == nil {
+			continue
+		}
+		if err := s.WriteFile(rel, data, meta); err != nil {
+			return err
+		}
+	}
+	if o.sums || o.signKey != "" {
+		sum := sha256.Sum256(data)
+		o.hashMu.Lock()
+		if o.hashes == nil {
+			o.hashes = make(map[string]string)
+		}
+		o.hashes[rel] = hex.EncodeToString(sum[:])
+		o.hashMu.Unlock()
+	}
+	return nil
+}
+
+// writeMeta writes a file of the run itself (manifest, report, checksums) at
+// the top of the output directory or archive.
+func (o *outputOptions) writeMeta(name string, data []byte) error {
+	return o.put(name, data, FileMeta{})
+}
+
+// reconstructs tells whether sources without sourcesContent are rebuilt.
+func (o *outputOptions) reconstructs() bool {
+	return o.reconstruct || o.prettyBuild
+}
+
+// render applies -beautify, -format-cmd and -eol to the source written at
+// path.
+func (o *outputOptions) render(path, content string) string {
+	if o.beautify && beautifiable(path) {
+		content = beautifyJS(content, beautifyWidth)
+	}
+	if !o.dryRun {
+		content = o.format.format(path, content)
+	}
+	return normalizeEOL(content, o.eol)
+}
+
+// writeFile writes data to the sinks, or only logs it in dry-run mode.
+func (o *outputOptions) writeFile(dst string, data []byte) error {
+	return o.writeFileMeta(dst, data, FileMeta{})
+}
+
+func (o *outputOptions) writeFileMeta(dst string, data []byte, meta FileMeta) error {
+	if o.dryRun {
+		if o.scripted() {
+			o.printPath(dst)
+		} else {
+			logger.Info(msgWouldWrite.String(), "path", dst, "bytes", len(data))
+		}
+		return nil
+	}
+	rel, err := filepath.Rel(o.root, dst)
+	if err != nil {
+		return err
+	}
+	if err := o.put(rel, data, meta); err != nil {
+		return err
+	}
+	o.printPath(dst)
+	return nil
+}
+
+// writeAtomic writes data to a temporary file next to dst and renames it, so an
+// interrupted run never leaves a truncated file. With sync, the file and its
+// directory are flushed to disk.
+func writeAtomic(dst string, data []byte, sync bool) error {
+	return writeAtomicFrom(dst, bytes.NewReader(data), sync)
+}
+
+// writeAtomicFrom is writeAtomic copying r.
+func writeAtomicFrom(dst string, r io.Reader, sync bool) error {
+	dst = longPath(dst)
+	dir := filepath.Dir(dst)
+	tmp, err := os.CreateTemp(dir, ".tsmap-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if sync {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	if sync {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+// syncDir flushes a directory entry; not supported on every platform, which is ignored.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) && runtime.GOOS != "windows" {
+		return err
+	}
+	return nil
+}
+
+// scripted reports whether stdout is reserved for the list of written paths.
+func (o *outputOptions) scripted() bool {
+	return o.pathsOnly || o.print0
+}
+
+// ownsStdout reports whether stdout carries output, so logs must go to stderr.
+func (o *outputOptions) ownsStdout() bool {
+	return o.scripted() || o.outZip == "-" || o.outTar == "-"
+}
+
+func (o *outputOptions) printPath(p string) {
+	if !o.scripted() {
+		return
+	}
+	sep := "\n"
+	if o.print0 {
+		sep = "\x00"
+	}
+	pathsMu.Lock()
+	defer pathsMu.Unlock()
+	fmt.Fprint(os.Stdout, p, sep)
+}
+
+// renamePath applies the -rename rules to a path already resolved under outDir,
+// then checks again that the result stays inside outDir. With -layout flat the
+// result is encoded as a single file name.
+func (o *outputOptions) renamePath(outDir, rel string) (string, string, error) {
+	if len(o.rename) > 0 {
+		p := filepath.ToSlash(rel)
+		for _, r := range o.rename {
+			p = r.apply(p)
+		}
+		p = path.Clean(strings.TrimLeft(p, "/"))
+		if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+			return "", "", errors.New("renamed path leaves the output directory")
+		}
+		rel = sanitizeSegments(p, o.scrubbed)
+	}
+	if o.flat {
+		rel = flatName(rel)
+	}
+	abs := filepath.Join(outDir, rel)
+	if err := mustBeUnder(outDir, abs); err != nil {
+		return "", "", err
+	}
+	return rel, abs, nil
+}
+
+// flatName encodes a relative path as one file name: "src/a%b.ts" gives
+// "src%2Fa%25b.ts", which url.PathUnescape turns back into the path.
+func flatName(rel string) string {
+	rel = strings.ReplaceAll(filepath.ToSlash(rel), "%", "%25")
+	return strings.ReplaceAll(rel, "/", "%2F")
+}
+
+// renameRule is one "s#regexp#replacement#[g]" rule; the first character after
+// "s" is the delimiter and \1 style back-references are accepted.
+type renameRule struct {
+	src    string
+	re     *regexp.Regexp
+	repl   string
+	global bool
+}
+
+var reSedRef = regexp.MustCompile(`\\(\d)`)
+
+func parseRenameRule(s string) (renameRule, error) {
+	if len(s) < 4 || s[0] != 's' {
+		return renameRule{}, fmt.Errorf("rename rule %q: expected s#regexp#replacement#", s)
+	}
+	delim := s[1]
+	var parts []string
+	var cur strings.Builder
+	for i := 2; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			cur.WriteByte(delim)
+			i++
+		case s[i] == delim:
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(s[i])
+		}
+	}
+	parts = append(parts, cur.String())
+	if len(parts) != 3 || (parts[2] != "" && parts[2] != "g") {
+		return renameRule{}, fmt.Errorf("rename rule %q: expected s#regexp#replacement#[g]", s)
+	}
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return renameRule{}, fmt.Errorf("rename rule %q: %w", s, err)
+	}
+	return renameRule{src: s, re: re, repl: reSedRef.ReplaceAllString(parts[1], "$${$1}"), global: parts[2] == "g"}, nil
+}
+
+func (r renameRule) apply(p string) string {
+	if r.global {
+		return r.re.ReplaceAllString(p, r.repl)
+	}
+	m := r.re.FindStringSubmatchIndex(p)
+	if m == nil {
+		return p
+	}
+	return p[:m[0]] + string(r.re.ExpandString(nil, r.repl, p, m)) + p[m[1]:]
+}
+
+// renameRules collects repeatable -rename flags, applied in order.
+type renameRules []renameRule
+
+func (r *renameRules) String() string {
+	if r == nil {
+		return ""
+	}
+	out := make([]string, len(*r))
+	for i, rule := range *r {
+		out[i] = rule.src
+	}
+	return strings.Join(out, " ")
+}
+
+func (r *renameRules) reset() { *r = nil }
+
+func (r *renameRules) Set(v string) error {
+	rule, err := parseRenameRule(v)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, rule)
+	return nil
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var reOutVar = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// variables of -out-template
+var outTemplateVars = map[string]bool{"host": true, "port": true, "path": true, "bundle": true, "date": true}
+
+// outTemplate is the -out-template value: the directory below -out of the
+// sources and saved files of a script, e.g. "{host}/{date}/{bundle}". It
+// replaces the one -layout merged or per-map would pick.
+type outTemplate string
+
+func (t *outTemplate) String() string { return string(*t) }
+
+func (t *outTemplate) Set(v string) error {
+	for _, m := range reOutVar.FindAllStringSubmatch(v, -1) {
+		if !outTemplateVars[m[1]] {
+			return fmt.Errorf("unknown -out-template variable %s ({host}, {port}, {path}, {bundle}, {date})", m[0])
+		}
+	}
+	if v != "" && strings.Trim(filepath.ToSlash(v), "/ ") == "" {
+		return fmt.Errorf("empty -out-template %q", v)
+	}
+	*t = outTemplate(v)
+	return nil
+}
+
+// dir returns the directory of scriptURL, crawled on date: {host} is its host
+// name, {port} its port or the default one of its scheme, {path} the directory
+// of its path, {bundle} its file name without .js (see bundleName) and {date}
+// the day of the crawl, 2006-01-02. Every segment is sanitized as source paths
+// are, and empty ones are dropped. When none is left ("{path}" for /app.js),
+// the host name is used, so that sources never land at the root of -out,
+// beside report.json, manifest.json or .git.
+func (t outTemplate) dir(scriptURL *url.URL, date time.Time) string {
+	port := scriptURL.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[scriptURL.Scheme]
+	}
+	dir := path.Dir(scriptURL.Path)
+	if dir == "." || dir == "/" {
+		dir = ""
+	}
+	vars := map[string]string{
+		"host":   scriptURL.Hostname(),
+		"port":   port,
+		"path":   dir,
+		"bundle": bundleName(scriptURL),
+		"date":   date.Format(time.DateOnly),
+	}
+	s := reOutVar.ReplaceAllStringFunc(string(t), func(m string) string { return vars[m[1:len(m)-1]] })
+	var segs []string
+	for _, seg := range strings.Split(filepath.ToSlash(s), "/") {
+		if seg = strings.TrimSpace(seg); seg != "" {
+			segs = append(segs, sanitizeSegments(seg, nil))
+		}
+	}
+	if len(segs) == 0 {
+		return sanitizeSegments(scriptURL.Hostname(), nil)
+	}
+	return filepath.Join(segs...)
+}
@@ -0,0 +1,14 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+
+//go:build !windows
+
+package tsmap
+
+import "runtime"
+
+// longPath is a no-op: only Windows limits paths to MAX_PATH.
+func longPath(p string) string { return p }
+
+// caseInsensitiveFS reports whether the usual filesystem of this OS folds case (macOS).
+func caseInsensitiveFS() bool { return runtime.GOOS == "darwin" || runtime.GOOS == "ios" }
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+
+//go:build windows
+
+package tsmap
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPath adds the \\?\ prefix to paths longer than MAX_PATH (260) so that deep
+// source trees can be written; such paths must be absolute and clean.
+func longPath(p string) string {
+	if len(p) < 248 || strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
+
+// caseInsensitiveFS reports whether the usual filesystem of this OS folds case.
+func caseInsensitiveFS() bool { return true }
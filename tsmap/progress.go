@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// activeProgress is the bar currently drawn on stderr, so log output can clear it first.
+var activeProgress atomic.Pointer[progressBar]
+
+// progressBar draws a one-line status on stderr. A nil *progressBar is valid and does nothing.
+type progressBar struct {
+	unit  string
+	start time.Time
+
+	total   atomic.Int64
+	done    atomic.Int64
+	bytes   atomic.Int64
+	written atomic.Int64
+
+	mu   sync.Mutex
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startProgress returns nil when disabled or when stderr is not a terminal.
+func startProgress(unit string, enabled bool) *progressBar {
+	if !enabled || !isTerminal(os.Stderr) || !enableVT(os.Stderr) {
+		return nil
+	}
+	p := &progressBar{unit: unit, start: time.Now(), stop: make(chan struct{})}
+	activeProgress.Store(p)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		t := time.NewTicker(200 * time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-t.C:
+				p.render()
+			}
+		}
+	}()
+	return p
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && (fi.Mode()&os.ModeCharDevice) != 0
+}
+
+func (p *progressBar) addTotal(n int) {
+	if p != nil {
+		p.total.Add(int64(n))
+	}
+}
+
+func (p *progressBar) incDone() {
+	if p != nil {
+		p.done.Add(1)
+	}
+}
+
+func (p *progressBar) addBytes(n int) {
+	if p != nil {
+		p.bytes.Add(int64(n))
+	}
+}
+
+func (p *progressBar) addWritten(n int) {
+	if p != nil {
+		p.written.Add(int64(n))
+	}
+}
+
+func (p *progressBar) render() {
+	done, total := p.done.Load(), p.total.Load()
+	line := fmt.Sprintf("%d/%d %s", done, total, p.unit)
+	if b := p.bytes.Load(); b > 0 {
+		line += "  " + humanBytes(b)
+	}
+	line += "  " + msgProgressSources.format(p.written.Load())
+	if done > 0 && total > done {
+		elapsed := time.Since(p.start)
+		eta := time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+		line += "  " + msgProgressETA.format(eta.Round(time.Second))
+	}
+	p.mu.Lock()
+	fmt.Fprintf(os.Stderr, "\r\033[K%s", line)
+	p.mu.Unlock()
+}
+
+// clearLine erases the bar so a log line can be printed; the next tick redraws it.
+func (p *progressBar) clearLine() {
+	p.mu.Lock()
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	p.mu.Unlock()
+}
+
+func (p *progressBar) finish() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+	activeProgress.CompareAndSwap(p, nil)
+	p.clearLine()
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// rawMapsDir is where -save-map-raw writes map bodies, below the output root.
+const rawMapsDir = "raw"
+
+// rawMap is one entry of raw/index.json.
+type rawMap struct {
+	URL    string `json:"url"` // "inline:<script>" for data URLs
+	Script string `json:"script"`
+	File   string `json:"file"` // relative to the output root, slash separated
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+	Error  string `json:"error,omitempty"` // why extraction failed
+}
+
+// rawMapStore keeps every map body fetched during a crawl as it came
+// (-save-map-raw), those that fail to parse or extract included, so that
+// nothing gathered is lost and failures can be looked at later. A nil store
+// does nothing.
+type rawMapStore struct {
+	mu   sync.Mutex
+	maps map[string]rawMap // by file
+}
+
+// add writes the body of the map key, found for scriptURL, below raw/<host>/
+// with the .meta.json of res, its response, and records it with the error of
+// its extraction, nil when it worked. Files are named after the map with a
+// prefix hashed from key, so maps of the same name in different directories
+// are kept apart and a rerun replaces its own.
+func (r *rawMapStore) add(data []byte, key string, scriptURL *url.URL, res *fetchResult, extractErr error, out *outputOptions, outBase string) {
+	if r == nil {
+		return
+	}
+	name := path.Base(scriptURL.Path) + ".map"
+	host := scriptURL.Hostname()
+	if u, err := url.Parse(key); err == nil && u.Host != "" {
+		name, host = path.Base(u.Path), u.Hostname()
+	}
+	keySum := sha256.Sum256([]byte(key))
+	file := path.Join(rawMapsDir, sanitizeSegments(host, nil), hex.EncodeToString(keySum[:6])+"-"+sanitizeSegments(name, nil))
+	sum := sha256.Sum256(data)
+	m := rawMap{URL: key, Script: scriptURL.String(), File: file, Size: len(data), SHA256: hex.EncodeToString(sum[:])}
+	if extractErr != nil {
+		m.Error = extractErr.Error()
+	}
+	dst := filepath.Join(outBase, filepath.FromSlash(file))
+	if err := out.writeFile(dst, data); err != nil {
+		logger.Warn(msgRawMapError.String(), "map", key, "err", err)
+		return
+	}
+	out.writeAssetMeta(dst, data, res)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maps == nil {
+		r.maps = make(map[string]rawMap)
+	}
+	r.maps[file] = m
+}
+
+// save writes raw/index.json, listing the saved maps by URL.
+func (r *rawMapStore) save(out *outputOptions) {
+	if r == nil || out.dryRun {
+		return
+	}
+	r.mu.Lock()
+	list := make([]rawMap, 0, len(r.maps))
+	failed := 0
+	for _, m := range r.maps {
+		list = append(list, m)
+		if m.Error != "" {
+			failed++
+		}
+	}
+	r.mu.Unlock()
+	if len(list) == 0 {
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].URL < list[j].URL })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err == nil {
+		err = out.writeMeta(path.Join(rawMapsDir, "index.json"), data)
+	}
+	if err != nil {
+		logger.Warn(msgRawMapError.String(), "map", "index.json", "err", err)
+		return
+	}
+	logger.Info(msgRawMapsSaved.String(), "maps", len(list), "failed", failed, "dir", rawMapsDir)
+}
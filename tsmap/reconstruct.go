@@ -0,0 +1,270 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// reconstructedNote ends every file rebuilt from mappings, so nobody mistakes it
+// for the original; at the end it keeps the original line numbers intact.
+const reconstructedNote = "\n// Approximate reconstruction by tsmap-extract from the map's mappings and the\n// generated bundle (no sourcesContent): lines match the original, code is the minified output.\n"
+
+// reconstructedPrettyNote ends the files rebuilt with -reconstruct-pretty.
+const reconstructedPrettyNote = "\n// Approximate reconstruction by tsmap-extract from the map's mappings and the\n// generated bundle (no sourcesContent): minified code in bundle order, renamed from names and reformatted.\n"
+
+// mangledMax is the length up to which an identifier the mappings leave
+// unnamed takes the name of a named occurrence in scope, minifiers giving
+// local variables one to three characters.
+const mangledMax = 3
+
+var reIdentifier = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+
+// words never renamed, nor used as names
+var jsReserved = map[string]bool{
+	"break": true, "case": true, "catch": true, "class": true, "const": true, "continue": true, "debugger": true,
+	"default": true, "delete": true, "do": true, "else": true, "export": true, "extends": true, "false": true,
+	"finally": true, "for": true, "function": true, "if": true, "import": true, "in": true, "instanceof": true,
+	"let": true, "new": true, "null": true, "return": true, "super": true, "switch": true, "this": true,
+	"throw": true, "true": true, "try": true, "typeof": true, "var": true, "void": true, "while": true,
+	"with": true, "yield": true, "await": true, "async": true, "of": true, "static": true, "get": true, "set": true,
+	"undefined": true, "arguments": true,
+}
+
+// reconstructMissing fills the empty sourcesContent entries of sm with a
+// best-effort rebuild from the mappings and the generated code. Identifiers
+// are first renamed back from names (see originalNames). Every mapped snippet
+// of the bundle is then placed at its original line and column or, with
+// pretty, written in bundle order and beautified. It returns the number of
+// sources filled.
+func reconstructMissing(sm *SourceMap, generated string, pretty bool) (int, error) {
+	if generated == "" || sm.Mappings == "" {
+		return 0, nil
+	}
+	segs, err := DecodeMappings(sm.Mappings)
+	if err != nil {
+		return 0, err
+	}
+	missing := make(map[int]bool)
+	for i := range sm.Sources {
+		if !sm.HasContent(i) {
+			missing[i] = true
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	type snippet struct {
+		line, col int    // original position
+		text      string // generated code, renamed
+		genLine   int
+		genStart  int // columns in the renamed line
+		genEnd    int
+	}
+	bySource := make(map[int][]snippet)
+	lines := strings.Split(generated, "\n")
+	columns := make(map[int]func(int) int) // renamed lines
+	for l, rs := range originalNames(generated, segs, sm.Names) {
+		lines[l], columns[l] = renameLine(lines[l], rs)
+	}
+	column := func(line, col int) int {
+		if f := columns[line]; f != nil {
+			return f(col)
+		}
+		return col
+	}
+	for k, s := range segs {
+		if !s.HasSource() || !missing[s.Source] || s.GeneratedLine >= len(lines) {
+			continue
+		}
+		line := strings.TrimRight(lines[s.GeneratedLine], "\r")
+		start := column(s.GeneratedLine, s.GeneratedColumn)
+		if start >= len(line) {
+			continue
+		}
+		end := len(line)
+		for _, next := range segs[k+1:] {
+			if next.GeneratedLine != s.GeneratedLine {
+				break
+			}
+			if next.GeneratedColumn > s.GeneratedColumn {
+				end = min(column(s.GeneratedLine, next.GeneratedColumn), end)
+				break
+			}
+		}
+		if strings.TrimSpace(line[start:end]) == "" {
+			continue
+		}
+		bySource[s.Source] = append(bySource[s.Source], snippet{s.OriginalLine, s.OriginalColumn, line[start:end], s.GeneratedLine, start, end})
+	}
+
+	sm.padContent(len(sm.Sources))
+	filled := 0
+	for i, snips := range bySource {
+		var b strings.Builder
+		if pretty {
+			// snippets are in bundle order; adjacent ones are joined as in the bundle
+			for k, sn := range snips {
+				if k > 0 {
+					prev := snips[k-1]
+					if sn.genLine == prev.genLine && sn.genStart < prev.genEnd {
+						continue
+					}
+					if sn.genLine != prev.genLine || sn.genStart != prev.genEnd {
+						b.WriteByte('\n')
+					}
+				}
+				b.WriteString(sn.text)
+			}
+			sm.setContent(i, beautifyJS(strings.TrimSpace(b.String()), 0)+reconstructedPrettyNote)
+			filled++
+			continue
+		}
+		sort.SliceStable(snips, func(a, b int) bool {
+			if snips[a].line != snips[b].line {
+				return snips[a].line < snips[b].line
+			}
+			return snips[a].col < snips[b].col
+		})
+		line, cur := 0, 0 // current original line and column
+		for _, sn := range snips {
+			if sn.line < 0 {
+				continue
+			}
+			text := strings.TrimSpace(sn.text)
+			for line < sn.line {
+				b.WriteByte('\n')
+				line++
+				cur = 0
+			}
+			if cur < sn.col {
+				b.WriteString(strings.Repeat(" ", sn.col-cur))
+				cur = sn.col
+			} else if cur > 0 {
+				b.WriteByte(' ')
+				cur++
+			}
+			b.WriteString(text)
+			cur += len(text)
+		}
+		b.WriteString(reconstructedNote)
+		sm.setContent(i, b.String())
+		filled++
+	}
+	return filled, nil
+}
+
+// identRename replaces the identifier at [col, end) of a generated line.
+type identRename struct {
+	col, end int
+	name     string
+}
+
+// originalNames returns, by generated line, the identifiers of generated to
+// rename from names: those a segment names, and the short ones left unnamed
+// after a named occurrence in the same or an enclosing block, since minifiers
+// name the declaration and not every use of a local variable. The nearest such
+// occurrence wins, so that sibling functions reusing a mangled name keep their
+// own. Property names after a dot and object keys are left alone.
+func originalNames(generated string, segs []Segment, names []string) map[int][]identRename {
+	named := make(map[[2]int]string) // generated line and column -> original name
+	for _, s := range segs {
+		if s.Name >= 0 && s.Name < len(names) {
+			named[[2]int{s.GeneratedLine, s.GeneratedColumn}] = names[s.Name]
+		}
+	}
+	type binding struct {
+		block int
+		name  string
+	}
+	var (
+		out       = make(map[int][]identRename)
+		bindings  = make(map[string][]binding) // by generated identifier, in bundle order
+		parents   = []int{-1}                  // enclosing block of each block, 0 being the top level
+		open      = []int{0}
+		line, col int
+		prev      jsToken
+		regexpOK  = true
+	)
+	encloses := func(b, in int) bool {
+		for ; in >= 0; in = parents[in] {
+			if in == b {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 0; i < len(generated); {
+		kind, end := nextJSToken(generated, i, regexpOK)
+		t := jsToken{kind, generated[i:end]}
+		switch {
+		case kind == tokPunct && t.text == "{":
+			parents = append(parents, open[len(open)-1])
+			open = append(open, len(parents)-1)
+		case kind == tokPunct && t.text == "}" && len(open) > 1:
+			open = open[:len(open)-1]
+		case kind == tokWord && reIdentifier.MatchString(t.text) && !jsReserved[t.text] && prev.text != ".":
+			block := open[len(open)-1]
+			name, ok := named[[2]int{line, col}]
+			if ok {
+				bindings[t.text] = append(bindings[t.text], binding{block, name})
+			} else if len(t.text) <= mangledMax && !isObjectKey(generated[end:], prev) {
+				bs := bindings[t.text]
+				for k := len(bs) - 1; k >= 0; k-- {
+					if encloses(bs[k].block, block) {
+						name = bs[k].name
+						break
+					}
+				}
+			}
+			if name != t.text && reIdentifier.MatchString(name) && !jsReserved[name] {
+				out[line] = append(out[line], identRename{col, col + len(t.text), name})
+			}
+		}
+		if kind != tokSpace && kind != tokComment {
+			prev = t
+		}
+		regexpOK = regexpAfter(kind, t.text, regexpOK)
+		if n := strings.LastIndexByte(t.text, '\n'); n >= 0 {
+			line += strings.Count(t.text, "\n")
+			col = len(t.text) - n - 1
+		} else {
+			col += len(t.text)
+		}
+		i = end
+	}
+	return out
+}
+
+// isObjectKey tells whether an identifier followed by rest and preceded by
+// prev is the key of an object literal member.
+func isObjectKey(rest string, prev jsToken) bool {
+	rest = strings.TrimLeft(rest, " \t\r\n")
+	return strings.HasPrefix(rest, ":") && prev.kind == tokPunct && (prev.text == "{" || prev.text == ",")
+}
+
+// renameLine applies renames, in column order, to a generated line. The
+// function returned maps a column of line to the same position in the result.
+func renameLine(line string, renames []identRename) (string, func(int) int) {
+	var b strings.Builder
+	shift := make([]int, len(renames)) // length change once renames[k] is applied
+	last, delta := 0, 0
+	for k, r := range renames {
+		b.WriteString(line[last:r.col])
+		b.WriteString(r.name)
+		last = r.end
+		delta += len(r.name) - (r.end - r.col)
+		shift[k] = delta
+	}
+	b.WriteString(line[last:])
+	return b.String(), func(col int) int {
+		k := sort.Search(len(renames), func(k int) bool { return renames[k].end > col })
+		if k == 0 {
+			return col
+		}
+		return col + shift[k-1]
+	}
+}
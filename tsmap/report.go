@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// crawlReport is written as report.json at the end of a crawl.
+type crawlReport struct {
+	RootURL        string           `json:"root_url"`
+	StartedAt      time.Time        `json:"started_at"`
+	DurationMS     int64            `json:"duration_ms"`
+	ScriptsTotal   int              `json:"scripts_total"`
+	SourcesWritten int              `json:"sources_written"`
+	Scripts        []*scriptReport  `json:"scripts"`
+	ScriptHosts    []string         `json:"script_hosts,omitempty"` // other hosts the page's CSP allows scripts from
+	SRIMismatches  int              `json:"integrity_mismatches,omitempty"`
+	AuthDiff       *authDiffReport  `json:"auth_diff,omitempty"`
+	PeakRSS        int64            `json:"peak_rss,omitempty"` // only measured with -max-rss
+	SourceCounts   *sourceCounts    `json:"source_counts,omitempty"`
+	Dedup          *dedupCounts     `json:"dedup,omitempty"`
+	Conflicts      []conflictRecord `json:"conflicts,omitempty"`
+	Scrubbed       []scrubbedName   `json:"scrubbed_names,omitempty"`
+	Secrets        []secretFinding  `json:"secrets,omitempty"`
+	Fingerprint    *fingerprint     `json:"fingerprint,omitempty"`
+	Languages      []langStat       `json:"languages,omitempty"`
+	Hosts          []hostTraffic    `json:"hosts,omitempty"`
+	Severity       riskLevel        `json:"severity,omitempty"` // none, low, medium, high or critical
+}
+
+// scriptReport records everything that happened to one script URL.
+type scriptReport struct {
+	URL        string         `json:"url"`
+	Parent     string         `json:"parent,omitempty"` // script that referenced this chunk
+	Status     int            `json:"status,omitempty"`
+	Bytes      int64          `json:"bytes"`
+	DurationMS int64          `json:"duration_ms"`
+	MapURL     string         `json:"map_url,omitempty"`        // "inline" for data: URLs, empty when none
+	NotJS      string         `json:"not_javascript,omitempty"` // sniffed type when the body was skipped
+	MapBytes   int64          `json:"map_bytes,omitempty"`      // size of the decoded map
+	Sources    int            `json:"sources_written"`
+	Severity   riskLevel      `json:"severity,omitempty"`
+	Attempts   []fetchAttempt `json:"map_attempts,omitempty"`
+	Integrity  *sriCheck      `json:"integrity,omitempty"`  // when the script tag has an integrity attribute
+	ExtraMaps  []string       `json:"extra_maps,omitempty"` // maps of the earlier sourceMappingURL comments, with -all-maps
+	Errors     []string       `json:"errors,omitempty"`
+
+	interrupted bool // finished after the crawl was canceled
+}
+
+// fetchAttempt is one HTTP request made while looking for a map.
+type fetchAttempt struct {
+	URL        string `json:"url"`
+	Status     int    `json:"status,omitempty"`
+	Bytes      int64  `json:"bytes"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+type authDiffReport struct {
+	Public   []string `json:"public"`
+	AuthOnly []string `json:"auth_only"`
+	AnonOnly []string `json:"anonymous_only"`
+}
+
+func newAttempt(u string, res fetchResult, err error) fetchAttempt {
+	a := fetchAttempt{
+		URL:        u,
+		Status:     res.Status,
+		Bytes:      int64(len(res.Body)),
+		DurationMS: res.Duration.Milliseconds(),
+	}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	return a
+}
+
+func writeReport(out *outputOptions, rep *crawlReport) error {
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	return out.writeMeta("report.json", data)
+}
@@ -0,0 +1,301 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// reportDocMax caps the rows of the long tables of report.html and report.md;
+// report.json and the other run files hold everything.
+const reportDocMax = 200
+
+// hostStat is one row of the per-host breakdown.
+type hostStat struct {
+	Host    string
+	Scripts int
+	Maps    int
+	Sources int
+	Bytes   int64
+}
+
+// reportDoc is what report.html and report.md show, built from report.json
+// and the findings of the run.
+type reportDoc struct {
+	Target     string
+	Date       string
+	Duration   string
+	Severity   riskLevel
+	Scripts    int
+	Maps       []*scriptReport // scripts whose map was found
+	Sources    int
+	Failed     int             // scripts with errors
+	Mismatches []*scriptReport // scripts not matching their integrity attribute
+	Hosts      []hostStat
+	Stack      string
+	Languages  []langStat
+	Secrets    []secretFinding
+	Endpoints  []*endpoint
+	AuthOnly   []string
+	Truncated  map[string]int // table -> rows left out
+	SecretOn   bool           // "no secrets found" is only claimed after a scan
+	EndpointOn bool
+}
+
+func newReportDoc(rep *crawlReport, o *outputOptions) *reportDoc {
+	d := &reportDoc{
+		Target:     rep.RootURL,
+		Date:       rep.StartedAt.Format(time.RFC1123),
+		Duration:   (time.Duration(rep.DurationMS) * time.Millisecond).Round(time.Second).String(),
+		Severity:   rep.Severity,
+		Scripts:    rep.ScriptsTotal,
+		Sources:    rep.SourcesWritten,
+		Languages:  rep.Languages,
+		Truncated:  make(map[string]int),
+		EndpointOn: o.endpoints != nil && o.endpoints.enabled,
+		SecretOn:   o.secrets != nil && o.secrets.enabled,
+	}
+	hosts := make(map[string]*hostStat)
+	for _, sr := range rep.Scripts {
+		host := sr.URL
+		if u, err := url.Parse(sr.URL); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		h := hosts[host]
+		if h == nil {
+			h = &hostStat{Host: host}
+			hosts[host] = h
+		}
+		h.Scripts++
+		h.Sources += sr.Sources
+		h.Bytes += sr.Bytes
+		if sr.MapURL != "" {
+			h.Maps++
+			d.Maps = append(d.Maps, sr)
+		}
+		if len(sr.Errors) > 0 {
+			d.Failed++
+		}
+		if sr.Integrity != nil && !sr.Integrity.Match {
+			d.Mismatches = append(d.Mismatches, sr)
+		}
+	}
+	for _, k := range sortedKeys(hosts) {
+		d.Hosts = append(d.Hosts, *hosts[k])
+	}
+	if fp := rep.Fingerprint; fp != nil {
+		var names []string
+		for _, h := range append(append([]techHit(nil), fp.Frameworks...), fp.Bundlers...) {
+			names = append(names, h.Name)
+		}
+		if fp.TypeScript {
+			names = append(names, "TypeScript")
+		}
+		d.Stack = strings.Join(names, ", ")
+	}
+	for _, s := range rep.Secrets {
+		s.Match = redactSecret(s.Match)
+		d.Secrets = append(d.Secrets, s)
+	}
+	d.Endpoints = o.endpoints.list()
+	if rep.AuthDiff != nil {
+		d.AuthOnly = rep.AuthDiff.AuthOnly
+	}
+	d.Maps = capRows(d, "maps", d.Maps)
+	d.Secrets = capRows(d, "secrets", d.Secrets)
+	d.Endpoints = capRows(d, "endpoints", d.Endpoints)
+	return d
+}
+
+func capRows[T any](d *reportDoc, table string, rows []T) []T {
+	if len(rows) > reportDocMax {
+		d.Truncated[table] = len(rows) - reportDocMax
+		return rows[:reportDocMax]
+	}
+	return rows
+}
+
+// markdown renders report.md.
+func (d *reportDoc) markdown() string {
+	cell := func(s string) string {
+		return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+	}
+	var b strings.Builder
+	p := func(format string, a ...any) { fmt.Fprintf(&b, format, a...) }
+	p("# Source map exposure report: %s\n\n", d.Target)
+	p("| | |\n|---|---|\n")
+	p("| Target | %s |\n| Date | %s |\n| Duration | %s |\n", cell(d.Target), d.Date, d.Duration)
+	p("| Severity | **%s** |\n", d.Severity)
+	p("| Scripts analyzed | %d |\n| Exposed source maps | %d |\n| Sources recovered | %d |\n", d.Scripts, len(d.Maps)+d.Truncated["maps"], d.Sources)
+	if d.Failed > 0 {
+		p("| Scripts with errors | %d |\n", d.Failed)
+	}
+	if len(d.Mismatches) > 0 {
+		p("| Integrity mismatches | %d |\n", len(d.Mismatches))
+	}
+	if d.Stack != "" {
+		p("| Stack | %s |\n", cell(d.Stack))
+	}
+	if d.SecretOn {
+		p("| Secrets | %d |\n", len(d.Secrets)+d.Truncated["secrets"])
+	}
+	if d.EndpointOn {
+		p("| Endpoints | %d |\n", len(d.Endpoints)+d.Truncated["endpoints"])
+	}
+
+	p("\n## Hosts\n\n| Host | Scripts | Maps | Sources | Bytes |\n|---|---:|---:|---:|---:|\n")
+	for _, h := range d.Hosts {
+		p("| %s | %d | %d | %d | %s |\n", cell(h.Host), h.Scripts, h.Maps, h.Sources, humanBytes(h.Bytes))
+	}
+	if len(d.Maps) > 0 {
+		p("\n## Exposed source maps\n\n| Script | Map | Sources | Severity |\n|---|---|---:|---|\n")
+		for _, m := range d.Maps {
+			p("| %s | %s | %d | %s |\n", cell(m.URL), cell(m.MapURL), m.Sources, m.Severity)
+		}
+		d.mdTruncated(&b, "maps", "report.json")
+	}
+	if len(d.Mismatches) > 0 {
+		p("\n## Integrity mismatches\n\nThese scripts differ from what the integrity attribute of their tag declares.\n\n")
+		p("| Script | Declared | Served |\n|---|---|---|\n")
+		for _, sr := range d.Mismatches {
+			p("| %s | `%s` | `%s` |\n", cell(sr.URL), cell(sr.Integrity.Declared), sr.Integrity.Actual)
+		}
+	}
+	if len(d.AuthOnly) > 0 {
+		p("\n## Maps only served to authenticated users\n\n")
+		for _, u := range d.AuthOnly {
+			p("- %s\n", u)
+		}
+	}
+	if len(d.Languages) > 0 {
+		p("\n## Recovered files\n\n| Language | Files | Lines | Bytes |\n|---|---:|---:|---:|\n")
+		for _, l := range d.Languages {
+			p("| %s | %d | %d | %s |\n", l.Language, l.Files, l.Lines, humanBytes(l.Bytes))
+		}
+	}
+	if d.SecretOn {
+		p("\n## Secrets\n\n")
+		if len(d.Secrets) == 0 {
+			p("No secrets found.\n")
+		} else {
+			p("| Rule | File | Match |\n|---|---|---|\n")
+			for _, s := range d.Secrets {
+				p("| %s | %s:%d | `%s` |\n", s.Rule, cell(s.Path), s.Line, cell(s.Match))
+			}
+			d.mdTruncated(&b, "secrets", secretsFile)
+		}
+	}
+	if d.EndpointOn {
+		p("\n## Endpoints\n\n")
+		if len(d.Endpoints) == 0 {
+			p("No endpoints found.\n")
+		} else {
+			p("| Endpoint | Methods | Kinds |\n|---|---|---|\n")
+			for _, e := range d.Endpoints {
+				p("| `%s` | %s | %s |\n", cell(e.Value), strings.Join(e.Methods, ", "), strings.Join(e.Kinds, ", "))
+			}
+			d.mdTruncated(&b, "endpoints", "endpoints.json")
+		}
+	}
+	return b.String()
+}
+
+func (d *reportDoc) mdTruncated(b *strings.Builder, table, file string) {
+	if n := d.Truncated[table]; n > 0 {
+		fmt.Fprintf(b, "\n%d more in %s.\n", n, file)
+	}
+}
+
+var reportHTML = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes":  humanBytes,
+	"join":   func(s []string) string { return strings.Join(s, ", ") },
+	"total":  func(rows, more int) int { return rows + more },
+	"severe": func(r riskLevel) bool { return r >= riskHigh },
+}).Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Source map exposure report: {{.Target}}</title>
+<style>
+body { font: 14px system-ui, sans-serif; max-width: 1100px; margin: 2em auto; padding: 0 1em; color: #222; }
+h1 { font-size: 1.6em; } h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ddd; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #eee; vertical-align: top; word-break: break-all; }
+th { background: #f6f8fa; } td.n { text-align: right; white-space: nowrap; }
+code { font: 12px ui-monospace, monospace; }
+.kpi { display: flex; gap: 1em; flex-wrap: wrap; }
+.kpi div { border: 1px solid #ddd; border-radius: 6px; padding: 8px 16px; }
+.kpi b { display: block; font-size: 1.5em; }
+.bad b { color: #c0392b; }
+</style>
+</head>
+<body>
+<h1>Source map exposure report</h1>
+<p><b>{{.Target}}</b><br>{{.Date}}, {{.Duration}}{{if .Stack}}<br>Stack: {{.Stack}}{{end}}</p>
+<div class="kpi">
+<div class="{{if severe .Severity}}bad{{end}}"><b>{{.Severity}}</b>severity</div>
+<div><b>{{.Scripts}}</b>scripts analyzed</div>
+<div class="{{if .Maps}}bad{{end}}"><b>{{total (len .Maps) (index .Truncated "maps")}}</b>exposed source maps</div>
+<div><b>{{.Sources}}</b>sources recovered</div>
+{{if .Failed}}<div><b>{{.Failed}}</b>scripts with errors</div>{{end}}
+{{if .Mismatches}}<div class="bad"><b>{{len .Mismatches}}</b>integrity mismatches</div>{{end}}
+{{if .SecretOn}}<div class="{{if .Secrets}}bad{{end}}"><b>{{total (len .Secrets) (index .Truncated "secrets")}}</b>secrets</div>{{end}}
+{{if .EndpointOn}}<div><b>{{total (len .Endpoints) (index .Truncated "endpoints")}}</b>endpoints</div>{{end}}
+</div>
+
+<h2>Hosts</h2>
+<table><tr><th>Host</th><th>Scripts</th><th>Maps</th><th>Sources</th><th>Bytes</th></tr>
+{{range .Hosts}}<tr><td>{{.Host}}</td><td class="n">{{.Scripts}}</td><td class="n">{{.Maps}}</td><td class="n">{{.Sources}}</td><td class="n">{{bytes .Bytes}}</td></tr>
+{{end}}</table>
+
+{{if .Maps}}<h2>Exposed source maps</h2>
+<table><tr><th>Script</th><th>Map</th><th>Sources</th><th>Severity</th></tr>
+{{range .Maps}}<tr><td><code>{{.URL}}</code></td><td><code>{{.MapURL}}</code></td><td class="n">{{.Sources}}</td><td>{{.Severity}}</td></tr>
+{{end}}</table>
+{{with index .Truncated "maps"}}<p>{{.}} more in report.json.</p>{{end}}{{end}}
+
+{{if .Mismatches}}<h2>Integrity mismatches</h2>
+<p>These scripts differ from what the integrity attribute of their tag declares.</p>
+<table><tr><th>Script</th><th>Declared</th><th>Served</th></tr>
+{{range .Mismatches}}<tr><td><code>{{.URL}}</code></td><td><code>{{.Integrity.Declared}}</code></td><td><code>{{.Integrity.Actual}}</code></td></tr>
+{{end}}</table>{{end}}
+
+{{if .AuthOnly}}<h2>Maps only served to authenticated users</h2>
+<ul>{{range .AuthOnly}}<li><code>{{.}}</code></li>{{end}}</ul>{{end}}
+
+{{if .Languages}}<h2>Recovered files</h2>
+<table><tr><th>Language</th><th>Files</th><th>Lines</th><th>Bytes</th></tr>
+{{range .Languages}}<tr><td>{{.Language}}</td><td class="n">{{.Files}}</td><td class="n">{{.Lines}}</td><td class="n">{{bytes .Bytes}}</td></tr>
+{{end}}</table>{{end}}
+
+{{if .SecretOn}}<h2>Secrets</h2>
+{{if .Secrets}}<table><tr><th>Rule</th><th>File</th><th>Match</th></tr>
+{{range .Secrets}}<tr><td>{{.Rule}}</td><td><code>{{.Path}}:{{.Line}}</code></td><td><code>{{.Match}}</code></td></tr>
+{{end}}</table>
+{{with index .Truncated "secrets"}}<p>{{.}} more in secrets.json.</p>{{end}}{{else}}<p>No secrets found.</p>{{end}}{{end}}
+
+{{if .EndpointOn}}<h2>Endpoints</h2>
+{{if .Endpoints}}<table><tr><th>Endpoint</th><th>Methods</th><th>Kinds</th></tr>
+{{range .Endpoints}}<tr><td><code>{{.Value}}</code></td><td>{{join .Methods}}</td><td>{{join .Kinds}}</td></tr>
+{{end}}</table>
+{{with index .Truncated "endpoints"}}<p>{{.}} more in endpoints.json.</p>{{end}}{{else}}<p>No endpoints found.</p>{{end}}{{end}}
+</body>
+</html>
+`))
+
+// writeReportDoc writes report.html or report.md, as chosen by -report.
+func writeReportDoc(o *outputOptions, rep *crawlReport, format string) error {
+	d := newReportDoc(rep, o)
+	if format == "md" {
+		return o.writeMeta("report.md", []byte(d.markdown()))
+	}
+	var b strings.Builder
+	if err := reportHTML.Execute(&b, d); err != nil {
+		return err
+	}
+	return o.writeMeta("report.html", []byte(b.String()))
+}
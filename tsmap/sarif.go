@@ -0,0 +1,227 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+)
+
+const sarifFile = "findings.sarif"
+
+const (
+	sarifRuleMap    = "source-map-exposed"
+	sarifRuleSecret = "secret-in-source"
+)
+
+// SARIF 2.1.0 subset written by -sarif; field names follow the specification.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string         `json:"id"`
+	Name                 string         `json:"name"`
+	ShortDescription     sarifText      `json:"shortDescription"`
+	FullDescription      sarifText      `json:"fullDescription"`
+	Help                 sarifText      `json:"help"`
+	DefaultConfiguration sarifRuleLevel `json:"defaultConfiguration"`
+	Properties           map[string]any `json:"properties"`
+}
+
+type sarifRuleLevel struct {
+	Level string `json:"level"`
+}
+
+type sarifText struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Level               string            `json:"level"`
+	Message             sarifText         `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+	Properties          map[string]any    `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysical `json:"physicalLocation"`
+}
+
+type sarifPhysical struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           *sarifRegion  `json:"region,omitempty"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+var sarifRules = []sarifRule{
+	{
+		ID:                   sarifRuleMap,
+		Name:                 "SourceMapExposed",
+		ShortDescription:     sarifText{"Source map publicly served"},
+		FullDescription:      sarifText{"A production script references a source map that anyone can download, exposing the original source code of the application."},
+		Help:                 sarifText{"Do not deploy .map files with the bundle, or serve them only to authenticated users; strip the sourceMappingURL comment from production builds."},
+		DefaultConfiguration: sarifRuleLevel{"warning"},
+		Properties:           map[string]any{"tags": []string{"security", "information-disclosure"}, "security-severity": "5.3"},
+	},
+	{
+		ID:                   sarifRuleSecret,
+		Name:                 "SecretInSource",
+		ShortDescription:     sarifText{"Credential in recovered source"},
+		FullDescription:      sarifText{"A source recovered from a public source map contains a value matching a credential pattern."},
+		Help:                 sarifText{"Revoke and rotate the credential, then move it out of client-side code."},
+		DefaultConfiguration: sarifRuleLevel{"error"},
+		Properties:           map[string]any{"tags": []string{"security", "secret"}, "security-severity": "8.0"},
+	},
+}
+
+// sarifExposure is one map served over HTTP; script is empty when unknown.
+type sarifExposure struct {
+	script  string
+	sources int
+}
+
+// sarifReport collects the exposed maps of a run and writes them, with the
+// -scan-secrets findings, as findings.sarif. A nil report does nothing.
+type sarifReport struct {
+	enabled bool
+	maps    map[string]*sarifExposure // map URL, or script URL for inline maps
+}
+
+func addSarifFlags(fs *flag.FlagSet) *sarifReport {
+	s := &sarifReport{maps: make(map[string]*sarifExposure)}
+	fs.BoolVar(&s.enabled, "sarif", false, "Write exposed maps and secrets as SARIF 2.1.0 in findings.sarif (implies -scan-secrets)")
+	return s
+}
+
+// addMap records that the map at mapURL, generated for script, was served; an
+// inline map is located at its script.
+func (s *sarifReport) addMap(mapURL, script string, sources int) {
+	if s == nil || !s.enabled {
+		return
+	}
+	if mapURL == "inline" {
+		mapURL = script
+	}
+	e := s.maps[mapURL]
+	if e == nil {
+		e = &sarifExposure{script: script}
+		s.maps[mapURL] = e
+	}
+	e.sources += sources
+}
+
+// sarifFingerprint hashes the parts identifying a result across runs.
+func sarifFingerprint(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// results returns the SARIF results of the recorded maps and of secrets.
+func (s *sarifReport) results(secrets []secretFinding) []sarifResult {
+	out := []sarifResult{}
+	for _, u := range sortedKeys(s.maps) {
+		e := s.maps[u]
+		text := fmt.Sprintf("Source map served at %s", u)
+		if e.script == u {
+			text = fmt.Sprintf("Inline source map embedded in %s", u)
+		} else if e.script != "" {
+			text += fmt.Sprintf(" for %s", e.script)
+		}
+		text += fmt.Sprintf("; %d source(s) recovered.", e.sources)
+		out = append(out, sarifResult{
+			RuleID:              sarifRuleMap,
+			RuleIndex:           0,
+			Level:               "warning",
+			Message:             sarifText{text},
+			Locations:           []sarifLocation{{sarifPhysical{ArtifactLocation: sarifArtifact{u}}}},
+			PartialFingerprints: map[string]string{"mapUrl/v1": sarifFingerprint(u)},
+			Properties:          map[string]any{"script": e.script, "sources": e.sources},
+		})
+	}
+	for _, f := range secrets {
+		props := map[string]any{"rule": f.Rule}
+		if f.Source != "" {
+			props["source"] = f.Source
+		}
+		if f.Map != "" {
+			props["map"] = f.Map
+		}
+		out = append(out, sarifResult{
+			RuleID:    sarifRuleSecret,
+			RuleIndex: 1,
+			Level:     "error",
+			Message:   sarifText{fmt.Sprintf("Possible %s: %s", f.Rule, redactSecret(f.Match))},
+			Locations: []sarifLocation{{sarifPhysical{
+				ArtifactLocation: sarifArtifact{f.Path},
+				Region:           &sarifRegion{StartLine: f.Line},
+			}}},
+			// the path may change between runs, the secret does not
+			PartialFingerprints: map[string]string{"secretHash/v1": sarifFingerprint(f.Rule, f.Match)},
+			Properties:          props,
+		})
+	}
+	return out
+}
+
+// save writes findings.sarif and logs the number of results per rule.
+func (s *sarifReport) save(o *outputOptions) {
+	if s == nil || !s.enabled {
+		return
+	}
+	secrets := o.secrets.list()
+	logger.Info(msgSarifSummary.String(), sarifRuleMap, len(s.maps), sarifRuleSecret, len(secrets))
+	if o.dryRun {
+		return
+	}
+	doc := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "tsmap-extract",
+				InformationURI: "https://github.com/safepic/tsmap-extract",
+				Rules:          sarifRules,
+			}},
+			Results: s.results(secrets),
+		}},
+	}
+	data, _ := json.MarshalIndent(doc, "", "  ")
+	if err := o.writeMeta(sarifFile, data); err != nil {
+		logger.Warn(msgSarifError.String(), "err", err)
+	}
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// scrubbedName is a path segment changed by scrubSegment, listed in report.json.
+type scrubbedName struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// scrubLog remembers the segments changed during a run, each logged once; it
+// belongs to the outputOptions of the run. A nil log records nothing.
+type scrubLog struct {
+	mu   sync.Mutex
+	seen map[string]string
+}
+
+// scrubSegment normalizes a path segment to NFC, so that "é" written as one or
+// two code points gives the same file, and removes control characters and
+// invisible format characters (zero-width spaces, BOM, bidi overrides).
+// Changed segments are recorded in log.
+func scrubSegment(seg string, log *scrubLog) string {
+	out := norm.NFC.String(seg)
+	if strings.IndexFunc(out, invisible) >= 0 {
+		out = strings.Map(func(r rune) rune {
+			if invisible(r) {
+				return -1
+			}
+			return r
+		}, out)
+	}
+	if out != seg {
+		log.add(seg, out)
+	}
+	return out
+}
+
+func invisible(r rune) bool {
+	return unicode.IsControl(r) || unicode.Is(unicode.Cf, r)
+}
+
+func (s *scrubLog) add(from, to string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.seen[from]; ok {
+		return
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]string)
+	}
+	s.seen[from] = to
+	q := strconv.QuoteToASCII(from) // shows what was removed
+	logger.Warn(msgNameScrubbed.String(), "from", q[1:len(q)-1], "to", to)
+}
+
+func (s *scrubLog) list() []scrubbedName {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]scrubbedName, 0, len(s.seen))
+	for from, to := range s.seen {
+		out = append(out, scrubbedName{From: from, To: to})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].From < out[j].From })
+	return out
+}
+
+func (s *scrubLog) logSummary() {
+	if n := len(s.list()); n > 0 {
+		logger.Info(msgScrubSummary.String(), "names", n)
+	}
+}
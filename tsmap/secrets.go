@@ -0,0 +1,227 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const secretsFile = "secrets.json"
+
+// secretRule is one pattern of the -scan-secrets ruleset. When the regexp has a
+// capture group, the first group is the secret; MinEntropy (bits per byte)
+// filters out placeholders such as "changeme" or "xxxxxxxx".
+type secretRule struct {
+	ID          string  `yaml:"id"`
+	Description string  `yaml:"description"`
+	Regex       string  `yaml:"regex"`
+	MinEntropy  float64 `yaml:"min-entropy"`
+
+	re *regexp.Regexp
+}
+
+// builtinSecretRules is the default ruleset; user rules (-secret-rules) run after it.
+var builtinSecretRules = []secretRule{
+	{ID: "aws-access-key-id", Description: "AWS access key ID", Regex: `\b((?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16})\b`},
+	{ID: "aws-secret-access-key", Description: "AWS secret access key", Regex: `(?i)aws[\w.-]{0,20}(?:secret|key)[\w.-]{0,20}["']?\s*[:=]\s*["']([A-Za-z0-9/+=]{40})["']`, MinEntropy: 4},
+	{ID: "gcp-api-key", Description: "Google API key", Regex: `\b(AIza[0-9A-Za-z_-]{35})\b`},
+	{ID: "gcp-service-account", Description: "Google service account key", Regex: `"type"\s*:\s*"service_account"`},
+	{ID: "jwt", Description: "JSON Web Token", Regex: `\b(eyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{16,})`},
+	{ID: "private-key", Description: "Private key", Regex: `-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY(?: BLOCK)?-----`},
+	{ID: "github-token", Description: "GitHub token", Regex: `\b(gh[pousr]_[0-9A-Za-z]{36})\b`},
+	{ID: "slack-token", Description: "Slack token", Regex: `\b(xox[baprs]-[0-9A-Za-z-]{10,})`},
+	{ID: "stripe-secret-key", Description: "Stripe secret key", Regex: `\b([rs]k_live_[0-9A-Za-z]{24,})`},
+}
+
+// genericSecretRule runs last, so that a value already matched by a specific
+// rule is not reported twice.
+var genericSecretRule = secretRule{ID: "generic-secret", Description: "High-entropy value assigned to a secret-like name", Regex: `(?i)(?:api[_-]?key|secret|token|passw(?:or)?d|credential|auth[_-]?key)[\w-]{0,20}["']?\s*[:=]\s*["']([A-Za-z0-9_+/=.-]{16,})["']`, MinEntropy: 3.5}
+
+// secretFinding is one match, reported in secrets.json and report.json.
+type secretFinding struct {
+	Rule   string `json:"rule"`
+	Path   string `json:"path"` // relative to the output directory, slash separated
+	Line   int    `json:"line"`
+	Match  string `json:"match"`
+	Source string `json:"source,omitempty"`
+	Map    string `json:"map,omitempty"`
+}
+
+// secretScanner runs the ruleset over every recovered source; crawl workers
+// call scan concurrently. A nil scanner scans nothing.
+type secretScanner struct {
+	enabled   bool
+	rulesFile string
+	rules     []secretRule
+
+	mu       sync.Mutex
+	findings []secretFinding
+}
+
+func addSecretFlags(fs *flag.FlagSet) *secretScanner {
+	s := &secretScanner{}
+	fs.BoolVar(&s.enabled, "scan-secrets", false, "Scan recovered sources for credentials (AWS, GCP, JWT, private keys, high-entropy values) and write secrets.json")
+	fs.StringVar(&s.rulesFile, "secret-rules", "", "YAML file of extra -scan-secrets rules (implies -scan-secrets)")
+	return s
+}
+
+// load compiles the rules once the flags are parsed.
+func (s *secretScanner) load() error {
+	if s == nil || (!s.enabled && s.rulesFile == "") {
+		return nil
+	}
+	s.enabled = true
+	rules := append([]secretRule(nil), builtinSecretRules...)
+	if s.rulesFile != "" {
+		raw, err := os.ReadFile(s.rulesFile)
+		if err != nil {
+			return err
+		}
+		var doc struct {
+			Rules []secretRule `yaml:"rules"`
+		}
+		if err := yaml.Unmarshal(raw, &doc); err != nil {
+			return fmt.Errorf("%s: %w", s.rulesFile, err)
+		}
+		for i, r := range doc.Rules {
+			if r.ID == "" || r.Regex == "" {
+				return fmt.Errorf("%s: rule %d needs an id and a regex", s.rulesFile, i+1)
+			}
+		}
+		rules = append(rules, doc.Rules...)
+	}
+	rules = append(rules, genericSecretRule)
+	for i := range rules {
+		r := &rules[i]
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", r.ID, err)
+		}
+		r.re = re
+	}
+	s.rules = rules
+	return nil
+}
+
+// scan records the secrets found in one written file.
+func (s *secretScanner) scan(rel string, data []byte, meta FileMeta) {
+	if s == nil || !s.enabled {
+		return
+	}
+	text := string(data)
+	var found []secretFinding
+	seen := make(map[string]bool)
+	for _, r := range s.rules {
+		for _, m := range r.re.FindAllStringSubmatchIndex(text, -1) {
+			start, end := m[0], m[1]
+			if len(m) >= 4 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+			value := text[start:end]
+			if r.MinEntropy > 0 && shannonEntropy(value) < r.MinEntropy {
+				continue
+			}
+			if seen[value] {
+				continue
+			}
+			seen[value] = true
+			found = append(found, secretFinding{
+				Rule:   r.ID,
+				Path:   rel,
+				Line:   strings.Count(text[:start], "\n") + 1,
+				Match:  value,
+				Source: meta.Source,
+				Map:    meta.Map,
+			})
+		}
+	}
+	for _, f := range found {
+		logger.Warn(msgSecretFound.String(), "rule", f.Rule, "path", f.Path, "line", f.Line, "match", redactSecret(f.Match))
+	}
+	s.mu.Lock()
+	s.findings = append(s.findings, found...)
+	s.mu.Unlock()
+}
+
+// list returns the findings sorted by path and line.
+func (s *secretScanner) list() []secretFinding {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := append([]secretFinding(nil), s.findings...)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Path != out[j].Path {
+			return out[i].Path < out[j].Path
+		}
+		return out[i].Line < out[j].Line
+	})
+	return out
+}
+
+// save writes secrets.json (an empty array when nothing matched) and logs the
+// number of findings per rule.
+func (s *secretScanner) save(o *outputOptions) {
+	if s == nil || !s.enabled {
+		return
+	}
+	list := s.list()
+	byRule := make(map[string]int)
+	for _, f := range list {
+		byRule[f.Rule]++
+	}
+	args := []any{"findings", len(list)}
+	for _, id := range sortedKeys(byRule) {
+		args = append(args, id, byRule[id])
+	}
+	logger.Info(msgSecretSummary.String(), args...)
+	if o.dryRun {
+		return
+	}
+	if list == nil {
+		list = []secretFinding{}
+	}
+	data, _ := json.MarshalIndent(list, "", "  ")
+	if err := o.writeMeta(secretsFile, data); err != nil {
+		logger.Warn(msgSecretsError.String(), "err", err)
+	}
+}
+
+// redactSecret keeps the first and last characters of a secret for the logs.
+func redactSecret(v string) string {
+	if len(v) <= 12 {
+		return strings.Repeat("*", len(v))
+	}
+	return v[:4] + strings.Repeat("*", 8) + v[len(v)-4:]
+}
+
+// shannonEntropy returns the entropy of s in bits per byte.
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	n := float64(len(s))
+	h := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
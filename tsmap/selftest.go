@@ -0,0 +1,302 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fixtureSite is a synthetic SPA served by "selftest".
+type fixtureSite struct {
+	name   string
+	root   string            // page path, e.g. /webpack/
+	files  map[string]string // path -> body
+	expect []string          // source paths that must be recovered
+}
+
+type selftestFlags struct {
+	listen string
+	tls    bool
+	serve  bool
+	out    string
+	keep   bool
+}
+
+func newSelftestFlags() (*flag.FlagSet, *selftestFlags) {
+	f := &selftestFlags{}
+	fs := flag.NewFlagSet("tsmap-extract selftest", flag.ExitOnError)
+	fs.StringVar(&f.listen, "listen", "127.0.0.1:0", "Fixture server listen address")
+	fs.BoolVar(&f.tls, "tls", false, "Serve fixtures over HTTPS with a self-signed certificate")
+	fs.BoolVar(&f.serve, "serve", false, "Only serve the fixtures (for use with other tools or a proxy) until interrupted")
+	fs.StringVar(&f.out, "out", "", "Output directory (default: a temporary directory)")
+	fs.BoolVar(&f.keep, "keep", false, "Keep the output directory")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tsmap-extract selftest [flags] [-- crawl flags]")
+		fmt.Fprintln(fs.Output(), "Crawl flags after -- are passed to every crawl, e.g. -- -proxy http://127.0.0.1:8080 -insecure")
+		fs.PrintDefaults()
+	}
+	return fs, f
+}
+
+// RunSelftest serves the fixture sites locally and crawls each of them,
+// checking that the expected sources are recovered.
+func RunSelftest(args []string) int {
+	useConsoleLogger()
+	fs, f := newSelftestFlags()
+	fs.Parse(args)
+	crawlArgs := fs.Args()
+	if len(crawlArgs) > 0 && crawlArgs[0] == "--" {
+		crawlArgs = crawlArgs[1:]
+	}
+
+	sites := fixtureSites()
+	mux := http.NewServeMux()
+	for _, site := range sites {
+		for p, body := range site.files {
+			body := body
+			ctype := "application/javascript"
+			switch {
+			case strings.HasSuffix(p, "/"):
+				ctype = "text/html; charset=utf-8"
+			case strings.HasSuffix(p, ".map"):
+				ctype = "application/json"
+			}
+			mux.HandleFunc(p, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != p {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("Content-Type", ctype)
+				_, _ = w.Write([]byte(body))
+			})
+		}
+	}
+
+	ln, err := net.Listen("tcp", f.listen)
+	if err != nil {
+		fail(msgSelftestListen, err)
+	}
+	scheme := "http"
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	if f.tls {
+		cert, err := selfSignedCert()
+		if err != nil {
+			fail(msgSelftestListen, err)
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		ln = tls.NewListener(ln, srv.TLSConfig)
+		scheme = "https"
+	}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+	base := scheme + "://" + ln.Addr().String()
+
+	if f.serve {
+		for _, site := range sites {
+			fmt.Printf("%-8s %s%s\n", site.name, base, site.root)
+		}
+		fmt.Println(msgSelftestServing.String())
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		<-ctx.Done()
+		return exitOK
+	}
+
+	out := f.out
+	if out == "" {
+		if out, err = os.MkdirTemp("", "tsmap-selftest-"); err != nil {
+			fail(msgCreateDir, err)
+		}
+		if !f.keep {
+			defer os.RemoveAll(out)
+		}
+	}
+
+	failed := 0
+	for _, site := range sites {
+		siteOut := filepath.Join(out, site.name)
+		args := append([]string{"-url", base + site.root, "-out", siteOut, "-q", "-no-progress"}, crawlArgs...)
+		if code := RunCrawl(args); code == exitUsage {
+			return code
+		}
+		missing := missingSources(siteOut, site.expect)
+		if len(missing) == 0 {
+			fmt.Printf("%s%s%s %s (%d %s)\n", cGrn, msgSelftestPass, cRst, site.name, len(site.expect), msgUnitSources)
+		} else {
+			failed++
+			fmt.Printf("%s%s%s %s: %s %s\n", cRed, msgSelftestFail, cRst, site.name, msgSelftestMissing, strings.Join(missing, ", "))
+		}
+	}
+	if f.keep || f.out != "" {
+		fmt.Printf("%s %s\n", msgSelftestKept, out)
+	}
+	if failed > 0 {
+		return exitNoSources
+	}
+	return exitOK
+}
+
+// missingSources returns the expected paths that no written file ends with.
+func missingSources(dir string, expect []string) []string {
+	var written []string
+	_ = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			written = append(written, filepath.ToSlash(p))
+		}
+		return nil
+	})
+	var missing []string
+	for _, e := range expect {
+		found := false
+		for _, w := range written {
+			if strings.HasSuffix(w, "/"+e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, e)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func fixtureMap(file string, sources map[string]string) string {
+	sm := SourceMap{Version: 3, File: file}
+	names := sortedKeys(sources)
+	for _, n := range names {
+		sm.Sources = append(sm.Sources, n)
+		sm.SourcesContent = append(sm.SourcesContent, sources[n])
+	}
+	data, _ := json.Marshal(sm)
+	return string(data)
+}
+
+func fixtureSites() []fixtureSite {
+	// webpack 4 / CRA: external map via comment, lazy chunk via the return() pattern,
+	// chunk map found by guessing script.js.map
+	webpackMain := `!function(e){function t(e){return "static/js/"+e+"."+{1:"9f8e7d6c"}[e]+".chunk.js"}}([]);` +
+		"\n//# sourceMappingURL=main.3f9ab2c1.js.map\n"
+	webpack := fixtureSite{
+		name: "webpack",
+		root: "/webpack/",
+		files: map[string]string{
+			"/webpack/":                           `<!doctype html><html><head><script src="static/js/main.3f9ab2c1.js"></script></head><body><div id="root"></div></body></html>`,
+			"/webpack/static/js/main.3f9ab2c1.js": webpackMain,
+			"/webpack/static/js/main.3f9ab2c1.js.map": fixtureMap("main.3f9ab2c1.js", map[string]string{
+				"webpack:///./src/index.tsx": "import App from './App';\nrender(<App />);\n",
+				"webpack:///./src/App.tsx":   "export default function App() { return <div>app</div>; }\n",
+			}),
+			"/webpack/static/js/1.9f8e7d6c.chunk.js": "(window.webpackJsonp=window.webpackJsonp||[]).push([[1],{}]);\n",
+			"/webpack/static/js/1.9f8e7d6c.chunk.js.map": fixtureMap("1.9f8e7d6c.chunk.js", map[string]string{
+				"webpack:///./src/pages/Admin.tsx": "export const Admin = () => null;\n",
+			}),
+		},
+		expect: []string{"src/index.tsx", "src/App.tsx", "src/pages/Admin.tsx"},
+	}
+
+	// Vite: module script with an inline base64 map
+	viteMap := fixtureMap("index-Df3kQ.js", map[string]string{
+		"../../src/main.ts":       "import { createApp } from 'vue';\n",
+		"../../src/App.vue":       "<template><div/></template>\n",
+		"../../src/api/client.ts": "export const api = '/api/v2';\n",
+	})
+	vite := fixtureSite{
+		name: "vite",
+		root: "/vite/",
+		files: map[string]string{
+			"/vite/": `<!doctype html><html><head><script type="module" crossorigin src="/vite/assets/index-Df3kQ.js"></script></head><body></body></html>`,
+			"/vite/assets/index-Df3kQ.js": "const a=1;export{a};\n//# sourceMappingURL=data:application/json;base64," +
+				base64.StdEncoding.EncodeToString([]byte(viteMap)) + "\n",
+		},
+		expect: []string{"src/main.ts", "src/App.vue", "src/api/client.ts"},
+	}
+
+	// Next.js: _next/static chunks with an absolute sourceMappingURL
+	next := fixtureSite{
+		name: "next",
+		root: "/next/",
+		files: map[string]string{
+			"/next/": `<!doctype html><html><head><script src="/next/_next/static/chunks/main-1a2b3c.js" defer></script></head><body></body></html>`,
+			"/next/_next/static/chunks/main-1a2b3c.js": "(self.webpackChunk_N_E=self.webpackChunk_N_E||[]).push([[179],{}]);\n" +
+				"//# sourceMappingURL=/next/_next/static/chunks/main-1a2b3c.js.map\n",
+			"/next/_next/static/chunks/main-1a2b3c.js.map": fixtureMap("main-1a2b3c.js", map[string]string{
+				"webpack://_N_E/./pages/index.tsx": "export default function Home() { return null; }\n",
+				"webpack://_N_E/./lib/config.ts":   "export const API = process.env.NEXT_PUBLIC_API;\n",
+				"webpack://_N_E/./pages/_app.tsx":  "export default function App({ Component }) { return <Component />; }\n",
+			}),
+		},
+		expect: []string{"pages/index.tsx", "lib/config.ts", "pages/_app.tsx"},
+	}
+
+	// Anchoring: sources at several "../" depths keep their relative layout, the
+	// deepest one sitting at the anchor root; absolute and Windows paths stay inside
+	anchoring := fixtureSite{
+		name: "anchoring",
+		root: "/anchoring/",
+		files: map[string]string{
+			"/anchoring/":          `<!doctype html><html><head><script src="/anchoring/js/app.js"></script></head><body></body></html>`,
+			"/anchoring/js/app.js": "console.log(1);\n//# sourceMappingURL=app.js.map\n",
+			"/anchoring/js/app.js.map": fixtureMap("app.js", map[string]string{
+				"../../shared/util.ts":      "export const up2 = 2;\n",
+				"../lib/api.ts":             "export const up1 = 1;\n",
+				"src/main.ts":               "export const up0 = 0;\n",
+				"/home/ci/build/env.ts":     "export const abs = true;\n",
+				`C:\work\src\windows.ts`:    "export const win = true;\n",
+				"webpack:///./src/blank.ts": "  \n",
+			}),
+		},
+		expect: []string{
+			"js/shared/util.ts",
+			"js/level/lib/api.ts",
+			"js/level/level/src/main.ts",
+			"js/level/level/home/ci/build/env.ts",
+			"js/level/level/work/src/windows.ts",
+		},
+	}
+
+	return []fixtureSite{webpack, vite, next, anchoring}
+}
+
+// selfSignedCert creates a throwaway certificate for 127.0.0.1 and localhost.
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "tsmap-extract selftest"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,289 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// serveSearchMax caps the matches returned by one search of the web UI; the
+// page shows the same number.
+const serveSearchMax = 500
+
+type serveFlags struct {
+	out    string
+	listen string
+	log    *logOptions
+}
+
+func newServeFlags() (*flag.FlagSet, *serveFlags) {
+	f := &serveFlags{}
+	fs := flag.NewFlagSet("tsmap-extract serve", flag.ExitOnError)
+	fs.StringVar(&f.out, "out", "", "Output directory of extract or crawl to browse (required)")
+	fs.StringVar(&f.listen, "listen", "127.0.0.1:8080", "Listen address; use :8080 to share with other hosts")
+	fs.String("config", "", "YAML config file (default ~/.config/tsmap-extract/config.yaml)")
+	f.log = addLogFlags(fs)
+	return fs, f
+}
+
+// RunServe runs the "serve" subcommand: a web UI over an output directory
+// with a file tree, a source viewer and search, until interrupted.
+func RunServe(args []string) int {
+	useConsoleLogger()
+	fs, f := newServeFlags()
+	loadDefaults(fs, "serve", args)
+	fs.Parse(args)
+	defer f.log.setup()()
+
+	if f.out == "" {
+		logger.Error(msgServeArgs.String())
+		fs.Usage()
+		return exitUsage
+	}
+	if _, err := grepFiles(f.out); err != nil {
+		fail(msgServeRead, err)
+	}
+	ln, err := net.Listen("tcp", f.listen)
+	if err != nil {
+		fail(msgServeListen, err)
+	}
+	srv := &http.Server{Handler: newServeMux(f.out), ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+	logger.Info(msgServing.String(), "url", "http://"+ln.Addr().String()+"/", "out", f.out)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdown)
+	return exitOK
+}
+
+// newServeMux serves the UI and its JSON API. The file list is read again on
+// every request, so a crawl still writing to out shows up on reload.
+func newServeMux(out string) *http.ServeMux {
+	mux := http.NewServeMux()
+	writeJSON := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(serveUI))
+	})
+	mux.HandleFunc("/api/files", func(w http.ResponseWriter, r *http.Request) {
+		entries, err := grepFiles(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if entries == nil {
+			entries = []manifestEntry{}
+		}
+		writeJSON(w, entries)
+	})
+	mux.HandleFunc("/api/file", func(w http.ResponseWriter, r *http.Request) {
+		// only local files of the list are served
+		p := r.URL.Query().Get("path")
+		if !filepath.IsLocal(filepath.FromSlash(p)) {
+			http.NotFound(w, r)
+			return
+		}
+		entries, err := grepFiles(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, e := range entries {
+			if e.Path == p {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				http.ServeFile(w, r, filepath.Join(out, filepath.FromSlash(p)))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		expr := q.Get("q")
+		if q.Get("regex") != "1" {
+			expr = regexp.QuoteMeta(expr)
+		}
+		if q.Get("i") == "1" {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil || q.Get("q") == "" {
+			http.Error(w, fmt.Sprintf("invalid pattern: %v", err), http.StatusBadRequest)
+			return
+		}
+		entries, err := grepFiles(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		matches := []grepMatch{}
+		for _, e := range entries {
+			data, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(e.Path)))
+			if err != nil {
+				continue
+			}
+			matches = append(matches, grepLines(re, e, strings.Split(string(data), "\n"), 0)...)
+			if len(matches) >= serveSearchMax {
+				matches = matches[:serveSearchMax]
+				break
+			}
+		}
+		writeJSON(w, matches)
+	})
+	return mux
+}
+
+// serveUI is the single page of "serve": a file tree built from /api/files, a
+// viewer with line numbers and a small JS/TS highlighter, and search.
+const serveUI = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>tsmap-extract</title>
+<style>
+body { margin: 0; font: 14px system-ui, sans-serif; display: flex; height: 100vh; color: #222; }
+#side { width: 320px; overflow: auto; border-right: 1px solid #ddd; padding: 8px; box-sizing: border-box; }
+#main { flex: 1; overflow: auto; display: flex; flex-direction: column; }
+#bar { padding: 8px; border-bottom: 1px solid #ddd; display: flex; gap: 8px; align-items: center; }
+#bar input[type=text] { flex: 1; padding: 4px; }
+#info { padding: 4px 8px; color: #555; font-size: 12px; border-bottom: 1px solid #eee; }
+#info a { color: #0366d6; }
+#view { flex: 1; overflow: auto; }
+details { margin-left: 10px; }
+summary { cursor: pointer; }
+.file { display: block; margin-left: 24px; cursor: pointer; white-space: nowrap; }
+.file:hover, .hit:hover { background: #eef; }
+.file.on { background: #dde; }
+table { border-collapse: collapse; font: 12px ui-monospace, monospace; }
+td.n { color: #999; text-align: right; padding: 0 8px; user-select: none; vertical-align: top; }
+td.c { white-space: pre; }
+tr.mark { background: #ffc; }
+.hit { font: 12px ui-monospace, monospace; padding: 2px 8px; cursor: pointer; white-space: pre; }
+.hit b { color: #0366d6; font-weight: normal; }
+.k { color: #a626a4; } .s { color: #50a14f; } .m { color: #a0a1a7; font-style: italic; } .d { color: #986801; }
+</style>
+</head>
+<body>
+<div id="side"></div>
+<div id="main">
+  <div id="bar">
+    <input type="text" id="q" placeholder="Search (Enter)">
+    <label><input type="checkbox" id="re"> regex</label>
+    <label><input type="checkbox" id="ci" checked> ignore case</label>
+  </div>
+  <div id="info"></div>
+  <div id="view"></div>
+</div>
+<script>
+const $ = id => document.getElementById(id);
+let files = [];
+const esc = s => s.replace(/[&<>"]/g, c => ({'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;'})[c]);
+const kw = new Set('abstract as async await break case catch class const continue debugger declare default delete do else enum export extends false finally for from function get if implements import in instanceof interface let new null of private protected public readonly return set static super switch this throw true try type typeof undefined var void while with yield'.split(' '));
+const tok = /(\/\/[^\n]*|\/\*[\s\S]*?\*\/)|("(?:\\.|[^"\\\n])*"|'(?:\\.|[^'\\\n])*'|` + "`" + `(?:\\.|[^` + "`" + `\\])*` + "`" + `)|\b(\d[\d_]*(?:\.\d+)?)\b|([A-Za-z_$][\w$]*)/g;
+
+function highlight(src) {
+  let out = '', last = 0, m;
+  tok.lastIndex = 0;
+  while ((m = tok.exec(src))) {
+    out += esc(src.slice(last, m.index));
+    const t = esc(m[0]);
+    // spans are closed at line ends: each line becomes a table row
+    const span = c => '<span class="' + c + '">' + t.split('\n').join('</span>\n<span class="' + c + '">') + '</span>';
+    if (m[1]) out += span('m');
+    else if (m[2]) out += span('s');
+    else if (m[3]) out += span('d');
+    else out += kw.has(m[4]) ? span('k') : t;
+    last = tok.lastIndex;
+  }
+  return out + esc(src.slice(last));
+}
+
+function tree() {
+  const root = {dirs: {}, files: []};
+  for (const f of files) {
+    const parts = f.path.split('/');
+    let n = root;
+    for (const p of parts.slice(0, -1)) n = n.dirs[p] = n.dirs[p] || {dirs: {}, files: []};
+    n.files.push(f);
+  }
+  const render = n => Object.keys(n.dirs).sort().map(d =>
+      '<details' + (Object.keys(n.dirs).length === 1 ? ' open' : '') + '><summary>' + esc(d) + '</summary>' + render(n.dirs[d]) + '</details>').join('') +
+    n.files.sort((a, b) => a.path.localeCompare(b.path)).map(f =>
+      '<span class="file" data-p="' + esc(f.path) + '">' + esc(f.path.split('/').pop()) + '</span>').join('');
+  $('side').innerHTML = render(root);
+}
+
+const link = u => /^https?:/.test(u) ? '<a href="' + esc(u) + '" target="_blank" rel="noreferrer">' + esc(u) + '</a>' : esc(u);
+
+async function open(p, line) {
+  const f = files.find(x => x.path === p);
+  const r = await fetch('/api/file?path=' + encodeURIComponent(p));
+  const text = await r.text();
+  document.querySelectorAll('.file.on').forEach(e => e.classList.remove('on'));
+  const el = document.querySelector('.file[data-p="' + CSS.escape(p) + '"]');
+  if (el) { el.classList.add('on'); let d = el.parentElement; while (d && d.tagName === 'DETAILS') { d.open = true; d = d.parentElement; } }
+  let info = '<b>' + esc(p) + '</b>';
+  if (f && f.source) info += ' &middot; source ' + esc(f.source);
+  if (f && f.map) info += ' &middot; map ' + link(f.map);
+  if (f && f.script) info += ' &middot; script ' + link(f.script);
+  $('info').innerHTML = info;
+  const lines = highlight(text).split('\n');
+  $('view').innerHTML = '<table>' + lines.map((l, i) =>
+    '<tr id="L' + (i + 1) + '"' + (i + 1 === line ? ' class="mark"' : '') + '><td class="n">' + (i + 1) + '</td><td class="c">' + l + '</td></tr>').join('') + '</table>';
+  if (line) $('L' + line).scrollIntoView({block: 'center'});
+  else $('view').scrollTop = 0;
+  history.replaceState(null, '', '#' + encodeURIComponent(p) + (line ? ':' + line : ''));
+}
+
+async function search() {
+  const q = $('q').value;
+  if (!q) return;
+  const r = await fetch('/api/search?q=' + encodeURIComponent(q) + '&regex=' + ($('re').checked ? 1 : 0) + '&i=' + ($('ci').checked ? 1 : 0));
+  if (!r.ok) { $('info').textContent = await r.text(); return; }
+  const hits = await r.json();
+  $('info').textContent = hits.length + ' match(es)' + (hits.length >= 500 ? ' (first 500)' : '');
+  $('view').innerHTML = hits.map(h =>
+    '<div class="hit" data-p="' + esc(h.path) + '" data-l="' + h.line + '"><b>' + esc(h.path) + ':' + h.line + '</b>  ' + esc(h.text.trim().slice(0, 300)) + '</div>').join('');
+}
+
+document.addEventListener('click', e => {
+  const f = e.target.closest('.file');
+  if (f) open(f.dataset.p);
+  const h = e.target.closest('.hit');
+  if (h) open(h.dataset.p, +h.dataset.l);
+});
+$('q').addEventListener('keydown', e => { if (e.key === 'Enter') search(); });
+
+fetch('/api/files').then(r => r.json()).then(list => {
+  files = list;
+  tree();
+  $('info').textContent = files.length + ' files';
+  const h = decodeURIComponent(location.hash.slice(1));
+  if (h) { const i = h.lastIndexOf(':'); i > 0 && /^\d+$/.test(h.slice(i + 1)) ? open(h.slice(0, i), +h.slice(i + 1)) : open(h); }
+});
+</script>
+</body>
+</html>
+`
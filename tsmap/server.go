@@ -0,0 +1,677 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job states: queued, then running, then one of the final three.
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+type serverFlags struct {
+	listen  string
+	data    string
+	workers int
+	queue   int
+	ttl     time.Duration
+	token   string
+	maxBody byteSize
+	http    *httpOptions
+	log     *logOptions
+}
+
+func newServerFlags() (*flag.FlagSet, *serverFlags) {
+	f := &serverFlags{maxBody: 256 << 20}
+	fs := flag.NewFlagSet("tsmap-extract server", flag.ExitOnError)
+	fs.StringVar(&f.listen, "listen", "127.0.0.1:9090", "Listen address; use :9090 to accept other hosts")
+	fs.StringVar(&f.data, "data", "tsmap-server", "Directory keeping the files and the manifest of every job")
+	fs.IntVar(&f.workers, "workers", 2, "Jobs run at the same time")
+	fs.IntVar(&f.queue, "queue", 100, "Jobs waiting for a worker; more are refused with 503")
+	fs.DurationVar(&f.ttl, "ttl", 24*time.Hour, "Delete finished jobs after this long (0: keep them)")
+	fs.StringVar(&f.token, "token", "", "Require 'Authorization: Bearer <token>' on every request (or TSMAP_TOKEN)")
+	fs.Var(&f.maxBody, "max-body", "Largest accepted request body, e.g. 256MB (default 256MB)")
+	fs.String("config", "", "YAML config file (default ~/.config/tsmap-extract/config.yaml)")
+	f.http = addHTTPFlags(fs)
+	f.log = addLogFlags(fs)
+	return fs, f
+}
+
+// jobRequest is the body of POST /jobs: url starts a crawl, map an extraction.
+// The other fields are the flags of the same name.
+type jobRequest struct {
+	URL           string            `json:"url,omitempty"`
+	Map           json.RawMessage   `json:"map,omitempty"` // the map object, or a string holding it
+	Headers       map[string]string `json:"headers,omitempty"`
+	Scope         []string          `json:"scope,omitempty"`
+	Concurrency   int               `json:"concurrency,omitempty"`
+	SaveJS        bool              `json:"save_js,omitempty"`
+	SaveMap       bool              `json:"save_map,omitempty"`
+	EvalChunks    bool              `json:"eval_chunks,omitempty"`
+	Harvest       bool              `json:"harvest,omitempty"`
+	MapGuess      string            `json:"map_guess,omitempty"`
+	MapTemplates  []string          `json:"map_templates,omitempty"`
+	AllMaps       bool              `json:"all_maps,omitempty"`
+	Beautify      bool              `json:"beautify,omitempty"`
+	EOL           string            `json:"eol,omitempty"`
+	KeepNamespace bool              `json:"keep_namespace,omitempty"`
+	SkipVendor    bool              `json:"skip_vendor,omitempty"`
+	SkipIgnored   bool              `json:"skip_ignored,omitempty"`
+	Include       []string          `json:"include,omitempty"`
+	Exclude       []string          `json:"exclude,omitempty"`
+	ScanSecrets   bool              `json:"scan_secrets,omitempty"`
+}
+
+func (r *jobRequest) extractOptions() ExtractOptions {
+	return ExtractOptions{
+		Beautify:      r.Beautify,
+		EOL:           r.EOL,
+		KeepNamespace: r.KeepNamespace,
+		SkipVendor:    r.SkipVendor,
+		SkipIgnored:   r.SkipIgnored,
+		Include:       r.Include,
+		Exclude:       r.Exclude,
+		ScanSecrets:   r.ScanSecrets,
+	}
+}
+
+// jobStatus is what GET /jobs/{id} returns.
+type jobStatus struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`          // extract or crawl
+	URL      string    `json:"url,omitempty"` // crawled page
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+	Files    int       `json:"files"` // written so far while running
+	Skipped  int       `json:"skipped,omitempty"`
+	Scripts  int       `json:"scripts,omitempty"`
+	Secrets  int       `json:"secrets"`
+	Severity string    `json:"severity,omitempty"` // none, low, medium, high or critical
+}
+
+// jobFile is a written file, as listed by GET /jobs/{id}/manifest.
+type jobFile struct {
+	Path   string `json:"path"` // relative to the job, slash separated
+	Source string `json:"source,omitempty"`
+	Map    string `json:"map,omitempty"`
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+}
+
+// jobManifest is the result of a finished job, kept next to its files as
+// <id>.json so that the server finds its jobs again after a restart.
+type jobManifest struct {
+	Job     jobStatus      `json:"job"`
+	Files   []jobFile      `json:"files"`
+	Secrets []Secret       `json:"secrets,omitempty"`
+	Scripts []ScriptResult `json:"scripts,omitempty"`
+}
+
+// jobSink writes the files of a job below its directory and lists them.
+type jobSink struct {
+	dir *DirSink
+
+	mu    sync.Mutex
+	files []jobFile
+}
+
+func (s *jobSink) WriteFile(p string, content []byte, meta FileMeta) error {
+	if err := s.dir.WriteFile(p, content, meta); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(content)
+	s.mu.Lock()
+	s.files = append(s.files, jobFile{Path: p, Source: meta.Source, Map: meta.Map, SHA256: hex.EncodeToString(sum[:]), Size: len(content)})
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *jobSink) Close() error { return nil }
+
+func (s *jobSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files)
+}
+
+type job struct {
+	jobStatus // guarded by jobServer.mu
+	result    jobManifest
+
+	req    jobRequest
+	sm     *SourceMap
+	sink   *jobSink
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// jobServer queues jobs and runs them on a fixed number of workers. Jobs are
+// independent library runs sharing only the HTTP client of the flags.
+type jobServer struct {
+	data    string
+	crawler *crawler
+	ttl     time.Duration
+	queue   chan *job
+	ctx     context.Context // canceled on shutdown
+	metrics *metricSet
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// RunServer runs the "server" subcommand: an HTTP API taking extraction and
+// crawl jobs, until interrupted.
+func RunServer(args []string) int {
+	useConsoleLogger()
+	fs, f := newServerFlags()
+	loadDefaults(fs, "server", args)
+	fs.Parse(args)
+	defer f.log.setup()()
+
+	if f.workers < 1 || f.queue < 0 {
+		usageFail(msgServerArgs)
+	}
+	if err := os.MkdirAll(f.data, 0755); err != nil {
+		fail(msgCreateDir, err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	s := &jobServer{
+		data:    f.data,
+		crawler: f.http.newCrawler(nil),
+		ttl:     f.ttl,
+		queue:   make(chan *job, f.queue),
+		ctx:     ctx,
+		metrics: newMetricSet(),
+		jobs:    make(map[string]*job),
+	}
+	s.crawler.metrics = s.metrics
+	s.load()
+
+	ln, err := net.Listen("tcp", f.listen)
+	if err != nil {
+		fail(msgServeListen, err)
+	}
+	if f.token == "" && !isLoopback(ln.Addr()) {
+		logger.Warn(msgServerNoToken.String())
+	}
+	var wg sync.WaitGroup
+	for range f.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work()
+		}()
+	}
+	go s.sweep()
+	srv := &http.Server{Handler: s.handler(f.token, int64(f.maxBody)), ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+	logger.Info(msgServerListening.String(), "url", "http://"+ln.Addr().String()+"/", "data", f.data, "workers", f.workers)
+
+	<-ctx.Done()
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdown)
+	wg.Wait()
+	return exitOK
+}
+
+func isLoopback(a net.Addr) bool {
+	tcp, ok := a.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// handler routes the API; every route but /healthz needs the token when set.
+// Answers are counted by route and status for GET /metrics.
+func (s *jobServer) handler(token string, maxBody int64) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", func(w http.ResponseWriter, r *http.Request) {
+		var req jobRequest
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			jsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		j, err := s.newJob(req)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !s.submit(j) {
+			jsonError(w, http.StatusServiceUnavailable, errors.New("queue full, retry later"))
+			return
+		}
+		w.Header().Set("Location", "/jobs/"+j.ID)
+		writeJSONStatus(w, http.StatusAccepted, s.status(j))
+	})
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		list := make([]jobStatus, 0, len(s.jobs))
+		for _, j := range s.jobs {
+			list = append(list, s.statusLocked(j))
+		}
+		s.mu.Unlock()
+		sort.Slice(list, func(a, b int) bool { return list[a].Created.Before(list[b].Created) })
+		writeJSONStatus(w, http.StatusOK, list)
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if j := s.lookup(w, r, false); j != nil {
+			writeJSONStatus(w, http.StatusOK, s.status(j))
+		}
+	})
+	mux.HandleFunc("GET /jobs/{id}/manifest", func(w http.ResponseWriter, r *http.Request) {
+		if j := s.lookup(w, r, true); j != nil {
+			writeJSONStatus(w, http.StatusOK, j.result)
+		}
+	})
+	mux.HandleFunc("GET /jobs/{id}/zip", func(w http.ResponseWriter, r *http.Request) {
+		if j := s.lookup(w, r, true); j != nil {
+			s.writeZip(w, j)
+		}
+	})
+	mux.HandleFunc("DELETE /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		j := s.lookup(w, r, false)
+		if j == nil {
+			return
+		}
+		s.mu.Lock()
+		active := j.State == jobQueued || j.State == jobRunning
+		if active {
+			j.cancel()
+		}
+		s.mu.Unlock()
+		if active {
+			writeJSONStatus(w, http.StatusAccepted, s.status(j))
+			return
+		}
+		s.remove(j)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		counts := map[string]int{jobQueued: 0, jobRunning: 0}
+		s.mu.Lock()
+		for _, j := range s.jobs {
+			counts[j.State]++
+		}
+		s.mu.Unlock()
+		writeJSONStatus(w, http.StatusOK, counts)
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		counts := map[string]int{jobQueued: 0, jobRunning: 0}
+		s.mu.Lock()
+		for _, j := range s.jobs {
+			counts[j.State]++
+		}
+		s.mu.Unlock()
+		for _, state := range []string{jobQueued, jobRunning} {
+			s.metrics.set("tsmap_jobs", float64(counts[state]), "state", state)
+		}
+		s.metrics.ServeHTTP(w, r)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "other"
+		}
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		defer func() { s.metrics.add("tsmap_api_requests_total", 1, "route", route, "code", strconv.Itoa(rec.code)) }()
+		if token != "" && r.URL.Path != "/healthz" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				jsonError(rec, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+				return
+			}
+		}
+		mux.ServeHTTP(rec, r)
+	})
+}
+
+// statusRecorder keeps the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func writeJSONStatus(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func jsonError(w http.ResponseWriter, code int, err error) {
+	writeJSONStatus(w, code, map[string]string{"error": err.Error()})
+}
+
+// newJob checks a request; a map is parsed now so that a bad one is refused
+// with 400 rather than failing later.
+func (s *jobServer) newJob(req jobRequest) (*job, error) {
+	j := &job{req: req}
+	j.Created = time.Now().UTC()
+	switch {
+	case req.URL != "" && len(req.Map) > 0:
+		return nil, errors.New("set either url or map")
+	case req.URL != "":
+		if !isHTTPURL(req.URL) {
+			return nil, errors.New("url must be an http(s) URL")
+		}
+		if req.MapGuess != "" && req.MapGuess != "default" && req.MapGuess != "aggressive" {
+			return nil, errors.New("map_guess must be default or aggressive")
+		}
+		for _, t := range req.MapTemplates {
+			if err := checkMapTemplate(t); err != nil {
+				return nil, fmt.Errorf("map_templates: %w", err)
+			}
+		}
+		j.Kind, j.URL = "crawl", req.URL
+	case len(req.Map) > 0:
+		data := []byte(req.Map)
+		var text string
+		if json.Unmarshal(req.Map, &text) == nil {
+			data = []byte(text)
+		}
+		sm, err := ParseSourceMap(data)
+		if err != nil {
+			return nil, fmt.Errorf("map: %w", err)
+		}
+		j.Kind, j.sm = "extract", sm
+	default:
+		return nil, errors.New("url or map is required")
+	}
+	opts := req.extractOptions()
+	opts.Sink = NewMemorySink()
+	if _, _, err := opts.output(); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	j.ID = hex.EncodeToString(id)
+	j.sink = &jobSink{dir: NewDirSink(filepath.Join(s.data, j.ID))}
+	return j, nil
+}
+
+// submit queues j, or reports false when the queue is full.
+func (s *jobServer) submit(j *job) bool {
+	j.ctx, j.cancel = context.WithCancel(s.ctx)
+	s.mu.Lock()
+	j.State = jobQueued
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+	select {
+	case s.queue <- j:
+		return true
+	default:
+		s.mu.Lock()
+		delete(s.jobs, j.ID)
+		s.mu.Unlock()
+		j.cancel()
+		return false
+	}
+}
+
+// work runs queued jobs until shutdown.
+func (s *jobServer) work() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case j := <-s.queue:
+			s.run(j)
+		}
+	}
+}
+
+// run runs j, then writes its manifest; a job canceled while queued ends
+// without running.
+func (s *jobServer) run(j *job) {
+	s.mu.Lock()
+	if j.ctx.Err() != nil {
+		j.State, j.Finished = jobCanceled, time.Now().UTC()
+		s.mu.Unlock()
+		return
+	}
+	j.State, j.Started = jobRunning, time.Now().UTC()
+	s.mu.Unlock()
+
+	opts := j.req.extractOptions()
+	opts.Sink = j.sink
+	opts.Observer = s.metrics.observer()
+	var err error
+	var m jobManifest
+	var skipped int
+	var severity string
+	if j.Kind == "crawl" {
+		var rep Report
+		rep, err = crawl(j.ctx, CrawlOptions{
+			URL:            j.req.URL,
+			Concurrency:    j.req.Concurrency,
+			Scope:          j.req.Scope,
+			SaveJS:         j.req.SaveJS,
+			SaveMap:        j.req.SaveMap,
+			EvalChunks:     j.req.EvalChunks,
+			Harvest:        j.req.Harvest,
+			MapGuess:       j.req.MapGuess,
+			MapTemplates:   j.req.MapTemplates,
+			AllMaps:        j.req.AllMaps,
+			ExtractOptions: opts,
+		}, s.crawler.withHeaders(s.headers(j.req.Headers)))
+		m.Scripts, m.Secrets, severity = rep.Scripts, rep.Secrets, rep.Severity
+	} else {
+		var res Result
+		res, err = Extract(j.ctx, j.sm, opts)
+		m.Secrets, skipped, severity = res.Secrets, res.Skipped, res.Severity
+		if err == nil {
+			s.metrics.add("tsmap_maps_found_total", 1)
+		}
+	}
+
+	s.mu.Lock()
+	j.Files, j.Skipped, j.Scripts, j.Secrets = len(j.sink.files), skipped, len(m.Scripts), len(m.Secrets)
+	j.Severity, j.Finished = severity, time.Now().UTC()
+	switch {
+	case j.ctx.Err() != nil:
+		j.State = jobCanceled
+	case err != nil:
+		j.State, j.Error = jobFailed, err.Error()
+	default:
+		j.State = jobDone
+	}
+	m.Job, m.Files = j.jobStatus, j.sink.files
+	j.result = m
+	s.mu.Unlock()
+	j.cancel()
+	s.metrics.add("tsmap_jobs_total", 1, "kind", j.Kind, "state", m.Job.State)
+
+	if m.Job.State == jobFailed {
+		logger.Warn(msgJobFailed.String(), "id", j.ID, "kind", j.Kind, "err", err)
+	} else {
+		logger.Info(msgJobDone.String(), "id", j.ID, "kind", j.Kind, "state", m.Job.State, "files", m.Job.Files, "duration", m.Job.Finished.Sub(m.Job.Started).Round(time.Millisecond))
+	}
+	data, _ := json.MarshalIndent(m, "", "  ")
+	if err := writeAtomic(s.manifestPath(j.ID), data, false); err != nil {
+		logger.Warn(msgJobSaveError.String(), "id", j.ID, "err", err)
+	}
+}
+
+// headers returns the -header and -cookie values with those of a job on top.
+func (s *jobServer) headers(extra map[string]string) http.Header {
+	h := s.crawler.headers.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	for k, v := range extra {
+		h.Set(k, v)
+	}
+	return h
+}
+
+func (s *jobServer) manifestPath(id string) string {
+	return filepath.Join(s.data, id+".json")
+}
+
+// status returns the current status of j, with the live file count of a running job.
+func (s *jobServer) status(j *job) jobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statusLocked(j)
+}
+
+func (s *jobServer) statusLocked(j *job) jobStatus {
+	st := j.jobStatus
+	if st.State == jobRunning {
+		st.Files = j.sink.count()
+	}
+	return st
+}
+
+// lookup returns the job of the {id} path value, or answers 404; with
+// finished set, a queued or running job is answered with 409.
+func (s *jobServer) lookup(w http.ResponseWriter, r *http.Request, finished bool) *job {
+	s.mu.Lock()
+	j := s.jobs[r.PathValue("id")]
+	var state string
+	if j != nil {
+		state = j.State
+	}
+	s.mu.Unlock()
+	switch {
+	case j == nil:
+		jsonError(w, http.StatusNotFound, errors.New("no such job"))
+		return nil
+	case finished && (state == jobQueued || state == jobRunning):
+		jsonError(w, http.StatusConflict, fmt.Errorf("job is %s", state))
+		return nil
+	}
+	return j
+}
+
+// writeZip sends the files of a finished job as a ZIP archive.
+func (s *jobServer) writeZip(w http.ResponseWriter, j *job) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", j.ID+".zip"))
+	zs := NewZipSink(w)
+	for _, f := range j.result.Files {
+		data, err := os.ReadFile(filepath.Join(s.data, j.ID, filepath.FromSlash(f.Path)))
+		if err != nil {
+			logger.Warn(msgJobSaveError.String(), "id", j.ID, "err", err)
+			continue
+		}
+		if err := zs.WriteFile(f.Path, data, FileMeta{}); err != nil {
+			logger.Warn(msgJobSaveError.String(), "id", j.ID, "err", err)
+			break
+		}
+	}
+	_ = zs.Close() // also removes the stage file; fails if the client is gone
+}
+
+// remove deletes a finished job and its files.
+func (s *jobServer) remove(j *job) {
+	s.mu.Lock()
+	delete(s.jobs, j.ID)
+	s.mu.Unlock()
+	_ = os.RemoveAll(filepath.Join(s.data, j.ID))
+	_ = os.Remove(s.manifestPath(j.ID))
+}
+
+// sweep deletes the jobs finished more than -ttl ago.
+func (s *jobServer) sweep() {
+	if s.ttl <= 0 {
+		return
+	}
+	tick := time.NewTicker(min(s.ttl, time.Minute))
+	defer tick.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-tick.C:
+		}
+		var old []*job
+		s.mu.Lock()
+		for _, j := range s.jobs {
+			if !j.Finished.IsZero() && time.Since(j.Finished) > s.ttl {
+				old = append(old, j)
+			}
+		}
+		s.mu.Unlock()
+		for _, j := range old {
+			s.remove(j)
+		}
+	}
+}
+
+// load finds the finished jobs of a previous run in the data directory. A job
+// directory without manifest was interrupted by a shutdown and is deleted.
+func (s *jobServer) load() {
+	entries, err := os.ReadDir(s.data)
+	if err != nil {
+		fail(msgJobsLoad, err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !isJobID(strings.TrimSuffix(name, ".json")) {
+			continue
+		}
+		if e.IsDir() {
+			if _, err := os.Stat(s.manifestPath(name)); errors.Is(err, os.ErrNotExist) {
+				_ = os.RemoveAll(filepath.Join(s.data, name))
+			}
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.data, name))
+		if err != nil {
+			continue
+		}
+		j := &job{cancel: func() {}}
+		if json.Unmarshal(data, &j.result) != nil || j.result.Job.ID+".json" != name {
+			continue
+		}
+		j.jobStatus = j.result.Job
+		s.jobs[j.ID] = j
+	}
+	if len(s.jobs) > 0 {
+		logger.Info(msgServerLoaded.String(), "jobs", len(s.jobs))
+	}
+}
+
+// isJobID reports whether name looks like a job ID, so that load leaves the
+// other files of -data alone.
+func isJobID(name string) bool {
+	if len(name) != 16 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
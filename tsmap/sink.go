@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink receives the files of a run: recovered sources, saved scripts and maps,
+// and the files of the run itself (manifest.json, report.json, SHA256SUMS).
+// Paths are slash separated and relative to the output root. The extract and
+// crawl subcommands write to a DirSink and, with -out-zip or -out-tar, to an
+// ArchiveSink; a Sink must be safe for concurrent use.
+type Sink interface {
+	WriteFile(path string, content []byte, meta FileMeta) error
+	Close() error
+}
+
+// FileMeta describes where a file comes from. Source and Map are empty for the
+// files of the run itself; ModTime is zero when unknown. Sinks date the file
+// with ModTime when set and make it executable with Executable, both only
+// passed on with -timestamps source.
+type FileMeta struct {
+	Source     string
+	Map        string
+	ModTime    time.Time
+	Executable bool
+}
+
+// linker is implemented by sinks able to hardlink a file (-dedup hardlink).
+type linker interface {
+	Link(oldPath, newPath string) error
+}
+
+// DirSink writes files below a local directory. Files are written atomically
+// and symlinks found below the root are never followed.
+type DirSink struct {
+	root  string
+	Fsync bool // flush every file and its directory to disk
+}
+
+// NewDirSink returns a sink writing below root, created on first write.
+func NewDirSink(root string) *DirSink { return &DirSink{root: root} }
+
+func (d *DirSink) WriteFile(p string, content []byte, meta FileMeta) error {
+	dst, err := d.local(p)
+	if err != nil {
+		return err
+	}
+	if err := d.mkdirParent(dst); err != nil {
+		return err
+	}
+	if err := writeAtomic(dst, content, d.Fsync); err != nil {
+		return err
+	}
+	if meta.Executable {
+		if err := os.Chmod(longPath(dst), 0755); err != nil {
+			return err
+		}
+	}
+	if !meta.ModTime.IsZero() {
+		return os.Chtimes(longPath(dst), meta.ModTime, meta.ModTime)
+	}
+	return nil
+}
+
+// Link makes newPath a hardlink to oldPath, replacing newPath.
+func (d *DirSink) Link(oldPath, newPath string) error {
+	src, err := d.local(oldPath)
+	if err != nil {
+		return err
+	}
+	dst, err := d.local(newPath)
+	if err != nil {
+		return err
+	}
+	if err := d.mkdirParent(dst); err != nil {
+		return err
+	}
+	_ = os.Remove(longPath(dst))
+	return os.Link(longPath(src), longPath(dst))
+}
+
+func (d *DirSink) Close() error { return nil }
+
+// local turns a sink path into a path below the root, refusing any escape.
+func (d *DirSink) local(p string) (string, error) {
+	clean := path.Clean("/" + filepath.ToSlash(p))[1:]
+	if clean == "" {
+		return "", fmt.Errorf("%q: empty path", p)
+	}
+	dst := filepath.Join(d.root, filepath.FromSlash(clean))
+	if err := mustBeUnder(d.root, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+var errSymlink = errors.New("refusing to write through a symlink in the output directory")
+
+// mkdirParent creates the parent directories of dst and checks that no path
+// element below the root, dst included, is a symlink: a hostile or reused
+// output tree cannot redirect writes elsewhere.
+func (d *DirSink) mkdirParent(dst string) error {
+	if err := d.checkNoSymlink(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(longPath(filepath.Dir(dst)), 0755); err != nil {
+		return err
+	}
+	return d.checkNoSymlink(dst)
+}
+
+func (d *DirSink) checkNoSymlink(p string) error {
+	rel, err := filepath.Rel(d.root, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil // only the tree below the root is checked
+	}
+	cur := d.root
+	for _, seg := range strings.Split(rel, string(filepath.Separator)) {
+		cur = filepath.Join(cur, seg)
+		fi, err := os.Lstat(cur)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s: %w", cur, errSymlink)
+		}
+	}
+	return nil
+}
+
+// MemorySink keeps the files in memory, e.g. to process recovered sources
+// without touching the disk. A path written twice keeps the last content.
+type MemorySink struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func NewMemorySink() *MemorySink { return &MemorySink{files: make(map[string][]byte)} }
+
+func (m *MemorySink) WriteFile(p string, content []byte, _ FileMeta) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[p] = append([]byte(nil), content...)
+	return nil
+}
+
+func (m *MemorySink) Close() error { return nil }
+
+// Files returns a copy of the path -> content map.
+func (m *MemorySink) Files() map[string][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string][]byte, len(m.files))
+	for k, v := range m.files {
+		out[k] = v
+	}
+	return out
+}
+
+// ExtractMap writes the sources of a sourcemap to sink with the path handling of
+// the extract subcommand (anchoring of leading "../", sanitized segments) and
+// returns the number of files written. Index maps must embed their sections.
+func ExtractMap(mapData []byte, sink Sink) (int, error) {
+	out := &outputOptions{conflicts: newConflictTracker(), scrubbed: &scrubLog{}, sinks: []Sink{sink}}
+	return processMapBytes(context.Background(), mapData, "", "", out, false, mapOrigin{}, nil)
+}
@@ -0,0 +1,315 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+)
+
+// spoolSize is the size from which a script body is kept in a temporary file
+// rather than in memory. Chunk detectors and map locators then see it by
+// windows of scanWindow bytes, overlapping by scanOverlap so that a match up
+// to that long is whole in one of them.
+const (
+	spoolSize   = 16 << 20
+	scanWindow  = 4 << 20
+	scanOverlap = 256 << 10
+)
+
+// spool is a script body kept on disk. A nil *spool is an empty one.
+type spool struct {
+	f    *os.File
+	size int64
+	temp bool // removed by close; false for a -http-cache body
+}
+
+// readSpooled reads r in memory when it is under spoolSize, and into a
+// temporary file otherwise.
+func readSpooled(r io.Reader) ([]byte, *spool, error) {
+	data, err := io.ReadAll(io.LimitReader(r, spoolSize))
+	if err != nil || len(data) < spoolSize {
+		return data, nil, err
+	}
+	f, err := os.CreateTemp("", "tsmap-script-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &spool{f: f, temp: true}
+	n, err := f.Write(data)
+	if err == nil {
+		var rest int64
+		rest, err = io.Copy(f, r)
+		s.size = int64(n) + rest
+	}
+	if err != nil {
+		s.close()
+		return nil, nil, err
+	}
+	return nil, s, nil
+}
+
+// openSpooled reads a cached body like readSpooled, without copying it.
+func openSpooled(p string) ([]byte, *spool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.Size() < spoolSize {
+		defer f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := io.ReadAll(f)
+		return data, nil, err
+	}
+	return nil, &spool{f: f, size: fi.Size()}, nil
+}
+
+func (s *spool) close() {
+	if s == nil {
+		return
+	}
+	s.f.Close()
+	if s.temp {
+		os.Remove(s.f.Name())
+	}
+}
+
+func (s *spool) reader() io.Reader {
+	return io.NewSectionReader(s.f, 0, s.size)
+}
+
+// bytes reads the whole body, for -save-js and -reconstruct.
+func (s *spool) bytes() ([]byte, error) {
+	return io.ReadAll(s.reader())
+}
+
+// windows calls fn with the offset and text of each window, from the start
+// or, with backward set, from the end, until fn returns false.
+func (s *spool) windows(backward bool, fn func(off int64, text string) bool) error {
+	var starts []int64
+	for off := int64(0); ; off += scanWindow - scanOverlap {
+		starts = append(starts, off)
+		if off+scanWindow >= s.size {
+			break
+		}
+	}
+	if backward {
+		slices.Reverse(starts)
+	}
+	buf := make([]byte, scanWindow)
+	for _, off := range starts {
+		n, err := s.f.ReadAt(buf[:min(scanWindow, s.size-off)], off)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if !fn(off, string(buf[:n])) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// sniff is sniffNonJS for a spooled body: the type is detected from the first
+// bytes, and a body looking like JSON is validated by streaming it.
+func (s *spool) sniff(contentType string) (string, error) {
+	head := make([]byte, 512)
+	n, err := s.f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if kind := sniffNonJS(contentType, head[:n]); kind != "" {
+		return kind, nil
+	}
+	b := bytes.TrimLeft(bytes.TrimPrefix(head[:n], []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(b) > 0 && (b[0] == '{' || b[0] == '[') && validJSON(s.reader()) {
+		return "application/json", nil
+	}
+	return "", nil
+}
+
+// validJSON reports whether r holds exactly one JSON value, like json.Valid.
+func validJSON(r io.Reader) bool {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	_, err := dec.Token()
+	return err == io.EOF
+}
+
+// inlineMap decodes the inline map of the last sourceMappingURL comment,
+// straight from the file; it returns nil when there is none.
+func (s *spool) inlineMap() ([]byte, error) {
+	start := int64(-1)
+	err := s.windows(true, func(off int64, text string) bool {
+		ref, at := sourceMapComment(text)
+		if at < 0 {
+			return true
+		}
+		// the payload may run past the window, not the prefix: a comment
+		// starting in the overlap was seen whole in the next window
+		if n := inlineMapPrefix(ref); n >= 0 {
+			start = off + int64(at+n)
+		}
+		return false
+	})
+	if err != nil || start < 0 {
+		return nil, err
+	}
+	return s.inlineMapAt(start)
+}
+
+// inlineMapAt decodes the base64 payload of an inline map starting at start.
+func (s *spool) inlineMapAt(start int64) ([]byte, error) {
+	payload := &base64Run{r: bufio.NewReader(io.NewSectionReader(s.f, start, s.size-start))}
+	data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, payload))
+	if err != nil {
+		return nil, fmt.Errorf("inline map: %w", err)
+	}
+	if payload.n == 0 {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// base64Run reads the base64 characters at the start of r, then ends.
+type base64Run struct {
+	r    *bufio.Reader
+	n    int64
+	done bool
+}
+
+func (b *base64Run) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.EOF
+	}
+	for i := range p {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			b.done = true
+			return i, err
+		}
+		if !isBase64Char(c) {
+			b.done = true
+			return i, io.EOF
+		}
+		p[i] = c
+		b.n++
+	}
+	return len(p), nil
+}
+
+func isBase64Char(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '+' || c == '/' || c == '='
+}
+
+// scriptBody is the text of a fetched script, in memory or spooled.
+type scriptBody struct {
+	text  string
+	spool *spool
+}
+
+// detectChunks runs d over the script, window by window when it is spooled.
+func (b scriptBody) detectChunks(d ChunkDetector, scriptURL, rootURL *url.URL) ([]*url.URL, error) {
+	if b.spool == nil {
+		return d.DetectChunks(b.text, scriptURL, rootURL), nil
+	}
+	seen := make(map[string]bool)
+	var out []*url.URL
+	err := b.spool.windows(false, func(_ int64, text string) bool {
+		for _, u := range d.DetectChunks(text, scriptURL, rootURL) {
+			if u != nil && !seen[u.String()] {
+				seen[u.String()] = true
+				out = append(out, u)
+			}
+		}
+		return true
+	})
+	return out, err
+}
+
+// mapComments returns every sourceMappingURL comment of the script. Those of
+// a spooled one are found window by window, with their offset in the file; a
+// value cut by the end of a window is taken whole from the next one.
+func (b scriptBody) mapComments() ([]mapComment, error) {
+	if b.spool == nil {
+		return sourceMapComments(b.text), nil
+	}
+	var out []mapComment
+	err := b.spool.windows(false, func(off int64, text string) bool {
+		for _, c := range sourceMapComments(text) {
+			c.at += off
+			if n := len(out); n > 0 && out[n-1].at >= c.at {
+				if out[n-1].at == c.at && len(c.ref) > len(out[n-1].ref) {
+					out[n-1] = c
+				}
+				continue
+			}
+			out = append(out, c)
+		}
+		return true
+	})
+	return out, err
+}
+
+// inlineMap decodes the map of an inline comment, from the file for a
+// spooled script, whose comment value may be cut.
+func (b scriptBody) inlineMap(c mapComment) ([]byte, error) {
+	if b.spool == nil {
+		return decodeInlineMap(c.ref)
+	}
+	n := inlineMapPrefix(c.ref)
+	if n < 0 {
+		return nil, nil
+	}
+	return b.spool.inlineMapAt(c.at + int64(n))
+}
+
+// locateMaps runs l over the script. A spooled one is scanned from the end,
+// where sourceMappingURL comments go, and the first window giving candidates
+// wins; inline maps are decoded from the file rather than from a window.
+func (b scriptBody) locateMaps(l MapLocator, scriptURL *url.URL) ([]MapCandidate, error) {
+	if b.spool == nil {
+		return l.LocateMaps(b.text, scriptURL)
+	}
+	if _, ok := l.(inlineMapLocator); ok {
+		data, err := b.spool.inlineMap()
+		if data == nil {
+			return nil, err
+		}
+		return []MapCandidate{{Data: data}}, nil
+	}
+	var cands []MapCandidate
+	var lerr error
+	err := b.spool.windows(true, func(_ int64, text string) bool {
+		cands, lerr = l.LocateMaps(text, scriptURL)
+		return lerr == nil && len(cands) == 0
+	})
+	if lerr != nil {
+		return nil, lerr
+	}
+	return cands, err
+}
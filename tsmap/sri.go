@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"io"
+	"slices"
+	"strings"
+)
+
+// sriCheck is the Subresource Integrity check of a script whose tag
+// carries an integrity attribute.
+type sriCheck struct {
+	Declared string `json:"declared"` // the integrity attribute
+	Actual   string `json:"actual"`   // digest of the body, with the strongest algorithm declared
+	Match    bool   `json:"match"`
+}
+
+// sriAlgorithms are the algorithms of Subresource Integrity, weakest first.
+var sriAlgorithms = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha256", sha256.New},
+	{"sha384", sha512.New384},
+	{"sha512", sha512.New},
+}
+
+// verifyIntegrity hashes r with the strongest algorithm of the integrity
+// attribute attr, the only one browsers check, and compares it to the digests
+// declared with it. It returns nil when attr declares no known algorithm.
+func verifyIntegrity(attr string, r io.Reader) (*sriCheck, error) {
+	alg := -1
+	var digests []string
+	for _, tok := range strings.Fields(attr) {
+		name, digest, ok := strings.Cut(tok, "-")
+		if !ok {
+			continue
+		}
+		digest, _, _ = strings.Cut(digest, "?") // options are reserved
+		i := sriAlgorithm(name)
+		switch {
+		case i > alg:
+			alg, digests = i, []string{digest}
+		case i == alg && i >= 0:
+			digests = append(digests, digest)
+		}
+	}
+	if alg < 0 {
+		return nil, nil
+	}
+	h := sriAlgorithms[alg].new()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	actual := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	return &sriCheck{
+		Declared: attr,
+		Actual:   sriAlgorithms[alg].name + "-" + actual,
+		Match:    slices.Contains(digests, actual),
+	}, nil
+}
+
+// sriAlgorithm returns the index of name in sriAlgorithms, or -1.
+func sriAlgorithm(name string) int {
+	for i, a := range sriAlgorithms {
+		if strings.EqualFold(a.name, name) {
+			return i
+		}
+	}
+	return -1
+}
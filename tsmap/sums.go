@@ -0,0 +1,229 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const sumsFile = "SHA256SUMS"
+
+// writeSums writes SHA256SUMS, in the format of sha256sum, for every file of
+// the output directory or archive, and signs it with a minisign secret key
+// when -sign-key is set.
+func (o *outputOptions) writeSums() error {
+	var lines []string
+	if o.tree != nil {
+		var err error
+		if lines, err = hashTree(o.root); err != nil {
+			return err
+		}
+	} else {
+		lines = o.writtenSums()
+	}
+	data := []byte(strings.Join(lines, "\n") + "\n")
+	if err := o.writeMeta(sumsFile, data); err != nil {
+		return err
+	}
+	logger.Info(msgSumsWritten.String(), "file", sumsFile, "files", len(lines))
+	if o.signKey == "" {
+		return nil
+	}
+	key, err := loadMinisignKey(o.signKey)
+	if err != nil {
+		return err
+	}
+	if err := o.writeMeta(sumsFile+".minisig", key.sign(data, sumsFile)); err != nil {
+		return err
+	}
+	logger.Info(msgSumsSigned.String(), "file", sumsFile+".minisig", "key_id", fmt.Sprintf("%016X", binary.LittleEndian.Uint64(key.id[:])))
+	return nil
+}
+
+// writtenSums lists the files written by this run, for output without a directory tree.
+func (o *outputOptions) writtenSums() []string {
+	o.hashMu.Lock()
+	defer o.hashMu.Unlock()
+	lines := make([]string, 0, len(o.hashes))
+	for name, sum := range o.hashes {
+		if name == sumsFile || name == sumsFile+".minisig" {
+			continue
+		}
+		lines = append(lines, sum+"  "+name)
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i][66:] < lines[j][66:] })
+	return lines
+}
+
+// hashTree returns the SHA256SUMS lines of every regular file under dir, but
+// for the repository of -git and a checkpoint.json, which are not evidence.
+func hashTree(dir string) ([]string, error) {
+	var lines []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		rel = filepath.ToSlash(rel)
+		if rel == sumsFile || rel == sumsFile+".minisig" || rel == checkpointName {
+			return nil
+		}
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, sum+"  "+rel)
+		return nil
+	})
+	sort.Slice(lines, func(i, j int) bool { return lines[i][66:] < lines[j][66:] })
+	return lines, err
+}
+
+func fileSHA256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// minisignKey is a decoded minisign secret key.
+type minisignKey struct {
+	id  [8]byte
+	key ed25519.PrivateKey
+}
+
+// loadMinisignKey reads a secret key created by "minisign -G". An encrypted
+// key is unlocked with $TSMAP_SIGN_PASSWORD, else with a password typed on the terminal.
+func loadMinisignKey(path string) (*minisignKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var raw []byte
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		if raw, err = base64.StdEncoding.DecodeString(line); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		break
+	}
+	// sig alg, kdf alg, checksum alg, salt, opslimit, memlimit, key id, secret key, checksum
+	if len(raw) != 2+2+2+32+8+8+8+64+32 || string(raw[:2]) != "Ed" || string(raw[4:6]) != "B2" {
+		return nil, fmt.Errorf("%s: not a minisign secret key", path)
+	}
+	secret := raw[54:]
+	switch string(raw[2:4]) {
+	case "\x00\x00":
+	case "Sc":
+		password := os.Getenv("TSMAP_SIGN_PASSWORD")
+		if password == "" {
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				return nil, errors.New("encrypted minisign key: set TSMAP_SIGN_PASSWORD")
+			}
+			fmt.Fprint(os.Stderr, "Password for "+path+": ")
+			b, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				return nil, err
+			}
+			password = string(b)
+		}
+		ops := binary.LittleEndian.Uint64(raw[38:46])
+		mem := binary.LittleEndian.Uint64(raw[46:54])
+		n, r, p := scryptParams(ops, mem)
+		stream, err := scrypt.Key([]byte(password), raw[6:38], n, r, p, len(secret))
+		if err != nil {
+			return nil, err
+		}
+		for i := range secret {
+			secret[i] ^= stream[i]
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported key derivation", path)
+	}
+	k := &minisignKey{key: ed25519.PrivateKey(secret[8:72])}
+	copy(k.id[:], secret[:8])
+	h, _ := blake2b.New256(nil)
+	h.Write(raw[:2])
+	h.Write(secret[:72])
+	if !bytes.Equal(h.Sum(nil), secret[72:]) {
+		return nil, fmt.Errorf("%s: wrong password or corrupted key", path)
+	}
+	return k, nil
+}
+
+// scryptParams converts libsodium opslimit/memlimit into scrypt N, r, p as
+// crypto_pwhash_scryptsalsa208sha256 does.
+func scryptParams(ops, mem uint64) (int, int, int) {
+	if ops < 32768 {
+		ops = 32768
+	}
+	const r = 8
+	logN := func(maxN uint64) uint {
+		n := uint(1)
+		for ; n < 63; n++ {
+			if uint64(1)<<n > maxN/2 {
+				break
+			}
+		}
+		return n
+	}
+	if ops < mem/32 {
+		return 1 << logN(ops/(r*4)), r, 1
+	}
+	n := logN(mem / (r * 128))
+	maxrp := (ops / 4) / (uint64(1) << n)
+	if maxrp > 0x3fffffff {
+		maxrp = 0x3fffffff
+	}
+	return 1 << n, r, int(maxrp / r)
+}
+
+// sign returns a prehashed minisign signature of data, verifiable with
+// "minisign -Vm <file> -p <public key>".
+func (k *minisignKey) sign(data []byte, name string) []byte {
+	digest := blake2b.Sum512(data)
+	sig := ed25519.Sign(k.key, digest[:])
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), name)
+	global := ed25519.Sign(k.key, append(append([]byte{}, sig...), trusted...))
+
+	var b bytes.Buffer
+	b.WriteString("untrusted comment: signature from tsmap-extract\n")
+	b.WriteString(base64.StdEncoding.EncodeToString(append(append([]byte("ED"), k.id[:]...), sig...)) + "\n")
+	b.WriteString("trusted comment: " + trusted + "\n")
+	b.WriteString(base64.StdEncoding.EncodeToString(global) + "\n")
+	return b.Bytes()
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// A key created by minisign, its public key, and the same key decrypted with
+// the password as "minisign -W" would write it.
+const (
+	minisignPlainKey = "untrusted comment: minisign secret key\n" +
+		"RWQAAEIytaz5znJmUO5kBt5xVkvpBl+29A7pZH86phD4h8vD3V8AAAACAAAAAAAAAEAAAAAAUIRnBzgZc8No/IJ584Ooy58pR9fDiA6frKn/clqCjEEfxI4gtNbGhYCoKyugkk4ioDfoxlXxC9LBx+VNhJ3w9w+cAxgvPsuoc3F2zlUdtwCEdVfs7zUi1TfW12XUD+IGlqDbWWtIj9o=\n"
+	minisignEncryptedKey = "untrusted comment: minisign encrypted secret key\n" +
+		"RWRTY0Iytaz5znJmUO5kBt5xVkvpBl+29A7pZH86phD4h8vD3V8AAAACAAAAAAAAAEAAAAAA9vH9EcS6NdXNIEGhYGoqG1CiL4aptyJreJ4IfuT4+1h+OgVaY/vi0HsbCP0Y6n/wcy0AN0wOXmVDPP33jZqv82YCj2fH+/6MRuAfzNQYoLvc3sH/8bIwqdfpKIjDRZhvqRf063RFYoI=\n"
+	minisignEncryptedPub = "RWRQhGcHOBlzw4CoKyugkk4ioDfoxlXxC9LBx+VNhJ3w9w+cAxgvPsuo"
+	minisignPassword     = "correct horse battery staple"
+)
+
+func TestScryptParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		ops, mem uint64
+		n, r, p  int
+	}{
+		{"sensitive (minisign)", 33554432, 1073741824, 1 << 20, 8, 1},
+		{"interactive", 524288, 16777216, 1 << 14, 8, 1},
+		{"ops bound", 32768, 1 << 30, 1 << 10, 8, 1},
+		{"ops raised to the minimum", 1, 1 << 30, 1 << 10, 8, 1},
+		{"mem bound", 1 << 30, 1 << 24, 1 << 14, 8, 2048},
+	}
+	for _, tt := range tests {
+		n, r, p := scryptParams(tt.ops, tt.mem)
+		if n != tt.n || r != tt.r || p != tt.p {
+			t.Errorf("%s: scryptParams(%d, %d) = %d, %d, %d, want %d, %d, %d", tt.name, tt.ops, tt.mem, n, r, p, tt.n, tt.r, tt.p)
+		}
+	}
+}
+
+func TestLoadMinisignKey(t *testing.T) {
+	corrupted := func(key string) string {
+		comment, b64, _ := strings.Cut(strings.TrimSpace(key), "\n")
+		raw, _ := base64.StdEncoding.DecodeString(b64)
+		raw[len(raw)-1] ^= 1
+		return comment + "\n" + base64.StdEncoding.EncodeToString(raw) + "\n"
+	}
+	pub, _ := base64.StdEncoding.DecodeString(minisignEncryptedPub)
+	tests := []struct {
+		name, key string
+		slow      bool   // scrypt with N=2^20, 1 GiB of memory
+		id        string // hex, "" when loading fails
+		public    []byte
+	}{
+		{name: "unencrypted", key: minisignPlainKey, id: hex.EncodeToString(pub[2:10]), public: pub[10:]},
+		{name: "encrypted", key: minisignEncryptedKey, slow: true, id: hex.EncodeToString(pub[2:10]), public: pub[10:]},
+		{name: "corrupted checksum", key: corrupted(minisignPlainKey)},
+		{name: "public key", key: "untrusted comment: minisign public key\n" + minisignEncryptedPub + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.slow && testing.Short() {
+				t.Skip("scrypt of minisign keys is slow")
+			}
+			t.Setenv("TSMAP_SIGN_PASSWORD", minisignPassword)
+			path := filepath.Join(t.TempDir(), "minisign.key")
+			if err := os.WriteFile(path, []byte(tt.key), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			k, err := loadMinisignKey(path)
+			if tt.id == "" {
+				if err == nil {
+					t.Fatal("loaded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(k.id[:]); got != tt.id {
+				t.Errorf("key id = %s, want %s", got, tt.id)
+			}
+			if got := k.key.Public().(ed25519.PublicKey); !bytes.Equal(got, tt.public) {
+				t.Errorf("public key = %x, want %x", got, tt.public)
+			}
+		})
+	}
+}
+
+func TestMinisignSign(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "minisign.key")
+	if err := os.WriteFile(path, []byte(minisignPlainKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := loadMinisignKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("Hello World!\n")
+	lines := strings.Split(string(k.sign(data, "message.txt")), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		t.Fatalf("signature file = %q", lines)
+	}
+	sig := mustB64(t, lines[1])
+	if len(sig) != 74 || string(sig[:2]) != "ED" || !bytes.Equal(sig[2:10], k.id[:]) {
+		t.Fatalf("signature line = %x", sig)
+	}
+	pub := ed25519.PublicKey(mustB64(t, minisignEncryptedPub)[10:])
+	digest := blake2b.Sum512(data)
+	if !ed25519.Verify(pub, digest[:], sig[10:]) {
+		t.Error("signature does not verify")
+	}
+	trusted := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !strings.HasSuffix(trusted, "\tfile:message.txt\thashed") {
+		t.Errorf("trusted comment = %q", trusted)
+	}
+	if !ed25519.Verify(pub, append(sig[10:], trusted...), mustB64(t, lines[3])) {
+		t.Error("global signature does not verify")
+	}
+}
+
+func mustB64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timestampMode is the -timestamps flag value. With "now", the default, files
+// are dated by their write. With "source", recovered sources take the date of
+// their map (Last-Modified, or the map file's own date) or, when unknown, the
+// start of the run, and scripts starting with #! are made executable, so that
+// a new run over unchanged maps leaves the dates of the tree alone.
+type timestampMode struct {
+	source  bool
+	started time.Time
+}
+
+func (t *timestampMode) String() string {
+	if t == nil || !t.source {
+		return "now"
+	}
+	return "source"
+}
+
+func (t *timestampMode) Set(v string) error {
+	switch strings.ToLower(v) {
+	case "now":
+		t.source = false
+	case "source":
+		t.source = true
+	default:
+		return fmt.Errorf("invalid -timestamps %q (now|source)", v)
+	}
+	return nil
+}
+
+// apply returns meta as handed to the sinks for data: ModTime and Executable
+// are only set for recovered sources with -timestamps source.
+func (t *timestampMode) apply(meta FileMeta, data []byte) FileMeta {
+	if t == nil || !t.source || meta.Source == "" {
+		meta.ModTime, meta.Executable = time.Time{}, false
+		return meta
+	}
+	if meta.ModTime.IsZero() {
+		meta.ModTime = t.started
+	}
+	meta.Executable = isExecutable(data)
+	return meta
+}
+
+// isExecutable tells whether data looks like a script run directly, starting
+// with a #! line.
+func isExecutable(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("#!"))
+}
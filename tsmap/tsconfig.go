@@ -0,0 +1,303 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"flag"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	tsconfigFile = "tsconfig.json"
+	srcIndexFile = "src-index.md"
+)
+
+// import prefixes resolved through compilerOptions.paths rather than node_modules
+var aliasPrefixes = []string{"@/", "~/", "#/", "$lib/", "src/"}
+
+// ECMAScript syntax giving a lower bound for compilerOptions.target, newest first.
+var targetFeatures = []struct {
+	target string
+	re     *regexp.Regexp
+}{
+	{"ES2022", regexp.MustCompile(`(?m)^\s*(?:static\s*\{|#[A-Za-z_$][\w$]*\s*[=;(])|\bawait\s+import\(|\.at\(-`)},
+	{"ES2021", regexp.MustCompile(`\?\?=|\|\|=|&&=|\b\d+_\d+\b`)},
+	{"ES2020", regexp.MustCompile(`\?\.[A-Za-z_$\[(]|\?\?[^=]|\b\d+n\b`)},
+	{"ES2018", regexp.MustCompile(`\.\.\.[A-Za-z_$]|for\s+await\s*\(`)},
+	{"ES2017", regexp.MustCompile(`\basync\s+(?:function\b|\(|[A-Za-z_$][\w$]*\s*=>)|\bawait\s`)},
+	{"ES2016", regexp.MustCompile(`[\w)\]]\s*\*\*\s*[\w(]`)},
+}
+
+var (
+	reJSXImportSource = regexp.MustCompile(`@jsxImportSource\s+(\S+)`)
+	reReactImport     = regexp.MustCompile(`\bimport\s+(?:\*\s+as\s+)?React\b`)
+	reESMSyntax       = regexp.MustCompile(`(?m)^\s*(?:import\s+[\w{*"'$]|export\s+(?:default|const|function|class|let|var|\{|\*|type|interface|enum|async))`)
+)
+
+// tsconfigBuilder infers a tsconfig.json and an index of the recovered
+// first-party sources (-tsconfig), so that the tree opens in an IDE. Crawl
+// workers call scan concurrently. A nil builder does nothing.
+type tsconfigBuilder struct {
+	enabled bool
+
+	mu        sync.Mutex
+	files     []string       // first-party sources, relative to the output root
+	aliases   map[string]int // import specifiers using an alias prefix -> count
+	target    int            // index in targetFeatures, len(targetFeatures) when none seen
+	esm, cjs  int
+	jsx       int // .jsx/.tsx files
+	jsxReact  int // .jsx/.tsx files importing React
+	jsxSource map[string]int
+	js        int // JavaScript files, which need allowJs
+}
+
+func addTsconfigFlags(fs *flag.FlagSet) *tsconfigBuilder {
+	b := &tsconfigBuilder{target: len(targetFeatures)}
+	fs.BoolVar(&b.enabled, "tsconfig", false, "Infer a tsconfig.json (jsx, target, module, path aliases) and write src-index.md so the recovered tree opens in an IDE")
+	return b
+}
+
+// scan records one written file; vendor code and non-script files are skipped.
+func (b *tsconfigBuilder) scan(rel string, data []byte, meta FileMeta) {
+	if b == nil || !b.enabled || isVendorSource(filterPath(rel)) || (meta.Source != "" && isVendorSource(filterPath(meta.Source))) {
+		return
+	}
+	ext := path.Ext(rel)
+	switch ext {
+	case ".ts", ".tsx", ".mts", ".cts", ".js", ".jsx", ".mjs", ".cjs", ".vue", ".svelte":
+	default:
+		return
+	}
+	text := string(data)
+	target := len(targetFeatures)
+	for i, f := range targetFeatures {
+		if f.re.MatchString(text) {
+			target = i
+			break
+		}
+	}
+	source := ""
+	if m := reJSXImportSource.FindStringSubmatch(text); m != nil {
+		source = m[1]
+	}
+	var aliased []string
+	for _, m := range reImport.FindAllStringSubmatch(text, -1) {
+		for _, p := range aliasPrefixes {
+			if strings.HasPrefix(m[1], p) {
+				aliased = append(aliased, m[1])
+				break
+			}
+		}
+		if source == "" && (importedPackage(m[1]) == "preact" || importedPackage(m[1]) == "solid-js") {
+			source = importedPackage(m[1])
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.aliases == nil {
+		b.aliases, b.jsxSource = make(map[string]int), make(map[string]int)
+	}
+	b.files = append(b.files, rel)
+	if target < b.target {
+		b.target = target
+	}
+	for _, a := range aliased {
+		b.aliases[a]++
+	}
+	if reESMSyntax.MatchString(text) {
+		b.esm++
+	} else if strings.Contains(text, "require(") || strings.Contains(text, "module.exports") {
+		b.cjs++
+	}
+	switch ext {
+	case ".js", ".jsx", ".mjs", ".cjs":
+		b.js++
+	}
+	if ext == ".tsx" || ext == ".jsx" {
+		b.jsx++
+		if reReactImport.MatchString(text) {
+			b.jsxReact++
+		}
+		if source != "" {
+			b.jsxSource[source]++
+		}
+	}
+}
+
+// resolveAliases maps each alias prefix to the directory its imports resolve
+// to in the recovered tree: "@/components/Button" and a recovered
+// "app/src/components/Button.tsx" give "@/*": ["app/src/*"].
+func (b *tsconfigBuilder) resolveAliases() map[string][]string {
+	stems := make(map[string]string, len(b.files)) // path without extension -> path
+	for _, f := range b.files {
+		stem := strings.TrimSuffix(f, path.Ext(f))
+		stems[stem] = f
+		stems[strings.TrimSuffix(stem, "/index")] = f
+	}
+	votes := make(map[string]map[string]int) // prefix -> directory -> imports
+	for spec, n := range b.aliases {
+		for _, p := range aliasPrefixes {
+			if !strings.HasPrefix(spec, p) {
+				continue
+			}
+			// "src/x" is a baseUrl import: keep src in the matched path
+			rest := spec
+			if p != "src/" {
+				rest = strings.TrimPrefix(spec, p)
+			}
+			for stem := range stems {
+				if stem != rest && !strings.HasSuffix(stem, "/"+rest) {
+					continue
+				}
+				dir := strings.TrimSuffix(strings.TrimSuffix(stem, rest), "/")
+				if p == "src/" {
+					dir = path.Join(dir, "src")
+				}
+				if votes[p] == nil {
+					votes[p] = make(map[string]int)
+				}
+				votes[p][dir] += n
+			}
+			break
+		}
+	}
+	out := make(map[string][]string)
+	for p, dirs := range votes {
+		best, max := "", 0
+		for _, d := range sortedKeys(dirs) {
+			if dirs[d] > max {
+				best, max = d, dirs[d]
+			}
+		}
+		if best == "" {
+			best = "."
+		}
+		out[p+"*"] = []string{best + "/*"}
+	}
+	return out
+}
+
+// includeRoots returns the directories holding first-party sources: the
+// directory of each "src" segment, or the top-level directory.
+func (b *tsconfigBuilder) includeRoots() []string {
+	roots := make(map[string]bool)
+	for _, f := range b.files {
+		switch i := strings.Index("/"+f, "/src/"); {
+		case i >= 0:
+			roots[path.Join(f[:i], "src")+"/**/*"] = true
+		case strings.Contains(f, "/"):
+			roots[strings.SplitN(f, "/", 2)[0]+"/**/*"] = true
+		default:
+			roots["*"] = true
+		}
+	}
+	return sortedKeys(roots)
+}
+
+// compilerOptions infers the options from what scan saw.
+func (b *tsconfigBuilder) compilerOptions() map[string]any {
+	target := "ES2015"
+	if b.target < len(targetFeatures) {
+		target = targetFeatures[b.target].target
+	}
+	opts := map[string]any{
+		"target":                       target,
+		"lib":                          []string{"DOM", "DOM.Iterable", target},
+		"module":                       "ESNext",
+		"moduleResolution":             "bundler",
+		"esModuleInterop":              true,
+		"resolveJsonModule":            true,
+		"isolatedModules":              true,
+		"skipLibCheck":                 true,
+		"noEmit":                       true,
+		"strict":                       false,
+		"allowSyntheticDefaultImports": true,
+	}
+	if b.cjs > b.esm {
+		opts["module"], opts["moduleResolution"] = "CommonJS", "node"
+	}
+	if b.js > 0 {
+		opts["allowJs"] = true
+	}
+	if b.jsx > 0 {
+		source := ""
+		for _, s := range sortedKeys(b.jsxSource) {
+			if source == "" || b.jsxSource[s] > b.jsxSource[source] {
+				source = s
+			}
+		}
+		switch {
+		case source == "solid-js":
+			opts["jsx"] = "preserve"
+			opts["jsxImportSource"] = source
+		case source != "":
+			opts["jsx"] = "react-jsx"
+			opts["jsxImportSource"] = source
+		case b.jsxReact == b.jsx:
+			opts["jsx"] = "react"
+		default:
+			opts["jsx"] = "react-jsx"
+		}
+	}
+	if aliases := b.resolveAliases(); len(aliases) > 0 {
+		opts["baseUrl"] = "."
+		opts["paths"] = aliases
+	}
+	return opts
+}
+
+// srcIndex lists the first-party sources grouped by directory.
+func (b *tsconfigBuilder) srcIndex() string {
+	byDir := make(map[string][]string)
+	for _, f := range b.files {
+		dir := path.Dir(f)
+		byDir[dir] = append(byDir[dir], path.Base(f))
+	}
+	var sb strings.Builder
+	sb.WriteString("# Recovered sources\n\n")
+	for _, dir := range sortedKeys(byDir) {
+		files := byDir[dir]
+		sort.Strings(files)
+		sb.WriteString("## " + dir + "\n\n")
+		for _, f := range files {
+			sb.WriteString("- [" + f + "](" + path.Join(dir, f) + ")\n")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// save writes tsconfig.json and src-index.md in the output root.
+func (b *tsconfigBuilder) save(o *outputOptions) {
+	if b == nil || !b.enabled {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	opts := b.compilerOptions()
+	jsx, _ := opts["jsx"].(string)
+	aliases, _ := opts["paths"].(map[string][]string)
+	logger.Info(msgTsconfigSummary.String(), "files", len(b.files), "target", opts["target"], "module", opts["module"], "jsx", jsx, "aliases", len(aliases))
+	if o.dryRun || len(b.files) == 0 {
+		return
+	}
+	cfg := map[string]any{
+		"compilerOptions": opts,
+		"include":         b.includeRoots(),
+		"exclude":         []string{"**/node_modules"},
+	}
+	data, _ := json.MarshalIndent(cfg, "", "  ")
+	if err := o.writeMeta(tsconfigFile, append(data, '\n')); err != nil {
+		logger.Warn(msgTsconfigError.String(), "err", err)
+		return
+	}
+	if err := o.writeMeta(srcIndexFile, []byte(b.srcIndex())); err != nil {
+		logger.Warn(msgTsconfigError.String(), "err", err)
+	}
+}
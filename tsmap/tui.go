@@ -0,0 +1,334 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+var errHostSkipped = errors.New("host skipped")
+
+// activeTUI lets fail() restore the terminal before exiting.
+var activeTUI atomic.Pointer[crawlTUI]
+
+// crawlTUI is the live screen shown by "crawl -tui". A nil *crawlTUI is valid and does nothing.
+//
+// Keys: p pause/resume, up/down (or k/j) select a host, s skip/unskip the host, q quit.
+type crawlTUI struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	root     string
+	scripts  []*tuiScript
+	byURL    map[string]*tuiScript
+	hosts    map[string]*tuiHost
+	selected int
+	paused   bool
+	quitting bool
+	logs     []string
+	closed   bool
+
+	oldState *term.State
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type tuiScript struct {
+	url   string
+	host  string
+	state string
+	srcs  int
+}
+
+type tuiHost struct {
+	name     string
+	scripts  int
+	requests int
+	bytes    int64
+	chunks   int
+	sources  int
+	skipped  bool
+}
+
+const tuiMaxLogs = 200
+
+// newCrawlTUI switches the terminal to the alternate screen and raw mode.
+func newCrawlTUI(root string) (*crawlTUI, error) {
+	if !isTerminal(os.Stdout) || !isTerminal(os.Stdin) || !enableVT(os.Stdout) {
+		return nil, errors.New("-tui needs an interactive terminal")
+	}
+	old, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	t := &crawlTUI{
+		root:     root,
+		byURL:    make(map[string]*tuiScript),
+		hosts:    make(map[string]*tuiHost),
+		oldState: old,
+		stop:     make(chan struct{}),
+	}
+	t.cond = sync.NewCond(&t.mu)
+	activeTUI.Store(t)
+	fmt.Fprint(os.Stdout, "\033[?1049h\033[?25l")
+	t.wg.Add(1)
+	go t.loop()
+	go t.readKeys()
+	return t, nil
+}
+
+// Write receives console log lines while the screen is active, and passes
+// them through to stdout once it is closed.
+func (t *crawlTUI) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return os.Stdout.Write(p)
+	}
+	for _, ln := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.logs = append(t.logs, ln)
+	}
+	if len(t.logs) > tuiMaxLogs {
+		t.logs = t.logs[len(t.logs)-tuiMaxLogs:]
+	}
+	return len(p), nil
+}
+
+func (t *crawlTUI) close() {
+	if t == nil || !activeTUI.CompareAndSwap(t, nil) {
+		return
+	}
+	close(t.stop)
+	t.wg.Wait()
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	fmt.Fprint(os.Stdout, "\033[?25h\033[?1049l")
+	_ = term.Restore(int(os.Stdin.Fd()), t.oldState)
+}
+
+func (t *crawlTUI) loop() {
+	defer t.wg.Done()
+	tick := time.NewTicker(250 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-tick.C:
+			t.render()
+		}
+	}
+}
+
+func (t *crawlTUI) readKeys() {
+	buf := make([]byte, 8)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			return
+		}
+		switch key := string(buf[:n]); key {
+		case "p", "P", " ":
+			t.paused = !t.paused
+		case "k", "\033[A":
+			if t.selected > 0 {
+				t.selected--
+			}
+		case "j", "\033[B":
+			if t.selected < len(t.hosts)-1 {
+				t.selected++
+			}
+		case "s", "S":
+			if names := t.hostNames(); t.selected < len(names) {
+				h := t.hosts[names[t.selected]]
+				h.skipped = !h.skipped
+			}
+		case "q", "Q", "\x03":
+			// stop issuing requests; the crawl drains and the report is still written
+			t.quitting = true
+			t.paused = false
+		}
+		t.cond.Broadcast()
+		t.mu.Unlock()
+	}
+}
+
+func (t *crawlTUI) host(name string) *tuiHost {
+	h, ok := t.hosts[name]
+	if !ok {
+		h = &tuiHost{name: name}
+		t.hosts[name] = h
+	}
+	return h
+}
+
+// hostNames returns hosts in display order; callers hold t.mu.
+func (t *crawlTUI) hostNames() []string {
+	names := make([]string, 0, len(t.hosts))
+	for n := range t.hosts {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// gate blocks while paused and refuses requests to skipped hosts.
+func (t *crawlTUI) gate(host string) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.paused && !t.quitting {
+		t.cond.Wait()
+	}
+	if t.quitting || t.host(host).skipped {
+		return errHostSkipped
+	}
+	return nil
+}
+
+func (t *crawlTUI) fetched(host string, n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.host(host)
+	h.requests++
+	h.bytes += int64(n)
+}
+
+func (t *crawlTUI) scriptState(u, host, state string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.byURL[u]
+	if !ok {
+		s = &tuiScript{url: u, host: host}
+		t.byURL[u] = s
+		t.scripts = append(t.scripts, s)
+		t.host(host).scripts++
+	}
+	s.state = state
+}
+
+func (t *crawlTUI) chunks(host string, n int) {
+	if t == nil || n == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.host(host).chunks += n
+}
+
+func (t *crawlTUI) written(u, host string, n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.byURL[u]; ok {
+		s.srcs += n
+	}
+	t.host(host).sources += n
+}
+
+func (t *crawlTUI) render() {
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || w < 40 || h < 12 {
+		w, h = 100, 30
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var lines []string
+	status := msgTUIRunning.String()
+	switch {
+	case t.quitting:
+		status = cYel + msgTUIQuitting.String() + cRst
+	case t.paused:
+		status = cYel + msgTUIPaused.String() + cRst
+	}
+	lines = append(lines, fmt.Sprintf("%stsmap-extract crawl%s %s  [%s]", cCyn, cRst, t.root, status))
+	lines = append(lines, "")
+	lines = append(lines, fmt.Sprintf("  %-32s %7s %6s %10s %7s %8s", "HOST", "SCRIPTS", "REQS", "BYTES", "CHUNKS", "SOURCES"))
+	for i, name := range t.hostNames() {
+		hs := t.hosts[name]
+		mark := "  "
+		if i == t.selected {
+			mark = "> "
+		}
+		row := fmt.Sprintf("%s%-32s %7d %6d %10s %7d %8d", mark, clip(name, 32), hs.scripts, hs.requests, humanBytes(hs.bytes), hs.chunks, hs.sources)
+		if hs.skipped {
+			row += " " + cRed + msgTUISkipped.String() + cRst
+		}
+		lines = append(lines, row)
+	}
+	lines = append(lines, "")
+
+	// scripts: show the most recent ones that fit in the upper half
+	room := h/2 - len(lines)
+	if room < 3 {
+		room = 3
+	}
+	start := 0
+	if len(t.scripts) > room {
+		start = len(t.scripts) - room
+	}
+	for _, s := range t.scripts[start:] {
+		lines = append(lines, fmt.Sprintf("  %-10s %5d  %s", s.state, s.srcs, clip(s.url, w-20)))
+	}
+	lines = append(lines, "")
+
+	// log tail fills the rest, minus the footer
+	room = h - len(lines) - 1
+	if room > 0 {
+		start = 0
+		if len(t.logs) > room {
+			start = len(t.logs) - room
+		}
+		for _, l := range t.logs[start:] {
+			lines = append(lines, clip(l, w))
+		}
+	}
+	for len(lines) < h-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, msgTUIKeys.String())
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for i, l := range lines {
+		b.WriteString(l)
+		b.WriteString("\033[K")
+		if i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	fmt.Fprint(os.Stdout, b.String())
+}
+
+// clip shortens s to at most n bytes, keeping the tail which is usually the interesting part of a URL.
+func clip(s string, n int) string {
+	if n <= 3 || len(s) <= n {
+		return s
+	}
+	return "..." + s[len(s)-n+3:]
+}
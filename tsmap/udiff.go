@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxDiffEdits bounds the Myers search; files changed more than this are only
+// reported as modified.
+const maxDiffEdits = 4000
+
+type editOp byte
+
+const (
+	opEqual editOp = ' '
+	opDel   editOp = '-'
+	opIns   editOp = '+'
+)
+
+type edit struct {
+	op   editOp
+	a, b int // line index in old and new
+}
+
+// diffLines returns the shortest edit script from a to b (Myers), or false when
+// it needs more than maxDiffEdits insertions and deletions.
+func diffLines(a, b []string) ([]edit, bool) {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > 2*maxDiffEdits {
+		max = 2 * maxDiffEdits
+	}
+	off := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, off, n, m), true
+			}
+		}
+	}
+	return nil, false
+}
+
+func backtrack(trace [][]int, off, x, y int) []edit {
+	var out []edit
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var pk int
+		if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := v[off+pk]
+		py := px - pk
+		for x > px && y > py {
+			x, y = x-1, y-1
+			out = append(out, edit{opEqual, x, y})
+		}
+		if d > 0 {
+			if x == px {
+				y--
+				out = append(out, edit{opIns, x, y})
+			} else {
+				x--
+				out = append(out, edit{opDel, x, y})
+			}
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// splitLines splits text into lines, keeping a final line without newline.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// writeUnified prints a unified diff of oldText and newText with ctx lines of
+// context. It returns false when the files differ too much to be diffed.
+func writeUnified(w io.Writer, oldName, newName, oldText, newText string, ctx int) bool {
+	a, b := splitLines(oldText), splitLines(newText)
+	edits, ok := diffLines(a, b)
+	if !ok {
+		return false
+	}
+	fmt.Fprintf(w, "%s--- %s%s\n%s+++ %s%s\n", cRed, oldName, cRst, cGrn, newName, cRst)
+	for i := 0; i < len(edits); {
+		if edits[i].op == opEqual {
+			i++
+			continue
+		}
+		// a hunk spans changes separated by at most 2*ctx equal lines
+		start := i - ctx
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(edits) {
+			if edits[end].op != opEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(edits) && edits[run].op == opEqual {
+				run++
+			}
+			if run == len(edits) || run-end > 2*ctx {
+				end += min(ctx, run-end)
+				break
+			}
+			end = run
+		}
+		printHunk(w, a, b, edits[start:end])
+		i = end
+	}
+	return true
+}
+
+func printHunk(w io.Writer, a, b []string, h []edit) {
+	oldStart, newStart := h[0].a, h[0].b
+	oldLen, newLen := 0, 0
+	for _, e := range h {
+		if e.op != opIns {
+			oldLen++
+		}
+		if e.op != opDel {
+			newLen++
+		}
+	}
+	fmt.Fprintf(w, "%s@@ -%s +%s @@%s\n", cCyn, hunkRange(oldStart, oldLen), hunkRange(newStart, newLen), cRst)
+	for _, e := range h {
+		var line, color string
+		switch e.op {
+		case opEqual:
+			line = a[e.a]
+		case opDel:
+			line, color = a[e.a], cRed
+		case opIns:
+			line, color = b[e.b], cGrn
+		}
+		text := strings.TrimSuffix(line, "\n")
+		if color != "" {
+			fmt.Fprintf(w, "%s%c%s%s\n", color, e.op, text, cRst)
+		} else {
+			fmt.Fprintf(w, "%c%s\n", e.op, text)
+		}
+		if !strings.HasSuffix(line, "\n") {
+			fmt.Fprintln(w, `\ No newline at end of file`)
+		}
+	}
+}
+
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
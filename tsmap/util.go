@@ -3,10 +3,12 @@
 package tsmap
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 )
@@ -21,8 +23,72 @@ func buildAnchors(outDir string, depth int) (string, string) {
 	return base, sub
 }
 
+// sourceAnchor places the sources of one map under outDir. Every source is
+// joined below as many anchor levels as the deepest leading "../", so sources
+// keep their relative layout and none escapes outDir. Crawl and extract both
+// go through it, so that the same map gives the same tree.
+type sourceAnchor struct {
+	outDir, base, sub string
+}
+
+func newSourceAnchor(outDir string, sm *SourceMap) sourceAnchor {
+	base, sub := buildAnchors(outDir, maxLeadingUps(sm))
+	return sourceAnchor{outDir: outDir, base: base, sub: sub}
+}
+
+// place returns the file of source i once anchored and renamed by -rename;
+// an error means the path is blocked.
+func (a sourceAnchor) place(o *outputOptions, sm *SourceMap, i int) (string, error) {
+	rel, _, err := resolveUnderAnchor(a.outDir, a.base, a.sub, normalizeKeepDots(sm.ResolvedSource(i)), o.scrubbed)
+	if err != nil {
+		return "", err
+	}
+	_, abs, err := o.renamePath(a.outDir, rel)
+	if err == nil && inGitDir(o.root, abs) {
+		return "", errGitDir
+	}
+	return abs, err
+}
+
+var errGitDir = errors.New("refusing to write into a .git directory")
+
+// inGitDir reports whether p, a file below root, is in a .git directory:
+// recovered sources and saved files come from the target and must not reach
+// the configuration or the hooks of the -git repository, nor make nested ones,
+// which git refuses to add. Case is ignored, as on the filesystems that fold it.
+func inGitDir(root, p string) bool {
+	if root != "" {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return false
+		}
+		p = rel
+	}
+	for _, seg := range strings.Split(filepath.ToSlash(p), "/") {
+		if strings.EqualFold(seg, ".git") {
+			return true
+		}
+	}
+	return false
+}
+
+// maxLeadingUps is the largest number of leading "../" among the sources of
+// sm, ignoring those shipped with a blank content.
+func maxLeadingUps(sm *SourceMap) int {
+	maxUp := 0
+	for i := range sm.Sources {
+		if sm.listsContent(i) && !sm.HasContent(i) {
+			continue
+		}
+		if n := countLeadingUps(normalizeKeepDots(sm.ResolvedSource(i))); n > maxUp {
+			maxUp = n
+		}
+	}
+	return maxUp
+}
+
 // joint sur subAnchor, clean, bloque si sort de baseAnchor, renvoie rel(outDir) + abs(outDir)
-func resolveUnderAnchor(outDir, baseAnchor, subAnchor, normKeep string) (string, string, error) {
+func resolveUnderAnchor(outDir, baseAnchor, subAnchor, normKeep string, log *scrubLog) (string, string, error) {
 	tmp := filepath.Join(subAnchor, filepath.FromSlash(normKeep))
 	clean := filepath.Clean(tmp)
 	if err := mustBeUnder(baseAnchor, clean); err != nil {
@@ -32,7 +98,7 @@ func resolveUnderAnchor(outDir, baseAnchor, subAnchor, normKeep string) (string,
 	if err != nil {
 		return "", "", err
 	}
-	relFromBase = sanitizeSegments(relFromBase)
+	relFromBase = sanitizeSegments(relFromBase, log)
 	if relFromBase == "" || relFromBase == "." {
 		relFromBase = "unnamed"
 	}
@@ -102,12 +168,13 @@ func mustBeUnder(base, target string) error {
 	return nil
 }
 
-// nettoie chaque segment (caract. douteux, vide -> "unnamed")
-func sanitizeSegments(p string) string {
+// nettoie chaque segment (NFC, caract. invisibles ou douteux, vide -> "unnamed"),
+// les segments modifies sont notes dans log
+func sanitizeSegments(p string, log *scrubLog) string {
 	parts := strings.Split(filepath.FromSlash(p), "/")
 	out := make([]string, 0, len(parts))
 	for _, seg := range parts {
-		seg = strings.TrimSpace(seg)
+		seg = strings.TrimSpace(scrubSegment(seg, log))
 		if seg == "" || seg == "." || seg == ".." {
 			seg = "unnamed"
 		}
@@ -126,34 +193,37 @@ func replaceWeird(s string) string {
 	s = strings.ReplaceAll(s, "|", "_")
 	s = strings.ReplaceAll(s, "?", "_")
 	s = strings.ReplaceAll(s, "*", "_")
-	return s
+	return escapeReserved(s)
 }
 
-// ------------------------------------------------------------------
-// Small utilities: beautify, EOL, joinMaybe, fail
-// ------------------------------------------------------------------
+// Windows reserves these device names, with or without an extension (con.ts, NUL.js).
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true, "CONIN$": true, "CONOUT$": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
 
-func beautifyBasic(s string) string {
-	r := strings.NewReplacer(";", ";\n", "{", "{\n", "}", "}\n")
-	s = r.Replace(s)
-	var buf bytes.Buffer
-	prevBlank := false
-	for _, ln := range strings.Split(s, "\n") {
-		line := strings.TrimRight(ln, " \t")
-		if line == "" {
-			if prevBlank {
-				continue
-			}
-			prevBlank = true
-		} else {
-			prevBlank = false
+// escapeReserved makes a segment usable on Windows: reserved device names get a
+// "_" suffix on their base name and trailing dots and spaces are replaced.
+// It is applied on every OS so that output trees stay portable.
+func escapeReserved(seg string) string {
+	if t := strings.TrimRight(seg, ". "); t != seg {
+		seg = t + strings.Repeat("_", len(seg)-len(t))
+	}
+	base, ext, _ := strings.Cut(seg, ".")
+	if reservedNames[strings.ToUpper(base)] {
+		if ext != "" {
+			return base + "_." + ext
 		}
-		buf.WriteString(line)
-		buf.WriteByte('\n')
+		return base + "_"
 	}
-	return buf.String()
+	return seg
 }
 
+// ------------------------------------------------------------------
+// Small utilities: EOL, joinMaybe, fail
+// ------------------------------------------------------------------
+
 func normalizeEOL(s, mode string) string {
 	switch strings.ToLower(mode) {
 	case "unix":
@@ -174,8 +244,73 @@ func joinMaybe(root, p string) string {
 	return strings.TrimRight(root, "/\\") + "/" + strings.TrimLeft(p, "/\\")
 }
 
-func fail(format string, a ...any) {
-	fmt.Printf("%sError:%s ", cRed, cRst)
-	fmt.Printf(format+"\n", a...)
-	os.Exit(2)
+// Exit codes returned by the subcommands.
+const (
+	exitOK        = 0 // sources recovered
+	exitNoSources = 1 // ran fine but nothing was recovered
+	exitUsage     = 2 // invalid flags or arguments
+	exitFatal     = 3 // fatal error, or any per-script error with -strict
+	exitSeverity  = 4 // exposure severity reached -fail-on
+)
+
+// fail reports a fatal error and exits with exitFatal.
+func fail(m message, a ...any) {
+	activeTUI.Load().close()
+	logger.Error(m.format(a...))
+	os.Exit(exitFatal)
+}
+
+// usageFail reports an invalid flag or argument and exits with exitUsage.
+func usageFail(m message, a ...any) {
+	activeTUI.Load().close()
+	logger.Error(m.format(a...))
+	os.Exit(exitUsage)
+}
+
+// headerList collects repeatable "-header 'Name: value'" flags.
+type headerList []string
+
+func (h *headerList) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerList) reset() { *h = nil }
+
+func (h *headerList) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("invalid header %q, expected 'Name: value'", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+// Header converts the collected flags to an http.Header.
+func (h headerList) Header() http.Header {
+	out := http.Header{}
+	for _, raw := range h {
+		k, v, _ := strings.Cut(raw, ":")
+		out.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+	return out
+}
+
+// interruptContext returns a context canceled by the first Ctrl-C, so that a
+// run stops fetching and writing and saves what it has; the default handling
+// is restored then, and a second Ctrl-C kills the process. stop releases the
+// signal.
+func interruptContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	go func() {
+		select {
+		case <-ch:
+			signal.Stop(ch)
+			logger.Warn(msgInterrupted.String())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(ch)
+		cancel()
+	}
 }
@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSourceAnchorPlace(t *testing.T) {
+	tests := []struct {
+		name       string
+		sources    []string
+		sourceRoot string
+		blank      []int    // sources shipped with a blank content
+		namespaces bool     // -keep-namespace
+		want       []string // relative to the output, slash separated; "" when blocked
+	}{
+		{
+			name:    "plain",
+			sources: []string{"src/a.ts", "./src/b.ts", "index.ts"},
+			want:    []string{"src/a.ts", "src/b.ts", "index.ts"},
+		},
+		{
+			name:    "leading ups",
+			sources: []string{"../../lib/a.ts", "../app/b.ts", "c.ts"},
+			want:    []string{"lib/a.ts", "level/app/b.ts", "level/level/c.ts"},
+		},
+		{
+			name:       "ups in sourceRoot",
+			sources:    []string{"x.ts", "../y.ts"},
+			sourceRoot: "../src/",
+			want:       []string{"src/x.ts", "y.ts"},
+		},
+		{
+			name:    "blank content ignored for depth",
+			sources: []string{"../../../gone.ts", "src/a.ts"},
+			blank:   []int{0},
+			want:    []string{"", "src/a.ts"},
+		},
+		{
+			name:    "webpack",
+			sources: []string{"webpack:///./src/a.ts", "webpack:///../node_modules/lib/i.js", "webpack:///webpack/bootstrap"},
+			want:    []string{"level/src/a.ts", "node_modules/lib/i.js", "level/webpack/bootstrap"},
+		},
+		{
+			name:    "webpack namespace",
+			sources: []string{"webpack://my-app/./src/a.ts", "webpack://my-app/../lib/b.ts"},
+			want:    []string{"my-app/src/a.ts", "lib/b.ts"},
+		},
+		{
+			name:       "webpack namespace kept",
+			sources:    []string{"webpack://my-app/./src/a.ts", "webpack://my-app/../../lib/b.ts", "webpack://other/x.ts"},
+			namespaces: true,
+			want:       []string{"my-app/src/a.ts", "my-app/lib/b.ts", "other/x.ts"},
+		},
+		{
+			name:    "other schemes",
+			sources: []string{"file:///home/dev/app/a.ts", "vscode://app/b.ts"},
+			want:    []string{"home/dev/app/a.ts", "app/b.ts"},
+		},
+		{
+			name:    "reserved names",
+			sources: []string{"src/CON.ts", "aux", "src/nul/x.ts", "lib/lpt1.d.ts", "src/ComPonent/com10.ts", "dots./x.ts"},
+			want:    []string{"src/CON_.ts", "aux_", "src/nul_/x.ts", "lib/lpt1_.d.ts", "src/ComPonent/com10.ts", "dots_/x.ts"},
+		},
+		{
+			name:    "weird characters",
+			sources: []string{"src/a<b>.ts", "src/q?.ts", "src/x|y*.ts"},
+			want:    []string{"src/a_b_.ts", "src/q_.ts", "src/x_y_.ts"},
+		},
+		{
+			name:    "absolute",
+			sources: []string{"/etc/passwd", `C:\Users\dev\a.ts`, "//server/share/b.ts"},
+			want:    []string{"etc/passwd", "Users/dev/a.ts", "server/share/b.ts"},
+		},
+		{
+			name:    "escaping",
+			sources: []string{"src/../../../etc/passwd", "a/../../b.ts", "ok.ts"},
+			want:    []string{"", "", "ok.ts"},
+		},
+		{
+			name:    "escaping past the anchor",
+			sources: []string{"../x/../../../y.ts", "../ok.ts"},
+			want:    []string{"", "ok.ts"},
+		},
+		{
+			name:    "inner ups staying inside",
+			sources: []string{"src/lib/../a.ts", "../x/../b.ts"},
+			want:    []string{"level/src/a.ts", "b.ts"},
+		},
+		{
+			name:    "git directory",
+			sources: []string{".git/config", "src/.Git/hooks/pre-commit", "src/.gitignore", "../.git/HEAD"},
+			want:    []string{"", "", "level/src/.gitignore", ""},
+		},
+		{
+			name:    "empty",
+			sources: []string{"", "./", "webpack:///"},
+			want:    []string{"unnamed", "unnamed", "unnamed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := &SourceMap{Sources: tt.sources, SourceRoot: tt.sourceRoot}
+			for i := range tt.sources {
+				content := "x"
+				if slices.Contains(tt.blank, i) {
+					content = ""
+				}
+				sm.SourcesContent = append(sm.SourcesContent, content)
+			}
+			if tt.namespaces {
+				keepNamespaces(sm)
+			}
+			outDir := t.TempDir()
+			o := &outputOptions{scrubbed: &scrubLog{}}
+			a := newSourceAnchor(outDir, sm)
+			for i := range tt.sources {
+				abs, err := a.place(o, sm, i)
+				if tt.want[i] == "" {
+					if err == nil {
+						t.Errorf("%q: placed at %q, want blocked", tt.sources[i], abs)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("%q: %v", tt.sources[i], err)
+					continue
+				}
+				if want := filepath.Join(outDir, filepath.FromSlash(tt.want[i])); abs != want {
+					t.Errorf("%q: placed at %q, want %q", tt.sources[i], abs, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeKeepDots(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ups      int
+	}{
+		{"src/a.ts", "src/a.ts", 0},
+		{"  ../a.ts ", "../a.ts", 1},
+		{"../../a/../b.ts", "../../a/../b.ts", 2},
+		{"webpack:///../../x.ts", "../../x.ts", 2},
+		{"webpack://ns/../x.ts", "ns/../x.ts", 0},
+		{"webpack:///webpack://x.ts", "webpack:/x.ts", 0},
+		{`..\..\win\a.ts`, "../../win/a.ts", 2},
+		{"file:///C:/dev/a.ts", "dev/a.ts", 0},
+		{"D:relative.ts", "relative.ts", 0},
+		{"///a//b///c.ts", "a/b/c.ts", 0},
+		{"./../a.ts", "./../a.ts", 0},
+	}
+	for _, tt := range tests {
+		got := normalizeKeepDots(tt.in)
+		if got != tt.want {
+			t.Errorf("normalizeKeepDots(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if n := countLeadingUps(got); n != tt.ups {
+			t.Errorf("countLeadingUps(%q) = %d, want %d", got, n, tt.ups)
+		}
+	}
+}
+
+func TestMustBeUnder(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "out")
+	tests := []struct {
+		target string
+		ok     bool
+	}{
+		{base, true},
+		{filepath.Join(base, "a", "b.ts"), true},
+		{filepath.Join(base, "..foo"), true},
+		{filepath.Join(base, "a", "..", "b.ts"), true},
+		{filepath.Join(base, ".."), false},
+		{filepath.Join(base, "..", "out2", "a.ts"), false},
+		{base + "2", false},
+		{filepath.Dir(base), false},
+	}
+	for _, tt := range tests {
+		if err := mustBeUnder(base, tt.target); (err == nil) != tt.ok {
+			t.Errorf("mustBeUnder(%q, %q) = %v, want ok %v", base, tt.target, err, tt.ok)
+		}
+	}
+}
+
+func TestEscapeReserved(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"CON", "CON_"},
+		{"con.ts", "con_.ts"},
+		{"Nul.d.ts", "Nul_.d.ts"},
+		{"CONIN$", "CONIN$_"},
+		{"com9.js", "com9_.js"},
+		{"com0.js", "com0.js"},
+		{"console.ts", "console.ts"},
+		{"x.", "x_"},
+		{"x. .", "x___"},
+		{"aux.", "aux_"},
+		{"index.ts", "index.ts"},
+	}
+	for _, tt := range tests {
+		if got := escapeReserved(tt.in); got != tt.want {
+			t.Errorf("escapeReserved(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeSegmentsScrubLog(t *testing.T) {
+	log := &scrubLog{}
+	got := sanitizeSegments("src/a\u200b.ts/../ok.ts", log)
+	want := filepath.Join("src", "a.ts", "unnamed", "ok.ts")
+	if got != want {
+		t.Errorf("sanitizeSegments = %q, want %q", got, want)
+	}
+	if !slices.Equal(log.list(), []scrubbedName{{From: "a\u200b.ts", To: "a.ts"}}) {
+		t.Errorf("scrubbed = %q", log.list())
+	}
+	// URL-derived names are not logged
+	if got := sanitizeSegments("x\u200b.js", nil); got != "x.js" {
+		t.Errorf("sanitizeSegments without log = %q", got)
+	}
+}
@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// severity of a validation problem.
+type severity string
+
+const (
+	sevError   severity = "error"   // the map is broken or misleading for consumers
+	sevWarning severity = "warning" // the map works but something is likely wrong
+	sevInfo    severity = "info"    // worth knowing, e.g. no sourcesContent
+)
+
+// lintProblem is one finding of the "validate" subcommand.
+type lintProblem struct {
+	Severity severity `json:"severity"`
+	Where    string   `json:"where"`
+	Message  string   `json:"message"`
+}
+
+type validateFlags struct {
+	mapPath string
+	json    bool
+	strict  bool
+	http    *httpOptions
+	log     *logOptions
+}
+
+func newValidateFlags() (*flag.FlagSet, *validateFlags) {
+	f := &validateFlags{}
+	fs := flag.NewFlagSet("tsmap-extract validate", flag.ExitOnError)
+	fs.StringVar(&f.mapPath, "map", "", "Path or http(s) URL of the .map file")
+	fs.BoolVar(&f.json, "json", false, "Print the problems as a JSON array")
+	fs.BoolVar(&f.strict, "strict", false, "Also fail on warnings")
+	fs.String("config", "", "YAML config file (default ~/.config/tsmap-extract/config.yaml)")
+	f.http = addHTTPFlags(fs)
+	f.log = addLogFlags(fs)
+	return fs, f
+}
+
+// RunValidate runs the "validate" subcommand: it exits 0 for a valid map, 1 when
+// errors (or warnings with -strict) were found and 3 when the map cannot be read.
+func RunValidate(args []string) int {
+	useConsoleLogger()
+	fs, f := newValidateFlags()
+	loadDefaults(fs, "validate", args)
+	fs.Parse(args)
+	if f.json {
+		f.log.console = os.Stderr
+	}
+	defer f.log.setup()()
+
+	if strings.TrimSpace(f.mapPath) == "" {
+		logger.Error(msgMissingMap.String())
+		fs.Usage()
+		return exitUsage
+	}
+	raw, err := readMapFile(context.Background(), f.mapPath, f.http)
+	if err != nil {
+		fail(msgReadMap, err)
+	}
+	var sm SourceMap
+	if err := json.Unmarshal(unwrapMapJSON(raw), &sm); err != nil {
+		fail(msgInvalidMapJSON, err)
+	}
+	problems := lintSourceMap(sm, "")
+
+	errs, warns := 0, 0
+	for _, p := range problems {
+		switch p.Severity {
+		case sevError:
+			errs++
+		case sevWarning:
+			warns++
+		}
+	}
+	if f.json {
+		data, _ := json.MarshalIndent(problems, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		for _, p := range problems {
+			color := cCyn
+			switch p.Severity {
+			case sevError:
+				color = cRed
+			case sevWarning:
+				color = cYel
+			}
+			fmt.Printf("%s%-7s%s %s: %s\n", color, p.Severity, cRst, p.Where, p.Message)
+		}
+		logger.Info(msgValidateSummary.String(), "errors", errs, "warnings", warns)
+	}
+	if errs > 0 || (f.strict && warns > 0) {
+		return exitNoSources
+	}
+	return exitOK
+}
+
+// lintSourceMap checks sm against the v3 format; prefix locates nested section maps.
+func lintSourceMap(sm SourceMap, prefix string) []lintProblem {
+	var out []lintProblem
+	add := func(sev severity, where, format string, a ...any) {
+		out = append(out, lintProblem{Severity: sev, Where: prefix + where, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if sm.Version != 3 {
+		add(sevError, "version", "is %d, expected 3", sm.Version)
+	}
+	if len(sm.Sections) > 0 {
+		if len(sm.Sources) > 0 || sm.Mappings != "" {
+			add(sevError, "sections", "index map must not also have sources or mappings")
+		}
+		prevLine, prevCol := -1, -1
+		for i, sec := range sm.Sections {
+			where := fmt.Sprintf("sections[%d]", i)
+			if sec.Offset.Line < prevLine || (sec.Offset.Line == prevLine && sec.Offset.Column < prevCol) {
+				add(sevError, where+".offset", "sections are not in order")
+			}
+			prevLine, prevCol = sec.Offset.Line, sec.Offset.Column
+			switch {
+			case sec.Map != nil && sec.URL != "":
+				add(sevError, where, "has both map and url")
+			case sec.Map != nil:
+				out = append(out, lintSourceMap(*sec.Map, prefix+where+".map.")...)
+			case sec.URL != "":
+				add(sevInfo, where+".url", "references %s, not checked", sec.URL)
+			default:
+				add(sevError, where, "has neither map nor url")
+			}
+		}
+		return out
+	}
+
+	if len(sm.Sources) == 0 {
+		add(sevError, "sources", "is empty")
+	}
+	switch n, c := len(sm.Sources), len(sm.SourcesContent); {
+	case c == 0:
+		add(sevInfo, "sourcesContent", "missing, sources cannot be recovered from the map alone")
+	case c > n:
+		add(sevError, "sourcesContent", "has %d entries for %d sources", c, n)
+	case c < n:
+		add(sevWarning, "sourcesContent", "has %d entries for %d sources", c, n)
+	default:
+		empty := 0
+		for _, s := range sm.SourcesContent {
+			if strings.TrimSpace(s) == "" {
+				empty++
+			}
+		}
+		if empty > 0 {
+			add(sevInfo, "sourcesContent", "%d of %d entries are empty", empty, n)
+		}
+	}
+	seen := make(map[string]int)
+	for i, s := range sm.Sources {
+		if j, ok := seen[s]; ok {
+			add(sevWarning, fmt.Sprintf("sources[%d]", i), "duplicates sources[%d] %q", j, s)
+			continue
+		}
+		seen[s] = i
+	}
+
+	for name, list := range map[string][]int{"ignoreList": sm.IgnoreList, "x_google_ignoreList": sm.GoogleIgnore} {
+		for k, i := range list {
+			if i < 0 || i >= len(sm.Sources) {
+				add(sevError, fmt.Sprintf("%s[%d]", name, k), "source index %d out of range", i)
+			}
+		}
+	}
+
+	if sm.Mappings == "" {
+		if len(sm.Names) > 0 {
+			add(sevWarning, "names", "present but mappings is empty")
+		}
+		return out
+	}
+	segs, err := DecodeMappings(sm.Mappings)
+	var me *MappingError
+	if errors.As(err, &me) {
+		add(sevError, "mappings", "line %d, segment %d: %s", me.Line+1, me.Segment+1, me.Msg)
+	}
+	badSrc, badName := 0, 0
+	for _, s := range segs {
+		if s.Source < -1 || s.Source >= len(sm.Sources) || (s.HasSource() && (s.OriginalLine < 0 || s.OriginalColumn < 0)) {
+			if badSrc == 0 {
+				add(sevError, "mappings", "line %d column %d: source %d line %d column %d out of range",
+					s.GeneratedLine+1, s.GeneratedColumn, s.Source, s.OriginalLine, s.OriginalColumn)
+			}
+			badSrc++
+		}
+		if s.Name < -1 || s.Name >= len(sm.Names) {
+			if badName == 0 {
+				add(sevError, "mappings", "line %d column %d: name %d out of range (%d names)",
+					s.GeneratedLine+1, s.GeneratedColumn, s.Name, len(sm.Names))
+			}
+			badName++
+		}
+	}
+	if badSrc > 1 {
+		add(sevError, "mappings", "%d segments with an out of range source position", badSrc)
+	}
+	if badName > 1 {
+		add(sevError, "mappings", "%d segments with an out of range name", badName)
+	}
+	return out
+}
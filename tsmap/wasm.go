@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+)
+
+// wasmMagic starts every WebAssembly binary module.
+var wasmMagic = []byte("\x00asm")
+
+// wasmSourceMapSection is the custom section emscripten and wasm-pack builds
+// name their map in.
+const wasmSourceMapSection = "sourceMappingURL"
+
+var errNotWasm = errors.New("not a WebAssembly module")
+
+// reWasmLiteral matches a quoted path or URL of a .wasm file, as in
+// new URL("app_bg.wasm", import.meta.url) or an emscripten wasmBinaryFile.
+var reWasmLiteral = regexp.MustCompile("[\"'`]((?:https?:)?[\\w./~@%+-]*\\.wasm(?:\\?[\\w=&.%-]*)?)[\"'`]")
+
+// wasmDetector finds the WebAssembly modules a script or page loads. They are
+// resolved against the script, as wasm-bindgen and emscripten do, and crawled
+// for the map their sourceMappingURL section names.
+type wasmDetector struct{}
+
+func (wasmDetector) Name() string { return "wasm" }
+
+func (wasmDetector) DetectChunks(js string, scriptURL, _ *url.URL) []*url.URL {
+	return wasmRefs(js, scriptURL)
+}
+
+// wasmRefs returns the .wasm URLs quoted in text, resolved against base.
+func wasmRefs(text string, base *url.URL) []*url.URL {
+	seen := make(map[string]bool)
+	var out []*url.URL
+	for _, m := range reWasmLiteral.FindAllStringSubmatch(text, -1) {
+		u, err := base.Parse(m[1])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || seen[u.String()] {
+			continue
+		}
+		seen[u.String()] = true
+		out = append(out, u)
+	}
+	return out
+}
+
+// wasmSourceMapURL returns the value of the sourceMappingURL custom section of
+// the WebAssembly module r of size bytes, or "" when it has none. Sections are
+// skipped by their length, so only their headers are read.
+func wasmSourceMapURL(r io.ReaderAt, size int64) (string, error) {
+	head := make([]byte, 8)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		if err == io.EOF {
+			return "", errNotWasm
+		}
+		return "", err
+	}
+	if !bytes.Equal(head[:4], wasmMagic) {
+		return "", errNotWasm
+	}
+	br := &byteReaderAt{r: r, off: 8}
+	for br.off < size {
+		id, err := br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return "", fmt.Errorf("wasm section at %d: %w", br.off, err)
+		}
+		end := br.off + int64(n)
+		if end > size {
+			return "", fmt.Errorf("wasm section at %d: %d bytes past the end", br.off, end-size)
+		}
+		if id == 0 {
+			name, err := br.readName(end)
+			if err != nil {
+				return "", err
+			}
+			if name == wasmSourceMapSection {
+				return br.readName(end)
+			}
+		}
+		br.off = end
+	}
+	return "", nil
+}
+
+// byteReaderAt reads an io.ReaderAt byte by byte from off.
+type byteReaderAt struct {
+	r   io.ReaderAt
+	off int64
+}
+
+func (b *byteReaderAt) ReadByte() (byte, error) {
+	var c [1]byte
+	if _, err := b.r.ReadAt(c[:], b.off); err != nil {
+		return 0, err
+	}
+	b.off++
+	return c[0], nil
+}
+
+// readName reads a length-prefixed string ending before end.
+func (b *byteReaderAt) readName(end int64) (string, error) {
+	n, err := binary.ReadUvarint(b)
+	if err != nil {
+		return "", err
+	}
+	if b.off+int64(n) > end {
+		return "", fmt.Errorf("wasm name at %d: %d bytes past its section", b.off, b.off+int64(n)-end)
+	}
+	s := make([]byte, n)
+	if _, err := b.r.ReadAt(s, b.off); err != nil {
+		return "", err
+	}
+	b.off += int64(n)
+	return string(s), nil
+}
@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+// memWatchdog samples the process memory and trips once it goes over -max-rss,
+// so that callers stop taking new work and write a checkpoint. A nil *memWatchdog never trips.
+type memWatchdog struct {
+	limit   int64
+	peak    atomic.Int64
+	tripped atomic.Bool
+	stop    chan struct{}
+}
+
+func startWatchdog(limit int64) *memWatchdog {
+	if limit <= 0 {
+		return nil
+	}
+	// let the GC work harder before we give up
+	debug.SetMemoryLimit(limit * 9 / 10)
+	w := &memWatchdog{limit: limit, stop: make(chan struct{})}
+	w.sample()
+	go func() {
+		tick := time.NewTicker(200 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-tick.C:
+				w.sample()
+			}
+		}
+	}()
+	return w
+}
+
+func (w *memWatchdog) sample() {
+	rss := processMemory()
+	for {
+		p := w.peak.Load()
+		if rss <= p || w.peak.CompareAndSwap(p, rss) {
+			break
+		}
+	}
+	if rss > w.limit && !w.tripped.Swap(true) {
+		logger.Warn(msgMemoryLimit.String(), "rss", humanBytes(rss), "max", humanBytes(w.limit))
+	}
+}
+
+// exceeded reports whether the limit was reached; new work should not be started.
+func (w *memWatchdog) exceeded() bool {
+	return w != nil && w.tripped.Load()
+}
+
+func (w *memWatchdog) peakRSS() int64 {
+	if w == nil {
+		return 0
+	}
+	return w.peak.Load()
+}
+
+func (w *memWatchdog) close() {
+	if w != nil {
+		close(w.stop)
+	}
+}
+
+// processMemory approximates the resident size: memory mapped by the Go
+// runtime minus what was returned to the OS.
+func processMemory() int64 {
+	s := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindUint64 || s[1].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return int64(s[0].Value.Uint64() - s[1].Value.Uint64())
+}
+
+// checkpoint lists the work already done by an interrupted run, for -resume.
+type checkpoint struct {
+	Command   string    `json:"command"`
+	Target    string    `json:"target"` // root URL or map path
+	CreatedAt time.Time `json:"created_at"`
+	Done      []string  `json:"done"` // processed script URLs or source names
+}
+
+const checkpointName = "checkpoint.json"
+
+func writeCheckpoint(outDir string, cp *checkpoint) (string, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	p := filepath.Join(outDir, checkpointName)
+	return p, os.WriteFile(p, data, 0644)
+}
+
+// loadCheckpoint reads a -resume file and returns its done set; target must match.
+func loadCheckpoint(p, command, target string) (map[string]bool, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	if cp.Command != command || cp.Target != target {
+		return nil, fmt.Errorf("checkpoint is for %s %s", cp.Command, cp.Target)
+	}
+	done := make(map[string]bool, len(cp.Done))
+	for _, d := range cp.Done {
+		done[d] = true
+	}
+	return done, nil
+}
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: LGPL-3.0-or-later
+// Author: Michel Prunet - Safe Pic Technologies
+package tsmap
+
+import (
+	"flag"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// wordlist categories, one file each in -wordlist-out.
+const (
+	wordDirs   = "directories.txt"
+	wordFiles  = "files.txt"
+	wordParams = "params.txt"
+	wordAPI    = "api-segments.txt"
+)
+
+var (
+	reWord        = regexp.MustCompile(`^[A-Za-z0-9._~-]{1,64}$`)
+	reQueryParam  = regexp.MustCompile("[?&]([A-Za-z_][A-Za-z0-9_.\\[\\]-]{0,63})=")
+	reParamMethod = regexp.MustCompile(`(?:[sS]earchParams|[pP]arams|[qQ]uery|[fF]orm[dD]ata)\.(?:get|getAll|set|append|has|delete)\(\s*["'` + "`" + `]([A-Za-z_][A-Za-z0-9_.\[\]-]{0,63})["'` + "`" + `]`)
+)
+
+// wordlistBuilder collects fuzzing words from the recovered sources (-wordlist-out):
+// directory and file names of the source paths, parameter names and the
+// segments of API paths. Crawl workers call add concurrently.
+type wordlistBuilder struct {
+	dir string
+
+	mu    sync.Mutex
+	words map[string]map[string]bool // category -> words
+}
+
+func addWordlistFlags(fs *flag.FlagSet) *wordlistBuilder {
+	w := &wordlistBuilder{}
+	fs.StringVar(&w.dir, "wordlist-out", "", "Write fuzzing wordlists (directories, files, params, API segments) from the recovered sources to this directory")
+	return w
+}
+
+func (w *wordlistBuilder) put(category, word string) {
+	if !reWord.MatchString(word) || word == "." || word == ".." {
+		return
+	}
+	if w.words[category] == nil {
+		w.words[category] = make(map[string]bool)
+	}
+	w.words[category][word] = true
+}
+
+// scan records the words of one written file; vendor code is skipped.
+func (w *wordlistBuilder) scan(rel string, data []byte, meta FileMeta) {
+	if w == nil || w.dir == "" {
+		return
+	}
+	src := filterPath(rel)
+	if meta.Source != "" {
+		src = filterPath(sourcePathOnly(meta.Source))
+	}
+	if isVendorSource(src) {
+		return
+	}
+	text := string(data)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.words == nil {
+		w.words = make(map[string]map[string]bool)
+	}
+
+	segs := strings.Split(src, "/")
+	for _, d := range segs[:len(segs)-1] {
+		w.put(wordDirs, d)
+	}
+	if base := segs[len(segs)-1]; base != "" {
+		w.put(wordFiles, base)
+		if ext := path.Ext(base); ext != "" && ext != base {
+			w.put(wordFiles, strings.TrimSuffix(base, ext))
+		}
+	}
+
+	for _, re := range []*regexp.Regexp{reQueryParam, reParamMethod} {
+		for _, m := range re.FindAllStringSubmatch(text, -1) {
+			w.put(wordParams, m[1])
+		}
+	}
+	for _, p := range endpointPatterns {
+		for _, m := range p.re.FindAllStringSubmatch(text, -1) {
+			value := m[len(m)-1]
+			if !isEndpoint(value) {
+				continue
+			}
+			if u, err := url.Parse(value); err == nil {
+				for k := range u.Query() {
+					w.put(wordParams, k)
+				}
+				value = u.Path
+			}
+			for _, seg := range strings.Split(value, "/") {
+				// route parameters and template placeholders are not words
+				if strings.HasPrefix(seg, ":") || strings.Contains(seg, "${") || strings.ContainsAny(seg, "*{}") {
+					continue
+				}
+				w.put(wordAPI, seg)
+			}
+		}
+	}
+}
+
+// sourcePathOnly drops the scheme of a source URL ("webpack://app/./src/x.ts"
+// gives "app/./src/x.ts") and a "?query" suffix.
+func sourcePathOnly(src string) string {
+	if i := strings.Index(src, "://"); i >= 0 {
+		src = src[i+3:]
+	}
+	if i := strings.IndexByte(src, '?'); i >= 0 {
+		src = src[:i]
+	}
+	return strings.TrimLeft(src, "/")
+}
+
+// save writes one sorted file per category.
+func (w *wordlistBuilder) save(o *outputOptions) {
+	if w == nil || w.dir == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	args := []any{"dir", w.dir}
+	for _, category := range []string{wordDirs, wordFiles, wordParams, wordAPI} {
+		words := sortedKeys(w.words[category])
+		args = append(args, strings.TrimSuffix(category, ".txt"), len(words))
+		if o.dryRun {
+			continue
+		}
+		if err := os.MkdirAll(w.dir, 0755); err != nil {
+			logger.Warn(msgWordlistError.String(), "err", err)
+			return
+		}
+		data := strings.Join(words, "\n")
+		if data != "" {
+			data += "\n"
+		}
+		if err := writeAtomic(filepath.Join(w.dir, category), []byte(data), false); err != nil {
+			logger.Warn(msgWordlistError.String(), "err", err)
+			return
+		}
+	}
+	logger.Info(msgWordlistWritten.String(), args...)
+}